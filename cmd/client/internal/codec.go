@@ -1,79 +1,21 @@
 package internal
 
 import (
-	"bufio"
-	"bytes"
-	"errors"
 	"fmt"
-	"io"
 	"strings"
 
 	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
+// FormatInput splits the input by the same quoting rules of the inline requests, and encodes it as a
+// multi bulk request
 func FormatInput(input string) (string, error) {
-	var arr []protocol.RedisObject
-	reader := bufio.NewReader(strings.NewReader(input))
-
-	for {
-		s, err := parseString(reader)
-
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				if s != "" {
-					arr = append(arr, protocol.NewBulkRedisString(s))
-				}
-				break
-			} else {
-				return "", err
-			}
-		}
-
-		if s != "" {
-			arr = append(arr, protocol.NewBulkRedisString(s))
-		}
+	request, err := protocol.NewInlineRequest(input)
+	if err != nil {
+		return "", err
 	}
 
-	return protocol.NewRedisArray(arr).String(), nil
-}
-
-func parseString(reader *bufio.Reader) (string, error) {
-	var buf bytes.Buffer
-	for {
-		b, e := reader.ReadByte()
-		if e != nil {
-			if errors.Is(e, io.EOF) {
-				return buf.String(), e
-			}
-			return "", e
-		}
-
-		if b == '"' {
-			return parseQuote(reader)
-		} else if b == ' ' {
-			break
-		}
-
-		buf.WriteByte(b)
-	}
-
-	return buf.String(), nil
-}
-
-func parseQuote(reader *bufio.Reader) (string, error) {
-	var buf bytes.Buffer
-	for {
-		b, e := reader.ReadByte()
-		if e != nil {
-			return "", e
-		} else if b == '"' {
-			break
-		}
-
-		buf.WriteByte(b)
-	}
-
-	return buf.String(), nil
+	return request.String(), nil
 }
 
 func FormatOutput(obj protocol.RedisObject) string {
@@ -90,6 +32,24 @@ func FormatOutput(obj protocol.RedisObject) string {
 		return formatInteger(obj.(protocol.RedisInteger))
 	case protocol.ArrayType:
 		return formatArray(obj.(protocol.RedisArray))
+	case protocol.MapType:
+		return formatMap(obj.(protocol.RedisMap).Data())
+	case protocol.AttributeType:
+		return formatMap(obj.(protocol.RedisAttribute).Data())
+	case protocol.SetType:
+		return formatElements(obj.(protocol.RedisSet).Data(), "~")
+	case protocol.PushType:
+		return formatElements(obj.(protocol.RedisPush).Data(), ")")
+	case protocol.DoubleType:
+		return fmt.Sprintf("(double) %s", protocol.FormatDouble(obj.(protocol.RedisDouble).Data()))
+	case protocol.BooleanType:
+		return fmt.Sprintf("(%t)", obj.(protocol.RedisBoolean).Data())
+	case protocol.NullType:
+		return "(nil)"
+	case protocol.BigNumberType:
+		return fmt.Sprintf("(big number) %s", obj.(protocol.RedisBigNumber).Data())
+	case protocol.VerbatimStringType:
+		return fmt.Sprintf("\"%s\"", obj.(protocol.RedisVerbatimString).Data())
 	default:
 		return fmt.Sprintf("ClientError: unknown obj! object=%+v", obj)
 	}
@@ -116,10 +76,32 @@ func formatArray(obj protocol.RedisArray) string {
 		return "(empty list or set)"
 	}
 
+	return formatElements(obj.Data(), ")")
+}
+
+func formatElements(objs []protocol.RedisObject, mark string) string {
+	if len(objs) == 0 {
+		return "(empty list or set)"
+	}
+
+	var ret []string
+
+	for idx, item := range objs {
+		ret = append(ret, fmt.Sprintf("%d%s %s", idx+1, mark, FormatOutput(item)))
+	}
+
+	return strings.Join(ret, "\n")
+}
+
+func formatMap(objs []protocol.RedisObject) string {
+	if len(objs) == 0 {
+		return "(empty hash)"
+	}
+
 	var ret []string
 
-	for idx, item := range obj.Data() {
-		ret = append(ret, fmt.Sprintf("%d) %s", idx+1, FormatOutput(item)))
+	for idx := 0; idx+1 < len(objs); idx += 2 {
+		ret = append(ret, fmt.Sprintf("%d# %s => %s", idx/2+1, FormatOutput(objs[idx]), FormatOutput(objs[idx+1])))
 	}
 
 	return strings.Join(ret, "\n")
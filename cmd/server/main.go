@@ -27,16 +27,35 @@ const banner string = `
 `
 
 func main() {
-	fmt.Println(banner)
+	fmt.Print(banner)
 
 	s := network.NewServer()
 	c := common.Config{
-		LogPath:       "./vertex.log",
-		LogLevel:      "DEBUG",
-		Port:          6789,
-		DatabaseFile:  "./database.vpf",
-		EnableReplica: true,
-		ReplicaPort:   9999,
+		LogPath:           "./vertex.log",
+		LogLevel:          "DEBUG",
+		Port:              6789,
+		DatabaseFile:      "./database.vpf",
+		EnableReplica:     true,
+		ReplicaPort:       9999,
+		ReplBacklogSize:   common.DefaultReplBacklogSize,
+		Databases:         common.DefaultDatabases,
+		OutputBufferLimit: common.DefaultOutputBufferLimit,
+		ProtoMaxBulkLen:   common.DefaultProtoMaxBulkLen,
+		MaxMultibulkLen:   common.DefaultMaxMultibulkLen,
+		MaxInlineSize:     common.DefaultMaxInlineSize,
+		AppendFsync:       common.DefaultAppendFsync,
+
+		AOFLoadTruncated: true,
+
+		AutoAOFRewritePercentage: common.DefaultAutoAOFRewritePercentage,
+		AutoAOFRewriteMinSize:    common.DefaultAutoAOFRewriteMinSize,
+
+		SnapshotFile: "./database.vss",
+
+		MinReplicasToWrite: 0,
+		MinReplicasMaxLag:  common.DefaultMinReplicasMaxLag,
+
+		RaftDir: "./raft",
 	}
 
 	common.InitLog(c, true)
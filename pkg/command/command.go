@@ -4,8 +4,10 @@ import (
 	"errors"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/types"
 
 	"github.com/lxdlam/vertex/pkg/protocol"
 )
@@ -29,6 +31,51 @@ var (
 
 	// ErrNoSuchKey will be raised when access an non-exist container
 	ErrNoSuchKey = errors.New("command: no such key")
+
+	// ErrWrongType will be raised if the key holds a container of another type
+	ErrWrongType = errors.New("command: operation against a key holding the wrong kind of value")
+
+	// ErrInvalidExpireTime will be raised if the given expire time is not positive or overflows
+	ErrInvalidExpireTime = errors.New("command: invalid expire time")
+
+	// ErrSyntax will be raised if the options of a command conflict with each other
+	ErrSyntax = errors.New("command: syntax error")
+
+	// ErrInvalidCursor will be raised if the cursor of the scan commands is not an unsigned integer
+	ErrInvalidCursor = errors.New("command: invalid cursor")
+
+	// ErrDBIndexOutOfRange will be raised if the db index is not in [0, databases)
+	ErrDBIndexOutOfRange = errors.New("command: db index is out of range")
+
+	// ErrSameObject will be raised if the source and the destination of MOVE are the same db
+	ErrSameObject = errors.New("command: source and destination objects are the same")
+
+	// ErrNotAFloat will be raised if a score or a weight is not a valid float
+	ErrNotAFloat = errors.New("command: value is not a valid float")
+
+	// ErrInvalidScoreBound will be raised if a bound of a score range is not a float
+	ErrInvalidScoreBound = errors.New("command: min or max is not a float")
+
+	// ErrInvalidLexBound will be raised if a bound of a lex range does not start with `[` or `(`, and is not `-` or `+`
+	ErrInvalidLexBound = errors.New("command: min or max not valid string range item")
+
+	// ErrInvalidTimeout will be raised if the timeout of a blocking command is not a float
+	ErrInvalidTimeout = errors.New("command: timeout is not a float or out of range")
+
+	// ErrNegativeTimeout will be raised if the timeout of a blocking command is negative
+	ErrNegativeTimeout = errors.New("command: timeout is negative")
+
+	// ErrInvalidProtocolVersion will be raised if the protocol version of HELLO is not an integer
+	ErrInvalidProtocolVersion = errors.New("command: protocol version is not an integer or out of range")
+
+	// ErrNoProto will be raised if the protocol version of HELLO is not supported
+	ErrNoProto = errors.New("command: unsupported protocol version")
+
+	// ErrInvalidClientName will be raised if the client name contains spaces, newlines or special characters
+	ErrInvalidClientName = errors.New("command: client names cannot contain spaces, newlines or special characters")
+
+	// ErrInvalidMasterPort will be raised if the port of REPLICAOF is not in [1, 65535]
+	ErrInvalidMasterPort = errors.New("command: invalid master port")
 )
 
 type Command interface {
@@ -49,6 +96,200 @@ type Command interface {
 	Type() CommandType
 }
 
+// Rewriter is implemented by the commands whose effect depends on the time they are executed, e.g.,
+// relative expirations. Rewrite is called after Execute, it returns the request that reproduces the
+// same effect whenever it is replayed, or nil if there is nothing to persist.
+type Rewriter interface {
+	Rewrite() []protocol.RedisObject
+}
+
+// Blocker is implemented by the blocking commands. If Blocked reports true after Execute, nothing is
+// served and the engine parks the client on the blocking keys, then executes the command again once any
+// of them is ready, or replies the current result when the timeout is reached. A timeout of 0 means
+// forever. In a transaction the command never blocks and the current result is replied at once.
+type Blocker interface {
+	Blocked() bool
+	BlockingKeys() []string
+	Timeout() time.Duration
+}
+
+// Waker is implemented by the commands that may serve the blocked clients, e.g., pushing to a list.
+// ReadyKeys is called after Execute and returns the keys that may be ready.
+type Waker interface {
+	ReadyKeys() []string
+}
+
+// DBWaker is implemented by the commands that may serve the blocked clients of other dbs, e.g., MOVE and
+// SWAPDB. ReadyDBs is called after Execute and returns the dbs in which any key may be ready.
+type DBWaker interface {
+	ReadyDBs() []int
+}
+
+// Server is the view of the engine given to the server commands.
+type Server interface {
+	// Databases returns the count of the dbs, the valid indexes are [0, Databases()).
+	Databases() int
+
+	// Keyspace returns the keyspace of the db, ErrDBIndexOutOfRange is raised if the index is invalid.
+	Keyspace(int) (container.Containers, error)
+
+	// Uptime returns the time since the engine is created.
+	Uptime() time.Duration
+
+	// Persistence returns the state of the database file.
+	Persistence() PersistenceInfo
+
+	// RewriteLog starts a background rewrite of the database file.
+	RewriteLog() error
+
+	// Save takes a snapshot of all dbs at once.
+	Save() error
+
+	// BackgroundSave starts a snapshot of all dbs, which is written in the background.
+	BackgroundSave() error
+
+	// Snapshot returns the state of the snapshot file.
+	Snapshot() SnapshotInfo
+
+	// Replication returns the role of the server and the state of the replication.
+	Replication() ReplicationInfo
+
+	// ReplicaOf follows the master listening on the address for the replicas, an empty address stops
+	// following. It reports false if nothing is changed, i.e., the master is followed already.
+	ReplicaOf(string) bool
+
+	// Raft returns the state of the raft node.
+	Raft() RaftInfo
+}
+
+// ReplicationInfo is the state of the replication reported by INFO and ROLE.
+type ReplicationInfo struct {
+	// Replica reports if the server follows a master, the fields of the link are empty if not.
+	Replica bool
+
+	// MasterHost and MasterPort are the address of the master for the replicas.
+	MasterHost string
+	MasterPort int
+
+	// LinkState is the state of the link replied by ROLE, i.e., connect, connecting, sync or connected.
+	LinkState string
+
+	// LastIO is the time anything is received from the master, and LinkDownSince is the time the link is
+	// broken. They are zero if never happened.
+	LastIO        time.Time
+	LinkDownSince time.Time
+
+	// ReplicaOffset is the offset of the stream received from the master, -1 before the first sync.
+	ReplicaOffset int64
+
+	// SyncSize and SyncPosition are the bytes of the dataset in transfer from the master and the ones
+	// applied, both are zero if no transfer is in progress. The size is -1 until it is known.
+	SyncSize     int64
+	SyncPosition int64
+
+	// ReplicationID and Offset are the position of the stream of the server for its replicas, the backlog
+	// is inactive if the server accepts no replica.
+	ReplicationID      string
+	Offset             int64
+	BacklogActive      bool
+	BacklogSize        int
+	BacklogFirstOffset int64
+	BacklogHistlen     int
+
+	// Replicas are the replicas connected to the server.
+	Replicas []ReplicaInfo
+
+	// MinReplicasToWrite is the count of the good replicas required to accept the modifications, zero if
+	// not required, and GoodReplicas is the count of the replicas whose lag is within the limit.
+	MinReplicasToWrite int
+	GoodReplicas       int
+}
+
+// ReplicaInfo is a replica connected to the server.
+type ReplicaInfo struct {
+	IP    string
+	Port  int
+	State string
+
+	// Offset is the offset acknowledged by the replica, and Lag is the time since the acknowledgement.
+	Offset int64
+	Lag    time.Duration
+}
+
+// RaftInfo is the state of the raft node reported by INFO.
+type RaftInfo struct {
+	// Enabled reports if the server runs in the raft mode, the others are empty if not.
+	Enabled bool
+
+	ID    string
+	State string
+	Term  uint64
+
+	// Leader is the id of the leader known by the node, and LeaderAddr is the address of its clients. Both
+	// are empty if the leader is unknown.
+	Leader     string
+	LeaderAddr string
+
+	CommitIndex   uint64
+	LastApplied   uint64
+	LastIndex     uint64
+	SnapshotIndex uint64
+}
+
+// SnapshotInfo is the state of the snapshot file reported by INFO and LASTSAVE.
+type SnapshotInfo struct {
+	// Enabled reports if a snapshot file is configured.
+	Enabled bool
+
+	// Saving reports if a background save is running.
+	Saving bool
+
+	// LastSave is the time the last successful snapshot is taken, or the time the server starts.
+	LastSave time.Time
+
+	// SaveErr is the error of the last save.
+	SaveErr error
+}
+
+// PersistenceInfo is the state of the database file reported by INFO.
+type PersistenceInfo struct {
+	// Enabled reports if the modifications are appended to a database file, the others are empty if not.
+	Enabled bool
+
+	// AppendFsync is the fsync policy of the file, i.e., always, everysec or no.
+	AppendFsync string
+
+	// LastFsync is the time of the last successful fsync, zero if the file is never committed.
+	LastFsync time.Time
+
+	// Buffered is the bytes appended but not written to the file yet.
+	Buffered int
+
+	// WriteErr is the error that stops the file, the modifications are refused until the server restarts.
+	WriteErr error
+
+	// FsyncErr is the error of the last fsync, which is retried by the next one.
+	FsyncErr error
+
+	// Size is the size of the file, and BaseSize is the size when it is opened or rewritten last time.
+	Size     int64
+	BaseSize int64
+
+	// Rewriting reports if a rewrite is running, and RewriteErr is the error of the last rewrite.
+	Rewriting  bool
+	RewriteErr error
+}
+
+// ServerCommand is implemented by the commands working on the connection or across the dbs rather than
+// on the keys of the selected db, e.g., SELECT and SWAPDB. The engine calls SetServer instead of
+// SetAccessObjects before Execute, the session is nil if the command is replayed from the log outside a
+// transaction.
+type ServerCommand interface {
+	Command
+
+	SetServer(Server, *types.Session)
+}
+
 var keyMap map[string]func(string, int, []protocol.RedisObject) (Command, error) = nil
 var lock sync.RWMutex
 
@@ -72,6 +313,60 @@ func init() {
 	keyMap["decrby"] = newGlobalCommand
 	keyMap["getrange"] = newGlobalCommand
 
+	// Expire Commands
+	keyMap["expire"] = newExpireCommand
+	keyMap["pexpire"] = newExpireCommand
+	keyMap["expireat"] = newExpireCommand
+	keyMap["pexpireat"] = newExpireCommand
+	keyMap["ttl"] = newExpireCommand
+	keyMap["pttl"] = newExpireCommand
+	keyMap["persist"] = newExpireCommand
+
+	// Key Commands
+	keyMap["del"] = newKeyCommand
+	keyMap["unlink"] = newKeyCommand
+	keyMap["type"] = newKeyCommand
+	keyMap["keys"] = newKeyCommand
+	keyMap["rename"] = newKeyCommand
+	keyMap["renamenx"] = newKeyCommand
+	keyMap["randomkey"] = newKeyCommand
+	keyMap["dbsize"] = newKeyCommand
+	keyMap["touch"] = newKeyCommand
+
+	// Database Commands
+	keyMap["select"] = newDatabaseCommand
+	keyMap["swapdb"] = newDatabaseCommand
+	keyMap["move"] = newDatabaseCommand
+	keyMap["flushdb"] = newDatabaseCommand
+	keyMap["flushall"] = newDatabaseCommand
+
+	// Scan Commands
+	keyMap["scan"] = newScanCommand
+	keyMap["hscan"] = newScanCommand
+	keyMap["sscan"] = newScanCommand
+	keyMap["zscan"] = newScanCommand
+
+	// Sorted Set Commands
+	keyMap["zadd"] = newSortedSetCommand
+	keyMap["zincrby"] = newSortedSetCommand
+	keyMap["zrem"] = newSortedSetCommand
+	keyMap["zscore"] = newSortedSetCommand
+	keyMap["zmscore"] = newSortedSetCommand
+	keyMap["zcard"] = newSortedSetCommand
+	keyMap["zcount"] = newSortedSetCommand
+	keyMap["zrank"] = newSortedSetCommand
+	keyMap["zrevrank"] = newSortedSetCommand
+	keyMap["zrange"] = newSortedSetCommand
+	keyMap["zrangebyscore"] = newSortedSetCommand
+	keyMap["zremrangebyrank"] = newSortedSetCommand
+	keyMap["zremrangebyscore"] = newSortedSetCommand
+	keyMap["zremrangebylex"] = newSortedSetCommand
+	keyMap["zpopmin"] = newSortedSetCommand
+	keyMap["zpopmax"] = newSortedSetCommand
+	keyMap["zunionstore"] = newSortedSetCommand
+	keyMap["zinterstore"] = newSortedSetCommand
+	keyMap["zrandmember"] = newSortedSetCommand
+
 	// List Commands
 	keyMap["lpop"] = newListCommand
 	keyMap["rpop"] = newListCommand
@@ -84,6 +379,12 @@ func init() {
 	keyMap["ltrim"] = newListCommand
 	keyMap["lrem"] = newListCommand
 	keyMap["lset"] = newListCommand
+	keyMap["lmove"] = newListCommand
+	keyMap["rpoplpush"] = newListCommand
+	keyMap["blpop"] = newListCommand
+	keyMap["brpop"] = newListCommand
+	keyMap["blmove"] = newListCommand
+	keyMap["brpoplpush"] = newListCommand
 
 	// Hash Commands
 	keyMap["hset"] = newHashCommand
@@ -108,6 +409,23 @@ func init() {
 	keyMap["sinter"] = newSetCommand
 	keyMap["sunion"] = newSetCommand
 	keyMap["scard"] = newSetCommand
+
+	// Connection Commands
+	keyMap["ping"] = newConnectionCommand
+	keyMap["echo"] = newConnectionCommand
+	keyMap["hello"] = newConnectionCommand
+	keyMap["client"] = newConnectionCommand
+	keyMap["command"] = newConnectionCommand
+
+	// Server Commands
+	keyMap["info"] = newServerCommand
+	keyMap["bgrewriteaof"] = newServerCommand
+	keyMap["save"] = newServerCommand
+	keyMap["bgsave"] = newServerCommand
+	keyMap["lastsave"] = newServerCommand
+	keyMap["replicaof"] = newServerCommand
+	keyMap["slaveof"] = newServerCommand
+	keyMap["role"] = newServerCommand
 }
 
 // NewCommand will returns a new command by the name
@@ -0,0 +1,560 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+func newConnectionCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "ping":
+		p := &pingCommand{
+			index: index,
+		}
+		err := p.ParseArguments(arguments)
+		return p, err
+	case "echo":
+		e := &echoCommand{
+			index: index,
+		}
+		err := e.ParseArguments(arguments)
+		return e, err
+	case "hello":
+		h := &helloCommand{
+			index: index,
+		}
+		err := h.ParseArguments(arguments)
+		return h, err
+	case "client":
+		c := &clientCommand{
+			index: index,
+		}
+		err := c.ParseArguments(arguments)
+		return c, err
+	case "command":
+		c := &commandCommand{
+			index: index,
+		}
+		err := c.ParseArguments(arguments)
+		return c, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// validClientName reports whether the name only contains the printable characters except the space
+func validClientName(name string) bool {
+	for idx := 0; idx < len(name); idx++ {
+		if name[idx] < '!' || name[idx] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+type pingCommand struct {
+	message *string
+	index   int
+	session *types.Session
+	result  protocol.RedisObject
+	err     error
+}
+
+func (p *pingCommand) Name() string {
+	return "ping"
+}
+
+func (p *pingCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) > 1 {
+		return ErrArgumentInvalid
+	}
+
+	if len(objects) == 1 {
+		message, err := parseStrings(objects)
+		if err != nil {
+			return err
+		}
+
+		p.message = &message[0]
+	}
+
+	return nil
+}
+
+// Execute replies PONG or the message, a RESP2 client in the subscribed mode gets them in a pong message
+func (p *pingCommand) Execute() {
+	if p.session != nil && p.session.Subscriptions() > 0 && p.session.Protocol() == protocol.Resp2 {
+		message := ""
+		if p.message != nil {
+			message = *p.message
+		}
+
+		p.result = protocol.NewRedisArray([]protocol.RedisObject{
+			protocol.NewBulkRedisString("pong"),
+			protocol.NewBulkRedisString(message),
+		})
+		return
+	}
+
+	if p.message != nil {
+		p.result = protocol.NewBulkRedisString(*p.message)
+	} else {
+		p.result = protocol.NewSimpleRedisString("PONG")
+	}
+}
+
+func (p *pingCommand) Result() (protocol.RedisObject, error) {
+	return p.result, p.err
+}
+
+func (p *pingCommand) Cluster() int {
+	return p.index
+}
+
+func (p *pingCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (p *pingCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (p *pingCommand) Keys() []string {
+	return nil
+}
+
+func (p *pingCommand) ShouldCreate() bool {
+	return false
+}
+
+func (p *pingCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (p *pingCommand) SetServer(_ Server, session *types.Session) {
+	p.session = session
+}
+
+func (p *pingCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type echoCommand struct {
+	message string
+	index   int
+	result  protocol.RedisObject
+	err     error
+}
+
+func (e *echoCommand) Name() string {
+	return "echo"
+}
+
+func (e *echoCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	message, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	e.message = message[0]
+	return nil
+}
+
+func (e *echoCommand) Execute() {
+	e.result = protocol.NewBulkRedisString(e.message)
+}
+
+func (e *echoCommand) Result() (protocol.RedisObject, error) {
+	return e.result, e.err
+}
+
+func (e *echoCommand) Cluster() int {
+	return e.index
+}
+
+func (e *echoCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (e *echoCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (e *echoCommand) Keys() []string {
+	return nil
+}
+
+func (e *echoCommand) ShouldCreate() bool {
+	return false
+}
+
+func (e *echoCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (e *echoCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// helloCommand is HELLO [protover [AUTH username password] [SETNAME clientname]]. It switches the client
+// to RESP2 or RESP3, the current version is kept if none is given. No password is required, so the AUTH
+// option is accepted as is.
+type helloCommand struct {
+	version int
+	name    *string
+	index   int
+	session *types.Session
+	result  protocol.RedisObject
+	err     error
+}
+
+func (h *helloCommand) Name() string {
+	return "hello"
+}
+
+func (h *helloCommand) ParseArguments(objects []protocol.RedisObject) error {
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) == 0 {
+		return nil
+	}
+
+	h.version, err = strconv.Atoi(arguments[0])
+	if err != nil {
+		return ErrInvalidProtocolVersion
+	} else if h.version <= 0 {
+		return fmt.Errorf("hello with protocol version %d, err={%w}", h.version, ErrNoProto)
+	}
+
+	for idx := 1; idx < len(arguments); idx++ {
+		switch strings.ToLower(arguments[idx]) {
+		case "auth":
+			if idx+2 >= len(arguments) {
+				return ErrSyntax
+			}
+			idx += 2
+		case "setname":
+			if idx+1 >= len(arguments) {
+				return ErrSyntax
+			}
+			idx++
+			h.name = &arguments[idx]
+		default:
+			return fmt.Errorf("unknown hello option. option=%s, err={%w}", strconv.Quote(arguments[idx]), ErrSyntax)
+		}
+	}
+
+	return nil
+}
+
+func (h *helloCommand) Execute() {
+	if h.version != 0 && h.version != protocol.Resp2 && h.version != protocol.Resp3 {
+		h.err = fmt.Errorf("hello with protocol version %d, err={%w}", h.version, ErrNoProto)
+		return
+	}
+
+	if h.name != nil && !validClientName(*h.name) {
+		h.err = ErrInvalidClientName
+		return
+	}
+
+	var id int64
+	version := protocol.Resp2
+	if h.session != nil {
+		if h.version != 0 {
+			h.session.SetProtocol(h.version)
+		}
+		if h.name != nil {
+			h.session.SetName(*h.name)
+		}
+
+		id = h.session.ClientID()
+		version = h.session.Protocol()
+	}
+
+	h.result = protocol.NewRedisMap([]protocol.RedisObject{
+		protocol.NewBulkRedisString("server"),
+		protocol.NewBulkRedisString("vertex"),
+		protocol.NewBulkRedisString("version"),
+		protocol.NewBulkRedisString(common.Version),
+		protocol.NewBulkRedisString("proto"),
+		protocol.NewRedisInteger(int64(version)),
+		protocol.NewBulkRedisString("id"),
+		protocol.NewRedisInteger(id),
+		protocol.NewBulkRedisString("mode"),
+		protocol.NewBulkRedisString("standalone"),
+		protocol.NewBulkRedisString("role"),
+		protocol.NewBulkRedisString("master"),
+		protocol.NewBulkRedisString("modules"),
+		protocol.NewRedisArray([]protocol.RedisObject{}),
+	})
+}
+
+func (h *helloCommand) Result() (protocol.RedisObject, error) {
+	return h.result, h.err
+}
+
+func (h *helloCommand) Cluster() int {
+	return h.index
+}
+
+func (h *helloCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (h *helloCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (h *helloCommand) Keys() []string {
+	return nil
+}
+
+func (h *helloCommand) ShouldCreate() bool {
+	return false
+}
+
+func (h *helloCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (h *helloCommand) SetServer(_ Server, session *types.Session) {
+	h.session = session
+}
+
+func (h *helloCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// clientCommand is CLIENT ID, CLIENT GETNAME, CLIENT SETNAME name and CLIENT SETINFO LIB-NAME|LIB-VER value
+type clientCommand struct {
+	subcommand string
+	arguments  []string
+	index      int
+	session    *types.Session
+	result     protocol.RedisObject
+	err        error
+}
+
+func (c *clientCommand) Name() string {
+	return "client"
+}
+
+func (c *clientCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) == 0 {
+		return ErrArgumentInvalid
+	}
+
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	c.subcommand = strings.ToLower(arguments[0])
+	c.arguments = arguments[1:]
+
+	switch c.subcommand {
+	case "id", "getname":
+		if len(c.arguments) != 0 {
+			return ErrArgumentInvalid
+		}
+	case "setname":
+		if len(c.arguments) != 1 {
+			return ErrArgumentInvalid
+		}
+	case "setinfo":
+		if len(c.arguments) != 2 {
+			return ErrArgumentInvalid
+		}
+
+		if attr := strings.ToLower(c.arguments[0]); attr != "lib-name" && attr != "lib-ver" {
+			return fmt.Errorf("unknown client setinfo attribute. attribute=%s, err={%w}", strconv.Quote(c.arguments[0]), ErrSyntax)
+		}
+	default:
+		return fmt.Errorf("unknown client subcommand. subcommand=%s, err={%w}", strconv.Quote(arguments[0]), ErrSyntax)
+	}
+
+	return nil
+}
+
+func (c *clientCommand) Execute() {
+	if c.session == nil {
+		c.err = fmt.Errorf("nil session")
+		return
+	}
+
+	switch c.subcommand {
+	case "id":
+		c.result = protocol.NewRedisInteger(c.session.ClientID())
+	case "getname":
+		if c.session.Name() == "" {
+			c.result = protocol.NewNullBulkRedisString()
+		} else {
+			c.result = protocol.NewBulkRedisString(c.session.Name())
+		}
+	case "setname":
+		if !validClientName(c.arguments[0]) {
+			c.err = ErrInvalidClientName
+			return
+		}
+
+		c.session.SetName(c.arguments[0])
+		c.result = protocol.NewSimpleRedisString("OK")
+	case "setinfo":
+		if !validClientName(c.arguments[1]) {
+			c.err = ErrInvalidClientName
+			return
+		}
+
+		if strings.ToLower(c.arguments[0]) == "lib-name" {
+			c.session.SetLibName(c.arguments[1])
+		} else {
+			c.session.SetLibVersion(c.arguments[1])
+		}
+		c.result = protocol.NewSimpleRedisString("OK")
+	}
+}
+
+func (c *clientCommand) Result() (protocol.RedisObject, error) {
+	return c.result, c.err
+}
+
+func (c *clientCommand) Cluster() int {
+	return c.index
+}
+
+func (c *clientCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (c *clientCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (c *clientCommand) Keys() []string {
+	return nil
+}
+
+func (c *clientCommand) ShouldCreate() bool {
+	return false
+}
+
+func (c *clientCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (c *clientCommand) SetServer(_ Server, session *types.Session) {
+	c.session = session
+}
+
+func (c *clientCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// commandCommand is COMMAND, COMMAND COUNT, COMMAND LIST, COMMAND INFO [name ...] and COMMAND DOCS [name ...].
+// The entries are in the redis 5 format, and no document is provided, so the clients use their own help.
+type commandCommand struct {
+	subcommand string
+	names      []string
+	index      int
+	result     protocol.RedisObject
+	err        error
+}
+
+func (c *commandCommand) Name() string {
+	return "command"
+}
+
+func (c *commandCommand) ParseArguments(objects []protocol.RedisObject) error {
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) == 0 {
+		return nil
+	}
+
+	c.subcommand = strings.ToLower(arguments[0])
+	c.names = arguments[1:]
+
+	switch c.subcommand {
+	case "count", "list":
+		if len(c.names) != 0 {
+			return ErrArgumentInvalid
+		}
+	case "info", "docs":
+	default:
+		return fmt.Errorf("unknown command subcommand. subcommand=%s, err={%w}", strconv.Quote(arguments[0]), ErrSyntax)
+	}
+
+	return nil
+}
+
+func (c *commandCommand) Execute() {
+	names := c.names
+	if c.subcommand == "" || (c.subcommand == "info" && len(names) == 0) {
+		names = commandNames()
+	}
+
+	switch c.subcommand {
+	case "count":
+		c.result = protocol.NewRedisInteger(int64(len(commandTable)))
+	case "list":
+		objs := []protocol.RedisObject{}
+		for _, name := range commandNames() {
+			objs = append(objs, protocol.NewBulkRedisString(name))
+		}
+
+		c.result = protocol.NewRedisArray(objs)
+	case "docs":
+		c.result = protocol.NewRedisArray([]protocol.RedisObject{})
+	default:
+		objs := []protocol.RedisObject{}
+		for _, name := range names {
+			if info, ok := lookupInfo(name); ok {
+				objs = append(objs, info.toRedisObject())
+			} else {
+				objs = append(objs, protocol.NewNullRedisArray())
+			}
+		}
+
+		c.result = protocol.NewRedisArray(objs)
+	}
+}
+
+func (c *commandCommand) Result() (protocol.RedisObject, error) {
+	return c.result, c.err
+}
+
+func (c *commandCommand) Cluster() int {
+	return c.index
+}
+
+func (c *commandCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (c *commandCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (c *commandCommand) Keys() []string {
+	return nil
+}
+
+func (c *commandCommand) ShouldCreate() bool {
+	return false
+}
+
+func (c *commandCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (c *commandCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
@@ -0,0 +1,504 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+func newDatabaseCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "select":
+		s := &selectCommand{
+			index: index,
+		}
+		err := s.ParseArguments(arguments)
+		return s, err
+	case "swapdb":
+		s := &swapDBCommand{
+			index: index,
+		}
+		err := s.ParseArguments(arguments)
+		return s, err
+	case "move":
+		m := &moveCommand{
+			index: index,
+		}
+		err := m.ParseArguments(arguments)
+		return m, err
+	case "flushdb":
+		f := &flushDBCommand{
+			index: index,
+		}
+		err := f.ParseArguments(arguments)
+		return f, err
+	case "flushall":
+		f := &flushAllCommand{
+			index: index,
+		}
+		err := f.ParseArguments(arguments)
+		return f, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+func parseDBIndex(obj protocol.RedisObject) (int, error) {
+	tmpObj, ok := obj.(protocol.RedisString)
+	if !ok {
+		return 0, ErrArgumentInvalid
+	}
+
+	index, err := strconv.Atoi(tmpObj.Data())
+	if err != nil {
+		return 0, ErrArgumentInvalid
+	}
+
+	return index, nil
+}
+
+// parseFlushMode accepts the optional ASYNC or SYNC flag of FLUSHDB and FLUSHALL. The flushed keyspace is
+// dropped at once and reclaimed by the GC, so both modes work the same.
+func parseFlushMode(objects []protocol.RedisObject) error {
+	if len(objects) == 0 {
+		return nil
+	} else if len(objects) > 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	if mode := strings.ToLower(tmpObj.Data()); mode != "async" && mode != "sync" {
+		return ErrSyntax
+	}
+
+	return nil
+}
+
+type selectCommand struct {
+	target  int
+	index   int
+	server  Server
+	session *types.Session
+	result  protocol.RedisString
+	err     error
+}
+
+func (s *selectCommand) Name() string {
+	return "select"
+}
+
+func (s *selectCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	var err error
+	s.target, err = parseDBIndex(objects[0])
+
+	return err
+}
+
+func (s *selectCommand) Execute() {
+	if s.server == nil {
+		s.err = fmt.Errorf("nil server")
+		return
+	}
+
+	if s.target < 0 || s.target >= s.server.Databases() {
+		s.err = ErrDBIndexOutOfRange
+		return
+	}
+
+	if s.session != nil {
+		s.session.SetDB(s.target)
+	}
+
+	s.result = protocol.NewSimpleRedisString("OK")
+}
+
+func (s *selectCommand) Result() (protocol.RedisObject, error) {
+	return s.result, s.err
+}
+
+func (s *selectCommand) Cluster() int {
+	return s.index
+}
+
+func (s *selectCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (s *selectCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (s *selectCommand) Keys() []string {
+	return nil
+}
+
+func (s *selectCommand) ShouldCreate() bool {
+	return false
+}
+
+func (s *selectCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (s *selectCommand) SetServer(server Server, session *types.Session) {
+	s.server = server
+	s.session = session
+}
+
+func (s *selectCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type swapDBCommand struct {
+	first  int
+	second int
+	index  int
+	server Server
+	result protocol.RedisString
+	err    error
+}
+
+func (s *swapDBCommand) Name() string {
+	return "swapdb"
+}
+
+func (s *swapDBCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	var err error
+	if s.first, err = parseDBIndex(objects[0]); err != nil {
+		return err
+	}
+
+	s.second, err = parseDBIndex(objects[1])
+
+	return err
+}
+
+func (s *swapDBCommand) Execute() {
+	if s.server == nil {
+		s.err = fmt.Errorf("nil server")
+		return
+	}
+
+	first, err := s.server.Keyspace(s.first)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	second, err := s.server.Keyspace(s.second)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	first.Swap(second)
+	s.result = protocol.NewSimpleRedisString("OK")
+}
+
+func (s *swapDBCommand) Result() (protocol.RedisObject, error) {
+	return s.result, s.err
+}
+
+// ReadyDBs wakes the clients blocked in both dbs, which see the keys of each other now
+func (s *swapDBCommand) ReadyDBs() []int {
+	if s.result == nil {
+		return nil
+	}
+
+	return []int{s.first, s.second}
+}
+
+func (s *swapDBCommand) Cluster() int {
+	return s.index
+}
+
+func (s *swapDBCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (s *swapDBCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (s *swapDBCommand) Keys() []string {
+	return nil
+}
+
+func (s *swapDBCommand) ShouldCreate() bool {
+	return false
+}
+
+func (s *swapDBCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (s *swapDBCommand) SetServer(server Server, _ *types.Session) {
+	s.server = server
+}
+
+func (s *swapDBCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// moveCommand moves a key from the selected db to the target db
+type moveCommand struct {
+	key    string
+	target int
+	index  int
+	server Server
+	result protocol.RedisInteger
+	err    error
+}
+
+func (m *moveCommand) Name() string {
+	return "move"
+}
+
+func (m *moveCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	m.key = tmpObj.Data()
+
+	var err error
+	m.target, err = parseDBIndex(objects[1])
+
+	return err
+}
+
+func (m *moveCommand) Execute() {
+	if m.server == nil {
+		m.err = fmt.Errorf("nil server")
+		return
+	}
+
+	if m.target == m.index {
+		m.err = ErrSameObject
+		return
+	}
+
+	source, err := m.server.Keyspace(m.index)
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	target, err := m.server.Keyspace(m.target)
+	if err != nil {
+		m.err = err
+		return
+	}
+
+	if source.Move(m.key, target) {
+		m.result = protocol.NewRedisInteger(1)
+	} else {
+		m.result = protocol.NewRedisInteger(0)
+	}
+}
+
+func (m *moveCommand) Result() (protocol.RedisObject, error) {
+	return m.result, m.err
+}
+
+// Rewrite skips the log if nothing is moved
+func (m *moveCommand) Rewrite() []protocol.RedisObject {
+	if m.result == nil || m.result.Data() == 0 {
+		return nil
+	}
+
+	return []protocol.RedisObject{
+		protocol.NewBulkRedisString("move"),
+		protocol.NewBulkRedisString(m.key),
+		protocol.NewBulkRedisString(strconv.Itoa(m.target)),
+	}
+}
+
+// ReadyDBs wakes the clients blocked in the target db, the moved key may be one they wait for
+func (m *moveCommand) ReadyDBs() []int {
+	if m.result == nil || m.result.Data() == 0 {
+		return nil
+	}
+
+	return []int{m.target}
+}
+
+func (m *moveCommand) Cluster() int {
+	return m.index
+}
+
+func (m *moveCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (m *moveCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (m *moveCommand) Keys() []string {
+	return []string{m.key}
+}
+
+func (m *moveCommand) ShouldCreate() bool {
+	return false
+}
+
+func (m *moveCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (m *moveCommand) SetServer(server Server, _ *types.Session) {
+	m.server = server
+}
+
+func (m *moveCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type flushDBCommand struct {
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisString
+	err          error
+}
+
+func (f *flushDBCommand) Name() string {
+	return "flushdb"
+}
+
+func (f *flushDBCommand) ParseArguments(objects []protocol.RedisObject) error {
+	return parseFlushMode(objects)
+}
+
+func (f *flushDBCommand) Execute() {
+	if f.accessObject == nil {
+		f.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if f.accessObject.Type() != f.TargetContainerType() {
+		f.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", f.TargetContainerType(), f.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	f.accessObject.(container.Containers).Flush()
+	f.result = protocol.NewSimpleRedisString("OK")
+}
+
+func (f *flushDBCommand) Result() (protocol.RedisObject, error) {
+	return f.result, f.err
+}
+
+func (f *flushDBCommand) Cluster() int {
+	return f.index
+}
+
+func (f *flushDBCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (f *flushDBCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (f *flushDBCommand) Keys() []string {
+	return nil
+}
+
+func (f *flushDBCommand) ShouldCreate() bool {
+	return false
+}
+
+func (f *flushDBCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	f.accessObject = objects[0]
+}
+
+func (f *flushDBCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type flushAllCommand struct {
+	index  int
+	server Server
+	result protocol.RedisString
+	err    error
+}
+
+func (f *flushAllCommand) Name() string {
+	return "flushall"
+}
+
+func (f *flushAllCommand) ParseArguments(objects []protocol.RedisObject) error {
+	return parseFlushMode(objects)
+}
+
+func (f *flushAllCommand) Execute() {
+	if f.server == nil {
+		f.err = fmt.Errorf("nil server")
+		return
+	}
+
+	for idx := 0; idx < f.server.Databases(); idx++ {
+		keyspace, err := f.server.Keyspace(idx)
+		if err != nil {
+			f.err = err
+			return
+		}
+
+		keyspace.Flush()
+	}
+
+	f.result = protocol.NewSimpleRedisString("OK")
+}
+
+func (f *flushAllCommand) Result() (protocol.RedisObject, error) {
+	return f.result, f.err
+}
+
+func (f *flushAllCommand) Cluster() int {
+	return f.index
+}
+
+func (f *flushAllCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (f *flushAllCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (f *flushAllCommand) Keys() []string {
+	return nil
+}
+
+func (f *flushAllCommand) ShouldCreate() bool {
+	return false
+}
+
+func (f *flushAllCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (f *flushAllCommand) SetServer(server Server, _ *types.Session) {
+	f.server = server
+}
+
+func (f *flushAllCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
@@ -0,0 +1,397 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+const (
+	millisecond int64 = 1
+	second      int64 = 1000
+)
+
+func newExpireCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "expire":
+		e := &expireCommand{
+			name:  name,
+			index: index,
+			unit:  second,
+		}
+		err := e.ParseArguments(arguments)
+		return e, err
+	case "pexpire":
+		e := &expireCommand{
+			name:  name,
+			index: index,
+			unit:  millisecond,
+		}
+		err := e.ParseArguments(arguments)
+		return e, err
+	case "expireat":
+		e := &expireCommand{
+			name:     name,
+			index:    index,
+			unit:     second,
+			absolute: true,
+		}
+		err := e.ParseArguments(arguments)
+		return e, err
+	case "pexpireat":
+		e := &expireCommand{
+			name:     name,
+			index:    index,
+			unit:     millisecond,
+			absolute: true,
+		}
+		err := e.ParseArguments(arguments)
+		return e, err
+	case "ttl":
+		t := &ttlCommand{
+			name:  name,
+			index: index,
+			unit:  second,
+		}
+		err := t.ParseArguments(arguments)
+		return t, err
+	case "pttl":
+		t := &ttlCommand{
+			name:  name,
+			index: index,
+			unit:  millisecond,
+		}
+		err := t.ParseArguments(arguments)
+		return t, err
+	case "persist":
+		p := &persistCommand{
+			index: index,
+		}
+		err := p.ParseArguments(arguments)
+		return p, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// parseExpireAmount parses the amount in the unit into milliseconds, it fails if the amount overflows.
+func parseExpireAmount(obj protocol.RedisObject, unit int64) (int64, error) {
+	amountObj, ok := obj.(protocol.RedisString)
+	if !ok {
+		return 0, ErrArgumentInvalid
+	}
+
+	amount, err := util.ParseInt64(amountObj.Data())
+	if err != nil {
+		return 0, ErrArgumentInvalid
+	}
+
+	if amount > math.MaxInt64/unit || amount < math.MinInt64/unit {
+		return 0, ErrInvalidExpireTime
+	}
+
+	return amount * unit, nil
+}
+
+// resolveDeadline converts an amount in milliseconds into an absolute deadline
+func resolveDeadline(amount int64, absolute bool) (int64, error) {
+	if absolute {
+		return amount, nil
+	}
+
+	now := util.UnixMilli()
+	if amount > 0 && now > math.MaxInt64-amount {
+		return 0, ErrInvalidExpireTime
+	}
+
+	return now + amount, nil
+}
+
+// expireCommand is EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT. All of them will be persisted as PEXPIREAT.
+type expireCommand struct {
+	name         string
+	key          string
+	index        int
+	unit         int64
+	absolute     bool
+	amount       int64
+	deadline     int64
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (e *expireCommand) Name() string {
+	return e.name
+}
+
+func (e *expireCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	e.key = tmpObj.Data()
+
+	var err error
+	e.amount, err = parseExpireAmount(objects[1], e.unit)
+
+	return err
+}
+
+func (e *expireCommand) Execute() {
+	if e.accessObject == nil {
+		e.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if e.accessObject.Type() != e.TargetContainerType() {
+		e.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", e.TargetContainerType(), e.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	e.deadline, e.err = resolveDeadline(e.amount, e.absolute)
+	if e.err != nil {
+		return
+	}
+
+	// A deadline in the past just works like DEL, the key will be removed at the next access.
+	if e.accessObject.(container.Containers).SetDeadline(e.key, e.deadline) {
+		e.result = protocol.NewRedisInteger(1)
+	} else {
+		e.result = protocol.NewRedisInteger(0)
+	}
+}
+
+func (e *expireCommand) Result() (protocol.RedisObject, error) {
+	return e.result, e.err
+}
+
+func (e *expireCommand) Rewrite() []protocol.RedisObject {
+	if e.result == nil || e.result.Data() == 0 {
+		return nil
+	}
+
+	return []protocol.RedisObject{
+		protocol.NewBulkRedisString("pexpireat"),
+		protocol.NewBulkRedisString(e.key),
+		protocol.NewBulkRedisString(strconv.FormatInt(e.deadline, 10)),
+	}
+}
+
+func (e *expireCommand) Cluster() int {
+	return e.index
+}
+
+func (e *expireCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (e *expireCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (e *expireCommand) Keys() []string {
+	return []string{e.key}
+}
+
+func (e *expireCommand) ShouldCreate() bool {
+	return false
+}
+
+func (e *expireCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	e.accessObject = objects[0]
+}
+
+func (e *expireCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// ttlCommand is TTL and PTTL
+type ttlCommand struct {
+	name         string
+	key          string
+	index        int
+	unit         int64
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (t *ttlCommand) Name() string {
+	return t.name
+}
+
+func (t *ttlCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	t.key = tmpObj.Data()
+
+	return nil
+}
+
+func (t *ttlCommand) Execute() {
+	if t.accessObject == nil {
+		t.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if t.accessObject.Type() != t.TargetContainerType() {
+		t.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", t.TargetContainerType(), t.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	keyspace := t.accessObject.(container.Containers)
+
+	if !keyspace.Exists(t.key) {
+		t.result = protocol.NewRedisInteger(-2)
+		return
+	}
+
+	deadline, ok := keyspace.Deadline(t.key)
+	if !ok {
+		t.result = protocol.NewRedisInteger(-1)
+		return
+	}
+
+	remain := deadline - util.UnixMilli()
+	if remain < 0 {
+		remain = 0
+	}
+
+	// round to the nearest unit as redis does
+	t.result = protocol.NewRedisInteger((remain + t.unit/2) / t.unit)
+}
+
+func (t *ttlCommand) Result() (protocol.RedisObject, error) {
+	return t.result, t.err
+}
+
+func (t *ttlCommand) Cluster() int {
+	return t.index
+}
+
+func (t *ttlCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (t *ttlCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (t *ttlCommand) Keys() []string {
+	return []string{t.key}
+}
+
+func (t *ttlCommand) ShouldCreate() bool {
+	return false
+}
+
+func (t *ttlCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	t.accessObject = objects[0]
+}
+
+func (t *ttlCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type persistCommand struct {
+	key          string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (p *persistCommand) Name() string {
+	return "persist"
+}
+
+func (p *persistCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	p.key = tmpObj.Data()
+
+	return nil
+}
+
+func (p *persistCommand) Execute() {
+	if p.accessObject == nil {
+		p.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if p.accessObject.Type() != p.TargetContainerType() {
+		p.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", p.TargetContainerType(), p.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	if p.accessObject.(container.Containers).Persist(p.key) {
+		p.result = protocol.NewRedisInteger(1)
+	} else {
+		p.result = protocol.NewRedisInteger(0)
+	}
+}
+
+func (p *persistCommand) Result() (protocol.RedisObject, error) {
+	return p.result, p.err
+}
+
+func (p *persistCommand) Cluster() int {
+	return p.index
+}
+
+func (p *persistCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (p *persistCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (p *persistCommand) Keys() []string {
+	return []string{p.key}
+}
+
+func (p *persistCommand) ShouldCreate() bool {
+	return false
+}
+
+func (p *persistCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	p.accessObject = objects[0]
+}
+
+func (p *persistCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
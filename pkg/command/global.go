@@ -3,6 +3,8 @@ package command
 import (
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/lxdlam/vertex/pkg/util"
 
@@ -99,12 +101,27 @@ func newGlobalCommand(name string, index int, arguments []protocol.RedisObject)
 	return nil, ErrCommandNotExist
 }
 
+const (
+	setAlways = iota
+	setIfNotExist
+	setIfExist
+)
+
+// setCommand is SET with the options EX, PX, EXAT, PXAT, NX, XX, KEEPTTL and GET
 type setCommand struct {
 	key          string
 	index        int
 	accessObject container.ContainerObject
 	arguments    protocol.RedisString
-	result       protocol.RedisString
+	condition    int
+	keepTTL      bool
+	get          bool
+	hasExpire    bool
+	absolute     bool
+	amount       int64
+	deadline     int64
+	set          bool
+	result       protocol.RedisObject
 	err          error
 }
 
@@ -113,7 +130,8 @@ func (s *setCommand) Name() string {
 }
 
 func (s *setCommand) ParseArguments(objects []protocol.RedisObject) error {
-	if len(objects) != 2 {
+	length := len(objects)
+	if length < 2 {
 		return ErrArgumentInvalid
 	}
 
@@ -129,6 +147,59 @@ func (s *setCommand) ParseArguments(objects []protocol.RedisObject) error {
 		return ErrArgumentInvalid
 	}
 
+	for idx := 2; idx < length; idx++ {
+		optionObj, ok := objects[idx].(protocol.RedisString)
+		if !ok {
+			return ErrArgumentInvalid
+		}
+
+		switch option := strings.ToLower(optionObj.Data()); option {
+		case "nx", "xx":
+			if s.condition != setAlways {
+				return ErrSyntax
+			}
+
+			if option == "nx" {
+				s.condition = setIfNotExist
+			} else {
+				s.condition = setIfExist
+			}
+		case "keepttl":
+			if s.hasExpire {
+				return ErrSyntax
+			}
+
+			s.keepTTL = true
+		case "get":
+			s.get = true
+		case "ex", "px", "exat", "pxat":
+			if s.hasExpire || s.keepTTL || idx+1 >= length {
+				return ErrSyntax
+			}
+
+			unit := millisecond
+			if option == "ex" || option == "exat" {
+				unit = second
+			}
+
+			var err error
+			idx++
+			s.amount, err = parseExpireAmount(objects[idx], unit)
+			if err != nil {
+				return err
+			}
+
+			if s.amount <= 0 {
+				return ErrInvalidExpireTime
+			}
+
+			s.hasExpire = true
+			s.absolute = option == "exat" || option == "pxat"
+		default:
+			return ErrSyntax
+		}
+	}
+
 	return nil
 }
 
@@ -139,7 +210,7 @@ func (s *setCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -148,9 +219,52 @@ func (s *setCommand) Execute() {
 		return
 	}
 
-	s.err = s.accessObject.(container.StringMap).Set([]*container.StringContainer{container.NewString(s.key)}, []*container.StringContainer{container.NewString(s.arguments.Data())})
+	if s.hasExpire {
+		s.deadline, s.err = resolveDeadline(s.amount, s.absolute)
+		if s.err != nil {
+			return
+		}
+	}
+
+	keyspace := s.accessObject.(container.Containers)
+	key := container.NewString(s.key)
+
+	var previous protocol.RedisObject = protocol.NewNullBulkRedisString()
+	if obj := keyspace.Get(s.key); obj != nil {
+		if str, ok := obj.(*container.StringContainer); ok {
+			previous = protocol.NewBulkRedisString(str.String())
+		} else if s.get {
+			s.err = fmt.Errorf("set with get on a non-string key. key=%s, err={%w}", s.key, ErrWrongType)
+			return
+		}
+	}
+
+	exist := keyspace.Exists(s.key)
+	if (s.condition == setIfNotExist && exist) || (s.condition == setIfExist && !exist) {
+		if s.get {
+			s.result = previous
+		} else {
+			s.result = protocol.NewNullBulkRedisString()
+		}
+		return
+	}
+
+	s.err = keyspace.Global().Set([]*container.StringContainer{key}, []*container.StringContainer{container.NewString(s.arguments.Data())})
+	if s.err != nil {
+		return
+	}
 
-	if s.err == nil {
+	if s.hasExpire {
+		keyspace.SetDeadline(s.key, s.deadline)
+	} else if !s.keepTTL {
+		keyspace.Persist(s.key)
+	}
+
+	s.set = true
+
+	if s.get {
+		s.result = previous
+	} else {
 		s.result = protocol.NewSimpleRedisString("OK")
 	}
 }
@@ -159,6 +273,28 @@ func (s *setCommand) Result() (protocol.RedisObject, error) {
 	return s.result, s.err
 }
 
+// Rewrite will persist the relative expiration as an absolute deadline, and drop the options which
+// have no effect on the dataset.
+func (s *setCommand) Rewrite() []protocol.RedisObject {
+	if !s.set {
+		return nil
+	}
+
+	ret := []protocol.RedisObject{
+		protocol.NewBulkRedisString("set"),
+		protocol.NewBulkRedisString(s.key),
+		s.arguments,
+	}
+
+	if s.hasExpire {
+		ret = append(ret, protocol.NewBulkRedisString("pxat"), protocol.NewBulkRedisString(strconv.FormatInt(s.deadline, 10)))
+	} else if s.keepTTL {
+		ret = append(ret, protocol.NewBulkRedisString("keepttl"))
+	}
+
+	return ret
+}
+
 func (s *setCommand) Cluster() int {
 	return s.index
 }
@@ -187,7 +323,7 @@ func (s *setCommand) SetAccessObjects(objects []container.ContainerObject) {
 }
 
 func (s *setCommand) TargetContainerType() container.ContainerType {
-	return container.GlobalType
+	return container.KeyspaceType
 }
 
 type getCommand struct {
@@ -224,7 +360,7 @@ func (g *getCommand) Execute() {
 	}
 
 	if g.accessObject.Type() != g.TargetContainerType() {
-		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", g.TargetContainerType(), g.accessObject.Type())
+		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", g.TargetContainerType(), g.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -318,7 +454,7 @@ func (s *msetCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -335,8 +471,15 @@ func (s *msetCommand) Execute() {
 		values = append(values, container.NewString(s.values[idx]))
 	}
 
+	keyspace := s.accessObject.(container.Containers)
+
 	// MSET will produce no error.
-	_ = s.accessObject.(container.StringMap).Set(keys, values)
+	_ = keyspace.Global().Set(keys, values)
+
+	// Like SET, MSET discards the deadlines of the keys
+	for _, key := range s.keys {
+		keyspace.Persist(key)
+	}
 
 	s.err = nil
 	s.result = protocol.NewSimpleRedisString("OK")
@@ -374,7 +517,7 @@ func (s *msetCommand) SetAccessObjects(objects []container.ContainerObject) {
 }
 
 func (s *msetCommand) TargetContainerType() container.ContainerType {
-	return container.GlobalType
+	return container.KeyspaceType
 }
 
 type mgetCommand struct {
@@ -415,7 +558,7 @@ func (g *mgetCommand) Execute() {
 	}
 
 	if g.accessObject.Type() != g.TargetContainerType() {
-		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", g.TargetContainerType(), g.accessObject.Type())
+		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", g.TargetContainerType(), g.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -427,7 +570,8 @@ func (g *mgetCommand) Execute() {
 
 	var values []protocol.RedisObject
 
-	ret := g.accessObject.(container.StringMap).Get(keys)
+	// keys holding other types are reported as nil
+	ret := g.accessObject.(container.Containers).Global().Get(keys)
 	length := len(ret)
 
 	for idx := 0; idx < length; idx++ {
@@ -473,7 +617,7 @@ func (g *mgetCommand) SetAccessObjects(objects []container.ContainerObject) {
 }
 
 func (g *mgetCommand) TargetContainerType() container.ContainerType {
-	return container.GlobalType
+	return container.KeyspaceType
 }
 
 type existsCommand struct {
@@ -514,18 +658,20 @@ func (e *existsCommand) Execute() {
 	}
 
 	if e.accessObject.Type() != e.TargetContainerType() {
-		e.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", e.TargetContainerType(), e.accessObject.Type())
+		e.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", e.TargetContainerType(), e.accessObject.Type(), ErrWrongType)
 		return
 	}
 
-	var keys []*container.StringContainer
+	ret := 0
+	keyspace := e.accessObject.(container.Containers)
 
+	// a key is counted as many times as it is given
 	for _, key := range e.keys {
-		keys = append(keys, container.NewString(key))
+		if keyspace.Exists(key) {
+			ret++
+		}
 	}
 
-	ret := e.accessObject.(container.StringMap).Exists(keys)
-
 	e.result = protocol.NewRedisInteger(int64(ret))
 }
 
@@ -561,7 +707,7 @@ func (e *existsCommand) SetAccessObjects(objects []container.ContainerObject) {
 }
 
 func (e *existsCommand) TargetContainerType() container.ContainerType {
-	return container.GlobalType
+	return container.KeyspaceType
 }
 
 type strlenCommand struct {
@@ -598,7 +744,7 @@ func (s *strlenCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -682,7 +828,7 @@ func (a *appendCommand) Execute() {
 	}
 
 	if a.accessObject.Type() != a.TargetContainerType() {
-		a.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", a.TargetContainerType(), a.accessObject.Type())
+		a.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", a.TargetContainerType(), a.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -691,7 +837,11 @@ func (a *appendCommand) Execute() {
 		return
 	}
 
-	ret := a.accessObject.(container.StringMap).Append(container.NewString(a.key), container.NewString(a.arguments.Data()))
+	ret, err := a.accessObject.(container.StringMap).Append(container.NewString(a.key), container.NewString(a.arguments.Data()))
+	if err != nil {
+		a.err = err
+		return
+	}
 
 	a.result = protocol.NewRedisInteger(int64(ret))
 	a.err = nil
@@ -777,7 +927,7 @@ func (i *incrCommand) Execute() {
 	}
 
 	if i.accessObject.Type() != i.TargetContainerType() {
-		i.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", i.TargetContainerType(), i.accessObject.Type())
+		i.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", i.TargetContainerType(), i.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -884,7 +1034,7 @@ func (d *decrCommand) Execute() {
 	}
 
 	if d.accessObject.Type() != d.TargetContainerType() {
-		d.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", d.TargetContainerType(), d.accessObject.Type())
+		d.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", d.TargetContainerType(), d.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -1006,7 +1156,7 @@ func (g *getRangeCommand) Execute() {
 	}
 
 	if g.accessObject.Type() != g.TargetContainerType() {
-		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", g.TargetContainerType(), g.accessObject.Type())
+		g.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", g.TargetContainerType(), g.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -122,7 +122,7 @@ func (h *hsetCommand) ParseArguments(objects []protocol.RedisObject) error {
 
 func (h *hsetCommand) Execute() {
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -221,7 +221,7 @@ func (h *hgetCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -328,7 +328,7 @@ func (h *hmgetCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -431,7 +431,7 @@ func (h *hexistsCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -516,7 +516,7 @@ func (h *hlenCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -564,7 +564,7 @@ type hgetallCommand struct {
 	key          string
 	index        int
 	accessObject container.ContainerObject
-	result       protocol.RedisArray
+	result       protocol.RedisObject
 	err          error
 }
 
@@ -594,21 +594,26 @@ func (h *hgetallCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
-	var objs []protocol.RedisObject
+	hash := h.accessObject.(container.HashContainer)
 
-	fields, values := h.accessObject.(container.HashContainer).Entries()
-	l := len(fields)
+	// the entries are written straight into the client
+	h.result = protocol.NewRedisStream(hash.WriteEntries, func() protocol.RedisObject {
+		var objs []protocol.RedisObject
 
-	for idx := 0; idx < l; idx++ {
-		objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
-		objs = append(objs, protocol.NewBulkRedisString(values[idx].String()))
-	}
+		fields, values := hash.Entries()
+		l := len(fields)
 
-	h.result = protocol.NewRedisArray(objs)
+		for idx := 0; idx < l; idx++ {
+			objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
+			objs = append(objs, protocol.NewBulkRedisString(values[idx].String()))
+		}
+
+		return protocol.NewRedisMap(objs)
+	})
 }
 
 func (h *hgetallCommand) Result() (protocol.RedisObject, error) {
@@ -680,7 +685,7 @@ func (h *hkeysCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -765,7 +770,7 @@ func (h *hvalsCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -858,7 +863,7 @@ func (h *hstrlenCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -957,7 +962,7 @@ func (h *hdelCommand) Execute() {
 	}
 
 	if h.accessObject.Type() != h.TargetContainerType() {
-		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", h.TargetContainerType(), h.accessObject.Type())
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -0,0 +1,229 @@
+package command
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
+)
+
+// commandInfo describes a command replied by COMMAND. A negative arity means at least -arity arguments
+// including the name. The keys are the arguments from firstKey to lastKey with step, and a negative
+// lastKey counts from the end.
+type commandInfo struct {
+	name     string
+	arity    int
+	flags    []string
+	firstKey int
+	lastKey  int
+	step     int
+}
+
+func (c commandInfo) toRedisObject() protocol.RedisObject {
+	var flags []protocol.RedisObject
+	for _, flag := range c.flags {
+		flags = append(flags, protocol.NewSimpleRedisString(flag))
+	}
+
+	return protocol.NewRedisArray([]protocol.RedisObject{
+		protocol.NewBulkRedisString(c.name),
+		protocol.NewRedisInteger(int64(c.arity)),
+		protocol.NewRedisArray(flags),
+		protocol.NewRedisInteger(int64(c.firstKey)),
+		protocol.NewRedisInteger(int64(c.lastKey)),
+		protocol.NewRedisInteger(int64(c.step)),
+	})
+}
+
+var infoMap map[string]commandInfo = nil
+var infoOnce sync.Once
+
+// commandTable lists every command served by vertex, including the ones handled by the engine directly,
+// e.g., the transaction and the pub/sub commands
+var commandTable = []commandInfo{
+	// Global Commands
+	{"set", -3, []string{"write", "denyoom"}, 1, 1, 1},
+	{"get", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"mset", -3, []string{"write", "denyoom"}, 1, -1, 2},
+	{"mget", -2, []string{"readonly", "fast"}, 1, -1, 1},
+	{"exists", -2, []string{"readonly", "fast"}, 1, -1, 1},
+	{"strlen", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"append", 3, []string{"write", "denyoom"}, 1, 1, 1},
+	{"incr", 2, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"incrby", 3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"decr", 2, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"decrby", 3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"getrange", 4, []string{"readonly"}, 1, 1, 1},
+
+	// Expire Commands
+	{"expire", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"pexpire", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"expireat", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"pexpireat", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"ttl", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"pttl", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"persist", 2, []string{"write", "fast"}, 1, 1, 1},
+
+	// Key Commands
+	{"del", -2, []string{"write"}, 1, -1, 1},
+	{"unlink", -2, []string{"write", "fast"}, 1, -1, 1},
+	{"type", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"keys", 2, []string{"readonly"}, 0, 0, 0},
+	{"rename", 3, []string{"write"}, 1, 2, 1},
+	{"renamenx", 3, []string{"write", "fast"}, 1, 2, 1},
+	{"randomkey", 1, []string{"readonly"}, 0, 0, 0},
+	{"dbsize", 1, []string{"readonly", "fast"}, 0, 0, 0},
+	{"touch", -2, []string{"readonly", "fast"}, 1, -1, 1},
+
+	// Database Commands
+	{"select", 2, []string{"loading", "stale", "fast"}, 0, 0, 0},
+	{"swapdb", 3, []string{"write", "fast"}, 0, 0, 0},
+	{"move", 3, []string{"write", "fast"}, 1, 1, 1},
+	{"flushdb", -1, []string{"write"}, 0, 0, 0},
+	{"flushall", -1, []string{"write"}, 0, 0, 0},
+
+	// Scan Commands
+	{"scan", -2, []string{"readonly"}, 0, 0, 0},
+	{"hscan", -3, []string{"readonly"}, 1, 1, 1},
+	{"sscan", -3, []string{"readonly"}, 1, 1, 1},
+	{"zscan", -3, []string{"readonly"}, 1, 1, 1},
+
+	// Sorted Set Commands
+	{"zadd", -4, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"zincrby", 4, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"zrem", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"zscore", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zmscore", -3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zcard", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zcount", 4, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zrank", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zrevrank", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"zrange", -4, []string{"readonly"}, 1, 1, 1},
+	{"zrangebyscore", -4, []string{"readonly"}, 1, 1, 1},
+	{"zremrangebyrank", 4, []string{"write"}, 1, 1, 1},
+	{"zremrangebyscore", 4, []string{"write"}, 1, 1, 1},
+	{"zremrangebylex", 4, []string{"write"}, 1, 1, 1},
+	{"zpopmin", -2, []string{"write", "fast"}, 1, 1, 1},
+	{"zpopmax", -2, []string{"write", "fast"}, 1, 1, 1},
+	{"zunionstore", -4, []string{"write", "denyoom", "movablekeys"}, 1, 1, 1},
+	{"zinterstore", -4, []string{"write", "denyoom", "movablekeys"}, 1, 1, 1},
+	{"zrandmember", -2, []string{"readonly"}, 1, 1, 1},
+
+	// List Commands
+	{"lpop", -2, []string{"write", "fast"}, 1, 1, 1},
+	{"rpop", -2, []string{"write", "fast"}, 1, 1, 1},
+	{"lindex", 3, []string{"readonly"}, 1, 1, 1},
+	{"linsert", 5, []string{"write", "denyoom"}, 1, 1, 1},
+	{"llen", 2, []string{"readonly", "fast"}, 1, 1, 1},
+	{"lpush", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"rpush", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"lrange", 4, []string{"readonly"}, 1, 1, 1},
+	{"ltrim", 4, []string{"write"}, 1, 1, 1},
+	{"lrem", 4, []string{"write"}, 1, 1, 1},
+	{"lset", 4, []string{"write", "denyoom"}, 1, 1, 1},
+	{"lmove", 5, []string{"write", "denyoom"}, 1, 2, 1},
+	{"rpoplpush", 3, []string{"write", "denyoom"}, 1, 2, 1},
+	{"blpop", -3, []string{"write", "noscript", "blocking"}, 1, -2, 1},
+	{"brpop", -3, []string{"write", "noscript", "blocking"}, 1, -2, 1},
+	{"blmove", 6, []string{"write", "denyoom", "noscript", "blocking"}, 1, 2, 1},
+	{"brpoplpush", 4, []string{"write", "denyoom", "noscript", "blocking"}, 1, 2, 1},
+
+	// Hash Commands
+	{"hset", -4, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"hget", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"hexists", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"hdel", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"hmget", -3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"hkeys", 2, []string{"readonly"}, 1, 1, 1},
+	{"hvals", 2, []string{"readonly"}, 1, 1, 1},
+	{"hgetall", 2, []string{"readonly"}, 1, 1, 1},
+	{"hstrlen", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"hlen", 2, []string{"readonly", "fast"}, 1, 1, 1},
+
+	// Set Commands
+	{"sadd", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
+	{"srem", -3, []string{"write", "fast"}, 1, 1, 1},
+	{"sismember", 3, []string{"readonly", "fast"}, 1, 1, 1},
+	{"smembers", 2, []string{"readonly"}, 1, 1, 1},
+	{"srandmember", -2, []string{"readonly"}, 1, 1, 1},
+	{"spop", -2, []string{"write", "fast"}, 1, 1, 1},
+	{"sdiff", -2, []string{"readonly"}, 1, -1, 1},
+	{"sinter", -2, []string{"readonly"}, 1, -1, 1},
+	{"sunion", -2, []string{"readonly"}, 1, -1, 1},
+	{"scard", 2, []string{"readonly", "fast"}, 1, 1, 1},
+
+	// Transaction Commands
+	{"multi", 1, []string{"noscript", "loading", "stale", "fast"}, 0, 0, 0},
+	{"exec", 1, []string{"noscript", "loading", "stale"}, 0, 0, 0},
+	{"discard", 1, []string{"noscript", "loading", "stale", "fast"}, 0, 0, 0},
+	{"watch", -2, []string{"noscript", "loading", "stale", "fast"}, 1, -1, 1},
+	{"unwatch", 1, []string{"noscript", "loading", "stale", "fast"}, 0, 0, 0},
+
+	// Pub/Sub Commands
+	{"subscribe", -2, []string{"pubsub", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"unsubscribe", -1, []string{"pubsub", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"psubscribe", -2, []string{"pubsub", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"punsubscribe", -1, []string{"pubsub", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"publish", 3, []string{"pubsub", "loading", "stale", "fast"}, 0, 0, 0},
+	{"pubsub", -2, []string{"pubsub", "random", "loading", "stale"}, 0, 0, 0},
+
+	// Connection Commands
+	{"ping", -1, []string{"stale", "fast"}, 0, 0, 0},
+	{"echo", 2, []string{"fast"}, 0, 0, 0},
+	{"quit", -1, []string{"loading", "stale", "fast"}, 0, 0, 0},
+	{"hello", -1, []string{"noscript", "loading", "stale", "fast"}, 0, 0, 0},
+	{"client", -2, []string{"admin", "noscript", "random", "loading", "stale"}, 0, 0, 0},
+	{"command", -1, []string{"random", "loading", "stale"}, 0, 0, 0},
+
+	// Server Commands
+	{"info", -1, []string{"random", "loading", "stale"}, 0, 0, 0},
+	{"bgrewriteaof", 1, []string{"admin", "noscript"}, 0, 0, 0},
+	{"save", 1, []string{"admin", "noscript"}, 0, 0, 0},
+	{"bgsave", -1, []string{"admin", "noscript"}, 0, 0, 0},
+	{"lastsave", 1, []string{"random", "loading", "stale", "fast"}, 0, 0, 0},
+	{"replicaof", 3, []string{"admin", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"slaveof", 3, []string{"admin", "noscript", "loading", "stale"}, 0, 0, 0},
+	{"role", 1, []string{"noscript", "loading", "stale", "fast"}, 0, 0, 0},
+	{"wait", 3, []string{"noscript"}, 0, 0, 0},
+}
+
+func lookupInfo(name string) (commandInfo, bool) {
+	infoOnce.Do(func() {
+		infoMap = make(map[string]commandInfo)
+		for _, info := range commandTable {
+			infoMap[info.name] = info
+		}
+	})
+
+	info, ok := infoMap[strings.ToLower(name)]
+	return info, ok
+}
+
+// AllowedWhileLoading reports if the command is flagged as loading, which is served while the dataset is
+// being loaded
+func AllowedWhileLoading(name string) bool {
+	info, ok := lookupInfo(name)
+	if !ok {
+		return false
+	}
+
+	for _, flag := range info.flags {
+		if flag == "loading" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// commandNames returns the names of all commands in order
+func commandNames() []string {
+	var names []string
+	for _, info := range commandTable {
+		names = append(names, info.name)
+	}
+
+	sort.Strings(names)
+	return names
+}
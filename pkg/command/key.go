@@ -0,0 +1,670 @@
+package command
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+)
+
+func newKeyCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "del", "unlink":
+		d := &delCommand{
+			name:  name,
+			index: index,
+		}
+		err := d.ParseArguments(arguments)
+		return d, err
+	case "type":
+		t := &typeCommand{
+			index: index,
+		}
+		err := t.ParseArguments(arguments)
+		return t, err
+	case "keys":
+		k := &keysCommand{
+			index: index,
+		}
+		err := k.ParseArguments(arguments)
+		return k, err
+	case "rename":
+		r := &renameCommand{
+			name:  name,
+			index: index,
+		}
+		err := r.ParseArguments(arguments)
+		return r, err
+	case "renamenx":
+		r := &renameCommand{
+			name:  name,
+			index: index,
+			nx:    true,
+		}
+		err := r.ParseArguments(arguments)
+		return r, err
+	case "randomkey":
+		r := &randomKeyCommand{
+			index: index,
+		}
+		err := r.ParseArguments(arguments)
+		return r, err
+	case "dbsize":
+		d := &dbSizeCommand{
+			index: index,
+		}
+		err := d.ParseArguments(arguments)
+		return d, err
+	case "touch":
+		t := &touchCommand{
+			index: index,
+		}
+		err := t.ParseArguments(arguments)
+		return t, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// parseKeys parses all objects as keys, at least one key is required
+func parseKeys(objects []protocol.RedisObject) ([]string, error) {
+	if len(objects) == 0 {
+		return nil, ErrArgumentInvalid
+	}
+
+	var keys []string
+
+	for _, obj := range objects {
+		tmpObj, ok := obj.(protocol.RedisString)
+		if !ok {
+			return nil, ErrArgumentInvalid
+		}
+
+		keys = append(keys, tmpObj.Data())
+	}
+
+	return keys, nil
+}
+
+// delCommand is DEL and UNLINK, there is no difference between them since the memory is released by GC.
+type delCommand struct {
+	name         string
+	keys         []string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (d *delCommand) Name() string {
+	return d.name
+}
+
+func (d *delCommand) ParseArguments(objects []protocol.RedisObject) error {
+	var err error
+	d.keys, err = parseKeys(objects)
+	return err
+}
+
+func (d *delCommand) Execute() {
+	if d.accessObject == nil {
+		d.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if d.accessObject.Type() != d.TargetContainerType() {
+		d.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", d.TargetContainerType(), d.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	removed := 0
+	keyspace := d.accessObject.(container.Containers)
+
+	for _, key := range d.keys {
+		if keyspace.Remove(key) {
+			removed++
+		}
+	}
+
+	d.result = protocol.NewRedisInteger(int64(removed))
+}
+
+func (d *delCommand) Result() (protocol.RedisObject, error) {
+	return d.result, d.err
+}
+
+func (d *delCommand) Cluster() int {
+	return d.index
+}
+
+func (d *delCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (d *delCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (d *delCommand) Keys() []string {
+	return d.keys
+}
+
+func (d *delCommand) ShouldCreate() bool {
+	return false
+}
+
+func (d *delCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	d.accessObject = objects[0]
+}
+
+func (d *delCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type typeCommand struct {
+	key          string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisString
+	err          error
+}
+
+func (t *typeCommand) Name() string {
+	return "type"
+}
+
+func (t *typeCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	t.key = tmpObj.Data()
+
+	return nil
+}
+
+func (t *typeCommand) Execute() {
+	if t.accessObject == nil {
+		t.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if t.accessObject.Type() != t.TargetContainerType() {
+		t.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", t.TargetContainerType(), t.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	obj := t.accessObject.(container.Containers).Get(t.key)
+	if obj == nil {
+		t.result = protocol.NewSimpleRedisString("none")
+	} else {
+		t.result = protocol.NewSimpleRedisString(container.TypeName(obj.Type()))
+	}
+}
+
+func (t *typeCommand) Result() (protocol.RedisObject, error) {
+	return t.result, t.err
+}
+
+func (t *typeCommand) Cluster() int {
+	return t.index
+}
+
+func (t *typeCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (t *typeCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (t *typeCommand) Keys() []string {
+	return []string{t.key}
+}
+
+func (t *typeCommand) ShouldCreate() bool {
+	return false
+}
+
+func (t *typeCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	t.accessObject = objects[0]
+}
+
+func (t *typeCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type keysCommand struct {
+	pattern      string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (k *keysCommand) Name() string {
+	return "keys"
+}
+
+func (k *keysCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	k.pattern = tmpObj.Data()
+
+	return nil
+}
+
+func (k *keysCommand) Execute() {
+	if k.accessObject == nil {
+		k.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if k.accessObject.Type() != k.TargetContainerType() {
+		k.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", k.TargetContainerType(), k.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var objs []protocol.RedisObject
+
+	for _, key := range k.accessObject.(container.Containers).Keys(k.pattern) {
+		objs = append(objs, protocol.NewBulkRedisString(key))
+	}
+
+	k.result = protocol.NewRedisArray(objs)
+}
+
+func (k *keysCommand) Result() (protocol.RedisObject, error) {
+	return k.result, k.err
+}
+
+func (k *keysCommand) Cluster() int {
+	return k.index
+}
+
+func (k *keysCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (k *keysCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+// Keys returns nothing since the pattern is not a key
+func (k *keysCommand) Keys() []string {
+	return nil
+}
+
+func (k *keysCommand) ShouldCreate() bool {
+	return false
+}
+
+func (k *keysCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	k.accessObject = objects[0]
+}
+
+func (k *keysCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// renameCommand is RENAME and RENAMENX
+type renameCommand struct {
+	name         string
+	key          string
+	newKey       string
+	nx           bool
+	renamed      bool
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (r *renameCommand) Name() string {
+	return r.name
+}
+
+func (r *renameCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	r.key = tmpObj.Data()
+
+	tmpObj, ok = objects[1].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	r.newKey = tmpObj.Data()
+
+	return nil
+}
+
+func (r *renameCommand) Execute() {
+	if r.accessObject == nil {
+		r.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if r.accessObject.Type() != r.TargetContainerType() {
+		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", r.TargetContainerType(), r.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	keyspace := r.accessObject.(container.Containers)
+
+	if !keyspace.Exists(r.key) {
+		r.err = ErrNoSuchKey
+		return
+	}
+
+	if r.nx && keyspace.Exists(r.newKey) {
+		r.result = protocol.NewRedisInteger(0)
+		return
+	}
+
+	if err := keyspace.Rename(r.key, r.newKey); err != nil {
+		if errors.Is(err, container.ErrKeyNotFound) {
+			err = ErrNoSuchKey
+		}
+
+		r.err = err
+		return
+	}
+
+	r.renamed = true
+	if r.nx {
+		r.result = protocol.NewRedisInteger(1)
+	} else {
+		r.result = protocol.NewSimpleRedisString("OK")
+	}
+}
+
+func (r *renameCommand) Result() (protocol.RedisObject, error) {
+	return r.result, r.err
+}
+
+// ReadyKeys wakes the clients blocked on the new key
+func (r *renameCommand) ReadyKeys() []string {
+	if !r.renamed {
+		return nil
+	}
+
+	return []string{r.newKey}
+}
+
+func (r *renameCommand) Cluster() int {
+	return r.index
+}
+
+func (r *renameCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (r *renameCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (r *renameCommand) Keys() []string {
+	return []string{r.key, r.newKey}
+}
+
+func (r *renameCommand) ShouldCreate() bool {
+	return false
+}
+
+func (r *renameCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	r.accessObject = objects[0]
+}
+
+func (r *renameCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type randomKeyCommand struct {
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisString
+	err          error
+}
+
+func (r *randomKeyCommand) Name() string {
+	return "randomkey"
+}
+
+func (r *randomKeyCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 0 {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (r *randomKeyCommand) Execute() {
+	if r.accessObject == nil {
+		r.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if r.accessObject.Type() != r.TargetContainerType() {
+		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", r.TargetContainerType(), r.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	if key, ok := r.accessObject.(container.Containers).RandomKey(); ok {
+		r.result = protocol.NewBulkRedisString(key)
+	} else {
+		r.result = protocol.NewNullBulkRedisString()
+	}
+}
+
+func (r *randomKeyCommand) Result() (protocol.RedisObject, error) {
+	return r.result, r.err
+}
+
+func (r *randomKeyCommand) Cluster() int {
+	return r.index
+}
+
+func (r *randomKeyCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (r *randomKeyCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (r *randomKeyCommand) Keys() []string {
+	return nil
+}
+
+func (r *randomKeyCommand) ShouldCreate() bool {
+	return false
+}
+
+func (r *randomKeyCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	r.accessObject = objects[0]
+}
+
+func (r *randomKeyCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type dbSizeCommand struct {
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (d *dbSizeCommand) Name() string {
+	return "dbsize"
+}
+
+func (d *dbSizeCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 0 {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (d *dbSizeCommand) Execute() {
+	if d.accessObject == nil {
+		d.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if d.accessObject.Type() != d.TargetContainerType() {
+		d.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", d.TargetContainerType(), d.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	d.result = protocol.NewRedisInteger(int64(d.accessObject.(container.Containers).Len()))
+}
+
+func (d *dbSizeCommand) Result() (protocol.RedisObject, error) {
+	return d.result, d.err
+}
+
+func (d *dbSizeCommand) Cluster() int {
+	return d.index
+}
+
+func (d *dbSizeCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (d *dbSizeCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (d *dbSizeCommand) Keys() []string {
+	return nil
+}
+
+func (d *dbSizeCommand) ShouldCreate() bool {
+	return false
+}
+
+func (d *dbSizeCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	d.accessObject = objects[0]
+}
+
+func (d *dbSizeCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// touchCommand only counts the existing keys, since no access time is tracked
+type touchCommand struct {
+	keys         []string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (t *touchCommand) Name() string {
+	return "touch"
+}
+
+func (t *touchCommand) ParseArguments(objects []protocol.RedisObject) error {
+	var err error
+	t.keys, err = parseKeys(objects)
+	return err
+}
+
+func (t *touchCommand) Execute() {
+	if t.accessObject == nil {
+		t.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if t.accessObject.Type() != t.TargetContainerType() {
+		t.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", t.TargetContainerType(), t.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	count := 0
+	keyspace := t.accessObject.(container.Containers)
+
+	for _, key := range t.keys {
+		if keyspace.Exists(key) {
+			count++
+		}
+	}
+
+	t.result = protocol.NewRedisInteger(int64(count))
+}
+
+func (t *touchCommand) Result() (protocol.RedisObject, error) {
+	return t.result, t.err
+}
+
+func (t *touchCommand) Cluster() int {
+	return t.index
+}
+
+func (t *touchCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (t *touchCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (t *touchCommand) Keys() []string {
+	return t.keys
+}
+
+func (t *touchCommand) ShouldCreate() bool {
+	return false
+}
+
+func (t *touchCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	t.accessObject = objects[0]
+}
+
+func (t *touchCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
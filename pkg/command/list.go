@@ -3,7 +3,10 @@ package command
 import (
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/lxdlam/vertex/pkg/container"
 	"github.com/lxdlam/vertex/pkg/protocol"
@@ -78,6 +81,22 @@ func newListCommand(name string, index int, arguments []protocol.RedisObject) (C
 		}
 		err := l.ParseArguments(arguments)
 		return l, err
+	case "blpop", "brpop":
+		b := &blockingPopCommand{
+			name:  name,
+			index: index,
+			tail:  name == "brpop",
+		}
+		err := b.ParseArguments(arguments)
+		return b, err
+	case "lmove", "blmove", "rpoplpush", "brpoplpush":
+		l := &lmoveCommand{
+			name:     name,
+			index:    index,
+			blocking: strings.HasPrefix(name, "b"),
+		}
+		err := l.ParseArguments(arguments)
+		return l, err
 	}
 
 	return nil, ErrCommandNotExist
@@ -117,7 +136,7 @@ func (l *lpopCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -199,7 +218,7 @@ func (r *rpopCommand) Execute() {
 	}
 
 	if r.accessObject.Type() != r.TargetContainerType() {
-		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", r.TargetContainerType(), r.accessObject.Type())
+		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", r.TargetContainerType(), r.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -292,7 +311,7 @@ func (l *lpushCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -328,6 +347,15 @@ func (l *lpushCommand) Keys() []string {
 	return []string{l.key}
 }
 
+// ReadyKeys wakes the clients blocked on the list
+func (l *lpushCommand) ReadyKeys() []string {
+	if l.err != nil {
+		return nil
+	}
+
+	return []string{l.key}
+}
+
 func (l *lpushCommand) ShouldCreate() bool {
 	return true
 }
@@ -388,7 +416,7 @@ func (r *rpushCommand) Execute() {
 	}
 
 	if r.accessObject.Type() != r.TargetContainerType() {
-		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", r.TargetContainerType(), r.accessObject.Type())
+		r.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", r.TargetContainerType(), r.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -424,6 +452,15 @@ func (r *rpushCommand) Keys() []string {
 	return []string{r.key}
 }
 
+// ReadyKeys wakes the clients blocked on the list
+func (r *rpushCommand) ReadyKeys() []string {
+	if r.err != nil {
+		return nil
+	}
+
+	return []string{r.key}
+}
+
 func (r *rpushCommand) ShouldCreate() bool {
 	return true
 }
@@ -445,7 +482,7 @@ type lrangeCommand struct {
 	start        int
 	end          int
 	accessObject container.ContainerObject
-	result       protocol.RedisArray
+	result       protocol.RedisObject
 	err          error
 }
 
@@ -499,23 +536,34 @@ func (l *lrangeCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
-	ret, err := l.accessObject.(container.ListContainer).Range(l.start, l.end)
+	list := l.accessObject.(container.ListContainer)
+
+	// the elements are written straight into the client
+	l.result = protocol.NewRedisStream(func(w protocol.RESPWriter) error {
+		err := list.WriteRange(w, l.start, l.end)
+		if errors.Is(err, container.ErrOutOfRange) {
+			return w.WriteNullArray()
+		}
+
+		return err
+	}, func() protocol.RedisObject {
+		ret, err := list.Range(l.start, l.end)
+		if err != nil {
+			return protocol.NewNullRedisArray()
+		}
 
-	if err != nil {
-		l.result = protocol.NewNullRedisArray()
-	} else {
 		var results []protocol.RedisObject
 
 		for _, item := range ret {
 			results = append(results, protocol.NewBulkRedisString(item.String()))
 		}
 
-		l.result = protocol.NewRedisArray(results)
-	}
+		return protocol.NewRedisArray(results)
+	})
 }
 
 func (l *lrangeCommand) Result() (protocol.RedisObject, error) {
@@ -600,7 +648,7 @@ func (l *lindexCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -682,7 +730,7 @@ func (l *llenCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -779,7 +827,7 @@ func (l *lsetCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -880,7 +928,7 @@ func (l *lremCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -983,7 +1031,7 @@ func (l *ltrimCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -1093,7 +1141,7 @@ func (l *linsertCommand) Execute() {
 	}
 
 	if l.accessObject.Type() != l.TargetContainerType() {
-		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d", l.TargetContainerType(), l.accessObject.Type())
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -1126,6 +1174,15 @@ func (l *linsertCommand) Keys() []string {
 	return []string{l.key}
 }
 
+// ReadyKeys wakes the clients blocked on the list
+func (l *linsertCommand) ReadyKeys() []string {
+	if l.err != nil {
+		return nil
+	}
+
+	return []string{l.key}
+}
+
 func (l *linsertCommand) ShouldCreate() bool {
 	return false
 }
@@ -1140,3 +1197,380 @@ func (l *linsertCommand) SetAccessObjects(objects []container.ContainerObject) {
 func (l *linsertCommand) TargetContainerType() container.ContainerType {
 	return container.LinkedListType
 }
+
+// parseTimeout parses the timeout of the blocking commands in seconds, 0 means blocking forever
+func parseTimeout(obj protocol.RedisObject) (time.Duration, error) {
+	tmpObj, ok := obj.(protocol.RedisString)
+	if !ok {
+		return 0, ErrArgumentInvalid
+	}
+
+	seconds, err := strconv.ParseFloat(tmpObj.Data(), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		return 0, ErrInvalidTimeout
+	}
+
+	if seconds < 0 {
+		return 0, ErrNegativeTimeout
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// parseDirection parses LEFT or RIGHT of LMOVE, true if it is LEFT
+func parseDirection(obj protocol.RedisObject) (bool, error) {
+	tmpObj, ok := obj.(protocol.RedisString)
+	if !ok {
+		return false, ErrArgumentInvalid
+	}
+
+	switch strings.ToLower(tmpObj.Data()) {
+	case "left":
+		return true, nil
+	case "right":
+		return false, nil
+	}
+
+	return false, ErrSyntax
+}
+
+func formatDirection(left bool) string {
+	if left {
+		return "LEFT"
+	}
+
+	return "RIGHT"
+}
+
+// blockingPopCommand is BLPOP and BRPOP, the first non-empty list of the keys is popped
+type blockingPopCommand struct {
+	name          string
+	keys          []string
+	index         int
+	tail          bool
+	timeout       time.Duration
+	blocked       bool
+	served        bool
+	popped        string
+	accessObjects []container.ContainerObject
+	result        protocol.RedisArray
+	err           error
+}
+
+func (b *blockingPopCommand) Name() string {
+	return b.name
+}
+
+func (b *blockingPopCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 {
+		return ErrArgumentInvalid
+	}
+
+	for _, obj := range objects[:len(objects)-1] {
+		tmpObj, ok := obj.(protocol.RedisString)
+		if !ok {
+			return ErrArgumentInvalid
+		}
+
+		b.keys = append(b.keys, tmpObj.Data())
+	}
+
+	var err error
+	b.timeout, err = parseTimeout(objects[len(objects)-1])
+
+	return err
+}
+
+func (b *blockingPopCommand) Execute() {
+	for idx, obj := range b.accessObjects {
+		if obj == nil {
+			continue
+		}
+
+		if obj.Type() != b.TargetContainerType() {
+			b.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", b.TargetContainerType(), obj.Type(), ErrWrongType)
+			return
+		}
+
+		var ret *container.StringContainer
+		var err error
+
+		if b.tail {
+			ret, err = obj.(container.ListContainer).PopTail()
+		} else {
+			ret, err = obj.(container.ListContainer).PopHead()
+		}
+
+		if err == nil {
+			b.served = true
+			b.popped = b.keys[idx]
+			b.result = protocol.NewRedisArray([]protocol.RedisObject{
+				protocol.NewBulkRedisString(b.popped),
+				protocol.NewBulkRedisString(ret.String()),
+			})
+			return
+		}
+	}
+
+	b.blocked = true
+	b.result = protocol.NewNullRedisArray()
+}
+
+func (b *blockingPopCommand) Result() (protocol.RedisObject, error) {
+	return b.result, b.err
+}
+
+// Rewrite logs the pop of the served list only
+func (b *blockingPopCommand) Rewrite() []protocol.RedisObject {
+	if !b.served {
+		return nil
+	}
+
+	name := "lpop"
+	if b.tail {
+		name = "rpop"
+	}
+
+	return []protocol.RedisObject{
+		protocol.NewBulkRedisString(name),
+		protocol.NewBulkRedisString(b.popped),
+	}
+}
+
+func (b *blockingPopCommand) Blocked() bool {
+	return b.blocked
+}
+
+func (b *blockingPopCommand) BlockingKeys() []string {
+	return b.keys
+}
+
+func (b *blockingPopCommand) Timeout() time.Duration {
+	return b.timeout
+}
+
+func (b *blockingPopCommand) Cluster() int {
+	return b.index
+}
+
+func (b *blockingPopCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (b *blockingPopCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (b *blockingPopCommand) Keys() []string {
+	return b.keys
+}
+
+func (b *blockingPopCommand) ShouldCreate() bool {
+	return false
+}
+
+func (b *blockingPopCommand) SetAccessObjects(objects []container.ContainerObject) {
+	b.accessObjects = objects
+}
+
+func (b *blockingPopCommand) TargetContainerType() container.ContainerType {
+	return container.LinkedListType
+}
+
+// lmoveCommand is LMOVE, RPOPLPUSH and their blocking versions BLMOVE and BRPOPLPUSH. It works on the
+// keyspace since the destination is created if it is not exist.
+type lmoveCommand struct {
+	name         string
+	source       string
+	destination  string
+	index        int
+	fromLeft     bool
+	toLeft       bool
+	blocking     bool
+	timeout      time.Duration
+	blocked      bool
+	moved        bool
+	accessObject container.ContainerObject
+	result       protocol.RedisString
+	err          error
+}
+
+func (l *lmoveCommand) Name() string {
+	return l.name
+}
+
+func (l *lmoveCommand) ParseArguments(objects []protocol.RedisObject) error {
+	// RPOPLPUSH source destination, LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+	expected := 2
+	if strings.HasSuffix(l.name, "lmove") {
+		expected = 4
+	}
+
+	if l.blocking {
+		expected++
+	}
+
+	if len(objects) != expected {
+		return ErrArgumentInvalid
+	}
+
+	sourceObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	destinationObj, ok := objects[1].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	l.source = sourceObj.Data()
+	l.destination = destinationObj.Data()
+	l.fromLeft, l.toLeft = false, true
+
+	var err error
+	if expected >= 4 {
+		if l.fromLeft, err = parseDirection(objects[2]); err != nil {
+			return err
+		}
+
+		if l.toLeft, err = parseDirection(objects[3]); err != nil {
+			return err
+		}
+	}
+
+	if l.blocking {
+		l.timeout, err = parseTimeout(objects[len(objects)-1])
+	}
+
+	return err
+}
+
+func (l *lmoveCommand) Execute() {
+	if l.accessObject == nil {
+		l.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if l.accessObject.Type() != l.TargetContainerType() {
+		l.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", l.TargetContainerType(), l.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	keyspace := l.accessObject.(container.Containers)
+	l.result = protocol.NewNullBulkRedisString()
+
+	source := keyspace.Get(l.source)
+	if source == nil {
+		l.blocked = l.blocking
+		return
+	}
+
+	// both types are checked before anything is moved
+	for _, obj := range []container.ContainerObject{source, keyspace.Get(l.destination)} {
+		if obj != nil && obj.Type() != container.LinkedListType {
+			l.err = fmt.Errorf("lmove type mismatch. source=%s, destination=%s, got=%d, err={%w}", l.source, l.destination, obj.Type(), ErrWrongType)
+			return
+		}
+	}
+
+	var ret *container.StringContainer
+	var err error
+
+	if l.fromLeft {
+		ret, err = source.(container.ListContainer).PopHead()
+	} else {
+		ret, err = source.(container.ListContainer).PopTail()
+	}
+
+	if err != nil {
+		l.blocked = l.blocking
+		return
+	}
+
+	destination := keyspace.GetOrCreateList(l.destination)
+	if l.toLeft {
+		_, err = destination.PushHead([]*container.StringContainer{ret})
+	} else {
+		_, err = destination.PushTail([]*container.StringContainer{ret})
+	}
+
+	if err != nil {
+		l.err = fmt.Errorf("lmove push failed. destination=%s, err={%w}", l.destination, err)
+		return
+	}
+
+	l.moved = true
+	l.result = protocol.NewBulkRedisString(ret.String())
+}
+
+func (l *lmoveCommand) Result() (protocol.RedisObject, error) {
+	return l.result, l.err
+}
+
+// Rewrite logs every move as LMOVE, nothing is logged if nothing is moved
+func (l *lmoveCommand) Rewrite() []protocol.RedisObject {
+	if !l.moved {
+		return nil
+	}
+
+	return []protocol.RedisObject{
+		protocol.NewBulkRedisString("lmove"),
+		protocol.NewBulkRedisString(l.source),
+		protocol.NewBulkRedisString(l.destination),
+		protocol.NewBulkRedisString(formatDirection(l.fromLeft)),
+		protocol.NewBulkRedisString(formatDirection(l.toLeft)),
+	}
+}
+
+// ReadyKeys wakes the clients blocked on the destination
+func (l *lmoveCommand) ReadyKeys() []string {
+	if !l.moved {
+		return nil
+	}
+
+	return []string{l.destination}
+}
+
+func (l *lmoveCommand) Blocked() bool {
+	return l.blocked
+}
+
+func (l *lmoveCommand) BlockingKeys() []string {
+	return []string{l.source}
+}
+
+func (l *lmoveCommand) Timeout() time.Duration {
+	return l.timeout
+}
+
+func (l *lmoveCommand) Cluster() int {
+	return l.index
+}
+
+func (l *lmoveCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (l *lmoveCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (l *lmoveCommand) Keys() []string {
+	return []string{l.source, l.destination}
+}
+
+func (l *lmoveCommand) ShouldCreate() bool {
+	return false
+}
+
+func (l *lmoveCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	l.accessObject = objects[0]
+}
+
+func (l *lmoveCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
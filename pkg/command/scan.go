@@ -0,0 +1,486 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+// defaultScanCount is the count hint used if COUNT is not given
+const defaultScanCount = 10
+
+func newScanCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "scan":
+		s := &scanCommand{
+			index: index,
+		}
+		err := s.ParseArguments(arguments)
+		return s, err
+	case "hscan":
+		h := &hscanCommand{
+			index: index,
+		}
+		err := h.ParseArguments(arguments)
+		return h, err
+	case "sscan":
+		s := &sscanCommand{
+			index: index,
+		}
+		err := s.ParseArguments(arguments)
+		return s, err
+	case "zscan":
+		z := &zscanCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// scanOptions is the options shared by all scan commands, the type filter is only available in SCAN
+type scanOptions struct {
+	cursor   uint64
+	pattern  string
+	count    int
+	typeName string
+}
+
+// parseScanOptions parses `cursor [MATCH pattern] [COUNT count] [TYPE type]`
+func parseScanOptions(objects []protocol.RedisObject, allowType bool) (*scanOptions, error) {
+	if len(objects) == 0 {
+		return nil, ErrArgumentInvalid
+	}
+
+	var strs []string
+
+	for _, obj := range objects {
+		tmpObj, ok := obj.(protocol.RedisString)
+		if !ok {
+			return nil, ErrArgumentInvalid
+		}
+
+		strs = append(strs, tmpObj.Data())
+	}
+
+	cursor, err := strconv.ParseUint(strs[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	options := &scanOptions{
+		cursor:  cursor,
+		pattern: "*",
+		count:   defaultScanCount,
+	}
+
+	for idx := 1; idx < len(strs); idx += 2 {
+		if idx+1 >= len(strs) {
+			return nil, ErrSyntax
+		}
+
+		switch strings.ToLower(strs[idx]) {
+		case "match":
+			options.pattern = strs[idx+1]
+		case "count":
+			count, err := strconv.Atoi(strs[idx+1])
+			if err != nil {
+				return nil, ErrArgumentInvalid
+			}
+
+			if count < 1 {
+				return nil, ErrSyntax
+			}
+
+			options.count = count
+		case "type":
+			if !allowType {
+				return nil, ErrSyntax
+			}
+
+			options.typeName = strings.ToLower(strs[idx+1])
+		default:
+			return nil, ErrSyntax
+		}
+	}
+
+	return options, nil
+}
+
+// newScanReply builds the reply of the scan commands, which is the next cursor and the elements
+func newScanReply(cursor uint64, objs []protocol.RedisObject) protocol.RedisArray {
+	return protocol.NewRedisArray([]protocol.RedisObject{
+		protocol.NewBulkRedisString(strconv.FormatUint(cursor, 10)),
+		protocol.NewRedisArray(objs),
+	})
+}
+
+type scanCommand struct {
+	options      *scanOptions
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (s *scanCommand) Name() string {
+	return "scan"
+}
+
+func (s *scanCommand) ParseArguments(objects []protocol.RedisObject) error {
+	var err error
+	s.options, err = parseScanOptions(objects, true)
+
+	return err
+}
+
+func (s *scanCommand) Execute() {
+	if s.accessObject == nil {
+		s.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if s.accessObject.Type() != s.TargetContainerType() {
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	keyspace := s.accessObject.(container.Containers)
+	keys, cursor := keyspace.Scan(s.options.cursor, s.options.count)
+
+	var objs []protocol.RedisObject
+
+	for _, key := range keys {
+		if !util.GlobMatch(s.options.pattern, key) {
+			continue
+		}
+
+		if s.options.typeName != "" && container.TypeName(keyspace.Get(key).Type()) != s.options.typeName {
+			continue
+		}
+
+		objs = append(objs, protocol.NewBulkRedisString(key))
+	}
+
+	s.result = newScanReply(cursor, objs)
+}
+
+func (s *scanCommand) Result() (protocol.RedisObject, error) {
+	return s.result, s.err
+}
+
+func (s *scanCommand) Cluster() int {
+	return s.index
+}
+
+func (s *scanCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (s *scanCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+// Keys returns nothing since SCAN walks the whole keyspace
+func (s *scanCommand) Keys() []string {
+	return nil
+}
+
+func (s *scanCommand) ShouldCreate() bool {
+	return false
+}
+
+func (s *scanCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	s.accessObject = objects[0]
+}
+
+func (s *scanCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+type hscanCommand struct {
+	key          string
+	options      *scanOptions
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (h *hscanCommand) Name() string {
+	return "hscan"
+}
+
+func (h *hscanCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	h.key = tmpObj.Data()
+
+	var err error
+	h.options, err = parseScanOptions(objects[1:], false)
+
+	return err
+}
+
+func (h *hscanCommand) Execute() {
+	if h.accessObject == nil {
+		h.result = newScanReply(0, nil)
+		return
+	}
+
+	if h.accessObject.Type() != h.TargetContainerType() {
+		h.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", h.TargetContainerType(), h.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var objs []protocol.RedisObject
+
+	fields, values, cursor := h.accessObject.(container.HashContainer).Scan(h.options.cursor, h.options.count)
+	l := len(fields)
+
+	for idx := 0; idx < l; idx++ {
+		if !util.GlobMatch(h.options.pattern, fields[idx].String()) {
+			continue
+		}
+
+		objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
+		objs = append(objs, protocol.NewBulkRedisString(values[idx].String()))
+	}
+
+	h.result = newScanReply(cursor, objs)
+}
+
+func (h *hscanCommand) Result() (protocol.RedisObject, error) {
+	return h.result, h.err
+}
+
+func (h *hscanCommand) Cluster() int {
+	return h.index
+}
+
+func (h *hscanCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (h *hscanCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (h *hscanCommand) Keys() []string {
+	return []string{h.key}
+}
+
+func (h *hscanCommand) ShouldCreate() bool {
+	return false
+}
+
+func (h *hscanCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	h.accessObject = objects[0]
+}
+
+func (h *hscanCommand) TargetContainerType() container.ContainerType {
+	return container.HashType
+}
+
+type sscanCommand struct {
+	key          string
+	options      *scanOptions
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (s *sscanCommand) Name() string {
+	return "sscan"
+}
+
+func (s *sscanCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	s.key = tmpObj.Data()
+
+	var err error
+	s.options, err = parseScanOptions(objects[1:], false)
+
+	return err
+}
+
+func (s *sscanCommand) Execute() {
+	if s.accessObject == nil {
+		s.result = newScanReply(0, nil)
+		return
+	}
+
+	if s.accessObject.Type() != s.TargetContainerType() {
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var objs []protocol.RedisObject
+
+	members, cursor := s.accessObject.(container.SetContainer).Scan(s.options.cursor, s.options.count)
+
+	for _, member := range members {
+		if util.GlobMatch(s.options.pattern, member.String()) {
+			objs = append(objs, protocol.NewBulkRedisString(member.String()))
+		}
+	}
+
+	s.result = newScanReply(cursor, objs)
+}
+
+func (s *sscanCommand) Result() (protocol.RedisObject, error) {
+	return s.result, s.err
+}
+
+func (s *sscanCommand) Cluster() int {
+	return s.index
+}
+
+func (s *sscanCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (s *sscanCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (s *sscanCommand) Keys() []string {
+	return []string{s.key}
+}
+
+func (s *sscanCommand) ShouldCreate() bool {
+	return false
+}
+
+func (s *sscanCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	s.accessObject = objects[0]
+}
+
+func (s *sscanCommand) TargetContainerType() container.ContainerType {
+	return container.SetType
+}
+
+type zscanCommand struct {
+	key          string
+	options      *scanOptions
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (z *zscanCommand) Name() string {
+	return "zscan"
+}
+
+func (z *zscanCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	z.key = tmpObj.Data()
+
+	var err error
+	z.options, err = parseScanOptions(objects[1:], false)
+
+	return err
+}
+
+func (z *zscanCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = newScanReply(0, nil)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var objs []protocol.RedisObject
+
+	entries, scores, cursor := z.accessObject.(container.SortedSetContainer).Scan(z.options.cursor, z.options.count)
+	l := len(entries)
+
+	for idx := 0; idx < l; idx++ {
+		if !util.GlobMatch(z.options.pattern, entries[idx].String()) {
+			continue
+		}
+
+		objs = append(objs, protocol.NewBulkRedisString(entries[idx].String()))
+		objs = append(objs, protocol.NewBulkRedisString(util.FormatFloat(scores[idx])))
+	}
+
+	z.result = newScanReply(cursor, objs)
+}
+
+func (z *zscanCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zscanCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zscanCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zscanCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zscanCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zscanCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zscanCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zscanCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
@@ -0,0 +1,710 @@
+package command
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+func newServerCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "info":
+		i := &infoCommand{
+			index: index,
+		}
+		err := i.ParseArguments(arguments)
+		return i, err
+	case "bgrewriteaof":
+		b := &bgRewriteAOFCommand{
+			index: index,
+		}
+		err := b.ParseArguments(arguments)
+		return b, err
+	case "save", "bgsave":
+		sc := &saveCommand{
+			background: name == "bgsave",
+			index:      index,
+		}
+		err := sc.ParseArguments(arguments)
+		return sc, err
+	case "lastsave":
+		l := &lastSaveCommand{
+			index: index,
+		}
+		err := l.ParseArguments(arguments)
+		return l, err
+	case "replicaof", "slaveof":
+		r := &replicaOfCommand{
+			name:  name,
+			index: index,
+		}
+		err := r.ParseArguments(arguments)
+		return r, err
+	case "role":
+		r := &roleCommand{
+			index: index,
+		}
+		err := r.ParseArguments(arguments)
+		return r, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// infoSections lists the sections of INFO in the order they are replied, with the functions writing the
+// fields of each
+var infoSections = []struct {
+	name  string
+	write func(*strings.Builder, Server)
+}{
+	{"server", writeServerInfo},
+	{"persistence", writePersistenceInfo},
+	{"replication", writeReplicationInfo},
+	{"raft", writeRaftInfo},
+}
+
+func writeServerInfo(sb *strings.Builder, server Server) {
+	uptime := int64(server.Uptime().Seconds())
+
+	_, _ = fmt.Fprintf(sb, "redis_version:%s\r\n", common.Version)
+	sb.WriteString("redis_mode:standalone\r\n")
+	_, _ = fmt.Fprintf(sb, "process_id:%d\r\n", os.Getpid())
+	_, _ = fmt.Fprintf(sb, "uptime_in_seconds:%d\r\n", uptime)
+	_, _ = fmt.Fprintf(sb, "uptime_in_days:%d\r\n", uptime/86400)
+}
+
+func writePersistenceInfo(sb *strings.Builder, server Server) {
+	info := server.Persistence()
+
+	status := func(err error) string {
+		if err != nil {
+			return "err"
+		}
+		return "ok"
+	}
+
+	lastFsync := int64(-1)
+	if !info.LastFsync.IsZero() {
+		lastFsync = info.LastFsync.Unix()
+	}
+
+	snapshot := server.Snapshot()
+
+	sb.WriteString("loading:0\r\n")
+	_, _ = fmt.Fprintf(sb, "rdb_bgsave_in_progress:%d\r\n", boolToInt(snapshot.Saving))
+	_, _ = fmt.Fprintf(sb, "rdb_last_save_time:%d\r\n", snapshot.LastSave.Unix())
+	_, _ = fmt.Fprintf(sb, "rdb_last_bgsave_status:%s\r\n", status(snapshot.SaveErr))
+	_, _ = fmt.Fprintf(sb, "aof_enabled:%d\r\n", boolToInt(info.Enabled))
+	_, _ = fmt.Fprintf(sb, "appendfsync:%s\r\n", info.AppendFsync)
+	_, _ = fmt.Fprintf(sb, "aof_last_fsync_time:%d\r\n", lastFsync)
+	_, _ = fmt.Fprintf(sb, "aof_last_write_status:%s\r\n", status(info.WriteErr))
+	_, _ = fmt.Fprintf(sb, "aof_last_fsync_status:%s\r\n", status(info.FsyncErr))
+	_, _ = fmt.Fprintf(sb, "aof_rewrite_in_progress:%d\r\n", boolToInt(info.Rewriting))
+	_, _ = fmt.Fprintf(sb, "aof_last_bgrewrite_status:%s\r\n", status(info.RewriteErr))
+	_, _ = fmt.Fprintf(sb, "aof_current_size:%d\r\n", info.Size)
+	_, _ = fmt.Fprintf(sb, "aof_base_size:%d\r\n", info.BaseSize)
+	_, _ = fmt.Fprintf(sb, "aof_buffer_length:%d\r\n", info.Buffered)
+
+	// the pending error is the one stopping the file, or the fsync error to be retried
+	if info.WriteErr != nil {
+		_, _ = fmt.Fprintf(sb, "aof_pending_error:%s\r\n", oneLine(info.WriteErr.Error()))
+	} else if info.FsyncErr != nil {
+		_, _ = fmt.Fprintf(sb, "aof_pending_error:%s\r\n", oneLine(info.FsyncErr.Error()))
+	}
+}
+
+func writeReplicationInfo(sb *strings.Builder, server Server) {
+	info := server.Replication()
+
+	if !info.Replica {
+		sb.WriteString("role:master\r\n")
+	} else {
+		linkStatus := "down"
+		if info.LinkState == "connected" {
+			linkStatus = "up"
+		}
+
+		lastIO := int64(-1)
+		if !info.LastIO.IsZero() {
+			lastIO = int64(time.Since(info.LastIO).Seconds())
+		}
+
+		sb.WriteString("role:slave\r\n")
+		_, _ = fmt.Fprintf(sb, "master_host:%s\r\n", info.MasterHost)
+		_, _ = fmt.Fprintf(sb, "master_port:%d\r\n", info.MasterPort)
+		_, _ = fmt.Fprintf(sb, "master_link_status:%s\r\n", linkStatus)
+		_, _ = fmt.Fprintf(sb, "master_last_io_seconds_ago:%d\r\n", lastIO)
+		_, _ = fmt.Fprintf(sb, "master_sync_in_progress:%d\r\n", boolToInt(info.LinkState == "sync"))
+		_, _ = fmt.Fprintf(sb, "slave_repl_offset:%d\r\n", info.ReplicaOffset)
+
+		// the transfer is reported until it is done, even if it is interrupted and waiting to be resumed, the
+		// size streamed is -1 until it is known
+		if info.SyncSize != 0 {
+			_, _ = fmt.Fprintf(sb, "master_sync_total_bytes:%d\r\n", info.SyncSize)
+			_, _ = fmt.Fprintf(sb, "master_sync_read_bytes:%d\r\n", info.SyncPosition)
+		}
+
+		if info.SyncSize > 0 {
+			_, _ = fmt.Fprintf(sb, "master_sync_left_bytes:%d\r\n", info.SyncSize-info.SyncPosition)
+			_, _ = fmt.Fprintf(sb, "master_sync_perc:%.2f\r\n", float64(info.SyncPosition)*100/float64(info.SyncSize))
+		}
+
+		if !info.LinkDownSince.IsZero() {
+			_, _ = fmt.Fprintf(sb, "master_link_down_since_seconds:%d\r\n",
+				int64(time.Since(info.LinkDownSince).Seconds()))
+		}
+
+		sb.WriteString("slave_read_only:1\r\n")
+	}
+
+	_, _ = fmt.Fprintf(sb, "connected_slaves:%d\r\n", len(info.Replicas))
+	for idx, replica := range info.Replicas {
+		_, _ = fmt.Fprintf(sb, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\r\n", idx, replica.IP,
+			replica.Port, replica.State, replica.Offset, int64(replica.Lag.Seconds()))
+	}
+
+	if info.MinReplicasToWrite > 0 {
+		_, _ = fmt.Fprintf(sb, "min_slaves_good_slaves:%d\r\n", info.GoodReplicas)
+	}
+
+	_, _ = fmt.Fprintf(sb, "master_replid:%s\r\n", info.ReplicationID)
+	_, _ = fmt.Fprintf(sb, "master_repl_offset:%d\r\n", info.Offset)
+	_, _ = fmt.Fprintf(sb, "repl_backlog_active:%d\r\n", boolToInt(info.BacklogActive))
+	_, _ = fmt.Fprintf(sb, "repl_backlog_size:%d\r\n", info.BacklogSize)
+	_, _ = fmt.Fprintf(sb, "repl_backlog_first_byte_offset:%d\r\n", info.BacklogFirstOffset)
+	_, _ = fmt.Fprintf(sb, "repl_backlog_histlen:%d\r\n", info.BacklogHistlen)
+}
+
+func writeRaftInfo(sb *strings.Builder, server Server) {
+	info := server.Raft()
+
+	_, _ = fmt.Fprintf(sb, "raft_enabled:%d\r\n", boolToInt(info.Enabled))
+	if !info.Enabled {
+		return
+	}
+
+	_, _ = fmt.Fprintf(sb, "raft_node_id:%s\r\n", info.ID)
+	_, _ = fmt.Fprintf(sb, "raft_state:%s\r\n", info.State)
+	_, _ = fmt.Fprintf(sb, "raft_term:%d\r\n", info.Term)
+	_, _ = fmt.Fprintf(sb, "raft_leader_id:%s\r\n", info.Leader)
+	_, _ = fmt.Fprintf(sb, "raft_leader_addr:%s\r\n", info.LeaderAddr)
+	_, _ = fmt.Fprintf(sb, "raft_commit_index:%d\r\n", info.CommitIndex)
+	_, _ = fmt.Fprintf(sb, "raft_last_applied:%d\r\n", info.LastApplied)
+	_, _ = fmt.Fprintf(sb, "raft_last_index:%d\r\n", info.LastIndex)
+	_, _ = fmt.Fprintf(sb, "raft_snapshot_index:%d\r\n", info.SnapshotIndex)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// oneLine replaces the line breaks, so the text can be a field of INFO
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// infoCommand is INFO [section ...]. The sections are replied in the verbatim text of redis, all of them
+// are replied for none, `all`, `default` or `everything`, and the unknown ones are ignored.
+type infoCommand struct {
+	sections map[string]bool
+	index    int
+	server   Server
+	result   protocol.RedisObject
+	err      error
+}
+
+func (i *infoCommand) Name() string {
+	return "info"
+}
+
+func (i *infoCommand) ParseArguments(objects []protocol.RedisObject) error {
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	for _, argument := range arguments {
+		section := strings.ToLower(argument)
+		if section == "all" || section == "default" || section == "everything" {
+			i.sections = nil
+			return nil
+		}
+
+		if i.sections == nil {
+			i.sections = make(map[string]bool)
+		}
+		i.sections[section] = true
+	}
+
+	return nil
+}
+
+func (i *infoCommand) Execute() {
+	if i.server == nil {
+		i.err = fmt.Errorf("nil server")
+		return
+	}
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if i.sections != nil && !i.sections[section.name] {
+			continue
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+
+		sb.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		section.write(&sb, i.server)
+	}
+
+	i.result = protocol.NewRedisVerbatimString("txt", sb.String())
+}
+
+func (i *infoCommand) Result() (protocol.RedisObject, error) {
+	return i.result, i.err
+}
+
+func (i *infoCommand) Cluster() int {
+	return i.index
+}
+
+func (i *infoCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (i *infoCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (i *infoCommand) Keys() []string {
+	return nil
+}
+
+func (i *infoCommand) ShouldCreate() bool {
+	return false
+}
+
+func (i *infoCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (i *infoCommand) SetServer(server Server, _ *types.Session) {
+	i.server = server
+}
+
+func (i *infoCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// bgRewriteAOFCommand is BGREWRITEAOF, which rewrites the database file into the minimal records rebuilding
+// the dataset in the background
+type bgRewriteAOFCommand struct {
+	index  int
+	server Server
+	result protocol.RedisObject
+	err    error
+}
+
+func (b *bgRewriteAOFCommand) Name() string {
+	return "bgrewriteaof"
+}
+
+func (b *bgRewriteAOFCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 0 {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (b *bgRewriteAOFCommand) Execute() {
+	if b.server == nil {
+		b.err = fmt.Errorf("nil server")
+		return
+	}
+
+	if err := b.server.RewriteLog(); err != nil {
+		b.err = fmt.Errorf("bgrewriteaof failed. err={%w}", err)
+		return
+	}
+
+	b.result = protocol.NewSimpleRedisString("Background append only file rewriting started")
+}
+
+func (b *bgRewriteAOFCommand) Result() (protocol.RedisObject, error) {
+	return b.result, b.err
+}
+
+func (b *bgRewriteAOFCommand) Cluster() int {
+	return b.index
+}
+
+func (b *bgRewriteAOFCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (b *bgRewriteAOFCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (b *bgRewriteAOFCommand) Keys() []string {
+	return nil
+}
+
+func (b *bgRewriteAOFCommand) ShouldCreate() bool {
+	return false
+}
+
+func (b *bgRewriteAOFCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (b *bgRewriteAOFCommand) SetServer(server Server, _ *types.Session) {
+	b.server = server
+}
+
+func (b *bgRewriteAOFCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// saveCommand is SAVE and BGSAVE, which take a snapshot of all dbs into the snapshot file. SAVE writes it at
+// once, while BGSAVE replies at once and writes it in the background. The SCHEDULE option of BGSAVE is
+// accepted and ignored, as a save is never delayed by a rewrite.
+type saveCommand struct {
+	background bool
+	index      int
+	server     Server
+	result     protocol.RedisObject
+	err        error
+}
+
+func (s *saveCommand) Name() string {
+	if s.background {
+		return "bgsave"
+	}
+
+	return "save"
+}
+
+func (s *saveCommand) ParseArguments(objects []protocol.RedisObject) error {
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if !s.background && len(arguments) != 0 {
+		return ErrArgumentInvalid
+	} else if len(arguments) > 1 || (len(arguments) == 1 && strings.ToLower(arguments[0]) != "schedule") {
+		return ErrSyntax
+	}
+
+	return nil
+}
+
+func (s *saveCommand) Execute() {
+	if s.server == nil {
+		s.err = fmt.Errorf("nil server")
+		return
+	}
+
+	if !s.background {
+		if err := s.server.Save(); err != nil {
+			s.err = fmt.Errorf("save failed. err={%w}", err)
+			return
+		}
+
+		s.result = protocol.NewSimpleRedisString("OK")
+		return
+	}
+
+	if err := s.server.BackgroundSave(); err != nil {
+		s.err = fmt.Errorf("bgsave failed. err={%w}", err)
+		return
+	}
+
+	s.result = protocol.NewSimpleRedisString("Background saving started")
+}
+
+func (s *saveCommand) Result() (protocol.RedisObject, error) {
+	return s.result, s.err
+}
+
+func (s *saveCommand) Cluster() int {
+	return s.index
+}
+
+func (s *saveCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (s *saveCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (s *saveCommand) Keys() []string {
+	return nil
+}
+
+func (s *saveCommand) ShouldCreate() bool {
+	return false
+}
+
+func (s *saveCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (s *saveCommand) SetServer(server Server, _ *types.Session) {
+	s.server = server
+}
+
+func (s *saveCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// lastSaveCommand is LASTSAVE, which replies the unix time of the last successful snapshot
+type lastSaveCommand struct {
+	index  int
+	server Server
+	result protocol.RedisObject
+	err    error
+}
+
+func (l *lastSaveCommand) Name() string {
+	return "lastsave"
+}
+
+func (l *lastSaveCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 0 {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (l *lastSaveCommand) Execute() {
+	if l.server == nil {
+		l.err = fmt.Errorf("nil server")
+		return
+	}
+
+	l.result = protocol.NewRedisInteger(l.server.Snapshot().LastSave.Unix())
+}
+
+func (l *lastSaveCommand) Result() (protocol.RedisObject, error) {
+	return l.result, l.err
+}
+
+func (l *lastSaveCommand) Cluster() int {
+	return l.index
+}
+
+func (l *lastSaveCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (l *lastSaveCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (l *lastSaveCommand) Keys() []string {
+	return nil
+}
+
+func (l *lastSaveCommand) ShouldCreate() bool {
+	return false
+}
+
+func (l *lastSaveCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (l *lastSaveCommand) SetServer(server Server, _ *types.Session) {
+	l.server = server
+}
+
+func (l *lastSaveCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// replicaOfCommand is REPLICAOF host port and its alias SLAVEOF, which follows the master listening on the
+// address for the replicas. REPLICAOF NO ONE stops following and turns the server into a master, the
+// dataset is kept.
+type replicaOfCommand struct {
+	name   string
+	addr   string
+	index  int
+	server Server
+	result protocol.RedisObject
+	err    error
+}
+
+func (r *replicaOfCommand) Name() string {
+	return r.name
+}
+
+func (r *replicaOfCommand) ParseArguments(objects []protocol.RedisObject) error {
+	arguments, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(arguments) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	if strings.ToLower(arguments[0]) == "no" && strings.ToLower(arguments[1]) == "one" {
+		r.addr = ""
+		return nil
+	}
+
+	port, err := strconv.Atoi(arguments[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return ErrInvalidMasterPort
+	}
+
+	r.addr = net.JoinHostPort(arguments[0], strconv.Itoa(port))
+	return nil
+}
+
+func (r *replicaOfCommand) Execute() {
+	if r.server == nil {
+		r.err = fmt.Errorf("nil server")
+		return
+	}
+
+	if !r.server.ReplicaOf(r.addr) && r.addr != "" {
+		r.result = protocol.NewSimpleRedisString("OK Already connected to specified master")
+		return
+	}
+
+	r.result = protocol.NewSimpleRedisString("OK")
+}
+
+func (r *replicaOfCommand) Result() (protocol.RedisObject, error) {
+	return r.result, r.err
+}
+
+func (r *replicaOfCommand) Cluster() int {
+	return r.index
+}
+
+func (r *replicaOfCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (r *replicaOfCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (r *replicaOfCommand) Keys() []string {
+	return nil
+}
+
+func (r *replicaOfCommand) ShouldCreate() bool {
+	return false
+}
+
+func (r *replicaOfCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (r *replicaOfCommand) SetServer(server Server, _ *types.Session) {
+	r.server = server
+}
+
+func (r *replicaOfCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// roleCommand is ROLE, which replies the role of the server as redis does. A master replies its offset and
+// the replicas connected, and a replica replies its master, the state of the link and the offset received.
+type roleCommand struct {
+	index  int
+	server Server
+	result protocol.RedisObject
+	err    error
+}
+
+func (r *roleCommand) Name() string {
+	return "role"
+}
+
+func (r *roleCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 0 {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (r *roleCommand) Execute() {
+	if r.server == nil {
+		r.err = fmt.Errorf("nil server")
+		return
+	}
+
+	info := r.server.Replication()
+
+	if info.Replica {
+		r.result = protocol.NewRedisArray([]protocol.RedisObject{
+			protocol.NewBulkRedisString("slave"),
+			protocol.NewBulkRedisString(info.MasterHost),
+			protocol.NewRedisInteger(int64(info.MasterPort)),
+			protocol.NewBulkRedisString(info.LinkState),
+			protocol.NewRedisInteger(info.ReplicaOffset),
+		})
+		return
+	}
+
+	var replicas []protocol.RedisObject
+	for _, replica := range info.Replicas {
+		replicas = append(replicas, protocol.NewRedisArray([]protocol.RedisObject{
+			protocol.NewBulkRedisString(replica.IP),
+			protocol.NewBulkRedisString(strconv.Itoa(replica.Port)),
+			protocol.NewBulkRedisString(strconv.FormatInt(replica.Offset, 10)),
+		}))
+	}
+
+	r.result = protocol.NewRedisArray([]protocol.RedisObject{
+		protocol.NewBulkRedisString("master"),
+		protocol.NewRedisInteger(info.Offset),
+		protocol.NewRedisArray(replicas),
+	})
+}
+
+func (r *roleCommand) Result() (protocol.RedisObject, error) {
+	return r.result, r.err
+}
+
+func (r *roleCommand) Cluster() int {
+	return r.index
+}
+
+func (r *roleCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (r *roleCommand) Type() CommandType {
+	return SystemCommandType
+}
+
+func (r *roleCommand) Keys() []string {
+	return nil
+}
+
+func (r *roleCommand) ShouldCreate() bool {
+	return false
+}
+
+func (r *roleCommand) SetAccessObjects([]container.ContainerObject) {}
+
+func (r *roleCommand) SetServer(server Server, _ *types.Session) {
+	r.server = server
+}
+
+func (r *roleCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
@@ -108,7 +108,7 @@ func (s *scardCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -193,7 +193,7 @@ func (s *saddCommand) ParseArguments(objects []protocol.RedisObject) error {
 
 func (s *saddCommand) Execute() {
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -283,7 +283,7 @@ func (s *smembersCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -296,7 +296,7 @@ func (s *smembersCommand) Execute() {
 		objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
 	}
 
-	s.result = protocol.NewRedisArray(objs)
+	s.result = protocol.NewRedisSet(objs)
 }
 
 func (s *smembersCommand) Result() (protocol.RedisObject, error) {
@@ -376,7 +376,7 @@ func (s *sismemberCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -468,7 +468,7 @@ func (s *sremCommand) ParseArguments(objects []protocol.RedisObject) error {
 
 func (s *sremCommand) Execute() {
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -529,6 +529,7 @@ type spopCommand struct {
 	index        int
 	count        int
 	countSet     bool
+	popped       []string
 	accessObject container.ContainerObject
 	result       protocol.RedisObject
 	err          error
@@ -584,11 +585,16 @@ func (s *spopCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
 	ret := s.accessObject.(container.SetContainer).Pop(s.count)
+	for _, obj := range ret {
+		if obj != nil {
+			s.popped = append(s.popped, obj.String())
+		}
+	}
 
 	if !s.countSet {
 		if ret[0] == nil {
@@ -606,7 +612,7 @@ func (s *spopCommand) Execute() {
 				objs = append(objs, protocol.NewBulkRedisString(obj.String()))
 			}
 
-			s.result = protocol.NewRedisArray(objs)
+			s.result = protocol.NewRedisSet(objs)
 		}
 	}
 }
@@ -615,6 +621,23 @@ func (s *spopCommand) Result() (protocol.RedisObject, error) {
 	return s.result, s.err
 }
 
+// Rewrite logs the removal of the popped members, since the members are picked randomly
+func (s *spopCommand) Rewrite() []protocol.RedisObject {
+	if len(s.popped) == 0 {
+		return nil
+	}
+
+	objs := []protocol.RedisObject{
+		protocol.NewBulkRedisString("srem"),
+		protocol.NewBulkRedisString(s.key),
+	}
+	for _, member := range s.popped {
+		objs = append(objs, protocol.NewBulkRedisString(member))
+	}
+
+	return objs
+}
+
 func (s *spopCommand) Cluster() int {
 	return s.index
 }
@@ -706,7 +729,7 @@ func (s *srandmemberCommand) Execute() {
 	}
 
 	if s.accessObject.Type() != s.TargetContainerType() {
-		s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), s.accessObject.Type())
+		s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), s.accessObject.Type(), ErrWrongType)
 		return
 	}
 
@@ -809,7 +832,7 @@ func (s *sdiffCommand) Execute() {
 
 	for _, accessObject := range s.accessObjects {
 		if accessObject.Type() != s.TargetContainerType() {
-			s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), accessObject.Type())
+			s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), accessObject.Type(), ErrWrongType)
 			return
 		}
 	}
@@ -836,7 +859,7 @@ func (s *sdiffCommand) Execute() {
 			objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
 		}
 
-		s.result = protocol.NewRedisArray(objs)
+		s.result = protocol.NewRedisSet(objs)
 	}
 }
 
@@ -922,7 +945,7 @@ func (s *sinterCommand) Execute() {
 
 	for _, accessObject := range s.accessObjects {
 		if accessObject.Type() != s.TargetContainerType() {
-			s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), accessObject.Type())
+			s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), accessObject.Type(), ErrWrongType)
 			return
 		}
 	}
@@ -949,7 +972,7 @@ func (s *sinterCommand) Execute() {
 			objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
 		}
 
-		s.result = protocol.NewRedisArray(objs)
+		s.result = protocol.NewRedisSet(objs)
 	}
 }
 
@@ -1035,7 +1058,7 @@ func (s *sunionCommand) Execute() {
 
 	for _, accessObject := range s.accessObjects {
 		if accessObject.Type() != s.TargetContainerType() {
-			s.err = fmt.Errorf("target container type mismatcs. expected=%d, got=%d", s.TargetContainerType(), accessObject.Type())
+			s.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", s.TargetContainerType(), accessObject.Type(), ErrWrongType)
 			return
 		}
 	}
@@ -1062,7 +1085,7 @@ func (s *sunionCommand) Execute() {
 			objs = append(objs, protocol.NewBulkRedisString(fields[idx].String()))
 		}
 
-		s.result = protocol.NewRedisArray(objs)
+		s.result = protocol.NewRedisSet(objs)
 	}
 }
 
@@ -1 +1,1676 @@
 package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+func newSortedSetCommand(name string, index int, arguments []protocol.RedisObject) (Command, error) {
+	switch name {
+	case "zadd":
+		z := &zaddCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zincrby":
+		z := &zincrbyCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zrem":
+		z := &zremCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zscore", "zmscore":
+		z := &zscoreCommand{
+			name:  name,
+			index: index,
+			multi: name == "zmscore",
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zcard":
+		z := &zcardCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zcount":
+		z := &zcountCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zrank", "zrevrank":
+		z := &zrankCommand{
+			name:    name,
+			index:   index,
+			reverse: name == "zrevrank",
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zrange":
+		z := &zrangeCommand{
+			name:  name,
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zrangebyscore":
+		z := &zrangeCommand{
+			name:    name,
+			index:   index,
+			byScore: true,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zremrangebyrank", "zremrangebyscore", "zremrangebylex":
+		z := &zremRangeCommand{
+			name:    name,
+			index:   index,
+			byScore: name == "zremrangebyscore",
+			byLex:   name == "zremrangebylex",
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zpopmin", "zpopmax":
+		z := &zpopCommand{
+			name:  name,
+			index: index,
+			max:   name == "zpopmax",
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zunionstore", "zinterstore":
+		z := &zstoreCommand{
+			name:  name,
+			index: index,
+			union: name == "zunionstore",
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	case "zrandmember":
+		z := &zrandmemberCommand{
+			index: index,
+		}
+		err := z.ParseArguments(arguments)
+		return z, err
+	}
+
+	return nil, ErrCommandNotExist
+}
+
+// parseStrings converts all objects into strings
+func parseStrings(objects []protocol.RedisObject) ([]string, error) {
+	var ret []string
+
+	for _, obj := range objects {
+		tmpObj, ok := obj.(protocol.RedisString)
+		if !ok {
+			return nil, ErrArgumentInvalid
+		}
+
+		ret = append(ret, tmpObj.Data())
+	}
+
+	return ret, nil
+}
+
+// parseScore parses a score, `inf`, `+inf` and `-inf` are accepted but NaN is not
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, ErrNotAFloat
+	}
+
+	return score, nil
+}
+
+// parseScoreRange parses the bounds of a score range, a bound starts with `(` is exclusive
+func parseScoreRange(min, max string) (*container.ScoreRange, error) {
+	r := &container.ScoreRange{}
+
+	if strings.HasPrefix(min, "(") {
+		r.MinExclusive = true
+		min = min[1:]
+	}
+
+	if strings.HasPrefix(max, "(") {
+		r.MaxExclusive = true
+		max = max[1:]
+	}
+
+	var err error
+	if r.Min, err = parseScore(min); err != nil {
+		return nil, ErrInvalidScoreBound
+	}
+
+	if r.Max, err = parseScore(max); err != nil {
+		return nil, ErrInvalidScoreBound
+	}
+
+	return r, nil
+}
+
+// parseLexBound parses a bound of a lex range, it returns the string, whether it is exclusive and whether
+// it is `-` or `+`
+func parseLexBound(bound string) (string, bool, bool, error) {
+	switch {
+	case bound == "-" || bound == "+":
+		return "", false, true, nil
+	case strings.HasPrefix(bound, "["):
+		return bound[1:], false, false, nil
+	case strings.HasPrefix(bound, "("):
+		return bound[1:], true, false, nil
+	}
+
+	return "", false, false, ErrInvalidLexBound
+}
+
+// parseLexRange parses the bounds of a lex range. A bound is `-`, `+`, or a string starts with `[` for
+// inclusive and `(` for exclusive. The range covers nothing if min is `+` or max is `-`, nil is returned then.
+func parseLexRange(min, max string) (*container.LexRange, error) {
+	r := &container.LexRange{}
+
+	var err error
+	if r.Min, r.MinExclusive, r.MinUnbounded, err = parseLexBound(min); err != nil {
+		return nil, err
+	}
+
+	if r.Max, r.MaxExclusive, r.MaxUnbounded, err = parseLexBound(max); err != nil {
+		return nil, err
+	}
+
+	if min == "+" || max == "-" {
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// parseLimit parses `LIMIT offset count`, a negative count means all
+func parseLimit(offset, count string) (int, int, error) {
+	o, err := strconv.Atoi(offset)
+	if err != nil {
+		return 0, 0, ErrArgumentInvalid
+	}
+
+	c, err := strconv.Atoi(count)
+	if err != nil {
+		return 0, 0, ErrArgumentInvalid
+	}
+
+	return o, c, nil
+}
+
+// resolveRankRange resolves the rank range as redis does, the negative ranks count from the end.
+// false is returned if the range is empty.
+func resolveRankRange(start, end, length int) (int, int, bool) {
+	if start < 0 {
+		start += length
+	}
+
+	if end < 0 {
+		end += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if start > end || start >= length {
+		return 0, 0, false
+	}
+
+	if end >= length {
+		end = length - 1
+	}
+
+	return start, end, true
+}
+
+// newEntriesReply builds an array of the entries, the scores are interleaved if withScores is set
+func newEntriesReply(entries []*container.StringContainer, scores []float64, withScores bool) protocol.RedisArray {
+	objs := []protocol.RedisObject{}
+
+	for idx, entry := range entries {
+		objs = append(objs, protocol.NewBulkRedisString(entry.String()))
+
+		if withScores {
+			objs = append(objs, protocol.NewBulkRedisString(util.FormatFloat(scores[idx])))
+		}
+	}
+
+	return protocol.NewRedisArray(objs)
+}
+
+func reverseEntries(entries []*container.StringContainer, scores []float64) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+		scores[i], scores[j] = scores[j], scores[i]
+	}
+}
+
+// zaddCommand is ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+type zaddCommand struct {
+	key          string
+	index        int
+	nx, xx       bool
+	gt, lt       bool
+	ch           bool
+	incr         bool
+	scores       []float64
+	members      []string
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (z *zaddCommand) Name() string {
+	return "zadd"
+}
+
+func (z *zaddCommand) ParseArguments(objects []protocol.RedisObject) error {
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(strs) < 3 {
+		return ErrArgumentInvalid
+	}
+
+	z.key = strs[0]
+
+	idx := 1
+Options:
+	for ; idx < len(strs); idx++ {
+		switch strings.ToLower(strs[idx]) {
+		case "nx":
+			z.nx = true
+		case "xx":
+			z.xx = true
+		case "gt":
+			z.gt = true
+		case "lt":
+			z.lt = true
+		case "ch":
+			z.ch = true
+		case "incr":
+			z.incr = true
+		default:
+			break Options
+		}
+	}
+
+	if (z.nx && z.xx) || (z.gt && z.lt) || (z.nx && (z.gt || z.lt)) {
+		return ErrSyntax
+	}
+
+	pairs := strs[idx:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return ErrSyntax
+	}
+
+	if z.incr && len(pairs) != 2 {
+		return ErrSyntax
+	}
+
+	for idx := 0; idx < len(pairs); idx += 2 {
+		score, err := parseScore(pairs[idx])
+		if err != nil {
+			return err
+		}
+
+		z.scores = append(z.scores, score)
+		z.members = append(z.members, pairs[idx+1])
+	}
+
+	return nil
+}
+
+func (z *zaddCommand) Execute() {
+	if z.accessObject == nil {
+		z.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+	added, updated := 0, 0
+	var incrResult protocol.RedisObject = protocol.NewNullBulkRedisString()
+
+	for idx, member := range z.members {
+		entry := container.NewString(member)
+		score := z.scores[idx]
+
+		current, err := zset.Score(entry)
+		exists := err == nil
+
+		if (z.nx && exists) || (z.xx && !exists) {
+			continue
+		}
+
+		if z.incr && exists {
+			score += current
+			if math.IsNaN(score) {
+				z.err = container.ErrScoreNaN
+				return
+			}
+		}
+
+		if exists && ((z.gt && score <= current) || (z.lt && score >= current)) {
+			continue
+		}
+
+		if exists {
+			if score != current {
+				updated++
+			}
+		} else {
+			added++
+		}
+
+		_, _ = zset.Add([]float64{score}, []*container.StringContainer{entry})
+		incrResult = protocol.NewRedisDouble(score)
+	}
+
+	if z.incr {
+		z.result = incrResult
+	} else if z.ch {
+		z.result = protocol.NewRedisInteger(int64(added + updated))
+	} else {
+		z.result = protocol.NewRedisInteger(int64(added))
+	}
+}
+
+func (z *zaddCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zaddCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zaddCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zaddCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zaddCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zaddCommand) ShouldCreate() bool {
+	return true
+}
+
+func (z *zaddCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zaddCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+type zincrbyCommand struct {
+	key          string
+	index        int
+	increment    float64
+	member       string
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (z *zincrbyCommand) Name() string {
+	return "zincrby"
+}
+
+func (z *zincrbyCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 3 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.member = strs[2]
+	z.increment, err = parseScore(strs[1])
+
+	return err
+}
+
+func (z *zincrbyCommand) Execute() {
+	if z.accessObject == nil {
+		z.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	score, err := z.accessObject.(container.SortedSetContainer).IncreaseBy(container.NewString(z.member), z.increment)
+	if err != nil {
+		z.err = fmt.Errorf("zincrby failed. key=%s, member=%s, err={%w}", z.key, z.member, err)
+		return
+	}
+
+	z.result = protocol.NewRedisDouble(score)
+}
+
+func (z *zincrbyCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zincrbyCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zincrbyCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zincrbyCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zincrbyCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zincrbyCommand) ShouldCreate() bool {
+	return true
+}
+
+func (z *zincrbyCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zincrbyCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+type zremCommand struct {
+	key          string
+	index        int
+	members      []string
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (z *zremCommand) Name() string {
+	return "zrem"
+}
+
+func (z *zremCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.members = strs[1:]
+
+	return nil
+}
+
+func (z *zremCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = protocol.NewRedisInteger(0)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var entries []*container.StringContainer
+	for _, member := range z.members {
+		entries = append(entries, container.NewString(member))
+	}
+
+	z.result = protocol.NewRedisInteger(int64(z.accessObject.(container.SortedSetContainer).Del(entries)))
+}
+
+func (z *zremCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zremCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zremCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zremCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zremCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zremCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zremCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zremCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zscoreCommand is ZSCORE and ZMSCORE, ZMSCORE replies an array even if only one member is given
+type zscoreCommand struct {
+	name         string
+	key          string
+	index        int
+	multi        bool
+	members      []string
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (z *zscoreCommand) Name() string {
+	return z.name
+}
+
+func (z *zscoreCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 2 || (!z.multi && len(objects) != 2) {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.members = strs[1:]
+
+	return nil
+}
+
+func (z *zscoreCommand) Execute() {
+	if z.accessObject != nil && z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	var objs []protocol.RedisObject
+
+	for _, member := range z.members {
+		var obj protocol.RedisObject = protocol.NewNullBulkRedisString()
+
+		if z.accessObject != nil {
+			if score, err := z.accessObject.(container.SortedSetContainer).Score(container.NewString(member)); err == nil {
+				obj = protocol.NewRedisDouble(score)
+			}
+		}
+
+		objs = append(objs, obj)
+	}
+
+	if z.multi {
+		z.result = protocol.NewRedisArray(objs)
+	} else {
+		z.result = objs[0]
+	}
+}
+
+func (z *zscoreCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zscoreCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zscoreCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zscoreCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zscoreCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zscoreCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zscoreCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zscoreCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+type zcardCommand struct {
+	key          string
+	index        int
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (z *zcardCommand) Name() string {
+	return "zcard"
+}
+
+func (z *zcardCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 {
+		return ErrArgumentInvalid
+	}
+
+	tmpObj, ok := objects[0].(protocol.RedisString)
+	if !ok {
+		return ErrArgumentInvalid
+	}
+
+	z.key = tmpObj.Data()
+
+	return nil
+}
+
+func (z *zcardCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = protocol.NewRedisInteger(0)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	z.result = protocol.NewRedisInteger(int64(z.accessObject.(container.SortedSetContainer).Len()))
+}
+
+func (z *zcardCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zcardCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zcardCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zcardCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zcardCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zcardCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zcardCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zcardCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+type zcountCommand struct {
+	key          string
+	index        int
+	scoreRange   *container.ScoreRange
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (z *zcountCommand) Name() string {
+	return "zcount"
+}
+
+func (z *zcountCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 3 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.scoreRange, err = parseScoreRange(strs[1], strs[2])
+
+	return err
+}
+
+func (z *zcountCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = protocol.NewRedisInteger(0)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	z.result = protocol.NewRedisInteger(int64(z.accessObject.(container.SortedSetContainer).Count(z.scoreRange)))
+}
+
+func (z *zcountCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zcountCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zcountCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zcountCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zcountCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zcountCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zcountCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zcountCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zrankCommand is ZRANK and ZREVRANK
+type zrankCommand struct {
+	name         string
+	key          string
+	index        int
+	reverse      bool
+	member       string
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (z *zrankCommand) Name() string {
+	return z.name
+}
+
+func (z *zrankCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.member = strs[1]
+
+	return nil
+}
+
+func (z *zrankCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = protocol.NewNullBulkRedisString()
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+
+	rank, err := zset.Rank(container.NewString(z.member))
+	if err != nil {
+		z.result = protocol.NewNullBulkRedisString()
+		return
+	}
+
+	if z.reverse {
+		rank = zset.Len() - 1 - rank
+	}
+
+	z.result = protocol.NewRedisInteger(int64(rank))
+}
+
+func (z *zrankCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zrankCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zrankCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zrankCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zrankCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zrankCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zrankCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zrankCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zrangeCommand is ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES] and
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]. With REV, the start is the max bound
+// when ranging by score or lex.
+type zrangeCommand struct {
+	name         string
+	key          string
+	index        int
+	byScore      bool
+	byLex        bool
+	reverse      bool
+	withScores   bool
+	limited      bool
+	start, end   int
+	offset       int
+	count        int
+	scoreRange   *container.ScoreRange
+	lexRange     *container.LexRange
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (z *zrangeCommand) Name() string {
+	return z.name
+}
+
+func (z *zrangeCommand) ParseArguments(objects []protocol.RedisObject) error {
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(strs) < 3 {
+		return ErrArgumentInvalid
+	}
+
+	z.key = strs[0]
+	z.count = -1
+
+	for idx := 3; idx < len(strs); idx++ {
+		switch strings.ToLower(strs[idx]) {
+		case "byscore":
+			if z.name != "zrange" {
+				return ErrSyntax
+			}
+			z.byScore = true
+		case "bylex":
+			if z.name != "zrange" {
+				return ErrSyntax
+			}
+			z.byLex = true
+		case "rev":
+			if z.name != "zrange" {
+				return ErrSyntax
+			}
+			z.reverse = true
+		case "withscores":
+			z.withScores = true
+		case "limit":
+			if idx+2 >= len(strs) {
+				return ErrSyntax
+			}
+
+			if z.offset, z.count, err = parseLimit(strs[idx+1], strs[idx+2]); err != nil {
+				return err
+			}
+
+			z.limited = true
+			idx += 2
+		default:
+			return ErrSyntax
+		}
+	}
+
+	if (z.byScore && z.byLex) || (z.limited && !z.byScore && !z.byLex) || (z.withScores && z.byLex) {
+		return ErrSyntax
+	}
+
+	min, max := strs[1], strs[2]
+	if z.reverse {
+		min, max = max, min
+	}
+
+	if z.byScore {
+		z.scoreRange, err = parseScoreRange(min, max)
+		return err
+	} else if z.byLex {
+		z.lexRange, err = parseLexRange(min, max)
+		return err
+	}
+
+	if z.start, err = strconv.Atoi(strs[1]); err != nil {
+		return ErrArgumentInvalid
+	}
+
+	if z.end, err = strconv.Atoi(strs[2]); err != nil {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (z *zrangeCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = newEntriesReply(nil, nil, false)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+
+	var entries []*container.StringContainer
+	var scores []float64
+
+	if z.byScore || z.byLex {
+		// a negative offset gives nothing as redis does
+		if z.offset >= 0 {
+			if z.byScore {
+				entries, scores = zset.RangeByScore(z.scoreRange, z.offset, z.count, z.reverse)
+			} else if z.lexRange != nil {
+				entries, scores = zset.RangeByLex(z.lexRange, z.offset, z.count, z.reverse)
+			}
+		}
+	} else if start, end, ok := resolveRankRange(z.start, z.end, zset.Len()); ok {
+		if z.reverse {
+			start, end = zset.Len()-1-end, zset.Len()-1-start
+		}
+
+		entries, scores = zset.RangeByRank(start, end)
+
+		if z.reverse {
+			reverseEntries(entries, scores)
+		}
+	}
+
+	z.result = newEntriesReply(entries, scores, z.withScores)
+}
+
+func (z *zrangeCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zrangeCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zrangeCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zrangeCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zrangeCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zrangeCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zrangeCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zrangeCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zremRangeCommand is ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX
+type zremRangeCommand struct {
+	name         string
+	key          string
+	index        int
+	byScore      bool
+	byLex        bool
+	start, end   int
+	scoreRange   *container.ScoreRange
+	lexRange     *container.LexRange
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (z *zremRangeCommand) Name() string {
+	return z.name
+}
+
+func (z *zremRangeCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 3 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+
+	if z.byScore {
+		z.scoreRange, err = parseScoreRange(strs[1], strs[2])
+		return err
+	} else if z.byLex {
+		z.lexRange, err = parseLexRange(strs[1], strs[2])
+		return err
+	}
+
+	if z.start, err = strconv.Atoi(strs[1]); err != nil {
+		return ErrArgumentInvalid
+	}
+
+	if z.end, err = strconv.Atoi(strs[2]); err != nil {
+		return ErrArgumentInvalid
+	}
+
+	return nil
+}
+
+func (z *zremRangeCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = protocol.NewRedisInteger(0)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+	removed := 0
+
+	if z.byScore {
+		removed = zset.DelRangeByScore(z.scoreRange)
+	} else if z.byLex {
+		if z.lexRange != nil {
+			removed = zset.DelRangeByLex(z.lexRange)
+		}
+	} else if start, end, ok := resolveRankRange(z.start, z.end, zset.Len()); ok {
+		removed = zset.DelRangeByRank(start, end)
+	}
+
+	z.result = protocol.NewRedisInteger(int64(removed))
+}
+
+func (z *zremRangeCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zremRangeCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zremRangeCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zremRangeCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zremRangeCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zremRangeCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zremRangeCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zremRangeCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zpopCommand is ZPOPMIN and ZPOPMAX
+type zpopCommand struct {
+	name         string
+	key          string
+	index        int
+	max          bool
+	count        int
+	accessObject container.ContainerObject
+	result       protocol.RedisArray
+	err          error
+}
+
+func (z *zpopCommand) Name() string {
+	return z.name
+}
+
+func (z *zpopCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) != 1 && len(objects) != 2 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+	z.count = 1
+
+	if len(strs) == 2 {
+		if z.count, err = strconv.Atoi(strs[1]); err != nil || z.count < 0 {
+			return ErrArgumentInvalid
+		}
+	}
+
+	return nil
+}
+
+func (z *zpopCommand) Execute() {
+	if z.accessObject == nil {
+		z.result = newEntriesReply(nil, nil, true)
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+
+	var entries []*container.StringContainer
+	var scores []float64
+
+	if z.max {
+		entries, scores = zset.PopMax(z.count)
+	} else {
+		entries, scores = zset.PopMin(z.count)
+	}
+
+	z.result = newEntriesReply(entries, scores, true)
+}
+
+func (z *zpopCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zpopCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zpopCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zpopCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zpopCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zpopCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zpopCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zpopCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
+
+// zstoreCommand is ZUNIONSTORE and ZINTERSTORE, the sources can be sets whose members are scored 1.
+// It works on the keyspace since the destination is overwritten whatever it holds.
+type zstoreCommand struct {
+	name         string
+	destination  string
+	sources      []string
+	index        int
+	union        bool
+	weights      []float64
+	aggregate    string
+	accessObject container.ContainerObject
+	result       protocol.RedisInteger
+	err          error
+}
+
+func (z *zstoreCommand) Name() string {
+	return z.name
+}
+
+func (z *zstoreCommand) ParseArguments(objects []protocol.RedisObject) error {
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	if len(strs) < 3 {
+		return ErrArgumentInvalid
+	}
+
+	z.destination = strs[0]
+
+	numKeys, err := strconv.Atoi(strs[1])
+	if err != nil || numKeys < 1 || 2+numKeys > len(strs) {
+		return ErrArgumentInvalid
+	}
+
+	z.sources = strs[2 : 2+numKeys]
+	z.aggregate = "sum"
+
+	for idx := 2 + numKeys; idx < len(strs); idx++ {
+		switch strings.ToLower(strs[idx]) {
+		case "weights":
+			if idx+numKeys >= len(strs) {
+				return ErrSyntax
+			}
+
+			z.weights = nil
+			for offset := 1; offset <= numKeys; offset++ {
+				weight, err := parseScore(strs[idx+offset])
+				if err != nil {
+					return err
+				}
+
+				z.weights = append(z.weights, weight)
+			}
+
+			idx += numKeys
+		case "aggregate":
+			if idx+1 >= len(strs) {
+				return ErrSyntax
+			}
+
+			z.aggregate = strings.ToLower(strs[idx+1])
+			if z.aggregate != "sum" && z.aggregate != "min" && z.aggregate != "max" {
+				return ErrSyntax
+			}
+
+			idx++
+		default:
+			return ErrSyntax
+		}
+	}
+
+	return nil
+}
+
+// weightedEntries returns the scored entries of the source, it is nil if the key is not exist
+func (z *zstoreCommand) weightedEntries(keyspace container.Containers, key string, weight float64) (map[string]float64, error) {
+	obj := keyspace.Get(key)
+	if obj == nil {
+		return nil, nil
+	}
+
+	ret := make(map[string]float64)
+
+	switch obj.Type() {
+	case container.SortedSetType:
+		zset := obj.(container.SortedSetContainer)
+		entries, scores := zset.RangeByRank(0, zset.Len()-1)
+		for idx, entry := range entries {
+			ret[entry.String()] = scores[idx]
+		}
+	case container.SetType:
+		for _, member := range obj.(container.SetContainer).Members() {
+			ret[member.String()] = 1
+		}
+	default:
+		return nil, fmt.Errorf("zstore source type mismatch. key=%s, got=%d, err={%w}", key, obj.Type(), ErrWrongType)
+	}
+
+	for entry, score := range ret {
+		// 0 * inf gives 0 rather than NaN as redis does
+		if score = score * weight; math.IsNaN(score) {
+			score = 0
+		}
+
+		ret[entry] = score
+	}
+
+	return ret, nil
+}
+
+func (z *zstoreCommand) aggregateScore(lhs, rhs float64) float64 {
+	switch z.aggregate {
+	case "min":
+		return math.Min(lhs, rhs)
+	case "max":
+		return math.Max(lhs, rhs)
+	}
+
+	if ret := lhs + rhs; !math.IsNaN(ret) {
+		return ret
+	}
+
+	return 0
+}
+
+func (z *zstoreCommand) Execute() {
+	if z.accessObject == nil {
+		z.err = fmt.Errorf("nil access object")
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	keyspace := z.accessObject.(container.Containers)
+
+	var sources []map[string]float64
+	for idx, key := range z.sources {
+		weight := 1.0
+		if z.weights != nil {
+			weight = z.weights[idx]
+		}
+
+		source, err := z.weightedEntries(keyspace, key, weight)
+		if err != nil {
+			z.err = err
+			return
+		}
+
+		sources = append(sources, source)
+	}
+
+	result := make(map[string]float64)
+
+	if z.union {
+		for _, source := range sources {
+			for entry, score := range source {
+				if current, ok := result[entry]; ok {
+					result[entry] = z.aggregateScore(current, score)
+				} else {
+					result[entry] = score
+				}
+			}
+		}
+	} else {
+	Entries:
+		for entry, score := range sources[0] {
+			for _, source := range sources[1:] {
+				other, ok := source[entry]
+				if !ok {
+					continue Entries
+				}
+
+				score = z.aggregateScore(score, other)
+			}
+
+			result[entry] = score
+		}
+	}
+
+	keyspace.Remove(z.destination)
+
+	if len(result) > 0 {
+		var entries []*container.StringContainer
+		var scores []float64
+
+		for entry, score := range result {
+			entries = append(entries, container.NewString(entry))
+			scores = append(scores, score)
+		}
+
+		_, _ = keyspace.GetOrCreateSortedSet(z.destination).Add(scores, entries)
+	}
+
+	z.result = protocol.NewRedisInteger(int64(len(result)))
+}
+
+func (z *zstoreCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zstoreCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zstoreCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zstoreCommand) Type() CommandType {
+	return ModifyCommandType
+}
+
+func (z *zstoreCommand) Keys() []string {
+	return append([]string{z.destination}, z.sources...)
+}
+
+func (z *zstoreCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zstoreCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zstoreCommand) TargetContainerType() container.ContainerType {
+	return container.KeyspaceType
+}
+
+// zrandmemberCommand is ZRANDMEMBER key [count [WITHSCORES]], a negative count allows repeated members
+type zrandmemberCommand struct {
+	key          string
+	index        int
+	count        int
+	hasCount     bool
+	withScores   bool
+	accessObject container.ContainerObject
+	result       protocol.RedisObject
+	err          error
+}
+
+func (z *zrandmemberCommand) Name() string {
+	return "zrandmember"
+}
+
+func (z *zrandmemberCommand) ParseArguments(objects []protocol.RedisObject) error {
+	if len(objects) < 1 || len(objects) > 3 {
+		return ErrArgumentInvalid
+	}
+
+	strs, err := parseStrings(objects)
+	if err != nil {
+		return err
+	}
+
+	z.key = strs[0]
+
+	if len(strs) >= 2 {
+		if z.count, err = strconv.Atoi(strs[1]); err != nil {
+			return ErrArgumentInvalid
+		}
+
+		z.hasCount = true
+	}
+
+	if len(strs) == 3 {
+		if strings.ToLower(strs[2]) != "withscores" {
+			return ErrSyntax
+		}
+
+		z.withScores = true
+	}
+
+	return nil
+}
+
+func (z *zrandmemberCommand) Execute() {
+	if z.accessObject == nil {
+		if z.hasCount {
+			z.result = newEntriesReply(nil, nil, false)
+		} else {
+			z.result = protocol.NewNullBulkRedisString()
+		}
+		return
+	}
+
+	if z.accessObject.Type() != z.TargetContainerType() {
+		z.err = fmt.Errorf("target container type mismatch. expected=%d, got=%d, err={%w}", z.TargetContainerType(), z.accessObject.Type(), ErrWrongType)
+		return
+	}
+
+	zset := z.accessObject.(container.SortedSetContainer)
+
+	if !z.hasCount {
+		entries, _ := zset.RandomMembers(1)
+		z.result = protocol.NewBulkRedisString(entries[0].String())
+		return
+	}
+
+	entries, scores := zset.RandomMembers(z.count)
+	z.result = newEntriesReply(entries, scores, z.withScores)
+}
+
+func (z *zrandmemberCommand) Result() (protocol.RedisObject, error) {
+	return z.result, z.err
+}
+
+func (z *zrandmemberCommand) Cluster() int {
+	return z.index
+}
+
+func (z *zrandmemberCommand) ToLog() string {
+	panic("implement me")
+}
+
+func (z *zrandmemberCommand) Type() CommandType {
+	return AccessCommandType
+}
+
+func (z *zrandmemberCommand) Keys() []string {
+	return []string{z.key}
+}
+
+func (z *zrandmemberCommand) ShouldCreate() bool {
+	return false
+}
+
+func (z *zrandmemberCommand) SetAccessObjects(objects []container.ContainerObject) {
+	if len(objects) == 0 {
+		return
+	}
+	z.accessObject = objects[0]
+}
+
+func (z *zrandmemberCommand) TargetContainerType() container.ContainerType {
+	return container.SortedSetType
+}
@@ -6,27 +6,120 @@ import (
 	"github.com/pelletier/go-toml"
 )
 
+// Version is the version of vertex, which is reported to the clients
+const Version = "0.1.0"
+
+// DefaultDatabases is the count of the dbs if it is not configured
+const DefaultDatabases = 16
+
+// DefaultOutputBufferLimit is the max bytes of the pending messages and responses of a subscribed client,
+// the client is disconnected if it reads too slow to keep under the limit. The normal clients are not limited.
+const DefaultOutputBufferLimit = 32 * 1024 * 1024
+
+// The default limits of a request, a client sending a request over them is disconnected
+const (
+	DefaultProtoMaxBulkLen = 512 * 1024 * 1024
+	DefaultMaxMultibulkLen = 1024 * 1024
+	DefaultMaxInlineSize   = 64 * 1024
+)
+
+// DefaultAppendFsync is the fsync policy of the database file if it is not configured, the file is
+// committed once a second
+const DefaultAppendFsync = "everysec"
+
+// The default triggers of the automatic rewrite of the database file, it is rewritten once it grows by the
+// percentage since the last rewrite and is larger than the min size
+const (
+	DefaultAutoAOFRewritePercentage = 100
+	DefaultAutoAOFRewriteMinSize    = 64 * 1024 * 1024
+)
+
+// DefaultReplBacklogSize is the bytes of the replication stream kept for the replicas to resume from, if it
+// is not configured
+const DefaultReplBacklogSize = 1024 * 1024
+
+// DefaultMinReplicasMaxLag is the seconds since the last acknowledgement of a replica within which it is
+// counted by min_replicas_to_write, if it is not configured
+const DefaultMinReplicasMaxLag = 10
+
 // Config is a simple struct that contains all necessary options.
 type Config struct {
-	LogPath       string `toml:"log_path"`
-	LogLevel      string `toml:"log_level"`
-	Port          int    `toml:"port"`
-	DatabaseFile  string `toml:"database_file"`
-	EnableReplica bool   `toml:"enable_replica"`
-	ReplicaPort   int    `toml:"replica_port"`
-	MasterAddress string `toml:"master_address"`
+	LogPath           string `toml:"log_path"`
+	LogLevel          string `toml:"log_level"`
+	Port              int    `toml:"port"`
+	DatabaseFile      string `toml:"database_file"`
+	EnableReplica     bool   `toml:"enable_replica"`
+	ReplicaPort       int    `toml:"replica_port"`
+	MasterAddress     string `toml:"master_address"`
+	ReplBacklogSize   int    `toml:"repl_backlog_size"`
+	Databases         int    `toml:"databases"`
+	OutputBufferLimit int    `toml:"output_buffer_limit"`
+	ProtoMaxBulkLen   int64  `toml:"proto_max_bulk_len"`
+	MaxMultibulkLen   int64  `toml:"max_multibulk_len"`
+	MaxInlineSize     int    `toml:"max_inline_size"`
+	AppendFsync       string `toml:"appendfsync"`
+
+	// AOFLoadTruncated truncates the torn tail of the database file at startup, the server refuses to start
+	// with it otherwise
+	AOFLoadTruncated bool `toml:"aof_load_truncated"`
+
+	AutoAOFRewritePercentage int   `toml:"auto_aof_rewrite_percentage"`
+	AutoAOFRewriteMinSize    int64 `toml:"auto_aof_rewrite_min_size"`
+
+	SnapshotFile string `toml:"snapshot_file"`
+
+	// ImportRDBFile is a rdb file of redis loaded at startup, and ExportRDBFile is the rdb file the dataset
+	// is written into when the server stops
+	ImportRDBFile string `toml:"import_rdb_file"`
+	ExportRDBFile string `toml:"export_rdb_file"`
+
+	// MinReplicasToWrite refuses the modifications on the master unless the count of the replicas have
+	// acknowledged within MinReplicasMaxLag seconds, 0 disables it
+	MinReplicasToWrite int `toml:"min_replicas_to_write"`
+	MinReplicasMaxLag  int `toml:"min_replicas_max_lag"`
+
+	// RaftNodeID enables the raft mode, the modifications are replicated by the raft cluster of RaftPeers
+	// instead of the database file and the replicas. A peer is "id raft_address client_address", and the
+	// node itself is one of them. The raft state of the node is stored in RaftDir.
+	RaftNodeID string   `toml:"raft_node_id"`
+	RaftPeers  []string `toml:"raft_peers"`
+	RaftDir    string   `toml:"raft_dir"`
 }
 
 // NewConfig will return a config instance with default value
 func NewConfig() *Config {
 	return &Config{
-		LogPath:       "./log/vertex.log",
-		LogLevel:      "INFO",
-		Port:          8081,
-		DatabaseFile:  "",
-		EnableReplica: false,
-		ReplicaPort:   0,
-		MasterAddress: "",
+		LogPath:           "./log/vertex.log",
+		LogLevel:          "INFO",
+		Port:              8081,
+		DatabaseFile:      "",
+		EnableReplica:     false,
+		ReplicaPort:       0,
+		MasterAddress:     "",
+		ReplBacklogSize:   DefaultReplBacklogSize,
+		Databases:         DefaultDatabases,
+		OutputBufferLimit: DefaultOutputBufferLimit,
+		ProtoMaxBulkLen:   DefaultProtoMaxBulkLen,
+		MaxMultibulkLen:   DefaultMaxMultibulkLen,
+		MaxInlineSize:     DefaultMaxInlineSize,
+		AppendFsync:       DefaultAppendFsync,
+
+		AOFLoadTruncated: true,
+
+		AutoAOFRewritePercentage: DefaultAutoAOFRewritePercentage,
+		AutoAOFRewriteMinSize:    DefaultAutoAOFRewriteMinSize,
+
+		SnapshotFile: "",
+
+		ImportRDBFile: "",
+		ExportRDBFile: "",
+
+		MinReplicasToWrite: 0,
+		MinReplicasMaxLag:  DefaultMinReplicasMaxLag,
+
+		RaftNodeID: "",
+		RaftPeers:  nil,
+		RaftDir:    "./raft",
 	}
 }
 
@@ -1,5 +1,7 @@
 package container
 
+import "github.com/lxdlam/vertex/pkg/util"
+
 type ContainerType int
 
 const (
@@ -10,18 +12,49 @@ const (
 	HashType
 	SetType
 	SortedSetType
+	KeyspaceType
 	ContainerTypeLength
 )
 
+// randomKeyRetry is the max tries of RandomKey to skip the expired keys
+const randomKeyRetry = 100
+
 type ContainerObject interface {
 	isContainer()
 	Key() string
 	Type() ContainerType
 }
 
+// TypeName returns the name of the type which the TYPE command reports
+func TypeName(t ContainerType) string {
+	switch t {
+	case StringType:
+		return "string"
+	case LinkedListType:
+		return "list"
+	case HashType:
+		return "hash"
+	case SetType:
+		return "set"
+	case SortedSetType:
+		return "zset"
+	}
+
+	return "none"
+}
+
+// Containers is the keyspace of a db. It is also a ContainerObject with KeyspaceType so the commands
+// that work on keys rather than values, e.g., EXPIRE and TTL, can access it directly.
+//
+// A key is held by exactly one container, the typed getters return nil if the key holds another type.
 type Containers interface {
+	ContainerObject
+
 	Global() StringMap
 
+	// Get returns the container holding the key regardless of its type, nil if the key is not exist.
+	Get(string) ContainerObject
+
 	GetList(string) ListContainer
 	GetOrCreateList(string) ListContainer
 
@@ -31,36 +64,124 @@ type Containers interface {
 	GetSet(string) SetContainer
 	GetOrCreateSet(string) SetContainer
 
-	//GetSortedSet(string) SortedSetContainer
-	//GetOrCreateSortedSet(string) SortedSetContainer
+	GetSortedSet(string) SortedSetContainer
+	GetOrCreateSortedSet(string) SortedSetContainer
+
+	// Exists reports if the key is held by any container.
+	Exists(string) bool
+
+	// Remove will delete the key from the keyspace along with its deadline.
+	Remove(string) bool
+
+	// RemoveIfEmpty will delete the key if it holds an empty list, hash, set or sorted set.
+	RemoveIfEmpty(string) bool
+
+	// Rename moves the container and the deadline of the key to the new key, the new key is overwritten.
+	Rename(string, string) error
+
+	// Keys returns all keys that are not expired and match the glob-style pattern.
+	Keys(string) []string
+
+	// RandomKey returns a key that is not expired, false if the keyspace is empty.
+	RandomKey() (string, bool)
+
+	// Scan returns about count keys that are not expired from the cursor, and the next cursor. A scan
+	// starts and ends with cursor 0, the keys present for the whole scan are returned at least once.
+	Scan(uint64, int) ([]string, uint64)
+
+	// Len returns the count of the keys, including the expired ones that are not removed yet.
+	Len() int
+
+	// SetDeadline sets the deadline of the key in unix milliseconds, false if the key is not exist.
+	SetDeadline(string, int64) bool
+
+	// Deadline returns the deadline of the key, false if the key has no deadline.
+	Deadline(string) (int64, bool)
+
+	// Persist removes the deadline of the key, false if the key has no deadline.
+	Persist(string) bool
+
+	// ExpireIfNeeded removes the key if its deadline has passed, true if the key is expired. The key is
+	// left in place if the expired keys are kept.
+	ExpireIfNeeded(string) bool
+
+	// ActiveExpire samples at most the given count of keys with deadline and removes the expired ones.
+	// It returns the sampled and the removed count, nothing is sampled if the expired keys are kept.
+	ActiveExpire(int) (int, int)
+
+	// OnExpire sets the function called with every key removed since its deadline has passed, it is kept
+	// by Swap and Flush.
+	OnExpire(func(string))
+
+	// KeepExpired sets whether the expired keys are kept until they are removed explicitly, e.g., by the
+	// DEL of the master of a replica. It is kept by Swap and Flush.
+	KeepExpired(bool)
+
+	// HideExpired sets whether the expired keys are missing for the lookups, so a kept one is never seen
+	// by the requests of the clients.
+	HideExpired(bool)
+
+	// Move moves the key along with its deadline to the target keyspace, false if the key is not exist
+	// or the target already holds the key.
+	Move(string, Containers) bool
+
+	// Swap exchanges all the keys with the other keyspace.
+	Swap(Containers)
+
+	// Flush removes all keys.
+	Flush()
 }
 
 type containers struct {
-	global     StringMap
-	lists      map[string]ListContainer
-	hashes     map[string]HashContainer
-	sets       map[string]SetContainer
-	sortedSets map[string]SortedSetContainer
+	global      StringMap
+	objects     *dict
+	deadlines   map[string]int64
+	onExpire    func(string)
+	keepExpired bool
+	hideExpired bool
 }
 
 // NewContainers will return a new container that includes all (key, data structures) mapping
 // for db to use
 func NewContainers() Containers {
-	return &containers{
-		global:     NewStringMap(),
-		lists:      make(map[string]ListContainer),
-		hashes:     make(map[string]HashContainer),
-		sets:       make(map[string]SetContainer),
-		sortedSets: make(map[string]SortedSetContainer),
+	objects := newDict()
+
+	c := &containers{
+		global:    newStringMapOn(objects),
+		objects:   objects,
+		deadlines: make(map[string]int64),
 	}
+	objects.hidden = c.hidden
+
+	return c
+}
+
+func (c *containers) isContainer() {}
+
+func (c *containers) Key() string {
+	return "keyspace"
+}
+
+func (c *containers) Type() ContainerType {
+	return KeyspaceType
 }
 
 func (c *containers) Global() StringMap {
 	return c.global
 }
 
+func (c *containers) Get(key string) ContainerObject {
+	obj, ok := c.objects.get(key)
+
+	if !ok {
+		return nil
+	}
+
+	return obj.(ContainerObject)
+}
+
 func (c *containers) GetList(key string) ListContainer {
-	l, ok := c.lists[key]
+	l, ok := c.Get(key).(ListContainer)
 
 	if !ok {
 		return nil
@@ -74,18 +195,15 @@ func (c *containers) GetOrCreateList(key string) ListContainer {
 		return nil
 	}
 
-	l, ok := c.lists[key]
-
-	if !ok {
-		l = NewLinkedListContainer(key)
-		c.lists[key] = l
+	if !c.Exists(key) {
+		c.objects.set(key, NewLinkedListContainer(key))
 	}
 
-	return l
+	return c.GetList(key)
 }
 
 func (c *containers) GetHash(key string) HashContainer {
-	h, ok := c.hashes[key]
+	h, ok := c.Get(key).(HashContainer)
 
 	if !ok {
 		return nil
@@ -99,18 +217,15 @@ func (c *containers) GetOrCreateHash(key string) HashContainer {
 		return nil
 	}
 
-	h, ok := c.hashes[key]
-
-	if !ok {
-		h = NewHashContainer(key)
-		c.hashes[key] = h
+	if !c.Exists(key) {
+		c.objects.set(key, NewHashContainer(key))
 	}
 
-	return h
+	return c.GetHash(key)
 }
 
 func (c *containers) GetSet(key string) SetContainer {
-	s, ok := c.sets[key]
+	s, ok := c.Get(key).(SetContainer)
 
 	if !ok {
 		return nil
@@ -124,34 +239,266 @@ func (c *containers) GetOrCreateSet(key string) SetContainer {
 		return nil
 	}
 
-	s, ok := c.sets[key]
+	if !c.Exists(key) {
+		c.objects.set(key, NewSetContainer(key))
+	}
+
+	return c.GetSet(key)
+}
+
+func (c *containers) GetSortedSet(key string) SortedSetContainer {
+	s, ok := c.Get(key).(SortedSetContainer)
 
 	if !ok {
-		s = NewSetContainer(key)
-		c.sets[key] = s
+		return nil
 	}
 
 	return s
 }
 
-//func (c *containers) GetSortedSet(key string) SortedSetContainer {
-//	s, ok := c.sets[key]
-//
-//	if !ok {
-//		s = NewSetContainer(key)
-//		c.sets[key] = s
-//	}
-//
-//	return s
-//}
-//
-//func (c *containers) GetOrCreateSortedSet(key string) SortedSetContainer {
-//	s, ok := c.sets[key]
-//
-//	if !ok {
-//		s = NewSortedSetContainer(key)
-//		c.sets[key] = s
-//	}
-//
-//	return s
-//}
+func (c *containers) GetOrCreateSortedSet(key string) SortedSetContainer {
+	if key == "" {
+		return nil
+	}
+
+	if !c.Exists(key) {
+		c.objects.set(key, NewSortedSetContainer(key))
+	}
+
+	return c.GetSortedSet(key)
+}
+
+func (c *containers) Exists(key string) bool {
+	_, ok := c.objects.get(key)
+	return ok
+}
+
+func (c *containers) Remove(key string) bool {
+	delete(c.deadlines, key)
+
+	return c.objects.remove(key)
+}
+
+func (c *containers) RemoveIfEmpty(key string) bool {
+	obj := c.Get(key)
+	if obj == nil || obj.Type() == StringType {
+		return false
+	}
+
+	if sized, ok := obj.(interface{ Len() int }); ok && sized.Len() == 0 {
+		return c.Remove(key)
+	}
+
+	return false
+}
+
+func (c *containers) Rename(key, newKey string) error {
+	obj := c.Get(key)
+	if obj == nil {
+		return ErrKeyNotFound
+	}
+
+	if key == newKey {
+		return nil
+	}
+
+	deadline, hasDeadline := c.deadlines[key]
+
+	c.Remove(key)
+	c.Remove(newKey)
+
+	c.objects.set(newKey, obj)
+	if hasDeadline {
+		c.deadlines[newKey] = deadline
+	}
+
+	return nil
+}
+
+func (c *containers) isExpired(key string, now int64) bool {
+	deadline, ok := c.deadlines[key]
+	return ok && deadline <= now
+}
+
+func (c *containers) Keys(pattern string) []string {
+	var ret []string
+	now := util.UnixMilli()
+
+	c.objects.each(func(key string, _ interface{}) bool {
+		if !c.isExpired(key, now) && util.GlobMatch(pattern, key) {
+			ret = append(ret, key)
+		}
+
+		return true
+	})
+
+	return ret
+}
+
+func (c *containers) RandomKey() (string, bool) {
+	for retry := 0; retry < randomKeyRetry; retry++ {
+		key, _, ok := c.objects.random()
+		if !ok {
+			break
+		}
+
+		if !c.ExpireIfNeeded(key) {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+func (c *containers) Scan(cursor uint64, count int) ([]string, uint64) {
+	var ret, expired []string
+	now := util.UnixMilli()
+
+	cursor = c.objects.scanCount(cursor, count, func(key string, _ interface{}) {
+		if c.isExpired(key, now) {
+			expired = append(expired, key)
+		} else {
+			ret = append(ret, key)
+		}
+	})
+
+	// the dict cannot be modified while scanning
+	for _, key := range expired {
+		c.expire(key)
+	}
+
+	return ret, cursor
+}
+
+func (c *containers) Len() int {
+	return c.objects.count()
+}
+
+func (c *containers) SetDeadline(key string, deadline int64) bool {
+	if !c.Exists(key) {
+		return false
+	}
+
+	c.deadlines[key] = deadline
+	return true
+}
+
+func (c *containers) Deadline(key string) (int64, bool) {
+	deadline, ok := c.deadlines[key]
+	return deadline, ok
+}
+
+func (c *containers) Persist(key string) bool {
+	if _, ok := c.deadlines[key]; !ok {
+		return false
+	}
+
+	delete(c.deadlines, key)
+	return true
+}
+
+func (c *containers) ExpireIfNeeded(key string) bool {
+	if !c.isExpired(key, util.UnixMilli()) {
+		return false
+	}
+
+	c.expire(key)
+	return true
+}
+
+func (c *containers) ActiveExpire(count int) (int, int) {
+	if c.keepExpired {
+		return 0, 0
+	}
+
+	var expired []string
+	sampled := 0
+	now := util.UnixMilli()
+
+	// go map iteration order is randomized, so it is already a random sample
+	for key := range c.deadlines {
+		if sampled >= count {
+			break
+		}
+
+		sampled++
+		if c.isExpired(key, now) {
+			expired = append(expired, key)
+		}
+	}
+
+	for _, key := range expired {
+		c.expire(key)
+	}
+
+	return sampled, len(expired)
+}
+
+func (c *containers) OnExpire(f func(string)) {
+	c.onExpire = f
+}
+
+func (c *containers) KeepExpired(keep bool) {
+	c.keepExpired = keep
+}
+
+func (c *containers) HideExpired(hide bool) {
+	c.hideExpired = hide
+}
+
+// hidden reports if the key is missing for the lookups of the dict
+func (c *containers) hidden(key string) bool {
+	return c.hideExpired && c.isExpired(key, util.UnixMilli())
+}
+
+// expire removes the expired key and reports it, unless the expired keys are kept
+func (c *containers) expire(key string) {
+	if c.keepExpired {
+		return
+	}
+
+	c.Remove(key)
+
+	if c.onExpire != nil {
+		c.onExpire(key)
+	}
+}
+
+func (c *containers) Move(key string, target Containers) bool {
+	t := target.(*containers)
+
+	obj := c.Get(key)
+	if obj == nil || t == c || t.Exists(key) {
+		return false
+	}
+
+	deadline, hasDeadline := c.deadlines[key]
+	c.Remove(key)
+
+	t.objects.set(key, obj)
+	if hasDeadline {
+		t.deadlines[key] = deadline
+	}
+
+	return true
+}
+
+func (c *containers) Swap(other Containers) {
+	o := other.(*containers)
+
+	// the global string map shares the dict of its keyspace, so they are swapped together, while the
+	// expire settings stay with the keyspaces
+	onExpire, otherOnExpire := c.onExpire, o.onExpire
+	keep, otherKeep := c.keepExpired, o.keepExpired
+	*c, *o = *o, *c
+	c.onExpire, o.onExpire = onExpire, otherOnExpire
+	c.keepExpired, o.keepExpired = keep, otherKeep
+	c.objects.hidden, o.objects.hidden = c.hidden, o.hidden
+}
+
+func (c *containers) Flush() {
+	onExpire, keep := c.onExpire, c.keepExpired
+	*c = *NewContainers().(*containers)
+	c.onExpire, c.keepExpired = onExpire, keep
+	c.objects.hidden = c.hidden
+}
@@ -0,0 +1,368 @@
+package container
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lxdlam/vertex/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContainersDeadline(t *testing.T) {
+	c := NewContainers()
+
+	assert.False(t, c.SetDeadline("missing", util.UnixMilli()+1000))
+
+	_ = c.Global().Set([]*StringContainer{NewString("key")}, []*StringContainer{NewString("value")})
+	_, _ = c.GetOrCreateList("list").PushTail([]*StringContainer{NewString("item")})
+
+	_, ok := c.Deadline("key")
+	assert.False(t, ok)
+
+	deadline := util.UnixMilli() + 100000
+	assert.True(t, c.SetDeadline("key", deadline))
+	assert.True(t, c.SetDeadline("list", deadline))
+
+	ret, ok := c.Deadline("key")
+	assert.True(t, ok)
+	assert.Equal(t, deadline, ret)
+
+	assert.True(t, c.Persist("key"))
+	assert.False(t, c.Persist("key"))
+
+	_, ok = c.Deadline("key")
+	assert.False(t, ok)
+
+	assert.False(t, c.ExpireIfNeeded("list"))
+	assert.True(t, c.Exists("list"))
+}
+
+func TestContainersExpireIfNeeded(t *testing.T) {
+	c := NewContainers()
+
+	_ = c.Global().Set([]*StringContainer{NewString("key")}, []*StringContainer{NewString("value")})
+	c.GetOrCreateHash("hash")
+
+	assert.True(t, c.SetDeadline("key", util.UnixMilli()-1))
+	assert.True(t, c.SetDeadline("hash", util.UnixMilli()-1))
+
+	assert.True(t, c.ExpireIfNeeded("key"))
+	assert.True(t, c.ExpireIfNeeded("hash"))
+	assert.False(t, c.ExpireIfNeeded("key"))
+
+	assert.False(t, c.Exists("key"))
+	assert.False(t, c.Exists("hash"))
+	assert.Nil(t, c.GetHash("hash"))
+
+	_, ok := c.Deadline("key")
+	assert.False(t, ok)
+}
+
+func TestContainersActiveExpire(t *testing.T) {
+	c := NewContainers()
+
+	for idx := 0; idx < 100; idx++ {
+		key := fmt.Sprintf("key%d", idx)
+		_ = c.Global().Set([]*StringContainer{NewString(key)}, []*StringContainer{NewString("value")})
+
+		if idx%2 == 0 {
+			c.SetDeadline(key, util.UnixMilli()-1)
+		} else {
+			c.SetDeadline(key, util.UnixMilli()+100000)
+		}
+	}
+
+	sampled, expired := c.ActiveExpire(20)
+	assert.Equal(t, 20, sampled)
+	assert.True(t, expired <= sampled)
+
+	removed := expired
+	for removed < 50 {
+		_, expired = c.ActiveExpire(100)
+		removed += expired
+	}
+
+	assert.Equal(t, 50, removed)
+	assert.Equal(t, 50, c.Global().Len())
+
+	sampled, expired = c.ActiveExpire(100)
+	assert.Equal(t, 50, sampled)
+	assert.Equal(t, 0, expired)
+}
+
+func TestContainersOnExpire(t *testing.T) {
+	c := NewContainers()
+
+	var expired []string
+	c.OnExpire(func(key string) {
+		expired = append(expired, key)
+	})
+
+	for _, key := range []string{"lazy", "active", "scan", "alive"} {
+		_ = c.Global().Set([]*StringContainer{NewString(key)}, []*StringContainer{NewString("value")})
+		c.SetDeadline(key, util.UnixMilli()-1)
+	}
+	c.SetDeadline("alive", util.UnixMilli()+100000)
+
+	assert.True(t, c.ExpireIfNeeded("lazy"))
+	assert.False(t, c.ExpireIfNeeded("alive"))
+	assert.True(t, c.Remove("active"))
+	assert.Equal(t, []string{"lazy"}, expired)
+
+	_, _ = c.Scan(0, 10)
+	assert.Equal(t, []string{"lazy", "scan"}, expired)
+
+	// the function stays with the keyspace
+	other := NewContainers()
+	_ = other.Global().Set([]*StringContainer{NewString("swapped")}, []*StringContainer{NewString("value")})
+	other.SetDeadline("swapped", util.UnixMilli()-1)
+	c.Swap(other)
+
+	_, removed := c.ActiveExpire(10)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, []string{"lazy", "scan", "swapped"}, expired)
+
+	c.Flush()
+	_ = c.Global().Set([]*StringContainer{NewString("flushed")}, []*StringContainer{NewString("value")})
+	c.SetDeadline("flushed", util.UnixMilli()-1)
+	assert.True(t, c.ExpireIfNeeded("flushed"))
+	assert.Equal(t, []string{"lazy", "scan", "swapped", "flushed"}, expired)
+}
+
+func TestContainersKeepExpired(t *testing.T) {
+	c := NewContainers()
+	c.KeepExpired(true)
+
+	var expired []string
+	c.OnExpire(func(key string) {
+		expired = append(expired, key)
+	})
+
+	for _, key := range []string{"expired", "alive"} {
+		_ = c.Global().Set([]*StringContainer{NewString(key)}, []*StringContainer{NewString("value")})
+	}
+	c.SetDeadline("expired", util.UnixMilli()-1)
+
+	// reported but kept
+	assert.True(t, c.ExpireIfNeeded("expired"))
+	sampled, removed := c.ActiveExpire(10)
+	assert.Equal(t, 0, sampled)
+	assert.Equal(t, 0, removed)
+	keys, _ := c.Scan(0, 10)
+	assert.Equal(t, []string{"alive"}, keys)
+	_, ok := c.RandomKey()
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.Len())
+	assert.True(t, c.Exists("expired"))
+	assert.Empty(t, expired)
+
+	// missing for the lookups once hidden
+	c.HideExpired(true)
+	assert.False(t, c.Exists("expired"))
+	assert.Nil(t, c.Get("expired"))
+	assert.Equal(t, []*StringContainer{nil}, c.Global().Get([]*StringContainer{NewString("expired")}))
+	assert.True(t, c.Exists("alive"))
+	c.HideExpired(false)
+
+	// the settings stay with the keyspace
+	other := NewContainers()
+	_ = other.Global().Set([]*StringContainer{NewString("swapped")}, []*StringContainer{NewString("value")})
+	other.SetDeadline("swapped", util.UnixMilli()-1)
+	c.Swap(other)
+
+	assert.True(t, c.ExpireIfNeeded("swapped"))
+	assert.True(t, c.Exists("swapped"))
+	assert.True(t, other.ExpireIfNeeded("expired"))
+	assert.False(t, other.Exists("expired"))
+	assert.Empty(t, expired)
+
+	c.HideExpired(true)
+	assert.False(t, c.Exists("swapped"))
+	c.HideExpired(false)
+
+	c.Flush()
+	_ = c.Global().Set([]*StringContainer{NewString("flushed")}, []*StringContainer{NewString("value")})
+	c.SetDeadline("flushed", util.UnixMilli()-1)
+	assert.True(t, c.ExpireIfNeeded("flushed"))
+	assert.True(t, c.Exists("flushed"))
+
+	// removed once the keyspace stops keeping them
+	c.KeepExpired(false)
+	assert.True(t, c.ExpireIfNeeded("flushed"))
+	assert.False(t, c.Exists("flushed"))
+	assert.Equal(t, []string{"flushed"}, expired)
+}
+
+func TestContainersRemove(t *testing.T) {
+	c := NewContainers()
+
+	c.GetOrCreateSet("set").Add([]*StringContainer{NewString("member")})
+	c.SetDeadline("set", util.UnixMilli()+100000)
+
+	assert.True(t, c.Remove("set"))
+	assert.False(t, c.Remove("set"))
+	assert.False(t, c.Exists("set"))
+
+	_, ok := c.Deadline("set")
+	assert.False(t, ok)
+}
+
+func TestContainersUnifiedKeyspace(t *testing.T) {
+	c := NewContainers()
+
+	_ = c.Global().Set([]*StringContainer{NewString("key")}, []*StringContainer{NewString("value")})
+
+	// a key holds exactly one type
+	assert.Nil(t, c.GetOrCreateList("key"))
+	assert.Nil(t, c.GetOrCreateHash("key"))
+	assert.Nil(t, c.GetOrCreateSet("key"))
+	assert.Equal(t, StringType, c.Get("key").Type())
+
+	c.GetOrCreateHash("hash")
+	assert.Nil(t, c.GetList("hash"))
+	assert.Equal(t, HashType, c.Get("hash").Type())
+
+	ret := c.Global().Get([]*StringContainer{NewString("key"), NewString("hash")})
+	assert.Equal(t, "value", ret[0].String())
+	assert.Nil(t, ret[1])
+
+	_, err := c.Global().Increase(NewString("hash"), 1)
+	assert.Equal(t, ErrWrongType, err)
+
+	// SET overwrites any type
+	_ = c.Global().Set([]*StringContainer{NewString("hash")}, []*StringContainer{NewString("value")})
+	assert.Equal(t, StringType, c.Get("hash").Type())
+	assert.Equal(t, 2, c.Global().Len())
+	assert.Equal(t, 2, c.Len())
+}
+
+func TestContainersRemoveIfEmpty(t *testing.T) {
+	c := NewContainers()
+
+	l := c.GetOrCreateList("list")
+	_, _ = l.PushTail([]*StringContainer{NewString("item")})
+	_ = c.Global().Set([]*StringContainer{NewString("empty")}, []*StringContainer{NewString("")})
+
+	assert.False(t, c.RemoveIfEmpty("list"))
+
+	_, _ = l.PopHead()
+	assert.True(t, c.RemoveIfEmpty("list"))
+	assert.False(t, c.Exists("list"))
+
+	// empty string is still a value
+	assert.False(t, c.RemoveIfEmpty("empty"))
+	assert.True(t, c.Exists("empty"))
+}
+
+func TestContainersRename(t *testing.T) {
+	c := NewContainers()
+
+	assert.Equal(t, ErrKeyNotFound, c.Rename("missing", "key"))
+
+	c.GetOrCreateSet("set").Add([]*StringContainer{NewString("member")})
+	c.GetOrCreateHash("hash")
+	deadline := util.UnixMilli() + 100000
+	c.SetDeadline("set", deadline)
+	c.SetDeadline("hash", deadline+1)
+
+	assert.Nil(t, c.Rename("set", "hash"))
+	assert.False(t, c.Exists("set"))
+	assert.Equal(t, SetType, c.Get("hash").Type())
+
+	ret, ok := c.Deadline("hash")
+	assert.True(t, ok)
+	assert.Equal(t, deadline, ret)
+
+	assert.Nil(t, c.Rename("hash", "hash"))
+	assert.True(t, c.Exists("hash"))
+}
+
+func TestContainersKeysAndRandomKey(t *testing.T) {
+	c := NewContainers()
+
+	_, ok := c.RandomKey()
+	assert.False(t, ok)
+
+	for _, key := range []string{"user:1", "user:2", "session:1"} {
+		_ = c.Global().Set([]*StringContainer{NewString(key)}, []*StringContainer{NewString("value")})
+	}
+
+	c.SetDeadline("user:2", util.UnixMilli()-1)
+
+	assert.ElementsMatch(t, []string{"user:1", "session:1"}, c.Keys("*"))
+	assert.ElementsMatch(t, []string{"user:1"}, c.Keys("user:*"))
+	assert.Equal(t, 3, c.Len())
+
+	for idx := 0; idx < 10; idx++ {
+		key, ok := c.RandomKey()
+		assert.True(t, ok)
+		assert.NotEqual(t, "user:2", key)
+	}
+}
+
+func TestContainersScan(t *testing.T) {
+	c := NewContainers()
+
+	for idx := 0; idx < 100; idx++ {
+		_ = c.Global().Set([]*StringContainer{NewString(fmt.Sprintf("key%d", idx))}, []*StringContainer{NewString("value")})
+	}
+
+	c.SetDeadline("key0", util.UnixMilli()-1)
+
+	seen := make(map[string]bool)
+	keys, cursor := c.Scan(0, 10)
+	for {
+		for _, key := range keys {
+			seen[key] = true
+		}
+
+		if cursor == 0 {
+			break
+		}
+
+		keys, cursor = c.Scan(cursor, 10)
+	}
+
+	assert.Equal(t, 99, len(seen))
+	assert.False(t, seen["key0"])
+	assert.False(t, c.Exists("key0"))
+}
+
+func TestContainersMoveSwapFlush(t *testing.T) {
+	src := NewContainers()
+	dst := NewContainers()
+
+	_ = src.Global().Set([]*StringContainer{NewString("key")}, []*StringContainer{NewString("value")})
+	src.GetOrCreateSet("set").Add([]*StringContainer{NewString("member")})
+	_ = dst.Global().Set([]*StringContainer{NewString("set")}, []*StringContainer{NewString("value")})
+
+	deadline := util.UnixMilli() + 100000
+	src.SetDeadline("key", deadline)
+
+	assert.True(t, src.Move("key", dst))
+	assert.False(t, src.Move("key", dst))
+	assert.False(t, src.Move("set", dst))
+	assert.False(t, src.Move("set", src))
+	assert.False(t, src.Exists("key"))
+	assert.Equal(t, "value", dst.Global().Get([]*StringContainer{NewString("key")})[0].String())
+
+	ret, ok := dst.Deadline("key")
+	assert.True(t, ok)
+	assert.Equal(t, deadline, ret)
+
+	src.Swap(dst)
+	assert.Equal(t, 2, src.Len())
+	assert.Equal(t, StringType, src.Get("set").Type())
+	assert.Equal(t, 2, src.Global().Len())
+	assert.Equal(t, SetType, dst.Get("set").Type())
+	assert.Equal(t, 0, dst.Global().Len())
+
+	src.Flush()
+	assert.Equal(t, 0, src.Len())
+	_, ok = src.Deadline("key")
+	assert.False(t, ok)
+
+	_ = src.Global().Set([]*StringContainer{NewString("key")}, []*StringContainer{NewString("value")})
+	assert.True(t, src.Exists("key"))
+	assert.Equal(t, 1, dst.Len())
+}
@@ -0,0 +1,229 @@
+package container
+
+import (
+	"hash/maphash"
+	"math/bits"
+
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+const (
+	// dictMinSize is the least bucket count of a dict, it must be a power of two
+	dictMinSize = 4
+
+	// dictShrinkRatio is the ratio of buckets to entries which causes the dict to shrink
+	dictShrinkRatio = 8
+)
+
+type dictEntry struct {
+	key   string
+	value interface{}
+	next  *dictEntry
+}
+
+// dict is a chained hash table with power of two buckets. Unlike the go map, it can be scanned by a
+// cursor, which guarantees that every entry present for the whole scan is returned at least once, even
+// if the dict is resized between the calls.
+//
+// The cursor is the reversed bucket index. Incrementing it from the highest bit visits the buckets in an
+// order that, when the table grows or shrinks, the buckets already visited are mapped to buckets which
+// are also already visited. It's the same algorithm used by the redis dictScan.
+type dict struct {
+	seed    maphash.Seed
+	buckets []*dictEntry
+	size    int
+
+	// hidden reports the keys missing for get, e.g., the expired keys kept in a keyspace, it may be nil
+	hidden func(string) bool
+}
+
+func newDict() *dict {
+	return &dict{
+		seed:    maphash.MakeSeed(),
+		buckets: make([]*dictEntry, dictMinSize),
+		size:    0,
+	}
+}
+
+func (d *dict) hash(key string) uint64 {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+	_, _ = h.WriteString(key)
+	return h.Sum64()
+}
+
+func (d *dict) mask() uint64 {
+	return uint64(len(d.buckets) - 1)
+}
+
+func (d *dict) find(key string) *dictEntry {
+	for entry := d.buckets[d.hash(key)&d.mask()]; entry != nil; entry = entry.next {
+		if entry.key == key {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+func (d *dict) get(key string) (interface{}, bool) {
+	if d.hidden != nil && d.hidden(key) {
+		return nil, false
+	}
+
+	if entry := d.find(key); entry != nil {
+		return entry.value, true
+	}
+
+	return nil, false
+}
+
+// set stores the value of the key, true if the key is newly added
+func (d *dict) set(key string, value interface{}) bool {
+	if entry := d.find(key); entry != nil {
+		entry.value = value
+		return false
+	}
+
+	idx := d.hash(key) & d.mask()
+	d.buckets[idx] = &dictEntry{
+		key:   key,
+		value: value,
+		next:  d.buckets[idx],
+	}
+	d.size++
+
+	if d.size > len(d.buckets) {
+		d.resize(len(d.buckets) * 2)
+	}
+
+	return true
+}
+
+func (d *dict) remove(key string) bool {
+	idx := d.hash(key) & d.mask()
+
+	for prev, entry := (*dictEntry)(nil), d.buckets[idx]; entry != nil; prev, entry = entry, entry.next {
+		if entry.key != key {
+			continue
+		}
+
+		if prev == nil {
+			d.buckets[idx] = entry.next
+		} else {
+			prev.next = entry.next
+		}
+
+		entry.next = nil
+		entry.value = nil
+		d.size--
+
+		if len(d.buckets) > dictMinSize && d.size*dictShrinkRatio < len(d.buckets) {
+			d.resize(len(d.buckets) / 2)
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (d *dict) count() int {
+	return d.size
+}
+
+func (d *dict) resize(n int) {
+	if n < dictMinSize {
+		n = dictMinSize
+	}
+
+	buckets := make([]*dictEntry, n)
+	mask := uint64(n - 1)
+
+	for _, entry := range d.buckets {
+		for entry != nil {
+			next := entry.next
+			idx := d.hash(entry.key) & mask
+			entry.next = buckets[idx]
+			buckets[idx] = entry
+			entry = next
+		}
+	}
+
+	d.buckets = buckets
+}
+
+// each calls fn on every entry until fn returns false. The dict must not be modified in fn.
+func (d *dict) each(fn func(string, interface{}) bool) {
+	for _, entry := range d.buckets {
+		for ; entry != nil; entry = entry.next {
+			if !fn(entry.key, entry.value) {
+				return
+			}
+		}
+	}
+}
+
+// scan calls fn on every entry in the bucket pointed by the cursor, and returns the next cursor.
+// 0 is returned if the whole dict is scanned. The dict must not be modified in fn.
+func (d *dict) scan(cursor uint64, fn func(string, interface{})) uint64 {
+	mask := d.mask()
+
+	for entry := d.buckets[cursor&mask]; entry != nil; entry = entry.next {
+		fn(entry.key, entry.value)
+	}
+
+	// set the unmasked bits so incrementing the reversed cursor operates on the masked bits
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	cursor = bits.Reverse64(cursor)
+
+	return cursor
+}
+
+// scanCount scans from the cursor until about count entries are returned, the count is just a hint.
+func (d *dict) scanCount(cursor uint64, count int, fn func(string, interface{})) uint64 {
+	emitted := 0
+
+	// avoid visiting too many empty buckets in a sparse dict
+	for iterations := count * 10; iterations > 0; iterations-- {
+		cursor = d.scan(cursor, func(key string, value interface{}) {
+			emitted++
+			fn(key, value)
+		})
+
+		if cursor == 0 || emitted >= count {
+			break
+		}
+	}
+
+	return cursor
+}
+
+// random returns a random entry, false if the dict is empty
+func (d *dict) random() (string, interface{}, bool) {
+	if d.size == 0 {
+		return "", nil, false
+	}
+
+	r := util.GetGlobalRandom()
+
+	// the load factor is kept above 1/dictShrinkRatio, so a non-empty bucket will be found soon
+	var head *dictEntry
+	for head == nil {
+		head = d.buckets[r.Intn(len(d.buckets))]
+	}
+
+	length := 0
+	for entry := head; entry != nil; entry = entry.next {
+		length++
+	}
+
+	entry := head
+	for idx := r.Intn(length); idx > 0; idx-- {
+		entry = entry.next
+	}
+
+	return entry.key, entry.value, true
+}
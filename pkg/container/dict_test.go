@@ -0,0 +1,98 @@
+package container
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	defaultDictTestCase = 1000
+)
+
+func TestDictBasicOperation(t *testing.T) {
+	d := newDict()
+
+	for idx := 0; idx < defaultDictTestCase; idx++ {
+		assert.True(t, d.set(fmt.Sprintf("key%d", idx), idx))
+	}
+
+	assert.False(t, d.set("key0", -1))
+	assert.Equal(t, defaultDictTestCase, d.count())
+	assert.True(t, len(d.buckets) >= defaultDictTestCase)
+
+	value, ok := d.get("key0")
+	assert.True(t, ok)
+	assert.Equal(t, -1, value)
+
+	_, ok = d.get("missing")
+	assert.False(t, ok)
+
+	for idx := 0; idx < defaultDictTestCase; idx++ {
+		assert.True(t, d.remove(fmt.Sprintf("key%d", idx)))
+	}
+
+	assert.False(t, d.remove("key0"))
+	assert.Equal(t, 0, d.count())
+	assert.Equal(t, dictMinSize, len(d.buckets))
+
+	_, _, ok = d.random()
+	assert.False(t, ok)
+}
+
+func TestDictScan(t *testing.T) {
+	d := newDict()
+
+	for idx := 0; idx < defaultDictTestCase; idx++ {
+		d.set(fmt.Sprintf("key%d", idx), idx)
+	}
+
+	seen := make(map[string]int)
+	cursor := uint64(0)
+	round := 0
+
+	for {
+		cursor = d.scanCount(cursor, 10, func(key string, _ interface{}) {
+			seen[key]++
+		})
+
+		// grow and shrink the dict during the scan, only the keys present for the whole scan are checked
+		round++
+		if round == 10 {
+			for idx := defaultDictTestCase; idx < 4*defaultDictTestCase; idx++ {
+				d.set(fmt.Sprintf("key%d", idx), idx)
+			}
+		} else if round == 30 {
+			for idx := 100; idx < 4*defaultDictTestCase; idx++ {
+				d.remove(fmt.Sprintf("key%d", idx))
+			}
+		}
+
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for idx := 0; idx < 100; idx++ {
+		assert.True(t, seen[fmt.Sprintf("key%d", idx)] > 0, "key%d", idx)
+	}
+}
+
+func TestDictRandom(t *testing.T) {
+	d := newDict()
+
+	for idx := 0; idx < 10; idx++ {
+		d.set(fmt.Sprintf("key%d", idx), idx)
+	}
+
+	seen := make(map[string]bool)
+	for idx := 0; idx < 1000; idx++ {
+		key, value, ok := d.random()
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprintf("key%d", value), key)
+		seen[key] = true
+	}
+
+	assert.Equal(t, 10, len(seen))
+}
@@ -2,6 +2,8 @@ package container
 
 import (
 	"errors"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
 var (
@@ -25,6 +27,12 @@ type HashContainer interface {
 	Values() []*StringContainer
 	Entries() ([]*StringContainer, []*StringContainer)
 
+	// WriteEntries writes the entries as a map into the writer, one at a time without building them
+	WriteEntries(protocol.RESPWriter) error
+
+	// Scan returns about count entries from the cursor, and the next cursor. 0 means the scan is finished.
+	Scan(uint64, int) ([]*StringContainer, []*StringContainer, uint64)
+
 	KeyLen(*StringContainer) (int, error)
 	Len() int
 }
@@ -34,17 +42,17 @@ type hashEntry struct {
 	value *StringContainer
 }
 
-// So the real hashes is just a raw go map container
+// So the real hashes is just a dict container, which can be scanned by a cursor
 type hashContainer struct {
 	key       string
-	container map[string]*hashEntry
+	container *dict
 }
 
 // NewHashContainer returns a new hash container
 func NewHashContainer(key string) HashContainer {
 	return &hashContainer{
 		key:       key,
-		container: make(map[string]*hashEntry),
+		container: newDict(),
 	}
 }
 
@@ -68,13 +76,13 @@ func (h *hashContainer) Set(keys []*StringContainer, values []*StringContainer)
 	before := h.Len()
 
 	for i := 0; i < l; i++ {
-		if entry, ok := h.container[keys[i].String()]; !ok {
-			h.container[keys[i].String()] = &hashEntry{
+		if entry, ok := h.container.get(keys[i].String()); !ok {
+			h.container.set(keys[i].String(), &hashEntry{
 				key:   keys[i],
 				value: values[i],
-			}
+			})
 		} else {
-			entry.value = values[i]
+			entry.(*hashEntry).value = values[i]
 		}
 	}
 
@@ -85,10 +93,10 @@ func (h *hashContainer) Get(keys []*StringContainer) []*StringContainer {
 	var ret []*StringContainer
 
 	for _, key := range keys {
-		if entry, ok := h.container[key.String()]; !ok {
+		if entry, ok := h.container.get(key.String()); !ok {
 			ret = append(ret, nil)
 		} else {
-			ret = append(ret, entry.value)
+			ret = append(ret, entry.(*hashEntry).value)
 		}
 	}
 
@@ -96,7 +104,7 @@ func (h *hashContainer) Get(keys []*StringContainer) []*StringContainer {
 }
 
 func (h *hashContainer) Exists(key *StringContainer) bool {
-	_, ret := h.container[key.String()]
+	_, ret := h.container.get(key.String())
 
 	return ret
 }
@@ -105,8 +113,7 @@ func (h *hashContainer) Del(keys []*StringContainer) int {
 	removed := 0
 
 	for _, key := range keys {
-		if _, ok := h.container[key.String()]; ok {
-			delete(h.container, key.String())
+		if h.container.remove(key.String()) {
 			removed++
 		}
 	}
@@ -117,9 +124,10 @@ func (h *hashContainer) Del(keys []*StringContainer) int {
 func (h *hashContainer) Keys() []*StringContainer {
 	var ret []*StringContainer
 
-	for _, entry := range h.container {
-		ret = append(ret, entry.key)
-	}
+	h.container.each(func(_ string, entry interface{}) bool {
+		ret = append(ret, entry.(*hashEntry).key)
+		return true
+	})
 
 	return ret
 }
@@ -127,9 +135,10 @@ func (h *hashContainer) Keys() []*StringContainer {
 func (h *hashContainer) Values() []*StringContainer {
 	var ret []*StringContainer
 
-	for _, entry := range h.container {
-		ret = append(ret, entry.value)
-	}
+	h.container.each(func(_ string, entry interface{}) bool {
+		ret = append(ret, entry.(*hashEntry).value)
+		return true
+	})
 
 	return ret
 }
@@ -137,24 +146,48 @@ func (h *hashContainer) Values() []*StringContainer {
 func (h *hashContainer) Entries() ([]*StringContainer, []*StringContainer) {
 	var keys, values []*StringContainer
 
-	for _, entry := range h.container {
-		keys = append(keys, entry.key)
-		values = append(values, entry.value)
-	}
+	h.container.each(func(_ string, entry interface{}) bool {
+		keys = append(keys, entry.(*hashEntry).key)
+		values = append(values, entry.(*hashEntry).value)
+		return true
+	})
 
 	return keys, values
 }
 
+func (h *hashContainer) WriteEntries(w protocol.RESPWriter) error {
+	err := w.WriteMapHeader(h.container.count())
+
+	h.container.each(func(_ string, entry interface{}) bool {
+		_ = w.WriteBulkString(entry.(*hashEntry).key.String())
+		err = w.WriteBulkString(entry.(*hashEntry).value.String())
+		return err == nil
+	})
+
+	return err
+}
+
+func (h *hashContainer) Scan(cursor uint64, count int) ([]*StringContainer, []*StringContainer, uint64) {
+	var keys, values []*StringContainer
+
+	cursor = h.container.scanCount(cursor, count, func(_ string, entry interface{}) {
+		keys = append(keys, entry.(*hashEntry).key)
+		values = append(values, entry.(*hashEntry).value)
+	})
+
+	return keys, values, cursor
+}
+
 func (h *hashContainer) KeyLen(key *StringContainer) (int, error) {
-	entry, ok := h.container[key.String()]
+	entry, ok := h.container.get(key.String())
 
 	if !ok {
 		return 0, ErrKeyNotExist
 	}
 
-	return entry.value.Len(), nil
+	return entry.(*hashEntry).value.Len(), nil
 }
 
 func (h *hashContainer) Len() int {
-	return len(h.container)
+	return h.container.count()
 }
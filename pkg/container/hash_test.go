@@ -1,9 +1,11 @@
 package container
 
 import (
+	"bytes"
 	"fmt"
 	"testing"
 
+	"github.com/lxdlam/vertex/pkg/protocol"
 	"github.com/lxdlam/vertex/pkg/util"
 
 	"github.com/stretchr/testify/assert"
@@ -166,3 +168,43 @@ func TestHashKeyLen(t *testing.T) {
 	assert.Equal(t, 0, length)
 	assert.Equal(t, ErrKeyNotExist, err)
 }
+
+func TestHashWriteEntries(t *testing.T) {
+	h := NewHashContainer("test")
+
+	_, keys := genRandomCase(defaultHashTestCase)
+	_, values := genRandomCase(defaultHashTestCase)
+	_, err := h.Set(keys, values)
+	assert.Nil(t, err)
+
+	expected := make(map[string]string)
+	for idx := range keys {
+		expected[keys[idx].String()] = values[idx].String()
+	}
+
+	for _, version := range []int{protocol.Resp2, protocol.Resp3} {
+		var buf bytes.Buffer
+		w := protocol.NewRESPWriter(&buf)
+		w.SetVersion(version)
+
+		assert.Nil(t, h.WriteEntries(w))
+		assert.Nil(t, w.Flush())
+
+		obj, err := protocol.Parse(&buf)
+		assert.Nil(t, err)
+
+		var data []protocol.RedisObject
+		if version == protocol.Resp2 {
+			data = obj.(protocol.RedisArray).Data()
+		} else {
+			data = obj.(protocol.RedisMap).Data()
+		}
+
+		actual := make(map[string]string)
+		for idx := 0; idx+1 < len(data); idx += 2 {
+			actual[data[idx].(protocol.RedisString).Data()] = data[idx+1].(protocol.RedisString).Data()
+		}
+
+		assert.Equal(t, expected, actual, "version=%d", version)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/protocol"
 	"github.com/lxdlam/vertex/pkg/util"
 )
 
@@ -37,6 +38,10 @@ type ListContainer interface {
 	Index(int) (*StringContainer, error)
 	Range(int, int) ([]*StringContainer, error)
 
+	// WriteRange writes the elements of the range as an array into the writer, one at a time without
+	// building them. Nothing is written if the range is out of range.
+	WriteRange(protocol.RESPWriter, int, int) error
+
 	Len() int
 }
 
@@ -158,14 +163,19 @@ func (l *linkedList) extract(index int) *listNode {
 }
 
 // Avoid traverse twice
-func (l *linkedList) extractSegment(left, right int) (*listNode, *listNode) {
+// resolveSegment resolves the left and right to the indexes of the list, -1 means it is out of range
+func (l *linkedList) resolveSegment(left, right int) (int, int) {
 	right = util.NewIndex(right).ResolveRaw(l.size)
 
 	if right >= l.size {
 		right = l.size - 1
 	}
 
-	normLeft, normRight := util.NewSlice(left, right).Resolve(l.size)
+	return util.NewSlice(left, right).Resolve(l.size)
+}
+
+func (l *linkedList) extractSegment(left, right int) (*listNode, *listNode) {
+	normLeft, normRight := l.resolveSegment(left, right)
 
 	if normLeft != -1 && normRight != -1 {
 		var leftNode, rightNode *listNode
@@ -354,6 +364,32 @@ func (l *linkedList) Range(left, right int) ([]*StringContainer, error) {
 	return nil, ErrOutOfRange
 }
 
+func (l *linkedList) WriteRange(w protocol.RESPWriter, left, right int) error {
+	normLeft, normRight := l.resolveSegment(left, right)
+	if normLeft == -1 || normRight == -1 {
+		return ErrOutOfRange
+	}
+
+	if err := w.WriteArrayHeader(normRight - normLeft + 1); err != nil {
+		return err
+	}
+
+	// the head is the sentinel before the first element
+	cur := l.head
+	for idx := -1; idx < normLeft; idx++ {
+		cur = cur.next
+	}
+
+	for idx := normLeft; idx <= normRight; idx++ {
+		if err := w.WriteBulkString(cur.data.String()); err != nil {
+			return err
+		}
+		cur = cur.next
+	}
+
+	return nil
+}
+
 func (l *linkedList) Trim(left, right int) error {
 	leftNode, rightNode := l.extractSegment(left, right)
 	if leftNode != nil && rightNode != nil {
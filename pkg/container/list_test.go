@@ -1,9 +1,12 @@
 package container
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
 const (
@@ -102,6 +105,38 @@ func TestListRange(t *testing.T) {
 	assert.ElementsMatch(t, expected, extractRange(l, 0, -1))
 }
 
+func TestListWriteRange(t *testing.T) {
+	l := NewLinkedListContainer("test")
+
+	_, testCase := genRandomCase(defaultListTestCase)
+	_, err := l.PushTail(testCase)
+	assert.Nil(t, err)
+
+	ranges := [][2]int{{0, -1}, {0, 0}, {10, 20}, {-5, -1}, {-1000, 1000}, {50, 49}, {defaultListTestCase, -1}}
+	for _, r := range ranges {
+		var buf bytes.Buffer
+		w := protocol.NewRESPWriter(&buf)
+
+		err := l.WriteRange(w, r[0], r[1])
+		assert.Nil(t, w.Flush())
+
+		list, rangeErr := l.Range(r[0], r[1])
+		if rangeErr != nil {
+			assert.Equal(t, ErrOutOfRange, err, "range=%v", r)
+			assert.Equal(t, 0, buf.Len(), "range=%v", r)
+			continue
+		}
+
+		assert.Nil(t, err, "range=%v", r)
+
+		var objs []protocol.RedisObject
+		for _, item := range list {
+			objs = append(objs, item.AsBulkStringObject())
+		}
+		assert.Equal(t, protocol.NewRedisArray(objs).String(), buf.String(), "range=%v", r)
+	}
+}
+
 func extractRange(l ListContainer, left, right int) []string {
 	var ret []string
 	list, _ := l.Range(left, right)
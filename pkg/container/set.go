@@ -1,5 +1,7 @@
 package container
 
+import "github.com/lxdlam/vertex/pkg/util"
+
 // SetContainer is the set data structure interface
 type SetContainer interface {
 	ContainerObject
@@ -12,6 +14,9 @@ type SetContainer interface {
 	RandomMember(int) []*StringContainer
 	Pop(int) []*StringContainer
 
+	// Scan returns about count members from the cursor, and the next cursor. 0 means the scan is finished.
+	Scan(uint64, int) ([]*StringContainer, uint64)
+
 	Diff([]SetContainer) SetContainer
 	Intersect([]SetContainer) SetContainer
 	Union([]SetContainer) SetContainer
@@ -21,14 +26,14 @@ type SetContainer interface {
 
 type setContainer struct {
 	key       string
-	container map[string]*StringContainer
+	container *dict
 }
 
 // NewSetContainer returns a new hash container
 func NewSetContainer(key string) SetContainer {
 	return &setContainer{
 		key:       key,
-		container: make(map[string]*StringContainer),
+		container: newDict(),
 	}
 }
 
@@ -46,8 +51,8 @@ func (sc *setContainer) Add(s []*StringContainer) int {
 	var added int
 
 	for _, item := range s {
-		if _, ok := sc.container[item.String()]; !ok {
-			sc.container[item.String()] = item
+		if _, ok := sc.container.get(item.String()); !ok {
+			sc.container.set(item.String(), item)
 			added++
 		}
 	}
@@ -59,8 +64,7 @@ func (sc *setContainer) Delete(s []*StringContainer) int {
 	var removed int
 
 	for _, item := range s {
-		if _, ok := sc.container[item.String()]; ok {
-			delete(sc.container, item.String())
+		if sc.container.remove(item.String()) {
 			removed++
 		}
 	}
@@ -69,35 +73,55 @@ func (sc *setContainer) Delete(s []*StringContainer) int {
 }
 
 func (sc *setContainer) IsMember(s *StringContainer) bool {
-	_, ok := sc.container[s.String()]
+	_, ok := sc.container.get(s.String())
 	return ok
 }
 
 func (sc *setContainer) Members() []*StringContainer {
 	var ret []*StringContainer
 
-	for _, item := range sc.container {
-		ret = append(ret, item)
-	}
+	sc.container.each(func(_ string, item interface{}) bool {
+		ret = append(ret, item.(*StringContainer))
+		return true
+	})
 
 	return ret
 }
 
 func (sc *setContainer) RandomMember(count int) []*StringContainer {
-	var ret []*StringContainer
-
 	if count < 0 {
 		count = -count
 	}
 
-	for _, item := range sc.container {
-		if count--; count < 0 {
-			break
+	// a few members are sampled from the random buckets, so it does not visit the whole set
+	if size := sc.container.count(); count*2 <= size {
+		var ret []*StringContainer
+
+		picked := make(map[string]struct{}, count)
+		for len(ret) < count {
+			key, item, _ := sc.container.random()
+			if _, ok := picked[key]; !ok {
+				picked[key] = struct{}{}
+				ret = append(ret, item.(*StringContainer))
+			}
 		}
-		ret = append(ret, item)
+
+		return ret
 	}
 
-	return ret
+	// most members are returned, so shuffle the first count members
+	ret := sc.Members()
+	if count > len(ret) {
+		count = len(ret)
+	}
+
+	r := util.GetGlobalRandom()
+	for idx := 0; idx < count; idx++ {
+		target := idx + r.Intn(len(ret)-idx)
+		ret[idx], ret[target] = ret[target], ret[idx]
+	}
+
+	return ret[:count]
 }
 
 func (sc *setContainer) Pop(count int) []*StringContainer {
@@ -107,6 +131,16 @@ func (sc *setContainer) Pop(count int) []*StringContainer {
 	return ret
 }
 
+func (sc *setContainer) Scan(cursor uint64, count int) ([]*StringContainer, uint64) {
+	var ret []*StringContainer
+
+	cursor = sc.container.scanCount(cursor, count, func(_ string, item interface{}) {
+		ret = append(ret, item.(*StringContainer))
+	})
+
+	return ret, cursor
+}
+
 func (sc *setContainer) Diff(cs []SetContainer) SetContainer {
 	var candidate []*StringContainer
 
@@ -160,5 +194,5 @@ func (sc *setContainer) Union(cs []SetContainer) SetContainer {
 }
 
 func (sc *setContainer) Len() int {
-	return len(sc.container)
+	return sc.container.count()
 }
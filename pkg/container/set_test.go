@@ -123,6 +123,69 @@ func TestSetPop(t *testing.T) {
 	assert.Nil(t, s.Pop(100))
 }
 
+func TestSetRandomSample(t *testing.T) {
+	s := NewSetContainer("test")
+
+	_, items := genRandomCase(defaultSetTestCase)
+	s.Add(items)
+
+	// a few members are sampled, and most members are shuffled
+	for _, count := range []int{1, 10, defaultSetTestCase / 2, defaultSetTestCase/2 + 1, defaultSetTestCase - 1} {
+		members := s.RandomMember(count)
+		assert.Equal(t, count, len(members))
+
+		picked := make(map[string]bool)
+		for _, member := range members {
+			assert.True(t, s.IsMember(member))
+			assert.False(t, picked[member.String()])
+			picked[member.String()] = true
+		}
+	}
+
+	popped := s.Pop(10)
+	assert.Equal(t, 10, len(popped))
+	assert.Equal(t, defaultSetTestCase-10, s.Len())
+	for _, member := range popped {
+		assert.False(t, s.IsMember(member))
+	}
+}
+
+// TestSetRandomDistribution draws pairs of members from a small set, every member and every pair should be
+// drawn, which fails if the members next to each other in the dict are drawn together
+func TestSetRandomDistribution(t *testing.T) {
+	const size = 20
+	const rounds = 20000
+
+	s := NewSetContainer("test")
+
+	_, items := genRandomCase(size)
+	s.Add(items)
+
+	members := make(map[string]int)
+	pairs := make(map[[2]string]int)
+	for idx := 0; idx < rounds; idx++ {
+		drawn := s.RandomMember(2)
+		assert.Equal(t, 2, len(drawn))
+
+		first, second := drawn[0].String(), drawn[1].String()
+		if first > second {
+			first, second = second, first
+		}
+
+		members[first]++
+		members[second]++
+		pairs[[2]string{first, second}]++
+	}
+
+	assert.Equal(t, size, len(members))
+	assert.Equal(t, size*(size-1)/2, len(pairs))
+
+	// the expected count of a member is rounds*2/size, a member in a longer chain of its bucket is drawn less
+	for member, count := range members {
+		assert.True(t, count > rounds*2/size/4, "member=%s, count=%d", member, count)
+	}
+}
+
 // TODO: We may need more test case?
 func TestSetDiff(t *testing.T) {
 	// Redis case
@@ -19,32 +19,120 @@ var (
 	// ErrEntryNotFound will be raised in all the entry related operations when the entry is not found
 	ErrEntryNotFound = errors.New("sorted_set_container: entry not found")
 
-	// ErrSortedSetEmpty will be raised in PopMin/Max
-	ErrSortedSetEmpty = errors.New("sorted_set_container: empty sorted set")
+	// ErrScoreNaN will be raised if an increment makes the score not a number, e.g., +inf plus -inf
+	ErrScoreNaN = errors.New("sorted_set_container: resulting score is not a number")
 )
 
-// SortedSetContainer is the sorted set data structure interface
+// ScoreRange is a range of scores, the bounds are excluded if the exclusive flags are set.
+// The bounds can be infinite.
+type ScoreRange struct {
+	Min, Max                   float64
+	MinExclusive, MaxExclusive bool
+}
+
+func (r *ScoreRange) aboveMin(score float64) bool {
+	if r.MinExclusive {
+		return score > r.Min
+	}
+
+	return score >= r.Min
+}
+
+func (r *ScoreRange) belowMax(score float64) bool {
+	if r.MaxExclusive {
+		return score < r.Max
+	}
+
+	return score <= r.Max
+}
+
+func (r *ScoreRange) isEmpty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinExclusive || r.MaxExclusive))
+}
+
+// LexRange is a range of entries in lexical order, the bounds are excluded if the exclusive flags are set.
+// An unbounded side covers all entries on that side. It is only meaningful if all the scores are the same.
+type LexRange struct {
+	Min, Max                   string
+	MinExclusive, MaxExclusive bool
+	MinUnbounded, MaxUnbounded bool
+}
+
+func (r *LexRange) aboveMin(entry string) bool {
+	if r.MinUnbounded {
+		return true
+	} else if r.MinExclusive {
+		return entry > r.Min
+	}
+
+	return entry >= r.Min
+}
+
+func (r *LexRange) belowMax(entry string) bool {
+	if r.MaxUnbounded {
+		return true
+	} else if r.MaxExclusive {
+		return entry < r.Max
+	}
+
+	return entry <= r.Max
+}
+
+func (r *LexRange) isEmpty() bool {
+	if r.MinUnbounded || r.MaxUnbounded {
+		return false
+	}
+
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinExclusive || r.MaxExclusive))
+}
+
+// SortedSetContainer is the sorted set data structure interface. The entries are ordered by the score,
+// and the entries with the same score are ordered lexically. All ranks are 0-based in ascending order.
 type SortedSetContainer interface {
 	ContainerObject
 
-	Add([]float64, []*StringContainer) error
-	Del([]*StringContainer)
+	// Add sets the scores of the entries, the absent entries are inserted. It returns the count of the
+	// inserted entries.
+	Add([]float64, []*StringContainer) (int, error)
 
-	Count(float64, float64) int
+	// Del removes the entries, it returns the count of the removed entries.
+	Del([]*StringContainer) int
+
+	Count(*ScoreRange) int
 	Score(*StringContainer) (float64, error)
 
+	// IncreaseBy increases the score of the entry, the entry is inserted with the increment as its score
+	// if it is absent.
 	IncreaseBy(*StringContainer, float64) (float64, error)
 
-	PopMin() (*StringContainer, error)
-	PopMax() (*StringContainer, error)
+	// PopMin and PopMax remove at most count entries with the lowest or highest scores.
+	PopMin(int) ([]*StringContainer, []float64)
+	PopMax(int) ([]*StringContainer, []float64)
 
 	Rank(*StringContainer) (int, error)
 
-	RangeByRank(int, int) ([]*StringContainer, error)
-	RangeByScore(float64, float64) ([]*StringContainer, error)
+	// RangeByRank returns the entries in the rank range [start, end], which must be already resolved.
+	RangeByRank(int, int) ([]*StringContainer, []float64)
+
+	// RangeByScore and RangeByLex return the entries in the range, skipping offset entries and then
+	// returning at most count entries, a negative count means all. The entries are in descending order
+	// if reverse is set.
+	RangeByScore(*ScoreRange, int, int, bool) ([]*StringContainer, []float64)
+	RangeByLex(*LexRange, int, int, bool) ([]*StringContainer, []float64)
+
+	// DelRangeByRank, DelRangeByScore and DelRangeByLex remove the entries in the range, they return
+	// the count of the removed entries.
+	DelRangeByRank(int, int) int
+	DelRangeByScore(*ScoreRange) int
+	DelRangeByLex(*LexRange) int
 
-	DelRangeByRank(int, int) error
-	DelRangeByScore(float64, float64) error
+	// RandomMembers returns count distinct entries if count is positive, or -count entries which may
+	// repeat if count is negative.
+	RandomMembers(int) ([]*StringContainer, []float64)
+
+	// Scan returns about count entries and their scores from the cursor, and the next cursor.
+	// 0 means the scan is finished.
+	Scan(uint64, int) ([]*StringContainer, []float64, uint64)
 
 	Len() int
 }
@@ -65,17 +153,22 @@ func (sln *skipListNode) release() {
 	sln.data = nil
 	sln.prev = nil
 
-	for _, item := range sln.next {
-		item.node = nil
+	for idx := range sln.next {
+		sln.next[idx].node = nil
 	}
 }
 
+// less reports if the node is ordered before the given score and entry
+func (sln *skipListNode) less(score float64, entry *StringContainer) bool {
+	return sln.score < score || (sln.score == score && sln.data.CompareTo(entry) < 0)
+}
+
 type skipList struct {
 	key   string
 	head  *skipListNode
 	tail  *skipListNode
 	level int
-	set   map[string]*skipListNode
+	set   *dict
 }
 
 func getRandomLevel() int {
@@ -91,11 +184,8 @@ func getRandomLevel() int {
 	return level
 }
 
+// insert adds a new node, the entry must not be in the skip list
 func (sl *skipList) insert(score float64, entry *StringContainer) {
-	if _, exist := sl.set[entry.String()]; exist {
-		return
-	}
-
 	// update[level] is the node that is the predecessor of cur at level
 	// rank[level] is the total skipped node at level
 	// so rank[0] is the total skipped node, which is the rank of the new node
@@ -111,9 +201,7 @@ func (sl *skipList) insert(score float64, entry *StringContainer) {
 			rank[level] = rank[level+1]
 		}
 
-		for cur.next[level].node != sl.tail &&
-			(cur.next[level].node.score < score ||
-				(cur.next[level].node.score == score && cur.next[level].node.data.CompareTo(entry) < 0)) {
+		for cur.next[level].node != sl.tail && cur.next[level].node.less(score, entry) {
 			rank[level] += cur.next[level].span
 			cur = cur.next[level].node
 		}
@@ -136,7 +224,7 @@ func (sl *skipList) insert(score float64, entry *StringContainer) {
 	cur = &skipListNode{
 		score: score,
 		data:  entry,
-		next:  nil,
+		next:  make([]skipListLevel, newLevel),
 	}
 
 	// add nodes, do below two things:
@@ -155,8 +243,7 @@ func (sl *skipList) insert(score float64, entry *StringContainer) {
 	// |               | rank[0] - rank[level] + 1 |               | update[level].next[level].span - (rank[0] - rank[level]) |               |
 	// |               |                           |               |                                                          |               |
 	// +---------------+                           +---------------+                                                          +---------------+
-	for level := 0; level < sl.level; level++ {
-		cur.next = append(cur.next, skipListLevel{})
+	for level := 0; level < newLevel; level++ {
 		cur.next[level].node = update[level].next[level].node
 		update[level].next[level].node = cur
 
@@ -173,7 +260,7 @@ func (sl *skipList) insert(score float64, entry *StringContainer) {
 	cur.prev = update[0]
 	cur.next[0].node.prev = cur
 
-	sl.set[entry.String()] = cur
+	sl.set.set(entry.String(), cur)
 }
 
 // the prev node is only point to the 0 layer, but we need to adjust all node in all occured layer
@@ -181,18 +268,20 @@ func (sl *skipList) insert(score float64, entry *StringContainer) {
 func (sl *skipList) delete(node *skipListNode, update []*skipListNode) {
 	for level := 0; level < sl.level; level++ {
 		if update[level].next[level].node == node {
-			update[level].next[level].node = node.next[level].node
 			update[level].next[level].span += node.next[level].span - 1
+			update[level].next[level].node = node.next[level].node
 		} else {
 			update[level].next[level].span--
 		}
 	}
 
+	node.next[0].node.prev = node.prev
+
 	for sl.level > 1 && sl.head.next[sl.level-1].node == sl.tail {
 		sl.level--
 	}
 
-	delete(sl.set, node.data.String())
+	sl.set.remove(node.data.String())
 
 	node.release()
 }
@@ -200,9 +289,10 @@ func (sl *skipList) delete(node *skipListNode, update []*skipListNode) {
 func (sl *skipList) deleteNode(node *skipListNode) {
 	update := make([]*skipListNode, maxLevel)
 
+	// the predecessors are the last nodes ordered before the node at each level
 	cur := sl.head
 	for level := sl.level - 1; level >= 0; level-- {
-		for cur.next[level].node != sl.tail && cur.next[level].node != node {
+		for cur.next[level].node != sl.tail && cur.next[level].node.less(node.score, node.data) {
 			cur = cur.next[level].node
 		}
 
@@ -212,17 +302,200 @@ func (sl *skipList) deleteNode(node *skipListNode) {
 	sl.delete(node, update)
 }
 
+// getRank returns the 1-based rank of the node, the head is ranked 0
 func (sl *skipList) getRank(node *skipListNode) int {
 	rank := 0
 	cur := sl.head
 	for level := sl.level - 1; level >= 0; level-- {
-		for cur.next[level].node != sl.tail || cur.next[level].node != node {
+		for cur.next[level].node != sl.tail &&
+			(cur.next[level].node == node || cur.next[level].node.less(node.score, node.data)) {
 			rank += cur.next[level].span
 			cur = cur.next[level].node
 		}
+
+		if cur == node {
+			return rank
+		}
 	}
 
-	return rank
+	return 0
+}
+
+// getByRank returns the node of the 1-based rank, nil if the rank is out of range
+func (sl *skipList) getByRank(rank int) *skipListNode {
+	traversed := 0
+	cur := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for cur.next[level].node != sl.tail && traversed+cur.next[level].span <= rank {
+			traversed += cur.next[level].span
+			cur = cur.next[level].node
+		}
+
+		if traversed == rank && cur != sl.head {
+			return cur
+		}
+	}
+
+	return nil
+}
+
+// firstInScoreRange returns the first node in the range, nil if no node is in the range
+func (sl *skipList) firstInScoreRange(r *ScoreRange) *skipListNode {
+	if r.isEmpty() {
+		return nil
+	}
+
+	cur := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for cur.next[level].node != sl.tail && !r.aboveMin(cur.next[level].node.score) {
+			cur = cur.next[level].node
+		}
+	}
+
+	cur = cur.next[0].node
+	if cur == sl.tail || !r.belowMax(cur.score) {
+		return nil
+	}
+
+	return cur
+}
+
+// lastInScoreRange returns the last node in the range, nil if no node is in the range
+func (sl *skipList) lastInScoreRange(r *ScoreRange) *skipListNode {
+	if r.isEmpty() {
+		return nil
+	}
+
+	cur := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for cur.next[level].node != sl.tail && r.belowMax(cur.next[level].node.score) {
+			cur = cur.next[level].node
+		}
+	}
+
+	if cur == sl.head || !r.aboveMin(cur.score) {
+		return nil
+	}
+
+	return cur
+}
+
+// firstInLexRange returns the first node in the range, nil if no node is in the range
+func (sl *skipList) firstInLexRange(r *LexRange) *skipListNode {
+	if r.isEmpty() {
+		return nil
+	}
+
+	cur := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for cur.next[level].node != sl.tail && !r.aboveMin(cur.next[level].node.data.String()) {
+			cur = cur.next[level].node
+		}
+	}
+
+	cur = cur.next[0].node
+	if cur == sl.tail || !r.belowMax(cur.data.String()) {
+		return nil
+	}
+
+	return cur
+}
+
+// lastInLexRange returns the last node in the range, nil if no node is in the range
+func (sl *skipList) lastInLexRange(r *LexRange) *skipListNode {
+	if r.isEmpty() {
+		return nil
+	}
+
+	cur := sl.head
+	for level := sl.level - 1; level >= 0; level-- {
+		for cur.next[level].node != sl.tail && r.belowMax(cur.next[level].node.data.String()) {
+			cur = cur.next[level].node
+		}
+	}
+
+	if cur == sl.head || !r.aboveMin(cur.data.String()) {
+		return nil
+	}
+
+	return cur
+}
+
+// collect walks from the start node while the nodes are in the range, it skips offset nodes and then
+// collects at most count nodes, a negative count means all.
+func (sl *skipList) collect(start *skipListNode, offset, count int, reverse bool, inRange func(*skipListNode) bool) []*skipListNode {
+	var ret []*skipListNode
+
+	for cur := start; cur != nil && cur != sl.head && cur != sl.tail && inRange(cur) && count != 0; {
+		if offset > 0 {
+			offset--
+		} else {
+			ret = append(ret, cur)
+			count--
+		}
+
+		if reverse {
+			cur = cur.prev
+		} else {
+			cur = cur.next[0].node
+		}
+	}
+
+	return ret
+}
+
+func unpackNodes(nodes []*skipListNode) ([]*StringContainer, []float64) {
+	var entries []*StringContainer
+	var scores []float64
+
+	for _, node := range nodes {
+		entries = append(entries, node.data)
+		scores = append(scores, node.score)
+	}
+
+	return entries, scores
+}
+
+func (sl *skipList) rangeByRank(start, end int) []*skipListNode {
+	if start < 0 || start > end {
+		return nil
+	}
+
+	return sl.collect(sl.getByRank(start+1), 0, end-start+1, false, func(*skipListNode) bool {
+		return true
+	})
+}
+
+func (sl *skipList) rangeByScore(r *ScoreRange, offset, count int, reverse bool) []*skipListNode {
+	if reverse {
+		return sl.collect(sl.lastInScoreRange(r), offset, count, true, func(node *skipListNode) bool {
+			return r.aboveMin(node.score)
+		})
+	}
+
+	return sl.collect(sl.firstInScoreRange(r), offset, count, false, func(node *skipListNode) bool {
+		return r.belowMax(node.score)
+	})
+}
+
+func (sl *skipList) rangeByLex(r *LexRange, offset, count int, reverse bool) []*skipListNode {
+	if reverse {
+		return sl.collect(sl.lastInLexRange(r), offset, count, true, func(node *skipListNode) bool {
+			return r.aboveMin(node.data.String())
+		})
+	}
+
+	return sl.collect(sl.firstInLexRange(r), offset, count, false, func(node *skipListNode) bool {
+		return r.belowMax(node.data.String())
+	})
+}
+
+func (sl *skipList) deleteNodes(nodes []*skipListNode) int {
+	for _, node := range nodes {
+		sl.deleteNode(node)
+	}
+
+	return len(nodes)
 }
 
 // NewSortedSetContainer returns a new SortedSetContainer which implementation is
@@ -250,7 +523,7 @@ func NewSortedSetContainer(key string) SortedSetContainer {
 
 	s.head = head
 	s.tail = tail
-	s.set = make(map[string]*skipListNode)
+	s.set = newDict()
 
 	return s
 }
@@ -265,47 +538,80 @@ func (sl *skipList) Type() ContainerType {
 	return SortedSetType
 }
 
-func (sl *skipList) Add(scores []float64, entries []*StringContainer) error {
+func (sl *skipList) Add(scores []float64, entries []*StringContainer) (int, error) {
 	l := len(scores)
 	if l != len(entries) {
-		return ErrSortedSetLengthNotMatch
+		return 0, ErrSortedSetLengthNotMatch
 	}
 
+	added := 0
+
 	for idx := 0; idx < l; idx++ {
+		if obj, ok := sl.set.get(entries[idx].String()); ok {
+			node := obj.(*skipListNode)
+			if node.score == scores[idx] {
+				continue
+			}
+
+			sl.deleteNode(node)
+		} else {
+			added++
+		}
+
 		sl.insert(scores[idx], entries[idx])
 	}
 
-	return nil
+	return added, nil
 }
 
-func (sl *skipList) Del(entries []*StringContainer) {
+func (sl *skipList) Del(entries []*StringContainer) int {
+	removed := 0
+
 	for _, entry := range entries {
-		if node, ok := sl.set[entry.String()]; ok {
-			sl.deleteNode(node)
+		if node, ok := sl.set.get(entry.String()); ok {
+			sl.deleteNode(node.(*skipListNode))
+			removed++
 		}
 	}
+
+	return removed
 }
 
-func (sl *skipList) Count(min, max float64) int {
-	panic("implement me")
+func (sl *skipList) Count(r *ScoreRange) int {
+	first := sl.firstInScoreRange(r)
+	if first == nil {
+		return 0
+	}
+
+	return sl.getRank(sl.lastInScoreRange(r)) - sl.getRank(first) + 1
 }
 
 func (sl *skipList) Score(entry *StringContainer) (float64, error) {
-	if entryNode, ok := sl.set[entry.String()]; !ok {
+	if entryNode, ok := sl.set.get(entry.String()); !ok {
 		return 0, ErrEntryNotFound
 	} else {
-		return entryNode.score, nil
+		return entryNode.(*skipListNode).score, nil
 	}
 }
 
 func (sl *skipList) IncreaseBy(entry *StringContainer, increment float64) (float64, error) {
-	node, ok := sl.set[entry.String()]
+	obj, ok := sl.set.get(entry.String())
 
 	if !ok {
-		return 0, ErrEntryNotFound
+		if math.IsNaN(increment) {
+			return 0, ErrScoreNaN
+		}
+
+		sl.insert(increment, entry)
+		return increment, nil
 	}
 
+	node := obj.(*skipListNode)
 	newScore := node.score + increment
+	if math.IsNaN(newScore) {
+		return 0, ErrScoreNaN
+	}
+
 	data := node.data
 	sl.deleteNode(node)
 	sl.insert(newScore, data)
@@ -313,52 +619,110 @@ func (sl *skipList) IncreaseBy(entry *StringContainer, increment float64) (float
 	return newScore, nil
 }
 
-func (sl *skipList) PopMin() (*StringContainer, error) {
-	if len(sl.set) == 0 {
-		return nil, ErrSortedSetEmpty
-	}
+func (sl *skipList) PopMin(count int) ([]*StringContainer, []float64) {
+	var entries []*StringContainer
+	var scores []float64
 
-	data := sl.head.next[0].node.data
-	sl.deleteNode(sl.head.next[0].node)
+	for ; count > 0 && sl.head.next[0].node != sl.tail; count-- {
+		node := sl.head.next[0].node
+		entries = append(entries, node.data)
+		scores = append(scores, node.score)
+		sl.deleteNode(node)
+	}
 
-	return data, nil
+	return entries, scores
 }
 
-func (sl *skipList) PopMax() (*StringContainer, error) {
-	if len(sl.set) == 0 {
-		return nil, ErrSortedSetEmpty
-	}
+func (sl *skipList) PopMax(count int) ([]*StringContainer, []float64) {
+	var entries []*StringContainer
+	var scores []float64
 
-	data := sl.tail.prev.data
-	sl.deleteNode(sl.tail.prev)
+	for ; count > 0 && sl.tail.prev != sl.head; count-- {
+		node := sl.tail.prev
+		entries = append(entries, node.data)
+		scores = append(scores, node.score)
+		sl.deleteNode(node)
+	}
 
-	return data, nil
+	return entries, scores
 }
 
 func (sl *skipList) Rank(entry *StringContainer) (int, error) {
-	if node, ok := sl.set[entry.String()]; !ok {
+	if node, ok := sl.set.get(entry.String()); !ok {
 		return -1, ErrEntryNotFound
 	} else {
-		return sl.getRank(node), nil
+		return sl.getRank(node.(*skipListNode)) - 1, nil
 	}
 }
 
-func (sl *skipList) RangeByRank(start, end int) ([]*StringContainer, error) {
-	panic("implement me")
+func (sl *skipList) RangeByRank(start, end int) ([]*StringContainer, []float64) {
+	return unpackNodes(sl.rangeByRank(start, end))
+}
+
+func (sl *skipList) RangeByScore(r *ScoreRange, offset, count int, reverse bool) ([]*StringContainer, []float64) {
+	return unpackNodes(sl.rangeByScore(r, offset, count, reverse))
 }
 
-func (sl *skipList) RangeByScore(min, max float64) ([]*StringContainer, error) {
-	panic("implement me")
+func (sl *skipList) RangeByLex(r *LexRange, offset, count int, reverse bool) ([]*StringContainer, []float64) {
+	return unpackNodes(sl.rangeByLex(r, offset, count, reverse))
 }
 
-func (sl *skipList) DelRangeByRank(start, end int) error {
-	panic("implement me")
+func (sl *skipList) DelRangeByRank(start, end int) int {
+	return sl.deleteNodes(sl.rangeByRank(start, end))
+}
+
+func (sl *skipList) DelRangeByScore(r *ScoreRange) int {
+	return sl.deleteNodes(sl.rangeByScore(r, 0, -1, false))
+}
+
+func (sl *skipList) DelRangeByLex(r *LexRange) int {
+	return sl.deleteNodes(sl.rangeByLex(r, 0, -1, false))
+}
+
+func (sl *skipList) RandomMembers(count int) ([]*StringContainer, []float64) {
+	var nodes []*skipListNode
+
+	if count < 0 {
+		for idx := 0; idx > count; idx-- {
+			_, node, ok := sl.set.random()
+			if !ok {
+				break
+			}
+
+			nodes = append(nodes, node.(*skipListNode))
+		}
+
+		return unpackNodes(nodes)
+	}
+
+	if count >= sl.Len() {
+		return sl.RangeByRank(0, sl.Len()-1)
+	}
+
+	// shuffle the first count nodes
+	nodes = sl.rangeByRank(0, sl.Len()-1)
+
+	r := util.GetGlobalRandom()
+	for idx := 0; idx < count; idx++ {
+		target := idx + r.Intn(len(nodes)-idx)
+		nodes[idx], nodes[target] = nodes[target], nodes[idx]
+	}
+
+	return unpackNodes(nodes[:count])
 }
 
-func (sl *skipList) DelRangeByScore(min, max float64) error {
-	panic("implement me")
+func (sl *skipList) Scan(cursor uint64, count int) ([]*StringContainer, []float64, uint64) {
+	var entries []*StringContainer
+	var scores []float64
+
+	cursor = sl.set.scanCount(cursor, count, func(_ string, node interface{}) {
+		entries = append(entries, node.(*skipListNode).data)
+		scores = append(scores, node.(*skipListNode).score)
+	})
+
+	return entries, scores, cursor
 }
 
 func (sl *skipList) Len() int {
-	return len(sl.set)
+	return sl.set.count()
 }
@@ -1 +1,265 @@
 package container
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/lxdlam/vertex/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	defaultSortedSetTestCase = 500
+)
+
+type sortedSetModelEntry struct {
+	entry string
+	score float64
+}
+
+// sortedSetModel is a sorted slice which the skip list is compared with
+type sortedSetModel []sortedSetModelEntry
+
+func (m sortedSetModel) sorted() sortedSetModel {
+	sort.Slice(m, func(i, j int) bool {
+		return m[i].score < m[j].score || (m[i].score == m[j].score && m[i].entry < m[j].entry)
+	})
+
+	return m
+}
+
+func newSortedSet(entries []string, scores []float64) SortedSetContainer {
+	z := NewSortedSetContainer("test")
+
+	var containers []*StringContainer
+	for _, entry := range entries {
+		containers = append(containers, NewString(entry))
+	}
+
+	_, _ = z.Add(scores, containers)
+
+	return z
+}
+
+func toStrings(entries []*StringContainer) []string {
+	var ret []string
+
+	for _, entry := range entries {
+		ret = append(ret, entry.String())
+	}
+
+	return ret
+}
+
+func TestSortedSetAgainstModel(t *testing.T) {
+	z := NewSortedSetContainer("test")
+	model := make(map[string]float64)
+	r := util.GetGlobalRandom()
+
+	for round := 0; round < 10*defaultSortedSetTestCase; round++ {
+		entry := fmt.Sprintf("entry%d", r.Intn(defaultSortedSetTestCase))
+
+		switch r.Intn(3) {
+		case 0, 1:
+			score := float64(r.Intn(50))
+			_, _ = z.Add([]float64{score}, []*StringContainer{NewString(entry)})
+			model[entry] = score
+		case 2:
+			_, ok := model[entry]
+			assert.Equal(t, b2i(ok), z.Del([]*StringContainer{NewString(entry)}))
+			delete(model, entry)
+		}
+	}
+
+	var expected sortedSetModel
+	for entry, score := range model {
+		expected = append(expected, sortedSetModelEntry{entry, score})
+	}
+	expected = expected.sorted()
+
+	assert.Equal(t, len(expected), z.Len())
+
+	entries, scores := z.RangeByRank(0, z.Len()-1)
+	for idx, item := range expected {
+		assert.Equal(t, item.entry, entries[idx].String())
+		assert.Equal(t, item.score, scores[idx])
+
+		rank, err := z.Rank(NewString(item.entry))
+		assert.Nil(t, err)
+		assert.Equal(t, idx, rank)
+	}
+
+	// reverse walking checks the prev pointers
+	entries, _ = z.RangeByScore(&ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 0, -1, true)
+	for idx, item := range expected {
+		assert.Equal(t, item.entry, entries[len(entries)-1-idx].String())
+	}
+
+	count := 0
+	for _, item := range expected {
+		if item.score > 10 && item.score <= 20 {
+			count++
+		}
+	}
+	assert.Equal(t, count, z.Count(&ScoreRange{Min: 10, Max: 20, MinExclusive: true}))
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func TestSortedSetAddAndScore(t *testing.T) {
+	z := newSortedSet([]string{"a", "b", "c"}, []float64{1, 2, 3})
+
+	added, err := z.Add([]float64{4, 5}, []*StringContainer{NewString("a"), NewString("d")})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, added)
+
+	_, err = z.Add([]float64{1}, nil)
+	assert.Equal(t, ErrSortedSetLengthNotMatch, err)
+
+	score, err := z.Score(NewString("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 4.0, score)
+
+	_, err = z.Score(NewString("missing"))
+	assert.Equal(t, ErrEntryNotFound, err)
+
+	entries, _ := z.RangeByRank(0, 3)
+	assert.Equal(t, []string{"b", "c", "a", "d"}, toStrings(entries))
+
+	score, err = z.IncreaseBy(NewString("b"), 10)
+	assert.Nil(t, err)
+	assert.Equal(t, 12.0, score)
+
+	score, err = z.IncreaseBy(NewString("e"), -1)
+	assert.Nil(t, err)
+	assert.Equal(t, -1.0, score)
+
+	_, _ = z.Add([]float64{math.Inf(1)}, []*StringContainer{NewString("inf")})
+	_, err = z.IncreaseBy(NewString("inf"), math.Inf(-1))
+	assert.Equal(t, ErrScoreNaN, err)
+
+	rank, err := z.Rank(NewString("e"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, rank)
+
+	assert.Equal(t, 2, z.Del([]*StringContainer{NewString("e"), NewString("inf"), NewString("missing")}))
+	assert.Equal(t, 4, z.Len())
+}
+
+func TestSortedSetRangeByScore(t *testing.T) {
+	z := newSortedSet([]string{"a", "b", "c", "d", "e"}, []float64{1, 2, 2, 3, math.Inf(1)})
+
+	testCases := []struct {
+		r        ScoreRange
+		offset   int
+		count    int
+		reverse  bool
+		expected []string
+	}{
+		{ScoreRange{Min: 2, Max: 3}, 0, -1, false, []string{"b", "c", "d"}},
+		{ScoreRange{Min: 2, Max: 3, MinExclusive: true}, 0, -1, false, []string{"d"}},
+		{ScoreRange{Min: 1, Max: 3, MaxExclusive: true}, 0, -1, false, []string{"a", "b", "c"}},
+		{ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 1, 2, false, []string{"b", "c"}},
+		{ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}, 0, 2, true, []string{"e", "d"}},
+		{ScoreRange{Min: 2, Max: 3}, 1, -1, true, []string{"c", "b"}},
+		{ScoreRange{Min: 3, Max: 2}, 0, -1, false, nil},
+		{ScoreRange{Min: 2, Max: 2, MinExclusive: true}, 0, -1, false, nil},
+		{ScoreRange{Min: 4, Max: 5}, 0, -1, false, nil},
+	}
+
+	for _, testCase := range testCases {
+		entries, _ := z.RangeByScore(&testCase.r, testCase.offset, testCase.count, testCase.reverse)
+		assert.Equal(t, testCase.expected, toStrings(entries), "range=%+v", testCase.r)
+	}
+
+	assert.Equal(t, 3, z.Count(&ScoreRange{Min: 2, Max: 3}))
+	assert.Equal(t, 5, z.Count(&ScoreRange{Min: math.Inf(-1), Max: math.Inf(1)}))
+	assert.Equal(t, 0, z.Count(&ScoreRange{Min: 10, Max: 20}))
+
+	assert.Equal(t, 2, z.DelRangeByScore(&ScoreRange{Min: 2, Max: 2}))
+	entries, _ := z.RangeByRank(0, z.Len()-1)
+	assert.Equal(t, []string{"a", "d", "e"}, toStrings(entries))
+}
+
+func TestSortedSetRangeByLex(t *testing.T) {
+	z := newSortedSet([]string{"a", "b", "c", "d", "e"}, []float64{0, 0, 0, 0, 0})
+
+	testCases := []struct {
+		r        LexRange
+		offset   int
+		count    int
+		reverse  bool
+		expected []string
+	}{
+		{LexRange{MinUnbounded: true, MaxUnbounded: true}, 0, -1, false, []string{"a", "b", "c", "d", "e"}},
+		{LexRange{Min: "b", Max: "d"}, 0, -1, false, []string{"b", "c", "d"}},
+		{LexRange{Min: "b", Max: "d", MinExclusive: true, MaxExclusive: true}, 0, -1, false, []string{"c"}},
+		{LexRange{Min: "bb", MaxUnbounded: true}, 1, 2, false, []string{"d", "e"}},
+		{LexRange{MinUnbounded: true, Max: "c"}, 0, -1, true, []string{"c", "b", "a"}},
+		{LexRange{Min: "d", Max: "b"}, 0, -1, false, nil},
+	}
+
+	for _, testCase := range testCases {
+		entries, _ := z.RangeByLex(&testCase.r, testCase.offset, testCase.count, testCase.reverse)
+		assert.Equal(t, testCase.expected, toStrings(entries), "range=%+v", testCase.r)
+	}
+
+	assert.Equal(t, 2, z.DelRangeByLex(&LexRange{Min: "a", Max: "c", MinExclusive: true}))
+	entries, _ := z.RangeByRank(0, z.Len()-1)
+	assert.Equal(t, []string{"a", "d", "e"}, toStrings(entries))
+}
+
+func TestSortedSetRankAndPop(t *testing.T) {
+	z := newSortedSet([]string{"a", "b", "c", "d", "e"}, []float64{1, 2, 3, 4, 5})
+
+	entries, scores := z.RangeByRank(1, 3)
+	assert.Equal(t, []string{"b", "c", "d"}, toStrings(entries))
+	assert.Equal(t, []float64{2, 3, 4}, scores)
+
+	entries, _ = z.RangeByRank(3, 1)
+	assert.Nil(t, entries)
+
+	assert.Equal(t, 2, z.DelRangeByRank(0, 1))
+
+	entries, scores = z.PopMin(1)
+	assert.Equal(t, []string{"c"}, toStrings(entries))
+	assert.Equal(t, []float64{3}, scores)
+
+	entries, _ = z.PopMax(5)
+	assert.Equal(t, []string{"e", "d"}, toStrings(entries))
+	assert.Equal(t, 0, z.Len())
+
+	entries, _ = z.PopMin(1)
+	assert.Nil(t, entries)
+}
+
+func TestSortedSetRandomMembers(t *testing.T) {
+	z := newSortedSet([]string{"a", "b", "c", "d", "e"}, []float64{1, 2, 3, 4, 5})
+
+	entries, _ := z.RandomMembers(10)
+	assert.Equal(t, 5, len(entries))
+
+	entries, _ = z.RandomMembers(3)
+	assert.Equal(t, 3, len(entries))
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		assert.False(t, seen[entry.String()])
+		seen[entry.String()] = true
+	}
+
+	entries, scores := z.RandomMembers(-20)
+	assert.Equal(t, 20, len(entries))
+	for idx, entry := range entries {
+		score, _ := z.Score(entry)
+		assert.Equal(t, score, scores[idx])
+	}
+}
@@ -11,9 +11,13 @@ var (
 
 	// ErrKeyNotFound will be raised in any situation if the operation is related to the key
 	ErrKeyNotFound = errors.New("global: key not found")
+
+	// ErrWrongType will be raised if the key holds a container which is not a string
+	ErrWrongType = errors.New("global: key holds a non-string value")
 )
 
-// StringMap is the global string container data structure interface
+// StringMap is the global string container data structure interface.
+// The keys holding other containers are invisible to the getters, but can be overwritten by Set.
 type StringMap interface {
 	ContainerObject
 
@@ -21,7 +25,7 @@ type StringMap interface {
 	Get([]*StringContainer) []*StringContainer
 
 	StringLen(*StringContainer) (int, error)
-	Append(*StringContainer, *StringContainer) int
+	Append(*StringContainer, *StringContainer) (int, error)
 
 	Increase(*StringContainer, int64) (int64, error)
 	Decrease(*StringContainer, int64) (int64, error)
@@ -31,17 +35,38 @@ type StringMap interface {
 	Len() int
 
 	Exists([]*StringContainer) int
+	Del([]*StringContainer) int
 }
 
 // NewStringMap will return a new global string map instance
 func NewStringMap() StringMap {
+	return newStringMapOn(newDict())
+}
+
+// newStringMapOn returns a string map shares the given keyspace
+func newStringMapOn(container *dict) StringMap {
 	return &simpleStringMap{
-		container: make(map[string]*StringContainer),
+		container: container,
 	}
 }
 
 type simpleStringMap struct {
-	container map[string]*StringContainer
+	container *dict
+}
+
+// get returns the string of the key, error will be raised if the key is not exist or holds another type
+func (ssm *simpleStringMap) get(key *StringContainer) (*StringContainer, error) {
+	obj, ok := ssm.container.get(key.String())
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	str, ok := obj.(*StringContainer)
+	if !ok {
+		return nil, ErrWrongType
+	}
+
+	return str, nil
 }
 
 func (ssm *simpleStringMap) Set(keys, values []*StringContainer) error {
@@ -51,7 +76,7 @@ func (ssm *simpleStringMap) Set(keys, values []*StringContainer) error {
 	}
 
 	for idx := 0; idx < l; idx++ {
-		ssm.container[keys[idx].String()] = values[idx]
+		ssm.container.set(keys[idx].String(), values[idx])
 	}
 
 	return nil
@@ -62,7 +87,7 @@ func (ssm *simpleStringMap) Get(keys []*StringContainer) []*StringContainer {
 	result := make([]*StringContainer, l)
 
 	for idx := 0; idx < l; idx++ {
-		if entry, ok := ssm.container[keys[idx].String()]; ok {
+		if entry, err := ssm.get(keys[idx]); err == nil {
 			result[idx] = entry
 		} else {
 			result[idx] = nil
@@ -73,69 +98,102 @@ func (ssm *simpleStringMap) Get(keys []*StringContainer) []*StringContainer {
 }
 
 func (ssm *simpleStringMap) StringLen(key *StringContainer) (int, error) {
-	if entry, ok := ssm.container[key.String()]; ok {
-		return entry.Len(), nil
+	entry, err := ssm.get(key)
+	if err != nil {
+		return 0, err
 	}
 
-	return 0, ErrKeyNotFound
+	return entry.Len(), nil
 }
 
-func (ssm *simpleStringMap) Append(key, str *StringContainer) int {
-	if entry, ok := ssm.container[key.String()]; !ok {
-		ssm.container[key.String()] = str
-		return str.Len()
-	} else {
-		newStr := entry.Append(str)
-		ssm.container[key.String()] = newStr
-		return newStr.Len()
+func (ssm *simpleStringMap) Append(key, str *StringContainer) (int, error) {
+	entry, err := ssm.get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		ssm.container.set(key.String(), str)
+		return str.Len(), nil
+	} else if err != nil {
+		return 0, err
 	}
+
+	newStr := entry.Append(str)
+	ssm.container.set(key.String(), newStr)
+	return newStr.Len(), nil
 }
 
 func (ssm *simpleStringMap) Increase(key *StringContainer, increment int64) (int64, error) {
-	if entry, ok := ssm.container[key.String()]; ok {
-		if ret, err := entry.Increase(increment); err == nil {
-			return ret, nil
-		} else {
-			return 0, fmt.Errorf("global: increase met an error. key=%s, err={%w}", key.String(), err)
-		}
+	entry, err := ssm.get(key)
+	if err != nil {
+		return 0, err
 	}
 
-	return 0, ErrKeyNotFound
+	ret, err := entry.Increase(increment)
+	if err != nil {
+		return 0, fmt.Errorf("global: increase met an error. key=%s, err={%w}", key.String(), err)
+	}
+
+	return ret, nil
 }
 
 func (ssm *simpleStringMap) Decrease(key *StringContainer, decrement int64) (int64, error) {
-	if entry, ok := ssm.container[key.String()]; ok {
-		if ret, err := entry.Decrease(decrement); err == nil {
-			return ret, nil
-		} else {
-			return 0, fmt.Errorf("global: decrease met an error. key=%s, err={%w}", key.String(), err)
-		}
+	entry, err := ssm.get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	ret, err := entry.Decrease(decrement)
+	if err != nil {
+		return 0, fmt.Errorf("global: decrease met an error. key=%s, err={%w}", key.String(), err)
 	}
 
-	return 0, ErrKeyNotFound
+	return ret, nil
 }
 
 func (ssm *simpleStringMap) GetRange(key *StringContainer, start, end int) (*StringContainer, error) {
-	if entry, ok := ssm.container[key.String()]; ok {
-		if ret, err := entry.GetRange(start, end); err == nil {
-			return ret, nil
-		} else {
-			return nil, fmt.Errorf("global: get range error. key=%s, range=[%d, %d], err={%w}", key.String(), start, end, err)
-		}
+	entry, err := ssm.get(key)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, ErrKeyNotFound
+	ret, err := entry.GetRange(start, end)
+	if err != nil {
+		return nil, fmt.Errorf("global: get range error. key=%s, range=[%d, %d], err={%w}", key.String(), start, end, err)
+	}
+
+	return ret, nil
 }
 
 func (ssm *simpleStringMap) Len() int {
-	return len(ssm.container)
+	count := 0
+
+	ssm.container.each(func(_ string, obj interface{}) bool {
+		if obj.(ContainerObject).Type() == StringType {
+			count++
+		}
+
+		return true
+	})
+
+	return count
 }
 
 func (ssm *simpleStringMap) Exists(keys []*StringContainer) int {
 	count := 0
 
 	for _, key := range keys {
-		if _, ok := ssm.container[key.String()]; ok {
+		if _, err := ssm.get(key); err == nil {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (ssm *simpleStringMap) Del(keys []*StringContainer) int {
+	count := 0
+
+	for _, key := range keys {
+		if _, err := ssm.get(key); err == nil {
+			ssm.container.remove(key.String())
 			count++
 		}
 	}
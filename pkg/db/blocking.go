@@ -0,0 +1,220 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+// blockTimeoutInterval is the interval between two checks of the blocking timeouts
+const blockTimeoutInterval = 10 * time.Millisecond
+
+// waiter is a client blocked by a blocking command
+type waiter struct {
+	session *types.Session
+	name    string
+	objects []protocol.RedisObject
+	db      int
+	keys    []string
+
+	// deadline is zero if the client blocks forever
+	deadline time.Time
+
+	// ackOffset and ackReplicas are the target of WAIT, which blocks on no key
+	ackOffset   int64
+	ackReplicas int
+
+	// proposal is the index of the record proposed in the raft mode, and heldReply is replied once it is
+	// committed
+	proposal  uint64
+	heldReply protocol.RedisObject
+
+	// timeoutReply is replied when the deadline is reached
+	timeoutReply protocol.RedisObject
+
+	// deferred are the requests of the client arrived after it is blocked
+	deferred [][]protocol.RedisObject
+}
+
+// block parks the client on the blocking keys of the command, the mutex should be held
+func (e *engine) block(session *types.Session, name string, objects []protocol.RedisObject, index int, b command.Blocker, timeoutReply protocol.RedisObject) {
+	w := &waiter{
+		session:      session,
+		name:         name,
+		objects:      objects,
+		db:           index,
+		keys:         b.BlockingKeys(),
+		timeoutReply: timeoutReply,
+	}
+
+	if b.Timeout() > 0 {
+		w.deadline = time.Now().Add(b.Timeout())
+	}
+
+	for _, key := range w.keys {
+		wk := types.WatchedKey{DB: w.db, Key: key}
+		e.blocked[wk] = append(e.blocked[wk], w)
+	}
+
+	e.waiters[session] = w
+	session.SetBlocked(true)
+}
+
+// unblock removes the waiter from all its keys, the mutex should be held
+func (e *engine) unblock(w *waiter) {
+	for _, key := range w.keys {
+		wk := types.WatchedKey{DB: w.db, Key: key}
+
+		queue := e.blocked[wk]
+		for idx := range queue {
+			if queue[idx] == w {
+				queue = append(queue[:idx], queue[idx+1:]...)
+				break
+			}
+		}
+
+		if len(queue) == 0 {
+			delete(e.blocked, wk)
+		} else {
+			e.blocked[wk] = queue
+		}
+	}
+
+	delete(e.waiters, w.session)
+	w.session.SetBlocked(false)
+
+	if w.proposal != 0 {
+		delete(e.proposals, w.proposal)
+	}
+}
+
+// signalReady records the keys that may serve the blocked clients, only the keys with waiters are kept
+func (e *engine) signalReady(index int, keys []string) {
+	for _, key := range keys {
+		wk := types.WatchedKey{DB: index, Key: key}
+		if len(e.blocked[wk]) > 0 {
+			e.ready = append(e.ready, wk)
+		}
+	}
+}
+
+// signalReadyDBs records all the keys with waiters in the dbs, e.g., the dbs swapped by SWAPDB, the mutex
+// should be held
+func (e *engine) signalReadyDBs(dbs []int) {
+	for wk := range e.blocked {
+		for _, index := range dbs {
+			if wk.DB == index {
+				e.ready = append(e.ready, wk)
+				break
+			}
+		}
+	}
+}
+
+// serveReady serves the clients blocked on the ready keys in the FIFO order, the mutex should be held.
+// Serving a client may make other keys ready, e.g., BLMOVE, which are served in the same round.
+func (e *engine) serveReady() {
+	for len(e.ready) > 0 {
+		wk := e.ready[0]
+		e.ready = e.ready[1:]
+
+		for len(e.blocked[wk]) > 0 {
+			w := e.blocked[wk][0]
+
+			c, ret, logObjects, err := e.run(w.session, w.name, w.objects)
+			if b, ok := c.(command.Blocker); ok && err == nil && b.Blocked() {
+				// the key is drained by the former clients
+				break
+			}
+
+			e.unblock(w)
+
+			if err != nil {
+				ret = handleError(err)
+			} else if len(logObjects) > 0 {
+				e.writeLog(logObjects[0].(protocol.RedisString).Data(), c.Cluster(), logObjects)
+
+				// the reply is held until the record is committed in the raft mode, so are the deferred requests
+				if next, err := e.hold(w.session, ret); err != nil {
+					ret = handleError(err)
+				} else if next != nil {
+					next.deferred = w.deferred
+					continue
+				}
+			}
+
+			e.push(w.session, append([]protocol.RedisObject{ret}, e.resume(w)...)...)
+		}
+	}
+}
+
+// resume handles the requests deferred while the client is blocked, until it is blocked again. The
+// replies are returned to be sent along with the reply of the blocking command, so they keep in order.
+func (e *engine) resume(w *waiter) []protocol.RedisObject {
+	var ret []protocol.RedisObject
+
+	for idx, objects := range w.deferred {
+		if next, ok := e.waiters[w.session]; ok {
+			next.deferred = append(next.deferred, w.deferred[idx:]...)
+			break
+		}
+
+		replies, err := e.dispatch(w.session, objects)
+		if err != nil {
+			ret = append(ret, handleError(err))
+		} else {
+			for _, reply := range replies {
+				ret = append(ret, held(reply))
+			}
+		}
+	}
+
+	return ret
+}
+
+// expireBlocked replies the clients whose timeout is reached
+func (e *engine) expireBlocked() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now()
+
+	var expired []*waiter
+	for _, w := range e.waiters {
+		if !w.deadline.IsZero() && !now.Before(w.deadline) {
+			expired = append(expired, w)
+		}
+	}
+
+	for _, w := range expired {
+		e.unblock(w)
+
+		// WAIT replies the count of the replicas acknowledging its offset so far
+		reply := w.timeoutReply
+		if w.name == waitCommandName {
+			reply = protocol.NewRedisInteger(int64(e.ackedReplicas(w)))
+		}
+
+		e.push(w.session, append([]protocol.RedisObject{reply}, e.resume(w)...)...)
+	}
+
+	e.serveReady()
+}
+
+func (e *engine) startBlockWorker() {
+	go func() {
+		ticker := time.NewTicker(blockTimeoutInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.shutChan:
+				return
+			case <-ticker.C:
+				e.expireBlocked()
+			}
+		}
+	}()
+}
@@ -5,9 +5,28 @@ import (
 	"github.com/lxdlam/vertex/pkg/container"
 )
 
+const (
+	// activeExpireSampleSize is the count of keys with deadline checked in one round of active expire.
+	activeExpireSampleSize = 20
+
+	// activeExpireRepeatRatio is the percentage of expired keys in a round which causes another round.
+	activeExpireRepeatRatio = 25
+)
+
 type DB interface {
 	ExecuteCommand(command.Command)
 
+	// ReplayCommand works like ExecuteCommand but never expires any key, so replaying a log
+	// gives the same state no matter when it is replayed. The commands of the master are applied
+	// by it on a replica, which see the expired keys kept for the DEL of the master.
+	ReplayCommand(command.Command)
+
+	// ActiveExpire removes the expired keys by sampling, at most rounds times. It returns the removed count.
+	ActiveExpire(rounds int) int
+
+	// Keyspace returns the keyspace of the db, which is given to the server commands.
+	Keyspace() container.Containers
+
 	Index() int
 }
 
@@ -23,8 +42,26 @@ func NewDB(index int) DB {
 	}
 }
 
-func (d *db) resolveName(key string, t container.ContainerType, create bool) container.ContainerObject {
+func (d *db) resolveName(key string, t container.ContainerType, create bool, expire bool) container.ContainerObject {
+	if expire {
+		d.containers.ExpireIfNeeded(key)
+	}
+
+	// A key holding another type is returned as is, so the command reports a type mismatch
+	if obj := d.containers.Get(key); obj != nil && t != container.KeyspaceType {
+		expected := t
+		if t == container.GlobalType {
+			expected = container.StringType
+		}
+
+		if obj.Type() != expected {
+			return obj
+		}
+	}
+
 	switch t {
+	case container.KeyspaceType:
+		return d.containers
 	case container.GlobalType:
 		// create do nothing here
 		return d.containers.Global()
@@ -46,23 +83,72 @@ func (d *db) resolveName(key string, t container.ContainerType, create bool) con
 		} else {
 			return d.containers.GetSet(key)
 		}
+	case container.SortedSetType:
+		if create {
+			return d.containers.GetOrCreateSortedSet(key)
+		} else {
+			return d.containers.GetSortedSet(key)
+		}
 	}
 
 	return nil
 }
 
-func (d *db) ExecuteCommand(c command.Command) {
+func (d *db) execute(c command.Command, expire bool) {
+	// the clients never see an expired key, even the one kept for the DEL of the master on a replica
+	if expire {
+		d.containers.HideExpired(true)
+		defer d.containers.HideExpired(false)
+	}
+
 	var accessObjects []container.ContainerObject
 	targetType := c.TargetContainerType()
 	create := c.ShouldCreate()
 
 	for _, key := range c.Keys() {
-		accessObjects = append(accessObjects, d.resolveName(key, targetType, create))
+		accessObjects = append(accessObjects, d.resolveName(key, targetType, create, expire))
+	}
+
+	// keyspace commands may take no key at all
+	if len(accessObjects) == 0 && targetType == container.KeyspaceType {
+		accessObjects = append(accessObjects, d.containers)
 	}
 
 	c.SetAccessObjects(accessObjects)
 
 	c.Execute()
+
+	// An emptied container is removed as redis does, so the key is not exist anymore
+	for _, key := range c.Keys() {
+		d.containers.RemoveIfEmpty(key)
+	}
+}
+
+func (d *db) ExecuteCommand(c command.Command) {
+	d.execute(c, true)
+}
+
+func (d *db) ReplayCommand(c command.Command) {
+	d.execute(c, false)
+}
+
+func (d *db) ActiveExpire(rounds int) int {
+	removed := 0
+
+	for round := 0; round < rounds; round++ {
+		sampled, expired := d.containers.ActiveExpire(activeExpireSampleSize)
+		removed += expired
+
+		if sampled == 0 || expired*100 < sampled*activeExpireRepeatRatio {
+			break
+		}
+	}
+
+	return removed
+}
+
+func (d *db) Keyspace() container.Containers {
+	return d.containers
 }
 
 func (d *db) Index() int {
@@ -3,20 +3,21 @@ package db
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
-	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lxdlam/vertex/pkg/replication"
 
 	"github.com/lxdlam/vertex/pkg/command"
 	"github.com/lxdlam/vertex/pkg/common"
-	"github.com/lxdlam/vertex/pkg/concurrency"
 	"github.com/lxdlam/vertex/pkg/container"
 	"github.com/lxdlam/vertex/pkg/log"
 	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/raft"
 	"github.com/lxdlam/vertex/pkg/types"
 )
 
@@ -24,40 +25,165 @@ type Engine interface {
 	Start()
 	Stop()
 
-	SetFile(*os.File, string)
+	// SetFile sets the database file and its fsync policy, the executed modifications are appended to it.
+	SetFile(*os.File, string, string)
+
+	// SetAutoRewrite sets the triggers of the automatic rewrite of the database file, it is rewritten once
+	// it grows by the percentage since the last rewrite and is larger than the min size. A percentage that
+	// is not positive disables it.
+	SetAutoRewrite(int, int64)
+
+	// SetSnapshotFile sets the path of the snapshot file taken by SAVE and BGSAVE.
+	SetSnapshotFile(string)
 	BuildFromLog([]*log.VertexLog)
+
+	// Restore loads the snapshot file if any, then replays the logs written after the snapshot.
+	Restore([]*log.VertexLog)
+
+	// ReplicaOf follows the master listening on the address for the replicas, the dataset is synced with it
+	// and the modifications of it are applied from then on. The modifications of the clients are refused
+	// while following. An empty address stops following.
+	ReplicaOf(string)
+
+	// SetReplBacklogSize sets the bytes of the replication stream kept for the replicas to resume from.
+	SetReplBacklogSize(int)
+
+	// SetMinReplicas refuses the modifications on the master unless the count of the replicas have
+	// acknowledged within the lag, a count that is not positive accepts them anyway.
+	SetMinReplicas(int, time.Duration)
+
+	// EnableRaft replicates the modifications by the raft cluster instead of the database file and the
+	// replicas. The followers redirect the modifications to the leader by the addresses of the clients of the
+	// nodes in the map. It should be called before Start, and the node returned should serve the requests of
+	// the other nodes.
+	EnableRaft(raft.Config, raft.Storage, raft.Transport, map[string]string) (raft.Node, error)
+
+	// ImportRDB loads a rdb file of redis, and ExportRDB writes all dbs into one.
+	ImportRDB(io.Reader) error
+	ExportRDB(string) error
+
+	// Submit queues a request of the session. The requests are handled one by one in the submitted order,
+	// and the replies are written to the replier of the session in the same order. It blocks if the queue
+	// is full, so a request is never dropped.
+	Submit(*types.Session, protocol.RedisObject)
+
+	// Reject queues a protocol error of the session after its submitted requests. The error is replied
+	// once the requests are replied, then the connection is closed.
+	Reject(*types.Session, error)
+
+	// Disconnect queues the release of the states the engine holds for the session after its submitted
+	// requests, e.g., the watched keys, so a request handled after it can not block or subscribe again.
+	Disconnect(*types.Session)
+}
+
+const (
+	// activeExpireInterval is the interval between two active expire cycles
+	activeExpireInterval = 100 * time.Millisecond
+
+	// activeExpireRounds is the max sampling rounds of a db in one active expire cycle
+	activeExpireRounds = 16
+
+	// requestQueueSize is the max count of the requests waiting to be handled
+	requestQueueSize = 1024
+)
+
+// ErrLogWriteFailed will be raised if a modification is requested while the database file can not be written
+var ErrLogWriteFailed = errors.New("engine: errors writing to the append only log")
+
+// request is a request submitted by a session, or a protocol error of it if err is set, or the disconnection
+// of it if disconnect is set
+type request struct {
+	session    *types.Session
+	object     protocol.RedisObject
+	err        error
+	disconnect bool
 }
 
-// TODO(2020.5.22): currently only one db instance is supported, regardless the value required by client
 type engine struct {
-	dbMap           sync.Map
-	requestReceiver concurrency.Receiver
-	eventBus        concurrency.EventBus
-	shutChan        chan struct{}
-	file            *log.PersistentFile
-	master          replication.Master
+	// mutex guards all dbs, since both the request loop and the active expire cycle touch them
+	mutex     sync.Mutex
+	dbMap     sync.Map
+	databases int
+	watchers  map[types.WatchedKey]map[*types.Session]struct{}
+	blocked   map[types.WatchedKey][]*waiter
+	waiters   map[*types.Session]*waiter
+	ready     []types.WatchedKey
+	channels  map[string]map[*types.Session]struct{}
+	patterns  map[string]map[*types.Session]struct{}
+	requests  chan request
+	shutChan  chan struct{}
+	file      *log.PersistentFile
+	aof       *log.AppendLog
+	filePath  string
+	master    replication.Master
+	replica   replication.Replica
+	startTime time.Time
+
+	// replicaGeneration is increased by each REPLICAOF, the records of the replicas replaced are dropped
+	replicaGeneration int
+
+	// loading reports if a replica is applying the dataset of the master
+	loading bool
+
+	// minReplicasToWrite is the count of the good replicas required by the modifications, a replica is
+	// good if it has acknowledged within minReplicasMaxLag
+	minReplicasToWrite int
+	minReplicasMaxLag  time.Duration
+
+	snapshotPath string
+	saving       *saveState
+	lastSave     time.Time
+	saveErr      error
+
+	// dumps are the dumps of the dataset running, the keys are dumped for them before they are modified
+	dumps []*dumpState
+
+	// inExec reports if a transaction is running, and scheduledSave is the snapshot taken by it
+	inExec        bool
+	scheduledSave func() error
+
+	autoRewritePercentage int
+	autoRewriteMinSize    int64
+
+	// raft replicates the modifications in the raft mode, and raftAddrs are the addresses of the clients of
+	// the nodes by their ids
+	raft      raft.Node
+	raftAddrs map[string]string
+
+	// proposal is the index of the record proposed by the request being handled, or the error if it is
+	// refused, and proposals are the clients held until their records are committed
+	proposal    uint64
+	proposalErr error
+	proposals   map[uint64]*waiter
 }
 
-// NewEngine will return a new engine that listens to the requests and post responses
-func NewEngine(port int) Engine {
-	e := &engine{
-		shutChan: make(chan struct{}),
-		eventBus: concurrency.GetEventBus(),
+// NewEngine will return a new engine that handles the submitted requests and writes the replies.
+// The dbs are indexed in [0, databases), DefaultDatabases is used if databases is not positive.
+func NewEngine(port int, databases int) Engine {
+	if databases <= 0 {
+		databases = common.DefaultDatabases
 	}
 
-	var err error
-	e.requestReceiver, err = e.eventBus.Subscribe("request", "engine")
-	if err != nil {
-		_ = common.Errorf("subscribe to request failed. err={%w}", err)
-		return nil
+	e := &engine{
+		shutChan:  make(chan struct{}),
+		requests:  make(chan request, requestQueueSize),
+		databases: databases,
+		watchers:  make(map[types.WatchedKey]map[*types.Session]struct{}),
+		blocked:   make(map[types.WatchedKey][]*waiter),
+		waiters:   make(map[*types.Session]*waiter),
+		channels:  make(map[string]map[*types.Session]struct{}),
+		patterns:  make(map[string]map[*types.Session]struct{}),
+		proposals: make(map[uint64]*waiter),
+		startTime: time.Now(),
 	}
+	e.lastSave = e.startTime
 
 	if port > 0 {
 		addr := &net.TCPAddr{
 			IP:   []byte{0, 0, 0, 0},
 			Port: port,
 		}
-		e.master = replication.NewMaster(addr)
+		e.master = replication.NewMaster(addr, e)
 	}
 
 	return e
@@ -73,37 +199,194 @@ func (e *engine) getDB(index int) DB {
 }
 
 func (e *engine) getOrCreateDB(index int) DB {
-	db, _ := e.dbMap.LoadOrStore(index, NewDB(index))
+	db, loaded := e.dbMap.LoadOrStore(index, NewDB(index))
+	if !loaded {
+		db.(DB).Keyspace().OnExpire(func(key string) {
+			e.expired(index, key)
+		})
+		db.(DB).Keyspace().KeepExpired(e.replica != nil)
+	}
 
 	return db.(DB)
 }
 
-func parseRequest(data types.DataMap) (id string, objects []protocol.RedisObject, ok bool) {
-	i, ok := data.Get("id")
-	if !ok {
-		return
+// selectDB returns the db of the index, ErrDBIndexOutOfRange will be raised if the index is invalid
+func (e *engine) selectDB(index int) (DB, error) {
+	if index < 0 || index >= e.databases {
+		return nil, fmt.Errorf("select db failed. index=%d, databases=%d, err={%w}", index, e.databases, command.ErrDBIndexOutOfRange)
 	}
 
-	id, ok = i.(string)
+	return e.getOrCreateDB(index), nil
+}
+
+// serverView is the command.Server given to the server commands. The keys of the command are expired
+// in every keyspace it accesses as the db does, unless the command is replayed.
+type serverView struct {
+	engine *engine
+	keys   []string
+	expire bool
+}
+
+func (v *serverView) Databases() int {
+	return v.engine.databases
+}
+
+func (v *serverView) Keyspace(index int) (container.Containers, error) {
+	db, err := v.engine.selectDB(index)
+	if err != nil {
+		return nil, err
+	}
+
+	keyspace := db.Keyspace()
+	if v.expire {
+		for _, key := range v.keys {
+			keyspace.ExpireIfNeeded(key)
+		}
+	}
+
+	return keyspace, nil
+}
+
+func (v *serverView) Uptime() time.Duration {
+	return time.Since(v.engine.startTime)
+}
+
+func (v *serverView) Persistence() command.PersistenceInfo {
+	if v.engine.aof == nil {
+		return command.PersistenceInfo{}
+	}
+
+	status := v.engine.aof.Status()
+	return command.PersistenceInfo{
+		Enabled:     true,
+		AppendFsync: status.Policy,
+		LastFsync:   status.LastFsync,
+		Buffered:    status.Buffered,
+		WriteErr:    status.WriteErr,
+		FsyncErr:    status.FsyncErr,
+		Size:        status.Size,
+		BaseSize:    status.BaseSize,
+		Rewriting:   status.Rewriting,
+		RewriteErr:  status.RewriteErr,
+	}
+}
+
+func (v *serverView) RewriteLog() error {
+	return v.engine.rewriteLog()
+}
+
+func (v *serverView) Save() error {
+	return v.engine.save()
+}
+
+func (v *serverView) BackgroundSave() error {
+	return v.engine.backgroundSave()
+}
+
+func (v *serverView) Replication() command.ReplicationInfo {
+	return v.engine.replicationInfo()
+}
+
+func (v *serverView) ReplicaOf(addr string) bool {
+	old, changed := v.engine.replicaOf(addr)
+
+	// the mutex is held by the command, so the old replica is stopped in the background
+	if old != nil {
+		go old.Stop()
+	}
+
+	return changed
+}
+
+func (v *serverView) Raft() command.RaftInfo {
+	return v.engine.raftInfo()
+}
+
+func (v *serverView) Snapshot() command.SnapshotInfo {
+	return command.SnapshotInfo{
+		Enabled:  v.engine.snapshotPath != "",
+		Saving:   v.engine.saving != nil,
+		LastSave: v.engine.lastSave,
+		SaveErr:  v.engine.saveErr,
+	}
+}
+
+// executeLocked runs the command on the db it selects, the session is nil if the command is replayed. The
+// mutex should be held by the caller.
+func (e *engine) executeLocked(c command.Command, session *types.Session, expire bool) error {
+	if sc, ok := c.(command.ServerCommand); ok {
+		sc.SetServer(&serverView{engine: e, keys: c.Keys(), expire: expire}, session)
+		sc.Execute()
+		return nil
+	}
+
+	db, err := e.selectDB(c.Cluster())
+	if err != nil {
+		return err
+	}
+
+	if expire {
+		db.ExecuteCommand(c)
+	} else {
+		db.ReplayCommand(c)
+	}
+
+	return nil
+}
+
+// handleRequest handles a request and queues its replies, which is mostly one but the subscribe commands
+// reply one for each channel. Nothing is replied at once if the client is blocked. The replies are queued
+// with the mutex held, so they keep in order with the pushes to the session.
+func (e *engine) handleRequest(session *types.Session, obj protocol.RedisObject) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// the clients blocked on the keys made ready by the request are served before the next request
+	defer e.serveReady()
+
+	request, ok := obj.(protocol.RedisArray)
 	if !ok {
+		e.reply(session, []protocol.RedisObject{handleError(fmt.Errorf("invalid request, raw=%+v", obj))})
 		return
 	}
 
-	response, ok := data.Get("request")
-	if !ok {
+	ret, err := e.dispatch(session, request.Data())
+	if err != nil {
+		e.reply(session, []protocol.RedisObject{handleError(err)})
 		return
 	}
 
-	obj, ok := response.(protocol.RedisArray)
-	if !ok {
+	e.reply(session, ret)
+}
+
+// reply writes the replies into the client of the session in its protocol version, the mutex should be held
+func (e *engine) reply(session *types.Session, objs []protocol.RedisObject) {
+	r := session.Replier()
+	if r == nil || len(objs) == 0 {
 		return
 	}
 
-	objects = obj.Data()
-	return
+	w := r.Writer()
+	w.SetVersion(session.Protocol())
+
+	var err error
+	for _, obj := range objs {
+		if err = w.WriteObject(obj); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err != nil {
+		common.Warnf("reply to client failed. session.id=%s, err={%s}", session.ID(), err)
+	}
 }
 
-func (e *engine) handleRequest(objects []protocol.RedisObject) (protocol.RedisObject, error) {
+// dispatch handles a request with the mutex held
+func (e *engine) dispatch(session *types.Session, objects []protocol.RedisObject) ([]protocol.RedisObject, error) {
 	if len(objects) == 0 {
 		return nil, fmt.Errorf("empty request objects")
 	}
@@ -113,27 +396,218 @@ func (e *engine) handleRequest(objects []protocol.RedisObject) (protocol.RedisOb
 		return nil, fmt.Errorf("invalid command name, raw=%+v", objects[0])
 	}
 
-	name := n.Data()
+	name := strings.ToLower(n.Data())
+
+	// a blocked client handles its requests in order after it is served
+	if w, ok := e.waiters[session]; ok {
+		w.deferred = append(w.deferred, objects)
+		return nil, nil
+	}
+
+	// QUIT is handled first even in a transaction, the requests after it are never served
+	if name == "quit" {
+		e.quit(session)
+		return nil, nil
+	}
+
+	// a RESP3 client tells the messages from the replies by their push type, so it can call anything
+	if session.Subscriptions() > 0 && session.Protocol() == protocol.Resp2 && !allowedInSubscribedMode(name) {
+		return nil, fmt.Errorf("command in subscribed mode. name=%s, err={%w}", name, ErrSubscribedMode)
+	}
+
+	var ret protocol.RedisObject
+	var err error
+
+	if isTransactionCommand(name) {
+		ret, err = e.handleTransaction(session, name, objects[1:])
+	} else if session.InMulti() {
+		ret, err = e.queue(session, name, objects)
+	} else if isPubSubCommand(name) {
+		return e.handlePubSub(session, name, objects[1:])
+	} else if name == waitCommandName {
+		ret, err = e.wait(session, objects[1:], true)
+		if err == nil && ret == nil {
+			return nil, nil
+		}
+	} else {
+		var c command.Command
+		var logObjects []protocol.RedisObject
+
+		c, ret, logObjects, err = e.run(session, name, objects)
+		if err == nil && len(logObjects) > 0 {
+			e.writeLog(logObjects[0].(protocol.RedisString).Data(), c.Cluster(), logObjects)
+		}
+
+		if b, ok := c.(command.Blocker); ok && err == nil && b.Blocked() {
+			e.block(session, name, objects, c.Cluster(), b, ret)
+			return nil, nil
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// in the raft mode the reply of a modification is held until it is committed
+	if w, err := e.hold(session, ret); err != nil {
+		return nil, err
+	} else if w != nil {
+		return nil, nil
+	}
+
+	return []protocol.RedisObject{ret}, nil
+}
+
+// held builds the reply if it is a stream, so it can be held while other commands are executed
+func held(obj protocol.RedisObject) protocol.RedisObject {
+	if s, ok := obj.(protocol.RedisStream); ok {
+		return s.Build()
+	}
+
+	return obj
+}
+
+// quit replies OK and closes the connection once the reply is written, the mutex should be held
+func (e *engine) quit(session *types.Session) {
+	e.reply(session, []protocol.RedisObject{protocol.NewSimpleRedisString("OK")})
+
+	if r := session.Replier(); r != nil {
+		r.CloseAfterReply()
+	}
+}
+
+// reject replies the protocol error and closes the connection, as the rest of its input can not be framed
+func (e *engine) reject(session *types.Session, err error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	common.Warnf("engine: close the client for a protocol error. session=%s, err=%s", session.ID(), err)
+
+	var protocolErr *protocol.ProtocolError
+	if !errors.As(err, &protocolErr) {
+		protocolErr = protocol.ErrInvalidFrame
+	}
+
+	e.reply(session, []protocol.RedisObject{protocol.NewRedisError("ERR " + protocolErr.Error())})
+	if r := session.Replier(); r != nil {
+		r.CloseAfterReply()
+	}
+}
+
+// run executes a request with the mutex held. It returns the command, the result and the objects to log,
+// which are empty if the command modifies nothing. The command is nil for PUBLISH, PUBSUB and WAIT.
+func (e *engine) run(session *types.Session, name string, objects []protocol.RedisObject) (command.Command, protocol.RedisObject, []protocol.RedisObject, error) {
+	index := session.DB()
+
+	// PUBLISH and PUBSUB reply only one object, so they can be queued in a transaction
+	if isPubSubCommand(name) {
+		replies, err := e.handlePubSub(session, name, objects[1:])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return nil, replies[0], nil, nil
+	}
+
+	// WAIT replies the current count at once, as the other blocking commands do in a transaction
+	if name == waitCommandName {
+		ret, err := e.wait(session, objects[1:], false)
+		return nil, ret, nil, err
+	}
 
-	c, err := command.NewCommand(name, 1, objects[1:])
+	c, err := command.NewCommand(name, index, objects[1:])
 
 	if err != nil || c == nil {
-		return nil, fmt.Errorf("new commond error, name=%s, index=1, error={%w}", name, err)
+		return nil, nil, nil, fmt.Errorf("new commond error, name=%s, index=%d, error={%w}", name, index, err)
+	}
+
+	if err := e.checkReplication(name, c); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// a modification would be lost since the log is stopped, as redis refuses the writes for MISCONF
+	if c.Type() == command.ModifyCommandType && e.aof != nil {
+		if err := e.aof.Err(); err != nil {
+			return nil, nil, nil, fmt.Errorf("refuse a modification. name=%s, err={%w}, cause={%s}", name, ErrLogWriteFailed, err)
+		}
 	}
 
 	if c.Type() == command.ModifyCommandType {
-		go e.writeLog(name, 1, objects)
+		e.preserve(c)
 	}
 
-	db := e.getOrCreateDB(1)
-	db.ExecuteCommand(c)
+	if err := e.executeLocked(c, session, true); err != nil {
+		return nil, nil, nil, fmt.Errorf("execute error. name=%s, index=%d, err={%w}", name, index, err)
+	}
 
 	ret, err := c.Result()
 	if err != nil {
-		return nil, fmt.Errorf("execute error. name=%s, command=%+v, err={%w}", name, c, err)
+		return nil, nil, nil, fmt.Errorf("execute error. name=%s, command=%+v, err={%w}", name, c, err)
+	}
+
+	var logObjects []protocol.RedisObject
+	if c.Type() == command.ModifyCommandType {
+		logObjects = objects
+		if r, ok := c.(command.Rewriter); ok {
+			logObjects = r.Rewrite()
+		}
+
+		if len(logObjects) > 0 {
+			e.touch(c)
+		}
 	}
 
-	return ret, nil
+	if w, ok := c.(command.Waker); ok {
+		e.signalReady(c.Cluster(), w.ReadyKeys())
+	}
+
+	if w, ok := c.(command.DBWaker); ok {
+		e.signalReadyDBs(w.ReadyDBs())
+	}
+
+	return c, ret, logObjects, nil
+}
+
+// disconnect unwatches all keys, drops all subscriptions and cancels the blocking of the session
+func (e *engine) disconnect(session *types.Session) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.unwatch(session)
+	e.unsubscribeAll(session)
+
+	if w, ok := e.waiters[session]; ok {
+		e.unblock(w)
+	}
+}
+
+// activeExpireCycle removes the expired keys which are never accessed again
+func (e *engine) activeExpireCycle() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.dbMap.Range(func(_, value interface{}) bool {
+		if removed := value.(DB).ActiveExpire(activeExpireRounds); removed > 0 {
+			common.Debugf("active expire removed keys. db=%d, removed=%d", value.(DB).Index(), removed)
+		}
+		return true
+	})
+}
+
+func (e *engine) startExpireWorker() {
+	go func() {
+		ticker := time.NewTicker(activeExpireInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.shutChan:
+				return
+			case <-ticker.C:
+				e.activeExpireCycle()
+			}
+		}
+	}()
 }
 
 func (e *engine) Start() {
@@ -141,73 +615,116 @@ func (e *engine) Start() {
 		e.master.Start()
 	}
 
-Outer:
+	if e.raft != nil {
+		e.raft.Start()
+	}
+
+	e.startExpireWorker()
+	e.startBlockWorker()
+	e.startRewriteWorker()
+
 	for {
 		select {
 		case <-e.shutChan:
-			break Outer
-		default:
-			event, err := e.requestReceiver.Receive()
-
-			if err != nil {
-				if errors.Is(err, concurrency.ErrChannelClosed) {
-					break Outer
-				} else {
-					common.Warnf("unexpected error. event_id=%s, err=%+v", event.ID(), err)
-					break
-				}
+			return
+		case r := <-e.requests:
+			if r.disconnect {
+				e.disconnect(r.session)
+				continue
 			}
 
-			if err := event.Error(); err != nil {
-				if errors.Is(err, concurrency.ErrTopicRemoved) {
-					break Outer
-				} else {
-					common.Warnf("unexpected event error. event_id=%s, err=%+v", event.ID(), err)
-					break
-				}
+			if r.err != nil {
+				e.reject(r.session, r.err)
+			} else {
+				e.handleRequest(r.session, r.object)
 			}
 
-			data, ok := event.Data().(types.DataMap)
-			if !ok {
-				_ = common.Errorf("data from event is not an DataMap. event_id=%s, raw_type=%s", event.ID(), reflect.TypeOf(data).String())
-				break
+			// the replies of a burst of pipelined requests are sent at once
+			if r.session.Done() {
+				e.flush(r.session)
 			}
+		}
+	}
+}
 
-			id, objects, ok := parseRequest(data)
-
-			if !ok {
-				_ = common.Errorf("parse data from DataMap failed. event_id=%s, raw_data=%+v", event.ID(), data)
-				break
-			}
+func (e *engine) Submit(session *types.Session, obj protocol.RedisObject) {
+	session.Submit()
 
-			responseMap := types.NewSimpleDataMap()
-			responseMap.Set("id", id)
+	select {
+	case e.requests <- request{session: session, object: obj}:
+	case <-e.shutChan:
+	}
+}
 
-			ret, err := e.handleRequest(objects)
+func (e *engine) Reject(session *types.Session, err error) {
+	session.Submit()
 
-			if err != nil {
-				responseMap.Set("response", handleError(err))
-			} else {
-				responseMap.Set("response", ret)
-			}
+	select {
+	case e.requests <- request{session: session, err: err}:
+	case <-e.shutChan:
+	}
+}
 
-			e.eventBus.Publish("response", responseMap, err)
-		}
+func (e *engine) Disconnect(session *types.Session) {
+	select {
+	case e.requests <- request{session: session, disconnect: true}:
+	case <-e.shutChan:
 	}
 }
 
 func (e *engine) Stop() {
-	e.master.Stop()
-	_ = e.file.Flush()
+	e.ReplicaOf("")
+
+	if e.master != nil {
+		e.master.Stop()
+	}
+
+	if e.raft != nil {
+		e.raft.Stop()
+	}
+
+	if e.aof != nil {
+		if err := e.aof.Stop(); err != nil {
+			common.Warnf("stop append log failed. err=%s", err.Error())
+		}
+	}
+
 	close(e.shutChan)
 }
 
+// flush sends the queued replies of the session. If the policy is always, they are sent once the logs
+// appended are committed, so the engine goes on while the logs of the requests handled meanwhile join the
+// next commit.
+func (e *engine) flush(session *types.Session) {
+	r := session.Replier()
+	if r == nil {
+		return
+	}
+
+	if e.aof == nil {
+		r.Flush()
+		return
+	}
+
+	e.aof.Commit(r.Flush)
+}
+
+// writeLog appends the log to the database file and feeds it to the replicas, or proposes it in the raft
+// mode. It is called with the mutex held, so the logs are written in the order the commands are executed.
 func (e *engine) writeLog(name string, index int, objects []protocol.RedisObject) {
-	if e.file == nil {
+	if e.raft != nil {
+		e.propose(log.NewLog(name, index, objects))
 		return
 	}
 
-	vl := log.NewLog(name, index, objects)
+	e.appendLog(log.NewLog(name, index, objects))
+}
+
+// appendLog packs the log then writes it as writeLog does, the mutex should be held
+func (e *engine) appendLog(vl *log.VertexLog) {
+	if e.aof == nil && e.master == nil {
+		return
+	}
 
 	buf, err := log.PackLog(vl)
 	if err != nil {
@@ -215,33 +732,76 @@ func (e *engine) writeLog(name string, index int, objects []protocol.RedisObject
 		return
 	}
 
-	n, err := e.file.Write(buf)
-	if n != len(buf) {
-		common.Warnf("not all bytes wrote. n=%d, len(buf)=%d, buf=%+v", n, len(buf), buf)
+	if e.master != nil {
+		e.master.Feed(buf)
+	}
+
+	if e.aof == nil {
+		return
+	}
+
+	if err := e.aof.Append(buf); err != nil {
+		_ = common.Errorf("append log failed. log=%s, err=%s", log.FormatLog(vl), err.Error())
 		return
 	}
 
 	common.Debugf("write an log success. log=%s", log.FormatLog(vl))
 }
 
-// It will ignore any error, just build the database
+// expired writes the removal of an expired key as DEL, so the key is removed as well when the log is replayed
+// or applied by the replicas. A replica keeps the expired keys for the DEL of its master instead, and only
+// the leader of the raft cluster proposes it, which holds no client. The mutex should be held.
+func (e *engine) expired(index int, key string) {
+	vl := log.NewLog("del", index, newRequest("del", key))
+	if e.raft == nil {
+		e.appendLog(vl)
+		return
+	} else if e.raft.Status().State != raft.StateLeader {
+		return
+	}
+
+	buf, err := log.PackLog(vl)
+	if err == nil {
+		_, err = e.raft.Propose(buf)
+	}
+
+	if err != nil {
+		common.Warnf("propose expired key failed. index=%d, key=%s, err=%s", index, key, err.Error())
+	}
+}
+
+// It will ignore any error, just build the database.
+// No key is expired during the rebuilding, the expired keys are removed after the engine starts.
 func (e *engine) BuildFromLog(logs []*log.VertexLog) {
 	success := 0
 	common.Info("rebuild database by log start")
 
 	for _, vl := range logs {
-		arguments := convertArguments(vl.Arguments)
-		c, err := command.NewCommand(vl.Name, int(vl.Index), arguments)
+		if strings.ToLower(vl.Name) == snapshotLogName {
+			continue
+		}
+
+		if strings.ToLower(vl.Name) == transactionLogName {
+			e.mutex.Lock()
+			e.replayTransaction(vl, false)
+			e.mutex.Unlock()
+
+			success++
+			continue
+		}
+
+		c, err := command.NewCommand(vl.Name, int(vl.Index), convertArguments(vl.Args))
 
 		if err != nil || c == nil {
 			common.Warnf("rebuild: new command gives error, log=%s, error={%w}", log.FormatLog(vl), err)
 			continue
 		}
 
-		db := e.getOrCreateDB(int(vl.Index))
-		db.ExecuteCommand(c)
+		// each entry is applied to the db recorded in the log
+		e.mutex.Lock()
+		err = e.replayCommand(c, nil, false)
+		e.mutex.Unlock()
 
-		_, err = c.Result()
 		if err != nil {
 			common.Warnf("rebuild: execute gives error, log=%s, error={%w}", log.FormatLog(vl), err)
 			continue
@@ -253,15 +813,36 @@ func (e *engine) BuildFromLog(logs []*log.VertexLog) {
 	common.Infof("rebuild database by log end, success=%d", success)
 }
 
-func convertArguments(s []string) []protocol.RedisObject {
+// replayCommand executes a command of a log without expiring the keys, the mutex should be held. If live
+// is set, the command modifies the dataset being served, so the snapshot being taken and the watchers are
+// notified as the modifications of the clients.
+func (e *engine) replayCommand(c command.Command, session *types.Session, live bool) error {
+	modify := live && c.Type() == command.ModifyCommandType
+	if modify {
+		e.preserve(c)
+	}
+
+	if err := e.executeLocked(c, session, false); err != nil {
+		return err
+	}
+
+	if _, err := c.Result(); err != nil {
+		return err
+	}
+
+	if modify {
+		e.touch(c)
+	}
+
+	return nil
+}
+
+// convertArguments returns the raw arguments of a log as the bulk strings
+func convertArguments(args [][]byte) []protocol.RedisObject {
 	var ret []protocol.RedisObject
 
-	for _, item := range s {
-		obj, err := protocol.Parse(strings.NewReader(item))
-		if err != nil {
-			continue
-		}
-		ret = append(ret, obj)
+	for _, arg := range args {
+		ret = append(ret, protocol.NewBulkRedisString(string(arg)))
 	}
 
 	return ret
@@ -273,22 +854,112 @@ func handleError(err error) protocol.RedisError {
 		return protocol.NewRedisError("ERR no such command")
 	} else if errors.Is(err, command.ErrArgumentInvalid) {
 		return protocol.NewRedisError("ERR invalid argument")
+	} else if errors.Is(err, command.ErrWrongType) || errors.Is(err, container.ErrWrongType) {
+		return protocol.NewRedisError("WRONGTYPE Operation against a key holding the wrong kind of value")
 	} else if errors.Is(err, container.ErrNotAInt) {
 		return protocol.NewRedisError("ERR value is not an integer or out of range")
 	} else if errors.Is(err, command.ErrNoSuchKey) {
 		return protocol.NewRedisError("ERR no such key")
 	} else if errors.Is(err, container.ErrOutOfRange) {
 		return protocol.NewRedisError("ERR index out of range")
+	} else if errors.Is(err, command.ErrInvalidExpireTime) {
+		return protocol.NewRedisError("ERR invalid expire time")
+	} else if errors.Is(err, command.ErrSyntax) {
+		return protocol.NewRedisError("ERR syntax error")
+	} else if errors.Is(err, command.ErrInvalidCursor) {
+		return protocol.NewRedisError("ERR invalid cursor")
+	} else if errors.Is(err, command.ErrDBIndexOutOfRange) {
+		return protocol.NewRedisError("ERR DB index is out of range")
+	} else if errors.Is(err, command.ErrSameObject) {
+		return protocol.NewRedisError("ERR source and destination objects are the same")
+	} else if errors.Is(err, command.ErrNotAFloat) {
+		return protocol.NewRedisError("ERR value is not a valid float")
+	} else if errors.Is(err, command.ErrInvalidScoreBound) {
+		return protocol.NewRedisError("ERR min or max is not a float")
+	} else if errors.Is(err, command.ErrInvalidLexBound) {
+		return protocol.NewRedisError("ERR min or max not valid string range item")
+	} else if errors.Is(err, command.ErrInvalidTimeout) {
+		return protocol.NewRedisError("ERR timeout is not a float or out of range")
+	} else if errors.Is(err, command.ErrNegativeTimeout) {
+		return protocol.NewRedisError("ERR timeout is negative")
+	} else if errors.Is(err, container.ErrScoreNaN) {
+		return protocol.NewRedisError("ERR resulting score is not a number (NaN)")
+	} else if errors.Is(err, command.ErrInvalidProtocolVersion) {
+		return protocol.NewRedisError("ERR Protocol version is not an integer or out of range")
+	} else if errors.Is(err, command.ErrNoProto) {
+		return protocol.NewRedisError("NOPROTO unsupported protocol version")
+	} else if errors.Is(err, command.ErrInvalidClientName) {
+		return protocol.NewRedisError("ERR Client names cannot contain spaces, newlines or special characters.")
+	} else if errors.Is(err, ErrNestedMulti) {
+		return protocol.NewRedisError("ERR MULTI calls can not be nested")
+	} else if errors.Is(err, ErrExecWithoutMulti) {
+		return protocol.NewRedisError("ERR EXEC without MULTI")
+	} else if errors.Is(err, ErrDiscardWithoutMulti) {
+		return protocol.NewRedisError("ERR DISCARD without MULTI")
+	} else if errors.Is(err, ErrWatchInMulti) {
+		return protocol.NewRedisError("ERR WATCH inside MULTI is not allowed")
+	} else if errors.Is(err, ErrExecAbort) {
+		return protocol.NewRedisError("EXECABORT Transaction discarded because of previous errors.")
+	} else if errors.Is(err, ErrSubscribedMode) {
+		return protocol.NewRedisError("ERR only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+	} else if errors.Is(err, log.ErrRewriteInProgress) {
+		return protocol.NewRedisError("ERR Background append only file rewriting already in progress")
+	} else if errors.Is(err, ErrNoDatabaseFile) {
+		return protocol.NewRedisError("ERR no database file is configured")
+	} else if errors.Is(err, ErrSaveInProgress) {
+		return protocol.NewRedisError("ERR Background save already in progress")
+	} else if errors.Is(err, ErrNoSnapshotFile) {
+		return protocol.NewRedisError("ERR no snapshot file is configured")
+	} else if errors.Is(err, ErrLogWriteFailed) {
+		return protocol.NewRedisError("MISCONF Errors writing to the AOF file, the write commands are disabled. Check the server log.")
+	} else if errors.Is(err, ErrSubscribeInMulti) {
+		return protocol.NewRedisError("ERR Command not allowed inside a transaction")
+	} else if errors.Is(err, ErrLoading) {
+		return protocol.NewRedisError("LOADING Redis is loading the dataset in memory")
+	} else if errors.Is(err, ErrReadOnlyReplica) {
+		return protocol.NewRedisError("READONLY You can't write against a read only replica.")
+	} else if errors.Is(err, command.ErrInvalidMasterPort) {
+		return protocol.NewRedisError("ERR Invalid master port")
+	} else if errors.Is(err, ErrWaitOnReplica) {
+		return protocol.NewRedisError("ERR WAIT cannot be used with replica instances.")
+	} else if errors.Is(err, ErrNoGoodReplicas) {
+		return protocol.NewRedisError("NOREPLICAS Not enough good replicas to write.")
+	} else if errors.Is(err, ErrClusterDown) {
+		return protocol.NewRedisError("CLUSTERDOWN The cluster is down")
+	} else if errors.Is(err, ErrReplicaOfInRaft) {
+		return protocol.NewRedisError("ERR REPLICAOF not allowed in raft mode")
+	} else if errors.Is(err, raft.ErrNotReady) || errors.Is(err, raft.ErrNotLeader) {
+		return protocol.NewRedisError("TRYAGAIN The leader is changing, please try again")
+	} else if errors.Is(err, raft.ErrLeadershipLost) {
+		return protocol.NewRedisError("ERR leadership lost before the write is committed, it may be committed or not")
+	}
+
+	var redirect *redirectError
+	if errors.As(err, &redirect) {
+		return protocol.NewRedisError("MOVED 0 " + redirect.addr)
 	}
 
 	// TODO: do not send raw error
 	return protocol.NewRedisError(fmt.Sprintf("ERR vertex server internal error, err=%+v", err))
 }
 
-func (e *engine) SetFile(file *os.File, filePath string) {
+func (e *engine) SetFile(file *os.File, filePath string, appendFsync string) {
 	e.file = log.NewPersistentFile(file)
+	e.aof = log.NewAppendLog(e.file, appendFsync)
+	e.filePath = filePath
+}
 
-	if e.master != nil {
-		e.master.SetFile(e.file, filePath)
-	}
+func (e *engine) SetAutoRewrite(percentage int, minSize int64) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.autoRewritePercentage = percentage
+	e.autoRewriteMinSize = minSize
+}
+
+func (e *engine) SetSnapshotFile(path string) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.snapshotPath = path
 }
@@ -0,0 +1,257 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+var (
+	// ErrSubscribedMode will be raised if a command other than the subscribe commands is called in the
+	// subscribed mode
+	ErrSubscribedMode = errors.New("engine: only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+
+	// ErrSubscribeInMulti will be raised if a subscribe command is called in a transaction
+	ErrSubscribeInMulti = errors.New("engine: command not allowed inside a transaction")
+)
+
+func isPubSubCommand(name string) bool {
+	switch name {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "publish", "pubsub":
+		return true
+	}
+
+	return false
+}
+
+// allowedInSubscribedMode reports whether the command can be called when the session subscribes anything
+func allowedInSubscribedMode(name string) bool {
+	switch name {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ping", "quit":
+		return true
+	}
+
+	return false
+}
+
+func parseNames(arguments []protocol.RedisObject) ([]string, error) {
+	var ret []string
+
+	for _, obj := range arguments {
+		tmpObj, ok := obj.(protocol.RedisString)
+		if !ok {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		ret = append(ret, tmpObj.Data())
+	}
+
+	return ret, nil
+}
+
+// newSubscriptionReply builds the confirmation of a (un)subscription, the name is nil if nothing is
+// unsubscribed. It is a push as the messages are, which is an array for RESP2.
+func newSubscriptionReply(kind string, name *string, count int) protocol.RedisObject {
+	var obj protocol.RedisObject = protocol.NewNullBulkRedisString()
+	if name != nil {
+		obj = protocol.NewBulkRedisString(*name)
+	}
+
+	return protocol.NewRedisPush([]protocol.RedisObject{
+		protocol.NewBulkRedisString(kind),
+		obj,
+		protocol.NewRedisInteger(int64(count)),
+	})
+}
+
+// handlePubSub handles the pub/sub commands, the mutex should be held. A subscribe command replies one
+// confirmation for each channel or pattern.
+func (e *engine) handlePubSub(session *types.Session, name string, arguments []protocol.RedisObject) ([]protocol.RedisObject, error) {
+	names, err := parseNames(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "subscribe", "psubscribe":
+		if len(names) == 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		var replies []protocol.RedisObject
+		for idx := range names {
+			if name == "subscribe" {
+				e.subscribe(session, names[idx], session.Subscribe, e.channels)
+			} else {
+				e.subscribe(session, names[idx], session.PSubscribe, e.patterns)
+			}
+
+			replies = append(replies, newSubscriptionReply(name, &names[idx], session.Subscriptions()))
+		}
+
+		return replies, nil
+	case "unsubscribe", "punsubscribe":
+		unsubscribe, subscriptions, registry := session.Unsubscribe, session.Channels, e.channels
+		if name == "punsubscribe" {
+			unsubscribe, subscriptions, registry = session.PUnsubscribe, session.Patterns, e.patterns
+		}
+
+		// all are unsubscribed if none is given
+		if len(names) == 0 {
+			names = subscriptions()
+			sort.Strings(names)
+		}
+
+		if len(names) == 0 {
+			return []protocol.RedisObject{newSubscriptionReply(name, nil, session.Subscriptions())}, nil
+		}
+
+		var replies []protocol.RedisObject
+		for idx := range names {
+			e.unsubscribe(session, names[idx], unsubscribe, registry)
+			replies = append(replies, newSubscriptionReply(name, &names[idx], session.Subscriptions()))
+		}
+
+		return replies, nil
+	case "publish":
+		if len(names) != 2 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		return []protocol.RedisObject{protocol.NewRedisInteger(int64(e.publish(names[0], names[1])))}, nil
+	case "pubsub":
+		ret, err := e.pubsubIntrospect(names)
+		if err != nil {
+			return nil, err
+		}
+
+		return []protocol.RedisObject{ret}, nil
+	}
+
+	return nil, command.ErrCommandNotExist
+}
+
+func (e *engine) subscribe(session *types.Session, name string, subscribe func(string) bool, registry map[string]map[*types.Session]struct{}) {
+	if !subscribe(name) {
+		return
+	}
+
+	if _, ok := registry[name]; !ok {
+		registry[name] = make(map[*types.Session]struct{})
+	}
+	registry[name][session] = struct{}{}
+}
+
+func (e *engine) unsubscribe(session *types.Session, name string, unsubscribe func(string) bool, registry map[string]map[*types.Session]struct{}) {
+	if !unsubscribe(name) {
+		return
+	}
+
+	delete(registry[name], session)
+	if len(registry[name]) == 0 {
+		delete(registry, name)
+	}
+}
+
+// unsubscribeAll drops all subscriptions of the session, the mutex should be held
+func (e *engine) unsubscribeAll(session *types.Session) {
+	for _, channel := range session.Channels() {
+		e.unsubscribe(session, channel, session.Unsubscribe, e.channels)
+	}
+
+	for _, pattern := range session.Patterns() {
+		e.unsubscribe(session, pattern, session.PUnsubscribe, e.patterns)
+	}
+}
+
+// publish sends the message to the subscribers of the channel and the matched patterns, it returns the
+// count of the receivers. The messages are queued to the connections, so a slow subscriber never blocks the engine
+// but is limited by the output buffer of its connection.
+func (e *engine) publish(channel, message string) int {
+	receivers := 0
+
+	for session := range e.channels[channel] {
+		e.push(session, protocol.NewRedisPush([]protocol.RedisObject{
+			protocol.NewBulkRedisString("message"),
+			protocol.NewBulkRedisString(channel),
+			protocol.NewBulkRedisString(message),
+		}))
+		receivers++
+	}
+
+	for pattern, sessions := range e.patterns {
+		if !util.GlobMatch(pattern, channel) {
+			continue
+		}
+
+		for session := range sessions {
+			e.push(session, protocol.NewRedisPush([]protocol.RedisObject{
+				protocol.NewBulkRedisString("pmessage"),
+				protocol.NewBulkRedisString(pattern),
+				protocol.NewBulkRedisString(channel),
+				protocol.NewBulkRedisString(message),
+			}))
+			receivers++
+		}
+	}
+
+	return receivers
+}
+
+// push sends the objects to the client of the session out of the request-response order, the mutex should
+// be held
+func (e *engine) push(session *types.Session, objs ...protocol.RedisObject) {
+	e.reply(session, objs)
+	e.flush(session)
+}
+
+// pubsubIntrospect is PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...] and PUBSUB NUMPAT
+func (e *engine) pubsubIntrospect(arguments []string) (protocol.RedisObject, error) {
+	if len(arguments) == 0 {
+		return nil, command.ErrArgumentInvalid
+	}
+
+	switch strings.ToLower(arguments[0]) {
+	case "channels":
+		if len(arguments) > 2 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		var channels []string
+		for channel := range e.channels {
+			if len(arguments) == 1 || util.GlobMatch(arguments[1], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		sort.Strings(channels)
+
+		objs := []protocol.RedisObject{}
+		for _, channel := range channels {
+			objs = append(objs, protocol.NewBulkRedisString(channel))
+		}
+
+		return protocol.NewRedisArray(objs), nil
+	case "numsub":
+		objs := []protocol.RedisObject{}
+		for _, channel := range arguments[1:] {
+			objs = append(objs, protocol.NewBulkRedisString(channel), protocol.NewRedisInteger(int64(len(e.channels[channel]))))
+		}
+
+		return protocol.NewRedisArray(objs), nil
+	case "numpat":
+		if len(arguments) != 1 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		return protocol.NewRedisInteger(int64(len(e.patterns))), nil
+	}
+
+	return nil, fmt.Errorf("unknown pubsub subcommand. subcommand=%s, err={%w}", strconv.Quote(arguments[0]), command.ErrSyntax)
+}
@@ -0,0 +1,228 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/raft"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+var (
+	// ErrClusterDown will be raised if a modification is requested while no leader of the raft cluster is
+	// known, e.g., an election is in progress
+	ErrClusterDown = errors.New("engine: no leader of the raft cluster")
+
+	// ErrReplicaOfInRaft will be raised if REPLICAOF is called in the raft mode
+	ErrReplicaOfInRaft = errors.New("engine: REPLICAOF is not allowed in the raft mode")
+)
+
+// redirectError redirects a modification on a follower to the leader of the raft cluster
+type redirectError struct {
+	addr string
+}
+
+func (r *redirectError) Error() string {
+	return fmt.Sprintf("engine: redirect to the raft leader. addr=%s", r.addr)
+}
+
+// raftMachine is the state machine of the raft node, which is the dataset of the engine. The leader applies
+// a modification before the record is proposed, and the followers apply the records once they are committed.
+type raftMachine struct {
+	engine *engine
+}
+
+// Ready applies what the node has ready, then serves the clients blocked on the keys it modifies
+func (m *raftMachine) Ready() {
+	e := m.engine
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.raft.Apply()
+	e.serveReady()
+}
+
+// Apply applies a record proposed by the other nodes
+func (m *raftMachine) Apply(entry raft.Entry) {
+	vl, _, err := log.NewRecordReader(bytes.NewReader(entry.Data)).Next()
+	if err != nil {
+		_ = common.Errorf("raft: read record failed. index=%d, err=%s", entry.Index, err.Error())
+		return
+	}
+
+	m.engine.applyLog(vl)
+}
+
+// Resolve replies the client held by the record once it is committed, or the error if it may not be
+func (m *raftMachine) Resolve(index uint64, err error) {
+	e := m.engine
+
+	w, ok := e.proposals[index]
+	if !ok {
+		// the client is gone
+		return
+	}
+
+	e.unblock(w)
+
+	reply := w.heldReply
+	if err != nil {
+		reply = handleError(fmt.Errorf("resolve proposal failed. index=%d, err={%w}", index, err))
+	}
+
+	e.push(w.session, append([]protocol.RedisObject{reply}, e.resume(w)...)...)
+}
+
+// Snapshot packs the records rebuilding the dataset
+func (m *raftMachine) Snapshot() ([]byte, error) {
+	buf, err := m.engine.snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Restore replaces the dataset by the snapshot, as a replica applies the dataset of its master
+func (m *raftMachine) Restore(data []byte) error {
+	e := m.engine
+
+	flush := []protocol.RedisObject{protocol.NewBulkRedisString("flushall")}
+	e.applyLog(log.NewLog("flushall", 0, flush))
+
+	if data == nil {
+		return nil
+	}
+
+	reader := bytes.NewReader(data)
+	if version, err := log.ReadHeader(reader); err != nil {
+		return fmt.Errorf("restore raft snapshot failed. err={%w}", err)
+	} else if version != log.LogVersion {
+		return fmt.Errorf("restore raft snapshot failed. version=%d, err={%w}", version, log.ErrUnsupportedLogVersion)
+	}
+
+	records := log.NewRecordReader(reader)
+	for {
+		vl, _, err := records.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("restore raft snapshot failed. err={%w}", err)
+		}
+
+		e.applyLog(vl)
+	}
+
+	common.Infof("raft snapshot restored. size=%d", len(data))
+
+	return nil
+}
+
+func (e *engine) EnableRaft(config raft.Config, storage raft.Storage, transport raft.Transport, addrs map[string]string) (raft.Node, error) {
+	node, err := raft.NewNode(config, &raftMachine{engine: e}, storage, transport)
+	if err != nil {
+		return nil, fmt.Errorf("enable raft failed. id=%s, err={%w}", config.ID, err)
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.raft = node
+	e.raftAddrs = addrs
+
+	return node, nil
+}
+
+// propose proposes the record to the raft cluster, the mutex should be held. The modification is applied
+// already, so the dataset is rebuilt by the node if the proposal is refused.
+func (e *engine) propose(vl *log.VertexLog) {
+	buf, err := log.PackLog(vl)
+	if err != nil {
+		common.Warnf("new log failed. err=%s", err.Error())
+		return
+	}
+
+	e.proposal, e.proposalErr = e.raft.Propose(buf)
+	if e.proposalErr != nil {
+		e.proposalErr = fmt.Errorf("propose record failed. log=%s, err={%w}", log.FormatLog(vl), e.proposalErr)
+	}
+}
+
+// hold parks the client until the record proposed by its request is committed, so the reply is held along
+// with the later requests of the client. It returns nil if nothing is proposed, and the error if the proposal
+// is refused. The mutex should be held.
+func (e *engine) hold(session *types.Session, reply protocol.RedisObject) (*waiter, error) {
+	index, err := e.proposal, e.proposalErr
+	e.proposal, e.proposalErr = 0, nil
+
+	if err != nil {
+		return nil, err
+	} else if index == 0 {
+		return nil, nil
+	}
+
+	w := &waiter{
+		session:   session,
+		proposal:  index,
+		heldReply: held(reply),
+	}
+
+	e.waiters[session] = w
+	e.proposals[index] = w
+
+	return w, nil
+}
+
+// checkRaft refuses the modifications unless the node is the leader which has applied its log, the
+// followers redirect them to the leader. The mutex should be held.
+func (e *engine) checkRaft(name string, c command.Command) error {
+	if name == "replicaof" || name == "slaveof" {
+		return fmt.Errorf("refuse a request. name=%s, err={%w}", name, ErrReplicaOfInRaft)
+	} else if c.Type() != command.ModifyCommandType {
+		return nil
+	}
+
+	status := e.raft.Status()
+	if status.State == raft.StateLeader {
+		if status.LastApplied != status.LastIndex {
+			return fmt.Errorf("refuse a modification. name=%s, err={%w}", name, raft.ErrNotReady)
+		}
+
+		return nil
+	}
+
+	addr, ok := e.raftAddrs[status.Leader]
+	if status.Leader == "" || !ok {
+		return fmt.Errorf("refuse a modification. name=%s, err={%w}", name, ErrClusterDown)
+	}
+
+	return fmt.Errorf("refuse a modification. name=%s, err={%w}", name, &redirectError{addr: addr})
+}
+
+// raftInfo returns the state of the raft node with the mutex held
+func (e *engine) raftInfo() command.RaftInfo {
+	if e.raft == nil {
+		return command.RaftInfo{}
+	}
+
+	status := e.raft.Status()
+	return command.RaftInfo{
+		Enabled:       true,
+		ID:            status.ID,
+		State:         status.State,
+		Term:          status.Term,
+		Leader:        status.Leader,
+		LeaderAddr:    e.raftAddrs[status.Leader],
+		CommitIndex:   status.CommitIndex,
+		LastApplied:   status.LastApplied,
+		LastIndex:     status.LastIndex,
+		SnapshotIndex: status.SnapshotIndex,
+	}
+}
@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/rdb"
+)
+
+// ImportRDB loads a rdb file of redis, the keys in it overwrite the existing ones. The imported keys are
+// appended to the database file as the records rebuilding them, or saved into the snapshot file if there
+// is no database file.
+func (e *engine) ImportRDB(reader io.Reader) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	// the keys are loaded aside, so a failed import changes nothing
+	imported := make(map[int]container.Containers)
+	result, err := rdb.Load(reader, func(index int) (container.Containers, error) {
+		if index < 0 || index >= e.databases {
+			return nil, fmt.Errorf("index=%d, databases=%d", index, e.databases)
+		}
+
+		if _, ok := imported[index]; !ok {
+			imported[index] = container.NewContainers()
+		}
+		return imported[index], nil
+	})
+
+	if err != nil {
+		return fmt.Errorf("import rdb failed. err={%w}", err)
+	}
+
+	for index, keyspace := range imported {
+		target := e.getOrCreateDB(index).Keyspace()
+
+		for _, key := range keyspace.Keys("*") {
+			if target.Remove(key) {
+				e.writeLog("del", index, newRequest("del", key))
+			}
+
+			keyspace.Move(key, target)
+			for _, objects := range keyRecords(target, key) {
+				e.writeLog(objects[0].(protocol.RedisString).Data(), index, objects)
+			}
+		}
+	}
+
+	common.Infof("import rdb end. version=%d, keys=%d, expired=%d, functions=%d", result.Version, result.Keys,
+		result.Expired, result.Functions)
+
+	if e.aof == nil && e.snapshotPath != "" {
+		return e.save()
+	} else if e.aof == nil {
+		common.Warn("the imported keys are not persisted since neither the database file nor the snapshot file is set")
+	}
+
+	return nil
+}
+
+// ExportRDB writes all dbs into a rdb file of redis, the file is replaced once the new one is written
+func (e *engine) ExportRDB(path string) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	err := writeSnapshot(path, func(f *os.File) error {
+		return rdb.Dump(f, e.databases, func(index int) container.Containers {
+			if db := e.getDB(index); db != nil {
+				return db.Keyspace()
+			}
+			return nil
+		})
+	})
+
+	if err != nil {
+		return fmt.Errorf("export rdb failed. err={%w}", err)
+	}
+
+	common.Infof("export rdb end. path=%s", path)
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/replication"
+)
+
+var (
+	// ErrReadOnlyReplica will be raised if a client requests a modification on a replica
+	ErrReadOnlyReplica = errors.New("engine: write against a read only replica")
+
+	// ErrLoading will be raised if a client requests the dataset while a replica is applying the one of the
+	// master
+	ErrLoading = errors.New("engine: loading the dataset")
+)
+
+// SyncSnapshot writes the records rebuilding the dataset for a replica. The keys are queued and the attach
+// is called before the mutex is released, so the records fed to the master after it follow the snapshot
+// exactly, then the records are packed and written round by round while the requests go on.
+func (e *engine) SyncSnapshot(attach func(), write func([]byte) error) error {
+	e.mutex.Lock()
+	d := e.startRecordDump()
+	attach()
+	e.mutex.Unlock()
+
+	return e.runDump(d, write)
+}
+
+// replicaHandler applies what the replica of a REPLICAOF receives. The records of a replica replaced by
+// a later REPLICAOF are dropped, as it is stopped in the background and may still receive some.
+type replicaHandler struct {
+	engine     *engine
+	generation int
+}
+
+// BeginSync drops the dataset before the one of the master is applied, the FLUSHALL is appended to the
+// database file and fed to the replicas of this one as well. The clients are replied LOADING until EndSync.
+func (h *replicaHandler) BeginSync() {
+	e := h.engine
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if h.generation != e.replicaGeneration {
+		return
+	}
+
+	e.loading = true
+
+	flush := []protocol.RedisObject{protocol.NewBulkRedisString("flushall")}
+	e.applyLog(log.NewLog("flushall", 0, flush))
+
+	common.Info("full sync begin")
+}
+
+// EndSync serves the clients again once the dataset of the master is applied
+func (h *replicaHandler) EndSync() {
+	e := h.engine
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if h.generation != e.replicaGeneration {
+		return
+	}
+
+	e.loading = false
+
+	common.Info("full sync end")
+}
+
+// Apply applies a record of the dataset or the stream of the master
+func (h *replicaHandler) Apply(vl *log.VertexLog) {
+	e := h.engine
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if h.generation != e.replicaGeneration {
+		return
+	}
+
+	e.applyLog(vl)
+}
+
+// applyLog applies a record of the master as a modification of the clients, then appends it as it is, the
+// mutex should be held. The clients blocked on the keys are not served, as a replica never modifies the
+// dataset on its own.
+func (e *engine) applyLog(vl *log.VertexLog) {
+	switch strings.ToLower(vl.Name) {
+	case snapshotLogName:
+		// the snapshots of the master mean nothing here
+		return
+	case transactionLogName:
+		e.replayTransaction(vl, true)
+	default:
+		c, err := command.NewCommand(vl.Name, int(vl.Index), convertArguments(vl.Args))
+		if err != nil || c == nil {
+			common.Warnf("replicate: new command gives error, log=%s, error={%w}", log.FormatLog(vl), err)
+			return
+		}
+
+		if err := e.replayCommand(c, nil, true); err != nil {
+			common.Warnf("replicate: execute gives error, log=%s, error={%w}", log.FormatLog(vl), err)
+			return
+		}
+	}
+
+	e.appendLog(vl)
+}
+
+func (e *engine) ReplicaOf(addr string) {
+	e.mutex.Lock()
+	old, _ := e.replicaOf(addr)
+	e.mutex.Unlock()
+
+	// the old one may be applying a record, which needs the mutex
+	if old != nil {
+		old.Stop()
+	}
+}
+
+// replicaOf replaces the replica with the one following the address with the mutex held, an empty address
+// turns the server into a master. It returns the replica replaced, which should be stopped after the mutex
+// is released, and false if the address is followed already.
+func (e *engine) replicaOf(addr string) (replication.Replica, bool) {
+	if e.replica == nil && addr == "" {
+		return nil, false
+	} else if e.replica != nil && e.replica.Status().Addr == addr {
+		return nil, false
+	}
+
+	old := e.replica
+	e.replica = nil
+	e.replicaGeneration++
+
+	// the dataset of an interrupted transfer is served as it is
+	e.loading = false
+
+	if addr != "" {
+		e.replica = replication.NewReplica(addr, &replicaHandler{engine: e, generation: e.replicaGeneration})
+		e.replica.Start()
+		common.Infof("replica of master. addr=%s", addr)
+	} else {
+		common.Info("replica turns into a master")
+	}
+
+	// a replica never expires a key by itself, the expired ones are removed by the DEL of its master
+	e.dbMap.Range(func(_, value interface{}) bool {
+		value.(DB).Keyspace().KeepExpired(e.replica != nil)
+		return true
+	})
+
+	return old, true
+}
+
+// checkReplication refuses the requests of the clients the replication can not serve, i.e., the
+// modifications on a replica or on a master without enough good replicas, and the commands not flagged as
+// loading while the dataset of the master is applied. The raft mode is checked by checkRaft instead. The
+// mutex should be held.
+func (e *engine) checkReplication(name string, c command.Command) error {
+	if e.raft != nil {
+		return e.checkRaft(name, c)
+	} else if e.loading && !command.AllowedWhileLoading(name) {
+		return fmt.Errorf("refuse a request. name=%s, err={%w}", name, ErrLoading)
+	} else if c.Type() != command.ModifyCommandType {
+		return nil
+	}
+
+	if e.replica != nil {
+		return fmt.Errorf("refuse a modification. name=%s, err={%w}", name, ErrReadOnlyReplica)
+	} else if e.minReplicasToWrite > 0 && e.goodReplicas() < e.minReplicasToWrite {
+		return fmt.Errorf("refuse a modification. name=%s, min=%d, err={%w}", name, e.minReplicasToWrite,
+			ErrNoGoodReplicas)
+	}
+
+	return nil
+}
+
+// replicationInfo returns the state of the replication with the mutex held
+func (e *engine) replicationInfo() command.ReplicationInfo {
+	info := command.ReplicationInfo{
+		ReplicationID: strings.Repeat("0", 40),
+	}
+
+	if e.replica != nil {
+		status := e.replica.Status()
+
+		host, port, _ := net.SplitHostPort(status.Addr)
+		info.Replica = true
+		info.MasterHost = host
+		info.MasterPort, _ = strconv.Atoi(port)
+		info.LinkState = status.State
+		info.LastIO = status.LastIO
+		info.LinkDownSince = status.DownSince
+		info.ReplicaOffset = status.Offset
+		info.SyncSize = status.SyncSize
+		info.SyncPosition = status.SyncPosition
+	}
+
+	if e.master != nil {
+		status := e.master.Status()
+
+		info.ReplicationID = status.ReplicationID
+		info.Offset = status.Offset
+		info.BacklogActive = true
+		info.BacklogSize = status.BacklogSize
+		info.BacklogFirstOffset = status.BacklogFirstOffset
+		info.BacklogHistlen = status.BacklogHistlen
+
+		for _, r := range status.Replicas {
+			host, port, _ := net.SplitHostPort(r.Addr)
+			p, _ := strconv.Atoi(port)
+
+			info.Replicas = append(info.Replicas, command.ReplicaInfo{
+				IP:     host,
+				Port:   p,
+				State:  r.State,
+				Offset: r.AckOffset,
+				Lag:    time.Since(r.AckTime),
+			})
+		}
+	}
+
+	if e.replica == nil {
+		info.MinReplicasToWrite = e.minReplicasToWrite
+		info.GoodReplicas = e.goodReplicas()
+	}
+
+	return info
+}
+
+func (e *engine) SetReplBacklogSize(size int) {
+	if e.master != nil {
+		e.master.SetBacklogSize(size)
+	}
+}
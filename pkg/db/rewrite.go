@@ -0,0 +1,241 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/protocol"
+)
+
+const (
+	// rewriteItemsPerCommand is the max elements in one command of a rewritten file, so a large container
+	// does not make up a huge record
+	rewriteItemsPerCommand = 64
+
+	// rewriteCheckInterval is the interval between two checks of the automatic rewrite
+	rewriteCheckInterval = time.Second
+)
+
+// ErrNoDatabaseFile will be raised if the database file is rewritten while no file is set
+var ErrNoDatabaseFile = errors.New("engine: no database file")
+
+// rewriteLog starts a rewrite of the database file, the mutex should be held. The keys are queued at once,
+// so the records rebuilding them are a snapshot of the dataset, then they are dumped into the new file round
+// by round by a background goroutine while the requests go on.
+func (e *engine) rewriteLog() error {
+	if e.aof == nil {
+		return ErrNoDatabaseFile
+	} else if e.aof.Status().Rewriting {
+		return log.ErrRewriteInProgress
+	}
+
+	d := e.startRecordDump()
+	if err := e.aof.StartRewrite(); err != nil {
+		e.stopDump(d)
+		return fmt.Errorf("rewrite log failed. err={%w}", err)
+	}
+
+	common.Infof("background append only file rewriting started. keys=%d", len(d.queue))
+	go e.finishRewrite(d)
+
+	return nil
+}
+
+// finishRewrite dumps the records into a temporary file, then it replaces the database file with the
+// records appended meanwhile
+func (e *engine) finishRewrite(d *dumpState) {
+	tmpPath := e.filePath + ".rewrite"
+
+	err := func() error {
+		f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return fmt.Errorf("create rewrite file failed. path=%s, err={%w}", tmpPath, err)
+		}
+
+		err = e.runDump(d, func(data []byte) error {
+			_, err := f.Write(data)
+			return err
+		})
+		if err != nil {
+			_ = f.Close()
+			return fmt.Errorf("write snapshot failed. path=%s, err={%w}", tmpPath, err)
+		}
+
+		file, err := e.aof.FinishRewrite(f, e.filePath)
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+
+		e.mutex.Lock()
+		e.file = file
+		e.mutex.Unlock()
+
+		return nil
+	}()
+
+	if err != nil {
+		e.aof.AbortRewrite(err)
+		_ = os.Remove(tmpPath)
+		_ = common.Errorf("background append only file rewriting failed. err=%s", err.Error())
+		return
+	}
+
+	common.Infof("background append only file rewriting finished. path=%s", e.filePath)
+}
+
+// startRecordDump starts a dump of the records rebuilding all keys of all dbs after the header of the file,
+// the mutex should be held
+func (e *engine) startRecordDump() *dumpState {
+	d := &dumpState{}
+	d.buf.Write(log.Header())
+
+	d.dump = func(keyspace container.Containers, index int, key string) error {
+		for _, objects := range keyRecords(keyspace, key) {
+			record, err := log.PackLog(log.NewLog(objects[0].(protocol.RedisString).Data(), index, objects))
+			if err != nil {
+				return fmt.Errorf("pack snapshot failed. index=%d, key=%s, err={%w}", index, key, err)
+			}
+
+			d.buf.Write(record)
+		}
+
+		return nil
+	}
+
+	e.startDump(d)
+
+	return d
+}
+
+// snapshot packs the records rebuilding all keys of all dbs at once, the mutex should be held
+func (e *engine) snapshot() (*bytes.Buffer, error) {
+	d := e.startRecordDump()
+	if err := e.dumpAll(d); err != nil {
+		return nil, err
+	}
+
+	return &d.buf, nil
+}
+
+// keyRecords returns the requests rebuilding the key with its deadline, the large containers are split
+// into several requests
+func keyRecords(keyspace container.Containers, key string) [][]protocol.RedisObject {
+	var records [][]protocol.RedisObject
+
+	switch obj := keyspace.Get(key).(type) {
+	case *container.StringContainer:
+		records = append(records, newRequest("set", key, obj.String()))
+	case container.ListContainer:
+		elements, _ := obj.Range(0, obj.Len()-1)
+		records = appendBatches(records, "rpush", key, stringsOf(elements), 1)
+	case container.HashContainer:
+		fields, values := obj.Entries()
+
+		var items []string
+		for idx := range fields {
+			items = append(items, fields[idx].String(), values[idx].String())
+		}
+		records = appendBatches(records, "hset", key, items, 2)
+	case container.SetContainer:
+		records = appendBatches(records, "sadd", key, stringsOf(obj.Members()), 1)
+	case container.SortedSetContainer:
+		members, scores := obj.RangeByRank(0, obj.Len()-1)
+
+		var items []string
+		for idx := range members {
+			items = append(items, protocol.FormatDouble(scores[idx]), members[idx].String())
+		}
+		records = appendBatches(records, "zadd", key, items, 2)
+	}
+
+	if deadline, ok := keyspace.Deadline(key); ok && len(records) > 0 {
+		records = append(records, newRequest("pexpireat", key, strconv.FormatInt(deadline, 10)))
+	}
+
+	return records
+}
+
+// appendBatches appends the requests adding the items to the key, at most rewriteItemsPerCommand elements
+// a request. An element is made up of width items, e.g., a field and its value.
+func appendBatches(records [][]protocol.RedisObject, name string, key string, items []string, width int) [][]protocol.RedisObject {
+	batch := rewriteItemsPerCommand * width
+
+	for start := 0; start < len(items); start += batch {
+		end := start + batch
+		if end > len(items) {
+			end = len(items)
+		}
+
+		records = append(records, newRequest(name, key, items[start:end]...))
+	}
+
+	return records
+}
+
+func newRequest(name string, key string, arguments ...string) []protocol.RedisObject {
+	objects := []protocol.RedisObject{protocol.NewBulkRedisString(name), protocol.NewBulkRedisString(key)}
+	for _, argument := range arguments {
+		objects = append(objects, protocol.NewBulkRedisString(argument))
+	}
+
+	return objects
+}
+
+func stringsOf(containers []*container.StringContainer) []string {
+	var ret []string
+	for _, item := range containers {
+		ret = append(ret, item.String())
+	}
+
+	return ret
+}
+
+// shouldRewrite reports if the database file grows enough to be rewritten automatically
+func (e *engine) shouldRewrite() bool {
+	if e.aof == nil || e.autoRewritePercentage <= 0 {
+		return false
+	}
+
+	status := e.aof.Status()
+	if status.Rewriting || status.WriteErr != nil || status.Size < e.autoRewriteMinSize {
+		return false
+	}
+
+	base := status.BaseSize
+	if base <= 0 {
+		base = 1
+	}
+
+	return (status.Size-base)*100/base >= int64(e.autoRewritePercentage)
+}
+
+// startRewriteWorker rewrites the database file once it grows enough
+func (e *engine) startRewriteWorker() {
+	go func() {
+		ticker := time.NewTicker(rewriteCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-e.shutChan:
+				return
+			case <-ticker.C:
+				e.mutex.Lock()
+				if e.shouldRewrite() {
+					if err := e.rewriteLog(); err != nil {
+						common.Warnf("start automatic rewrite failed. err=%s", err.Error())
+					}
+				}
+				e.mutex.Unlock()
+			}
+		}
+	}()
+}
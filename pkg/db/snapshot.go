@@ -0,0 +1,586 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/snapshot"
+	"github.com/lxdlam/vertex/pkg/types"
+	"github.com/lxdlam/vertex/pkg/util"
+)
+
+const (
+	// snapshotLogName is the name of the record marking where a snapshot is taken in the database file, the
+	// records after it are replayed on the snapshot
+	snapshotLogName = "snapshot"
+
+	// snapshotKeysPerRound is the max keys dumped by a background save each time it holds the mutex
+	snapshotKeysPerRound = 256
+)
+
+var (
+	// ErrNoSnapshotFile will be raised if a snapshot is taken while no snapshot file is set
+	ErrNoSnapshotFile = errors.New("engine: no snapshot file")
+
+	// ErrSaveInProgress will be raised if a snapshot is taken while a background save is running
+	ErrSaveInProgress = errors.New("engine: background save in progress")
+)
+
+// dumpState is a dump of the dataset being taken. The keys are dumped as they are when the dump is started:
+// each key is queued at first, and a pending key is dumped before it is modified, so a background dump only
+// needs the mutex to dump a few keys at a time.
+type dumpState struct {
+	buf     bytes.Buffer
+	queue   []types.WatchedKey
+	next    int
+	pending map[types.WatchedKey]struct{}
+	err     error
+
+	// dump writes the key into buf, and close writes the end of the dump once all keys are dumped
+	dump  func(keyspace container.Containers, index int, key string) error
+	close func() error
+}
+
+// saveState is a snapshot being taken
+type saveState struct {
+	*dumpState
+	header snapshot.Header
+	writer *snapshot.Writer
+}
+
+// checkSave reports if a snapshot can be taken, the mutex should be held
+func (e *engine) checkSave() error {
+	if e.snapshotPath == "" {
+		return ErrNoSnapshotFile
+	} else if e.saving != nil {
+		return ErrSaveInProgress
+	}
+
+	return nil
+}
+
+// startDump queues all keys of all dbs into the dump, the keys are preserved for it until stopDump is
+// called. The mutex should be held.
+func (e *engine) startDump(d *dumpState) {
+	d.pending = make(map[types.WatchedKey]struct{})
+
+	for index := 0; index < e.databases; index++ {
+		db := e.getDB(index)
+		if db == nil {
+			continue
+		}
+
+		for _, key := range db.Keyspace().Keys("*") {
+			wk := types.WatchedKey{DB: index, Key: key}
+			d.queue = append(d.queue, wk)
+			d.pending[wk] = struct{}{}
+		}
+	}
+
+	e.dumps = append(e.dumps, d)
+}
+
+// stopDump stops preserving the keys for the dump, the mutex should be held
+func (e *engine) stopDump(d *dumpState) {
+	for idx := range e.dumps {
+		if e.dumps[idx] == d {
+			e.dumps = append(e.dumps[:idx], e.dumps[idx+1:]...)
+			return
+		}
+	}
+}
+
+// startSave queues all keys of all dbs into a new snapshot and marks it in the database file, the mutex
+// should be held
+func (e *engine) startSave() (*saveState, error) {
+	if err := e.checkSave(); err != nil {
+		return nil, err
+	}
+
+	st := &saveState{
+		dumpState: &dumpState{},
+		header: snapshot.Header{
+			Created: time.Now(),
+			ID:      util.GenNewUUID(),
+		},
+	}
+
+	writer, err := snapshot.NewWriter(&st.buf, st.header)
+	if err != nil {
+		return nil, fmt.Errorf("start save failed. err={%w}", err)
+	}
+	st.writer = writer
+
+	st.dump = func(keyspace container.Containers, index int, key string) error {
+		if entry := keyEntry(keyspace, index, key); entry != nil {
+			return writer.WriteEntry(entry)
+		}
+
+		return nil
+	}
+	st.close = writer.Close
+
+	e.startDump(st.dumpState)
+	e.writeLog(snapshotLogName, 0, newRequest(snapshotLogName, st.header.ID))
+
+	return st, nil
+}
+
+// dumpKey writes the key into the dump if it is not dumped yet, the mutex should be held
+func (e *engine) dumpKey(d *dumpState, wk types.WatchedKey) {
+	if _, ok := d.pending[wk]; !ok {
+		return
+	}
+	delete(d.pending, wk)
+
+	db := e.getDB(wk.DB)
+	if db == nil || d.err != nil {
+		return
+	}
+
+	d.err = d.dump(db.Keyspace(), wk.DB, wk.Key)
+}
+
+// dumpRound dumps at most count queued keys, it reports if all keys are dumped. The mutex should be held.
+func (e *engine) dumpRound(d *dumpState, count int) bool {
+	for ; d.next < len(d.queue) && count > 0; d.next++ {
+		if _, ok := d.pending[d.queue[d.next]]; ok {
+			e.dumpKey(d, d.queue[d.next])
+			count--
+		}
+	}
+
+	return d.next >= len(d.queue)
+}
+
+// dumpAll dumps the rest keys at once and closes the dump, the mutex should be held
+func (e *engine) dumpAll(d *dumpState) error {
+	e.dumpRound(d, len(d.queue))
+	e.stopDump(d)
+
+	if d.err == nil && d.close != nil {
+		d.err = d.close()
+	}
+
+	return d.err
+}
+
+// runDump dumps the keys round by round, and gives the dumped bytes to the write between the rounds
+func (e *engine) runDump(d *dumpState, write func([]byte) error) error {
+	defer func() {
+		e.mutex.Lock()
+		e.stopDump(d)
+		e.mutex.Unlock()
+	}()
+
+	for done := false; !done; {
+		e.mutex.Lock()
+		done = e.dumpRound(d, snapshotKeysPerRound)
+		err := d.err
+		if err == nil && done && d.close != nil {
+			err = d.close()
+		}
+		data := d.take()
+		e.mutex.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		if err := write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// preserve dumps the keys the command is going to modify while the dumps are running, so they are dumped
+// as they are when the dumps are started. The mutex should be held.
+func (e *engine) preserve(c command.Command) {
+	for _, d := range e.dumps {
+		if len(d.pending) == 0 {
+			continue
+		}
+
+		// the server commands may touch any db, e.g., SWAPDB and FLUSHALL
+		_, ok := c.(command.ServerCommand)
+		if ok || c.Keys() == nil {
+			e.dumpRound(d, len(d.queue))
+			continue
+		}
+
+		for _, key := range c.Keys() {
+			e.dumpKey(d, types.WatchedKey{DB: c.Cluster(), Key: key})
+		}
+	}
+}
+
+// take returns the bytes written into the dump since the last time, the mutex should be held
+func (d *dumpState) take() []byte {
+	data := append([]byte(nil), d.buf.Bytes()...)
+	d.buf.Reset()
+
+	return data
+}
+
+// schedule defers the save to the end of the running transaction, since the transaction is written as one
+// record and the snapshot is marked after it. The mutex should be held.
+func (e *engine) schedule(save func() error) error {
+	if err := e.checkSave(); err != nil {
+		return err
+	}
+
+	e.scheduledSave = save
+	return nil
+}
+
+// runScheduledSave takes the snapshot scheduled by the transaction, the mutex should be held
+func (e *engine) runScheduledSave() {
+	save := e.scheduledSave
+	if save == nil {
+		return
+	}
+
+	e.scheduledSave = nil
+	if err := save(); err != nil {
+		common.Warnf("scheduled save failed. err=%s", err.Error())
+	}
+}
+
+// save takes a snapshot at once with the mutex held
+func (e *engine) save() error {
+	if e.inExec {
+		return e.schedule(e.save)
+	}
+
+	st, err := e.startSave()
+	if err != nil {
+		return err
+	}
+
+	err = e.dumpAll(st.dumpState)
+	if err == nil {
+		err = writeSnapshot(e.snapshotPath, func(f *os.File) error {
+			_, err := f.Write(st.take())
+			return err
+		})
+	}
+
+	if err != nil {
+		e.saveErr = err
+		return fmt.Errorf("save failed. err={%w}", err)
+	}
+
+	e.lastSave, e.saveErr = st.header.Created, nil
+	common.Infof("db saved on disk. path=%s, id=%s", e.snapshotPath, st.header.ID)
+
+	return nil
+}
+
+// backgroundSave starts a snapshot which is dumped by a background goroutine, the mutex should be held
+func (e *engine) backgroundSave() error {
+	if e.inExec {
+		return e.schedule(e.backgroundSave)
+	}
+
+	st, err := e.startSave()
+	if err != nil {
+		return err
+	}
+
+	e.saving = st
+	common.Infof("background saving started. id=%s, keys=%d", st.header.ID, len(st.queue))
+	go e.finishSave(st)
+
+	return nil
+}
+
+// finishSave dumps the keys in the background, and writes the dumped bytes into the file between the rounds
+func (e *engine) finishSave(st *saveState) {
+	err := writeSnapshot(e.snapshotPath, func(f *os.File) error {
+		return e.runDump(st.dumpState, func(data []byte) error {
+			_, err := f.Write(data)
+			return err
+		})
+	})
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.saving = nil
+	e.saveErr = err
+
+	if err != nil {
+		_ = common.Errorf("background saving failed. path=%s, err=%s", e.snapshotPath, err.Error())
+		return
+	}
+
+	e.lastSave = st.header.Created
+	common.Infof("background saving finished. path=%s, id=%s", e.snapshotPath, st.header.ID)
+}
+
+// writeSnapshot writes a dump of the dataset into a temporary file by the function, then it replaces the
+// file once the temporary one is committed
+func writeSnapshot(path string, write func(*os.File) error) error {
+	tmpPath := path + ".tmp"
+
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create snapshot file failed. path=%s, err={%w}", tmpPath, err)
+	}
+
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("write snapshot file failed. path=%s, err={%w}", path, err)
+	}
+
+	log.SyncDir(filepath.Dir(path))
+
+	return nil
+}
+
+// keyEntry returns the entry of the key with its deadline, nil if the key is not exist
+func keyEntry(keyspace container.Containers, index int, key string) *snapshot.Entry {
+	entry := &snapshot.Entry{
+		DB:       index,
+		Key:      key,
+		Deadline: snapshot.NoDeadline,
+	}
+
+	switch obj := keyspace.Get(key).(type) {
+	case *container.StringContainer:
+		entry.Type = snapshot.StringEntry
+		entry.Values = []string{obj.String()}
+	case container.ListContainer:
+		elements, _ := obj.Range(0, obj.Len()-1)
+		entry.Type = snapshot.ListEntry
+		entry.Values = stringsOf(elements)
+	case container.HashContainer:
+		fields, values := obj.Entries()
+		entry.Type = snapshot.HashEntry
+		for idx := range fields {
+			entry.Values = append(entry.Values, fields[idx].String(), values[idx].String())
+		}
+	case container.SetContainer:
+		entry.Type = snapshot.SetEntry
+		entry.Values = stringsOf(obj.Members())
+	case container.SortedSetContainer:
+		members, scores := obj.RangeByRank(0, obj.Len()-1)
+		entry.Type = snapshot.SortedSetEntry
+		entry.Values = stringsOf(members)
+		entry.Scores = scores
+	default:
+		return nil
+	}
+
+	if deadline, ok := keyspace.Deadline(key); ok {
+		entry.Deadline = deadline
+	}
+
+	return entry
+}
+
+// Restore loads the snapshot file, then replays the records written after it. The snapshot is ignored if
+// it is corrupted or the records do not follow it, since the records always hold the whole dataset.
+func (e *engine) Restore(logs []*log.VertexLog) {
+	if e.snapshotPath == "" {
+		e.BuildFromLog(logs)
+		return
+	}
+
+	header, err := verifySnapshot(e.snapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		e.BuildFromLog(logs)
+		return
+	} else if err != nil {
+		common.Warnf("ignore the snapshot file. path=%s, err=%s", e.snapshotPath, err.Error())
+		e.BuildFromLog(logs)
+		return
+	}
+
+	tail := -1
+	for idx := len(logs) - 1; idx >= 0; idx-- {
+		if snapshotID(logs[idx]) == header.ID {
+			tail = idx + 1
+			break
+		}
+	}
+
+	if tail < 0 && len(logs) > 0 {
+		common.Warnf("ignore the snapshot file since the database file does not follow it. path=%s, id=%s", e.snapshotPath, header.ID)
+		e.BuildFromLog(logs)
+		return
+	}
+
+	if err := e.loadSnapshot(e.snapshotPath); err != nil {
+		_ = common.Errorf("load snapshot failed. path=%s, err=%s", e.snapshotPath, err.Error())
+		e.flushAll()
+		e.BuildFromLog(logs)
+		return
+	}
+
+	if tail < 0 {
+		// the records written from now on follow the snapshot
+		e.mutex.Lock()
+		e.writeLog(snapshotLogName, 0, newRequest(snapshotLogName, header.ID))
+		e.mutex.Unlock()
+		tail = 0
+	}
+
+	e.mutex.Lock()
+	e.lastSave = header.Created
+	e.mutex.Unlock()
+
+	e.BuildFromLog(logs[tail:])
+}
+
+// snapshotID returns the id of the snapshot the record marks, empty if it is not a mark
+func snapshotID(vl *log.VertexLog) string {
+	if strings.ToLower(vl.Name) != snapshotLogName {
+		return ""
+	}
+
+	if len(vl.Args) != 1 {
+		return ""
+	}
+
+	return string(vl.Args[0])
+}
+
+func verifySnapshot(path string) (snapshot.Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return snapshot.Header{}, fmt.Errorf("open snapshot file failed. err={%w}", err)
+	}
+	defer f.Close()
+
+	return snapshot.Verify(f)
+}
+
+// loadSnapshot applies the entries of the snapshot, the expired ones are skipped
+func (e *engine) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open snapshot file failed. err={%w}", err)
+	}
+	defer f.Close()
+
+	r, err := snapshot.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	loaded := 0
+
+	for {
+		entry, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		if entry.Deadline != snapshot.NoDeadline && entry.Deadline <= now {
+			continue
+		}
+
+		db, err := e.selectDB(entry.DB)
+		if err != nil {
+			return fmt.Errorf("load entry failed. key=%s, err={%w}", entry.Key, err)
+		}
+
+		if err := loadEntry(db.Keyspace(), entry); err != nil {
+			return fmt.Errorf("load entry failed. key=%s, err={%w}", entry.Key, err)
+		}
+		loaded++
+	}
+
+	common.Infof("load snapshot end. path=%s, id=%s, keys=%d", path, r.Header().ID, loaded)
+
+	return nil
+}
+
+func loadEntry(keyspace container.Containers, entry *snapshot.Entry) error {
+	values := make([]*container.StringContainer, 0, len(entry.Values))
+	for _, value := range entry.Values {
+		values = append(values, container.NewString(value))
+	}
+
+	var err error
+
+	switch entry.Type {
+	case snapshot.StringEntry:
+		err = keyspace.Global().Set([]*container.StringContainer{container.NewString(entry.Key)}, values)
+	case snapshot.ListEntry:
+		if l := keyspace.GetOrCreateList(entry.Key); l != nil {
+			_, err = l.PushTail(values)
+		}
+	case snapshot.HashEntry:
+		if h := keyspace.GetOrCreateHash(entry.Key); h != nil {
+			var fields, items []*container.StringContainer
+			for idx := 0; idx+1 < len(values); idx += 2 {
+				fields = append(fields, values[idx])
+				items = append(items, values[idx+1])
+			}
+			_, err = h.Set(fields, items)
+		}
+	case snapshot.SetEntry:
+		if s := keyspace.GetOrCreateSet(entry.Key); s != nil {
+			s.Add(values)
+		}
+	case snapshot.SortedSetEntry:
+		if z := keyspace.GetOrCreateSortedSet(entry.Key); z != nil {
+			_, err = z.Add(entry.Scores, values)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keyspace.RemoveIfEmpty(entry.Key)
+	if entry.Deadline != snapshot.NoDeadline {
+		keyspace.SetDeadline(entry.Key, entry.Deadline)
+	}
+
+	return nil
+}
+
+// flushAll removes all keys of all dbs, e.g., a snapshot is loaded partially
+func (e *engine) flushAll() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.dbMap.Range(func(_, value interface{}) bool {
+		value.(DB).Keyspace().Flush()
+		return true
+	})
+}
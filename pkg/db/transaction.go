@@ -0,0 +1,333 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+var (
+	// ErrNestedMulti will be raised if MULTI is called in a transaction
+	ErrNestedMulti = errors.New("engine: MULTI calls can not be nested")
+
+	// ErrExecWithoutMulti will be raised if EXEC is called outside a transaction
+	ErrExecWithoutMulti = errors.New("engine: EXEC without MULTI")
+
+	// ErrDiscardWithoutMulti will be raised if DISCARD is called outside a transaction
+	ErrDiscardWithoutMulti = errors.New("engine: DISCARD without MULTI")
+
+	// ErrWatchInMulti will be raised if WATCH is called in a transaction
+	ErrWatchInMulti = errors.New("engine: WATCH inside MULTI is not allowed")
+
+	// ErrExecAbort will be raised by EXEC if any request failed to queue
+	ErrExecAbort = errors.New("engine: transaction discarded because of previous errors")
+)
+
+// transactionLogName is the name of the log record holding a whole transaction, the arguments are the
+// requests in it. A `select` request is recorded between them if the db is changed.
+const transactionLogName = "exec"
+
+func isTransactionCommand(name string) bool {
+	switch name {
+	case "multi", "exec", "discard", "watch", "unwatch":
+		return true
+	}
+
+	return false
+}
+
+// handleTransaction handles the transaction commands, the mutex should be held
+func (e *engine) handleTransaction(session *types.Session, name string, arguments []protocol.RedisObject) (protocol.RedisObject, error) {
+	switch name {
+	case "multi":
+		if len(arguments) != 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		if session.InMulti() {
+			return nil, ErrNestedMulti
+		}
+
+		session.Multi()
+		return protocol.NewSimpleRedisString("OK"), nil
+	case "exec":
+		if len(arguments) != 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		return e.exec(session)
+	case "discard":
+		if len(arguments) != 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		if !session.InMulti() {
+			return nil, ErrDiscardWithoutMulti
+		}
+
+		session.ResetMulti()
+		e.unwatch(session)
+		return protocol.NewSimpleRedisString("OK"), nil
+	case "watch":
+		if len(arguments) == 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		if session.InMulti() {
+			return nil, ErrWatchInMulti
+		}
+
+		var keys []string
+		for _, obj := range arguments {
+			tmpObj, ok := obj.(protocol.RedisString)
+			if !ok {
+				return nil, command.ErrArgumentInvalid
+			}
+
+			keys = append(keys, tmpObj.Data())
+		}
+
+		e.watch(session, keys)
+		return protocol.NewSimpleRedisString("OK"), nil
+	case "unwatch":
+		if len(arguments) != 0 {
+			return nil, command.ErrArgumentInvalid
+		}
+
+		// EXEC unwatches all keys anyway, so UNWATCH in a transaction does nothing but being queued
+		if session.InMulti() {
+			session.Queue([]protocol.RedisObject{protocol.NewBulkRedisString(name)})
+			return protocol.NewSimpleRedisString("QUEUED"), nil
+		}
+
+		e.unwatch(session)
+		return protocol.NewSimpleRedisString("OK"), nil
+	}
+
+	return nil, command.ErrCommandNotExist
+}
+
+// queue validates the request and queues it into the transaction. A request that can not be built fails
+// the whole transaction, which is discarded by EXEC then.
+func (e *engine) queue(session *types.Session, name string, objects []protocol.RedisObject) (protocol.RedisObject, error) {
+	if isPubSubCommand(name) {
+		if name != "publish" && name != "pubsub" {
+			session.FailMulti()
+			return nil, ErrSubscribeInMulti
+		}
+
+		session.Queue(objects)
+		return protocol.NewSimpleRedisString("QUEUED"), nil
+	}
+
+	if name == waitCommandName {
+		if _, _, err := parseWait(objects[1:]); err != nil {
+			session.FailMulti()
+			return nil, fmt.Errorf("queue command failed. name=%s, err={%w}", name, err)
+		}
+
+		session.Queue(objects)
+		return protocol.NewSimpleRedisString("QUEUED"), nil
+	}
+
+	c, err := command.NewCommand(name, session.DB(), objects[1:])
+	if err == nil && c != nil {
+		err = e.checkReplication(name, c)
+	}
+
+	if err != nil {
+		session.FailMulti()
+		return nil, fmt.Errorf("queue command failed. name=%s, err={%w}", name, err)
+	}
+
+	session.Queue(objects)
+	return protocol.NewSimpleRedisString("QUEUED"), nil
+}
+
+// exec runs the queued requests at once with the mutex held, so no other request or active expiring can
+// happen in between. The errors of the requests are replied in place and do not stop the rest. The
+// modifications are written as one log record, so a transaction is either replayed as a whole or not.
+func (e *engine) exec(session *types.Session) (protocol.RedisObject, error) {
+	if !session.InMulti() {
+		return nil, ErrExecWithoutMulti
+	}
+
+	defer e.unwatch(session)
+	defer session.ResetMulti()
+
+	if session.MultiFailed() {
+		return nil, ErrExecAbort
+	}
+
+	if e.watchBroken(session) {
+		return protocol.NewNullRedisArray(), nil
+	}
+
+	index := session.DB()
+	current := index
+	records := []protocol.RedisObject{protocol.NewBulkRedisString(transactionLogName)}
+
+	// a snapshot taken in the transaction is started once its record is written
+	e.inExec = true
+	defer e.runScheduledSave()
+
+	var replies []protocol.RedisObject
+	for _, objects := range session.Queued() {
+		name := strings.ToLower(objects[0].(protocol.RedisString).Data())
+		if name == "unwatch" {
+			replies = append(replies, protocol.NewSimpleRedisString("OK"))
+			continue
+		}
+
+		// a blocking command replies its current result at once
+		c, ret, logObjects, err := e.run(session, name, objects)
+		if err != nil {
+			replies = append(replies, handleError(err))
+			continue
+		}
+
+		replies = append(replies, held(ret))
+
+		if len(logObjects) > 0 {
+			if cluster := c.Cluster(); cluster != current {
+				records = append(records, protocol.NewRedisArray([]protocol.RedisObject{
+					protocol.NewBulkRedisString("select"),
+					protocol.NewBulkRedisString(strconv.Itoa(cluster)),
+				}))
+				current = cluster
+			}
+
+			records = append(records, protocol.NewRedisArray(logObjects))
+		}
+	}
+
+	e.inExec = false
+	if len(records) > 1 {
+		e.writeLog(transactionLogName, index, records)
+	}
+
+	return protocol.NewRedisArray(replies), nil
+}
+
+// watch watches the keys in the selected db, the mutex should be held
+func (e *engine) watch(session *types.Session, keys []string) {
+	db := e.getOrCreateDB(session.DB())
+
+	for _, key := range keys {
+		// an expired key kept on a replica is missing as well
+		exists := !db.Keyspace().ExpireIfNeeded(key) && db.Keyspace().Get(key) != nil
+
+		wk := types.WatchedKey{DB: session.DB(), Key: key}
+		if !session.Watch(wk, exists) {
+			continue
+		}
+
+		if _, ok := e.watchers[wk]; !ok {
+			e.watchers[wk] = make(map[*types.Session]struct{})
+		}
+		e.watchers[wk][session] = struct{}{}
+	}
+}
+
+// unwatch forgets all keys watched by the session, the mutex should be held
+func (e *engine) unwatch(session *types.Session) {
+	for wk := range session.Watched() {
+		delete(e.watchers[wk], session)
+		if len(e.watchers[wk]) == 0 {
+			delete(e.watchers, wk)
+		}
+	}
+
+	session.Unwatch()
+}
+
+// touch marks the sessions watching the keys modified by the command. The commands working on the whole
+// keyspace or across the dbs touch every watched key.
+func (e *engine) touch(c command.Command) {
+	if len(e.watchers) == 0 {
+		return
+	}
+
+	_, ok := c.(command.ServerCommand)
+	if ok || c.Keys() == nil {
+		for _, sessions := range e.watchers {
+			for session := range sessions {
+				session.SetDirty()
+			}
+		}
+
+		return
+	}
+
+	for _, key := range c.Keys() {
+		for session := range e.watchers[types.WatchedKey{DB: c.Cluster(), Key: key}] {
+			session.SetDirty()
+		}
+	}
+}
+
+// watchBroken reports whether a watched key is modified or expired since it is watched
+func (e *engine) watchBroken(session *types.Session) bool {
+	if session.Dirty() {
+		return true
+	}
+
+	for wk, existed := range session.Watched() {
+		if !existed {
+			continue
+		}
+
+		keyspace := e.getOrCreateDB(wk.DB).Keyspace()
+		if keyspace.ExpireIfNeeded(wk.Key) || keyspace.Get(wk.Key) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// replayTransaction applies a transaction record as a whole, the mutex should be held. The session starts
+// from the recorded db and follows the `select` requests in the record.
+func (e *engine) replayTransaction(vl *log.VertexLog, live bool) {
+	session := types.NewSession("")
+	session.SetDB(int(vl.Index))
+
+	for _, arg := range vl.Args {
+		obj, err := protocol.Parse(bytes.NewReader(arg))
+		if err != nil {
+			common.Warnf("rebuild: parse request in transaction gives error, log=%s, error={%w}", log.FormatLog(vl), err)
+			continue
+		}
+
+		request, ok := obj.(protocol.RedisArray)
+		if !ok || len(request.Data()) == 0 {
+			common.Warnf("rebuild: invalid request in transaction, log=%s, request=%+v", log.FormatLog(vl), obj)
+			continue
+		}
+
+		objects := request.Data()
+		name, ok := objects[0].(protocol.RedisString)
+		if !ok {
+			common.Warnf("rebuild: invalid command name in transaction, log=%s, request=%+v", log.FormatLog(vl), obj)
+			continue
+		}
+
+		c, err := command.NewCommand(name.Data(), session.DB(), objects[1:])
+		if err != nil || c == nil {
+			common.Warnf("rebuild: new command in transaction gives error, log=%s, error={%w}", log.FormatLog(vl), err)
+			continue
+		}
+
+		if err := e.replayCommand(c, session, live); err != nil {
+			common.Warnf("rebuild: execute in transaction gives error, log=%s, error={%w}", log.FormatLog(vl), err)
+		}
+	}
+}
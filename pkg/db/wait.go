@@ -0,0 +1,139 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/command"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
+)
+
+var (
+	// ErrWaitOnReplica will be raised if WAIT is called on a replica
+	ErrWaitOnReplica = errors.New("engine: WAIT cannot be used with replica instances")
+
+	// ErrNoGoodReplicas will be raised if a modification is requested while fewer good replicas than
+	// min-replicas-to-write are connected
+	ErrNoGoodReplicas = errors.New("engine: not enough good replicas to write")
+)
+
+// waitCommandName is WAIT, which is handled by the engine since it blocks on the acknowledgements of the
+// replicas rather than the keys
+const waitCommandName = "wait"
+
+// parseWait parses WAIT numreplicas timeout, the timeout is in milliseconds and 0 means blocking forever
+func parseWait(arguments []protocol.RedisObject) (int, time.Duration, error) {
+	if len(arguments) != 2 {
+		return 0, 0, command.ErrArgumentInvalid
+	}
+
+	var values []int64
+	for _, obj := range arguments {
+		s, ok := obj.(protocol.RedisString)
+		if !ok {
+			return 0, 0, command.ErrArgumentInvalid
+		}
+
+		value, err := strconv.ParseInt(s.Data(), 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("parse wait failed. raw=%s, err={%w}", s.Data(), container.ErrNotAInt)
+		}
+
+		values = append(values, value)
+	}
+
+	if values[1] < 0 {
+		return 0, 0, command.ErrNegativeTimeout
+	}
+
+	return int(values[0]), time.Duration(values[1]) * time.Millisecond, nil
+}
+
+// wait handles WAIT with the mutex held. It replies the count of the replicas which acknowledge the
+// modifications before it, or parks the client until enough of them do if block is set. The client is
+// parked with the reply being nil, so the engine goes on serving the others meanwhile.
+func (e *engine) wait(session *types.Session, arguments []protocol.RedisObject, block bool) (protocol.RedisObject, error) {
+	replicas, timeout, err := parseWait(arguments)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.replica != nil {
+		return nil, ErrWaitOnReplica
+	}
+
+	w := &waiter{
+		session:     session,
+		name:        waitCommandName,
+		ackReplicas: replicas,
+	}
+
+	if e.master != nil {
+		w.ackOffset = e.master.Status().Offset
+	}
+
+	if count := e.ackedReplicas(w); count >= replicas || !block {
+		return protocol.NewRedisInteger(int64(count)), nil
+	}
+
+	if timeout > 0 {
+		w.deadline = time.Now().Add(timeout)
+	}
+
+	e.waiters[session] = w
+	session.SetBlocked(true)
+	return nil, nil
+}
+
+// ackedReplicas returns the count of the replicas which acknowledge the offset of the WAIT
+func (e *engine) ackedReplicas(w *waiter) int {
+	if e.master == nil {
+		return 0
+	}
+
+	return e.master.AckedReplicas(w.ackOffset)
+}
+
+// Acknowledged replies the clients of WAIT which have enough replicas acknowledging their offsets
+func (e *engine) Acknowledged() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	var served []*waiter
+	for _, w := range e.waiters {
+		if w.name == waitCommandName && e.ackedReplicas(w) >= w.ackReplicas {
+			served = append(served, w)
+		}
+	}
+
+	for _, w := range served {
+		e.unblock(w)
+
+		reply := protocol.NewRedisInteger(int64(e.ackedReplicas(w)))
+		e.push(w.session, append([]protocol.RedisObject{reply}, e.resume(w)...)...)
+	}
+
+	e.serveReady()
+}
+
+func (e *engine) SetMinReplicas(count int, maxLag time.Duration) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.minReplicasToWrite = count
+	e.minReplicasMaxLag = maxLag
+}
+
+// goodReplicas returns the count of the replicas whose lag is within min-replicas-max-lag, the mutex should
+// be held
+func (e *engine) goodReplicas() int {
+	if e.master == nil {
+		return 0
+	}
+
+	return e.master.GoodReplicas(e.minReplicasMaxLag)
+}
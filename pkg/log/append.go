@@ -0,0 +1,481 @@
+package log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The fsync policies of the append only log, as appendfsync of redis
+const (
+	// FsyncAlways commits every batch to the stable storage before the replies of its requests are sent
+	FsyncAlways = "always"
+
+	// FsyncEverySec writes every batch to the OS at once and commits the file once a second
+	FsyncEverySec = "everysec"
+
+	// FsyncNo writes every batch to the OS at once and leaves the commits to the OS
+	FsyncNo = "no"
+)
+
+// fsyncInterval is the interval between two commits of FsyncEverySec
+const fsyncInterval = time.Second
+
+// maxSpareSize is the max capacity of a written batch buffer kept to be reused
+const maxSpareSize = 1024 * 1024
+
+// rewriteDrainSize is the bytes buffered by a rewrite that are written into the new file with the appends
+// blocked, the more are written before that while the appends go on
+const rewriteDrainSize = 64 * 1024
+
+var (
+	// ErrInvalidFsyncPolicy will be raised if the policy is not always, everysec or no
+	ErrInvalidFsyncPolicy = errors.New("persistent: invalid appendfsync policy")
+
+	// ErrAppendLogClosed will be raised if append to a stopped AppendLog
+	ErrAppendLogClosed = errors.New("persistent: append log is closed")
+
+	// ErrRewriteInProgress will be raised if a rewrite is started while another one is running
+	ErrRewriteInProgress = errors.New("persistent: rewrite already in progress")
+
+	// ErrNoRewrite will be raised if a rewrite is finished or aborted without being started
+	ErrNoRewrite = errors.New("persistent: no rewrite in progress")
+)
+
+// ParseFsyncPolicy returns the policy in lower case, FsyncEverySec is used if the policy is empty
+func ParseFsyncPolicy(policy string) (string, error) {
+	switch p := strings.ToLower(policy); p {
+	case "":
+		return FsyncEverySec, nil
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("parse appendfsync failed. policy=%s, err={%w}", policy, ErrInvalidFsyncPolicy)
+}
+
+// AppendStatus is a snapshot of the state of an AppendLog
+type AppendStatus struct {
+	Policy string
+
+	// LastFsync is the time of the last successful commit, zero if the file is never committed
+	LastFsync time.Time
+
+	// Buffered is the bytes appended but not written to the file yet
+	Buffered int
+
+	// WriteErr is the error of the failed write, which is kept until the log is reopened
+	WriteErr error
+
+	// FsyncErr is the error of the last commit of FsyncEverySec, it is cleared by a successful commit
+	FsyncErr error
+
+	// Size is the bytes written to the file, and BaseSize is the size of the file when it is opened or
+	// rewritten last time
+	Size     int64
+	BaseSize int64
+
+	// Rewriting reports if a rewrite is running, and RewriteErr is the error of the last rewrite
+	Rewriting  bool
+	RewriteErr error
+}
+
+// AppendLog writes the records into a PersistentFile by a dedicated goroutine, in the order they are
+// appended. The records appended while a batch is written make up the next batch, which is written
+// with one flush, and committed with one fsync for FsyncAlways, so the concurrent requests share the
+// commit. The log stops at the first failed write, so it never has a hole, and Append fails then.
+//
+// A rewrite replaces the file with a new one holding the minimal records to rebuild the dataset. The
+// records appended after the rewrite starts are written into the old file as usual, and buffered to be
+// written into the new file after the snapshot, then the new file replaces the old one by a rename.
+type AppendLog struct {
+	file   *PersistentFile
+	policy string
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	pending   *bytes.Buffer
+	spare     *bytes.Buffer
+	appended  uint64
+	written   uint64
+	lastFsync time.Time
+	writeErr  error
+	fsyncErr  error
+	closed    bool
+	waiters   []commitWaiter
+	size      int64
+	baseSize  int64
+
+	rewriteBuf *bytes.Buffer
+	rewriteErr error
+
+	writerDone chan struct{}
+	stopChan   chan struct{}
+}
+
+// commitWaiter is a function waiting for the records appended before it to be committed
+type commitWaiter struct {
+	seq uint64
+	fn  func()
+}
+
+// NewAppendLog returns a new AppendLog writes into the file with the policy, which should be valid
+func NewAppendLog(file *PersistentFile, policy string) *AppendLog {
+	a := &AppendLog{
+		file:       file,
+		policy:     policy,
+		pending:    &bytes.Buffer{},
+		writerDone: make(chan struct{}),
+		stopChan:   make(chan struct{}),
+	}
+	a.cond = sync.NewCond(&a.mutex)
+
+	if info, err := file.file.Stat(); err == nil {
+		a.size = info.Size()
+		a.baseSize = a.size
+	}
+
+	go a.writeLoop()
+
+	if policy == FsyncEverySec {
+		go a.fsyncLoop()
+	}
+
+	return a
+}
+
+// Policy returns the fsync policy of the log
+func (a *AppendLog) Policy() string {
+	return a.policy
+}
+
+// Append queues the record to be written after the ones appended before it. It returns at once, the
+// error is the one stopped the log if any.
+func (a *AppendLog) Append(record []byte) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return ErrAppendLogClosed
+	} else if a.writeErr != nil {
+		return a.writeErr
+	}
+
+	a.pending.Write(record)
+	a.appended++
+	if a.rewriteBuf != nil {
+		a.rewriteBuf.Write(record)
+	}
+	a.cond.Broadcast()
+
+	return nil
+}
+
+// Err returns the error stopped the log, nil if the log works
+func (a *AppendLog) Err() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.writeErr
+}
+
+// Commit calls fn once the records appended are committed if the policy is FsyncAlways, or at once for
+// the other policies. The waiting fn are called by the writer in the order they are given, so fn should
+// not block, e.g., it should just wake a client up to send the replies. They are called as well if the
+// log is stopped by an error, which is reported by Err.
+func (a *AppendLog) Commit(fn func()) {
+	a.mutex.Lock()
+	if a.policy != FsyncAlways || a.written == a.appended || a.writeErr != nil {
+		a.mutex.Unlock()
+		fn()
+		return
+	}
+
+	a.waiters = append(a.waiters, commitWaiter{seq: a.appended, fn: fn})
+	a.mutex.Unlock()
+}
+
+// Status returns the state of the log
+func (a *AppendLog) Status() AppendStatus {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return AppendStatus{
+		Policy:     a.policy,
+		LastFsync:  a.lastFsync,
+		Buffered:   a.pending.Len(),
+		WriteErr:   a.writeErr,
+		FsyncErr:   a.fsyncErr,
+		Size:       a.size,
+		BaseSize:   a.baseSize,
+		Rewriting:  a.rewriteBuf != nil,
+		RewriteErr: a.rewriteErr,
+	}
+}
+
+// Stop writes the records appended and commits the file, nothing can be appended after it
+func (a *AppendLog) Stop() error {
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return ErrAppendLogClosed
+	}
+
+	a.closed = true
+	a.cond.Broadcast()
+	a.mutex.Unlock()
+
+	close(a.stopChan)
+	<-a.writerDone
+
+	if err := a.Err(); err != nil {
+		return err
+	}
+
+	return a.fsync()
+}
+
+// writeLoop writes the pending records batch by batch until the log is stopped and drained
+func (a *AppendLog) writeLoop() {
+	defer close(a.writerDone)
+
+	for {
+		a.mutex.Lock()
+		for a.pending.Len() == 0 && !a.closed {
+			a.cond.Wait()
+		}
+
+		if a.pending.Len() == 0 || a.writeErr != nil {
+			a.mutex.Unlock()
+			return
+		}
+
+		buf := a.pending
+		a.pending = a.spare
+		if a.pending == nil {
+			a.pending = &bytes.Buffer{}
+		}
+		a.spare = nil
+		seq := a.appended
+		file := a.file
+		a.mutex.Unlock()
+
+		n := buf.Len()
+		err := a.write(file, buf.Bytes())
+		buf.Reset()
+
+		a.mutex.Lock()
+		if buf.Cap() <= maxSpareSize {
+			a.spare = buf
+		}
+
+		// the result is dropped if the file is replaced by a rewrite meanwhile, the new file has the records
+		if file == a.file && err != nil {
+			a.writeErr = err
+		} else if file == a.file {
+			a.size += int64(n)
+			a.written = seq
+			if a.policy == FsyncAlways {
+				a.lastFsync = time.Now()
+			}
+		}
+
+		committed := a.committedWaiters()
+		a.mutex.Unlock()
+
+		for _, w := range committed {
+			w.fn()
+		}
+	}
+}
+
+// committedWaiters removes and returns the waiters whose records are committed, or all of them if the log
+// is stopped by an error. The mutex should be held.
+func (a *AppendLog) committedWaiters() []commitWaiter {
+	idx := 0
+	for idx < len(a.waiters) && (a.writeErr != nil || a.waiters[idx].seq <= a.written) {
+		idx++
+	}
+
+	committed := a.waiters[:idx]
+	a.waiters = a.waiters[idx:]
+	return committed
+}
+
+// write writes a batch into the file, and commits it if the policy is FsyncAlways
+func (a *AppendLog) write(file *PersistentFile, batch []byte) error {
+	n, err := file.Write(batch)
+	if err != nil {
+		return fmt.Errorf("write append log failed. n=%d, len=%d, err={%w}", n, len(batch), err)
+	}
+
+	if a.policy == FsyncAlways {
+		err = file.Sync()
+	} else {
+		err = file.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("commit append log failed. policy=%s, err={%w}", a.policy, err)
+	}
+
+	return nil
+}
+
+// fsyncLoop commits the file every fsyncInterval for FsyncEverySec
+func (a *AppendLog) fsyncLoop() {
+	ticker := time.NewTicker(fsyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+			_ = a.fsync()
+		}
+	}
+}
+
+// fsync commits the file and records the result
+func (a *AppendLog) fsync() error {
+	a.mutex.Lock()
+	file := a.file
+	a.mutex.Unlock()
+
+	err := file.Sync()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if err != nil {
+		a.fsyncErr = fmt.Errorf("fsync append log failed. err={%w}", err)
+		return a.fsyncErr
+	}
+
+	a.fsyncErr = nil
+	a.lastFsync = time.Now()
+	return nil
+}
+
+// StartRewrite starts buffering the records appended for a rewrite. The snapshot of the rewrite should be
+// taken with the appends blocked, so the records buffered are exactly the ones after it.
+func (a *AppendLog) StartRewrite() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return ErrAppendLogClosed
+	} else if a.writeErr != nil {
+		return a.writeErr
+	} else if a.rewriteBuf != nil {
+		return ErrRewriteInProgress
+	}
+
+	a.rewriteBuf = &bytes.Buffer{}
+	return nil
+}
+
+// AbortRewrite drops the records buffered for the rewrite and records the error which fails it
+func (a *AppendLog) AbortRewrite(err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.rewriteBuf = nil
+	a.rewriteErr = err
+}
+
+// FinishRewrite writes the records buffered into the new file holding the snapshot, then renames it to
+// path, so it replaces the old file atomically. The old file is closed and the new one is returned, the
+// records are appended to it from now on. The rewrite is aborted if it fails, and the old file is kept.
+func (a *AppendLog) FinishRewrite(newFile *os.File, path string) (*PersistentFile, error) {
+	file, err := a.finishRewrite(newFile, path)
+	if err != nil {
+		a.AbortRewrite(err)
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (a *AppendLog) finishRewrite(newFile *os.File, path string) (*PersistentFile, error) {
+	// most of the records buffered are written while the appends go on
+	for {
+		a.mutex.Lock()
+		if a.rewriteBuf == nil {
+			a.mutex.Unlock()
+			return nil, ErrNoRewrite
+		} else if a.rewriteBuf.Len() <= rewriteDrainSize {
+			break
+		}
+
+		buf := a.rewriteBuf
+		a.rewriteBuf = &bytes.Buffer{}
+		a.mutex.Unlock()
+
+		if _, err := newFile.Write(buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("write rewrite buffer failed. err={%w}", err)
+		}
+	}
+
+	// the rest are written with the appends blocked, the mutex is held from here
+	defer a.mutex.Unlock()
+
+	if a.closed {
+		return nil, ErrAppendLogClosed
+	}
+
+	if _, err := newFile.Write(a.rewriteBuf.Bytes()); err != nil {
+		return nil, fmt.Errorf("write rewrite buffer failed. err={%w}", err)
+	} else if err = newFile.Sync(); err != nil {
+		return nil, fmt.Errorf("fsync rewritten file failed. err={%w}", err)
+	}
+
+	info, err := newFile.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("stat rewritten file failed. err={%w}", err)
+	}
+
+	if err = os.Rename(newFile.Name(), path); err != nil {
+		return nil, fmt.Errorf("rename rewritten file failed. from=%s, to=%s, err={%w}", newFile.Name(), path, err)
+	}
+	SyncDir(filepath.Dir(path))
+
+	// the records pending are in the new file already, as they are either in the snapshot or buffered
+	old := a.file
+	a.file = NewPersistentFile(newFile)
+	a.pending.Reset()
+	a.written = a.appended
+	a.size = info.Size()
+	a.baseSize = a.size
+	a.lastFsync = time.Now()
+	a.rewriteBuf = nil
+	a.rewriteErr = nil
+
+	committed := a.committedWaiters()
+	go func() {
+		_ = old.file.Close()
+		for _, w := range committed {
+			w.fn()
+		}
+	}()
+
+	return a.file, nil
+}
+
+// SyncDir commits the entries of the dir, e.g., a rename, the error is ignored as some systems do not
+// support it
+func SyncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+
+	_ = d.Sync()
+	_ = d.Close()
+}
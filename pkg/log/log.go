@@ -6,8 +6,13 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -15,44 +20,143 @@ import (
 	"github.com/lxdlam/vertex/pkg/util"
 )
 
+// LogVersion is the version of the format of the database file written by PackLog
+const LogVersion = 3
+
+// HeaderSize is the bytes of the header of a database file
+const HeaderSize = 8
+
+var logMagic = []byte("VLOG")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
 var (
 	// ErrEmptyLog will be raised if in PackLog the proto.Marshal returns a zero length byte slice
 	ErrEmptyLog = errors.New("empty vertex log message")
 )
 
+var (
+	// ErrLogTruncated will be raised if the log ends with a torn or corrupted record
+	ErrLogTruncated = errors.New("vertex log has a torn tail")
+
+	// ErrLogCorrupted will be raised if a record in the middle of the log is corrupted
+	ErrLogCorrupted = errors.New("vertex log is corrupted")
+
+	// ErrUnsupportedLogVersion will be raised if the version in the header is not known
+	ErrUnsupportedLogVersion = errors.New("unsupported vertex log version")
+)
+
 var (
 	errUnexpectedEOF  = errors.New("unexpected eof")
 	errInvalidMessage = errors.New("invalid proto message")
 )
 
-// NewLog will create a new VertexLog instance
+// NewLog will create a new VertexLog instance, the arguments are kept as the raw bytes except the requests
+// in a transaction record, which are kept in RESP
 func NewLog(name string, index int, objects []protocol.RedisObject) *VertexLog {
 	vl := &VertexLog{}
 
 	vl.Id = util.GenNewUUID()
-	vl.Time = time.Now().UTC().UnixNano()
+	vl.Time = time.Now().UnixNano() / int64(time.Millisecond)
 	vl.Host = util.GetIP()
 	vl.Name = name
 	vl.Index = int32(index)
+	vl.Args = packArguments(objects[1:])
+
+	return vl
+}
+
+func packArguments(objects []protocol.RedisObject) [][]byte {
+	var ret [][]byte
+	for _, item := range objects {
+		if s, ok := item.(protocol.RedisString); ok {
+			ret = append(ret, []byte(s.Data()))
+		} else {
+			ret = append(ret, []byte(item.String()))
+		}
+	}
 
+	return ret
+}
+
+func FormatLog(vl *VertexLog) string {
 	var arguments []string
-	for _, item := range objects[1:] {
-		arguments = append(arguments, item.String())
+	for _, arg := range vl.Args {
+		arguments = append(arguments, string(arg))
 	}
 
-	vl.Arguments = arguments
-	vl.RawRequest = protocol.NewRedisArray(objects).String()
+	return fmt.Sprintf("VectexLog{id=%s, time=%d, host=%s, name=%s, index=%d, args=[%s]}", vl.Id, vl.Time, vl.Host, vl.Name, vl.Index, util.QuoteJoin(arguments, ","))
+}
 
-	return vl
+// migrateLog converts a log written before the version 2 of the file. The arguments are taken from the raw
+// request, or parsed from the RESP ones if it is absent, and the time is converted into milliseconds. The
+// legacy files are written before the databases can be selected, when every request is logged in the db 1
+// while the clients start in the db 0 now, so the records are moved into the db 0.
+func migrateLog(vl *VertexLog, version int) error {
+	var objects []protocol.RedisObject
+	if obj, err := protocol.Parse(strings.NewReader(vl.RawRequest)); err == nil {
+		if request, ok := obj.(protocol.RedisArray); ok && len(request.Data()) > 0 {
+			objects = request.Data()[1:]
+		}
+	}
+
+	if objects == nil {
+		for _, item := range vl.Arguments {
+			obj, err := protocol.Parse(strings.NewReader(item))
+			if err != nil {
+				return fmt.Errorf("migrate log failed. id=%s, argument=%s, err={%w}", vl.Id, strconv.Quote(item), err)
+			}
+			objects = append(objects, obj)
+		}
+	}
+
+	vl.Args = packArguments(objects)
+	vl.Arguments = nil
+	vl.RawRequest = ""
+	vl.Time /= int64(time.Millisecond)
+
+	if version == 0 && vl.Index == 1 {
+		vl.Index = 0
+	}
+
+	return nil
 }
 
-func FormatLog(vl *VertexLog) string {
-	return fmt.Sprintf("VectexLog{id=%s, time=%d, host=%s, name=%s, index=%d, arguments=[%s], raw_request=%s}", vl.Id, vl.Time, vl.Host, vl.Name, vl.Index, util.QuoteJoin(vl.Arguments, ","), strconv.Quote(vl.RawRequest))
+// Header returns the header of a database file, which is written before any record:
+//     represent: | magic | version | reserved |
+//     bytes:         ^4       ^2         ^2
+func Header() []byte {
+	b := make([]byte, HeaderSize)
+	copy(b, logMagic)
+	binary.LittleEndian.PutUint16(b[len(logMagic):], LogVersion)
+
+	return b
+}
+
+// ReadHeader reads the header of a database file from a stream, and returns the version in it
+func ReadHeader(reader io.Reader) (int, error) {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, fmt.Errorf("read log header failed. err={%w}", err)
+	}
+
+	if !bytes.HasPrefix(header, logMagic) {
+		return 0, fmt.Errorf("read log header failed. header=%q, err={%w}", header, ErrLogCorrupted)
+	}
+
+	version := int(binary.LittleEndian.Uint16(header[len(logMagic):]))
+	if version <= 0 || version > LogVersion {
+		return 0, fmt.Errorf("read log header failed. version=%d, err={%w}", version, ErrUnsupportedLogVersion)
+	}
+
+	return version, nil
 }
 
 // PackLog will package a log into the below form:
-//     represent: | length | log |
-//     bytes:         ^4      ^the value in length section
+//     represent: | length | length checksum | checksum | log |
+//     bytes:         ^4            ^4             ^4      ^the value in length section
+// The length checksum is the CRC32C of the length, so a corrupted length is told from a torn record, and the
+// checksum is the CRC32C of the length and the log.
 func PackLog(vl *VertexLog) ([]byte, error) {
 	var buf bytes.Buffer
 
@@ -67,8 +171,10 @@ func PackLog(vl *VertexLog) ([]byte, error) {
 		return nil, ErrEmptyLog
 	}
 
-	b := make([]byte, 4)
+	b := make([]byte, 12)
 	binary.LittleEndian.PutUint32(b, uint32(length))
+	binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(b[:4], crcTable))
+	binary.LittleEndian.PutUint32(b[8:], checksum(b[:4], message))
 
 	buf.Write(b)
 	buf.Write(message)
@@ -76,55 +182,231 @@ func PackLog(vl *VertexLog) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func checksum(length []byte, message []byte) uint32 {
+	return crc32.Update(crc32.Checksum(length, crcTable), crcTable, message)
+}
+
+// LogInfo describes a database file read by ReadLog
+type LogInfo struct {
+	// Version is the version of the format, 0 for a file written before the header is introduced, 1 for
+	// the records keeping the arguments in RESP, and 2 for the records without the length checksum
+	Version int
+
+	// Size is the bytes read, and ValidSize is the bytes of the header and the intact records before a
+	// torn or corrupted tail
+	Size      int64
+	ValidSize int64
+}
+
+// Dropped returns the bytes of the torn or corrupted tail, which should be truncated before appending
+func (i *LogInfo) Dropped() int64 {
+	return i.Size - i.ValidSize
+}
+
 type logReader struct {
-	reader *bufio.Reader
+	reader  *bufio.Reader
+	offset  int64
+	version int
 }
 
-// ParseLog will parse a log from a io.Reader which can be a file or net stream
-func ParseLog(reader io.Reader) ([]*VertexLog, error) {
+// ReadLog reads the logs from a io.Reader which can be a file or net stream. A file without the header is
+// read in the legacy format, where the records have no checksum. The logs of the older versions are
+// migrated into the current one.
+//
+// A torn or corrupted record is a tail if nothing is after it, the logs before it are returned with the
+// size of the tail in LogInfo, as the write of it was interrupted. A corrupted record in the middle
+// raises ErrLogCorrupted, since truncating it drops the intact records after it. A record is torn only if
+// its length is intact, so a corrupted length running past the end never drops the records after it.
+func ReadLog(reader io.Reader) ([]*VertexLog, *LogInfo, error) {
 	var ret []*VertexLog
 
-	lr := logReader{
+	lr := &logReader{
 		reader: bufio.NewReader(reader),
 	}
+	info := &LogInfo{Version: LogVersion}
+
+	header, err := lr.reader.Peek(HeaderSize)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("read log header failed. err={%w}", err)
+	}
+
+	switch {
+	case len(header) < HeaderSize && bytes.HasPrefix(logMagic, header[:min(len(header), len(logMagic))]):
+		// an empty file, or the write of the header was interrupted
+		info.Size = int64(len(header))
+		return nil, info, nil
+	case bytes.HasPrefix(header, logMagic):
+		info.Version = int(binary.LittleEndian.Uint16(header[len(logMagic):]))
+		if info.Version <= 0 || info.Version > LogVersion {
+			return nil, nil, fmt.Errorf("read log header failed. version=%d, err={%w}", info.Version,
+				ErrUnsupportedLogVersion)
+		}
+
+		_, _ = lr.reader.Discard(HeaderSize)
+		lr.offset = HeaderSize
+	default:
+		info.Version = 0
+	}
+	lr.version = info.Version
 
 	for {
+		start := lr.offset
 		obj, err := lr.readLog()
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				break
-			} else if errors.Is(err, errUnexpectedEOF) {
-				return ret, fmt.Errorf("unexpected eof when parsing log")
-			} else if errors.Is(err, errInvalidMessage) {
-				// Try parse more
-				continue
-			} else {
-				return nil, fmt.Errorf("unexpected error met. err={%w}", err)
+
+		if errors.Is(err, io.EOF) {
+			break
+		} else if errors.Is(err, errUnexpectedEOF) {
+			info.ValidSize = start
+			info.Size = lr.offset
+			return ret, info, nil
+		} else if errors.Is(err, errInvalidMessage) {
+			info.ValidSize = start
+			if _, err := lr.reader.Peek(1); errors.Is(err, io.EOF) {
+				info.Size = lr.offset
+				return ret, info, nil
+			}
+
+			rest, _ := io.Copy(ioutil.Discard, lr.reader)
+			info.Size = lr.offset + rest
+			return ret, info, fmt.Errorf("read log failed. offset=%d, err={%w}", start, ErrLogCorrupted)
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("unexpected error met. err={%w}", err)
+		}
+
+		if info.Version < 2 {
+			if err := migrateLog(obj, info.Version); err != nil {
+				return nil, nil, err
 			}
 		}
 
 		ret = append(ret, obj)
 	}
 
-	return ret, nil
+	info.Size = lr.offset
+	info.ValidSize = lr.offset
+
+	return ret, info, nil
 }
 
-func (lr *logReader) readBytes(count int) ([]byte, error) {
-	return util.ReadExactBytes(lr.reader, count)
+// ParseLog will parse a log from a io.Reader which can be a file or net stream, a torn tail raises
+// ErrLogTruncated with the logs before it
+func ParseLog(reader io.Reader) ([]*VertexLog, error) {
+	logs, info, err := ReadLog(reader)
+	if err != nil {
+		return logs, err
+	} else if info.Dropped() > 0 {
+		return logs, fmt.Errorf("parse log failed. offset=%d, dropped=%d, err={%w}", info.ValidSize,
+			info.Dropped(), ErrLogTruncated)
+	}
+
+	return logs, nil
 }
 
-func (lr *logReader) readLog() (*VertexLog, error) {
-	lengthByte, err := lr.readBytes(4)
-	if errors.Is(err, io.EOF) {
-		if len(lengthByte) != 0 {
-			return nil, errUnexpectedEOF
+// UpgradeLog writes the logs into a new file in the current format, which replaces the file at path by a
+// rename. The new file is returned to append the records.
+func UpgradeLog(path string, logs []*VertexLog) (*os.File, error) {
+	tmpPath := path + ".upgrade"
+
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("create upgrade file failed. path=%s, err={%w}", tmpPath, err)
+	}
+
+	err = func() error {
+		writer := bufio.NewWriter(f)
+		_, _ = writer.Write(Header())
+
+		for _, vl := range logs {
+			record, err := PackLog(vl)
+			if err != nil {
+				return err
+			}
+			_, _ = writer.Write(record)
+		}
+
+		if err := writer.Flush(); err != nil {
+			return err
+		} else if err := f.Sync(); err != nil {
+			return err
 		}
-		return nil, io.EOF
+
+		return os.Rename(tmpPath, path)
+	}()
+
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return nil, fmt.Errorf("upgrade log failed. path=%s, err={%w}", path, err)
+	}
+	SyncDir(filepath.Dir(path))
+
+	return f, nil
+}
+
+// RecordReader reads the records one by one from a stream without the header, e.g., the replication stream
+type RecordReader struct {
+	lr *logReader
+}
+
+// NewRecordReader returns a RecordReader reading the records in the current format from the reader
+func NewRecordReader(reader io.Reader) *RecordReader {
+	return &RecordReader{
+		lr: &logReader{
+			reader:  bufio.NewReader(reader),
+			version: LogVersion,
+		},
+	}
+}
+
+// Next returns the next record and the bytes it takes. It returns io.EOF at the end of the stream,
+// io.ErrUnexpectedEOF if the stream ends in a record, and ErrLogCorrupted if the record is corrupted.
+func (r *RecordReader) Next() (*VertexLog, int64, error) {
+	start := r.lr.offset
+
+	vl, err := r.lr.readLog()
+	if errors.Is(err, errUnexpectedEOF) {
+		return nil, 0, io.ErrUnexpectedEOF
+	} else if errors.Is(err, errInvalidMessage) {
+		return nil, 0, fmt.Errorf("read record failed. offset=%d, err={%w}", start, ErrLogCorrupted)
 	} else if err != nil {
+		return nil, 0, err
+	}
+
+	return vl, r.lr.offset - start, nil
+}
+
+func (lr *logReader) readBytes(count int) ([]byte, error) {
+	// the bytes are copied as they are read, so a corrupted length does not allocate the whole of it
+	var buf bytes.Buffer
+
+	n, err := io.CopyN(&buf, lr.reader, int64(count))
+	lr.offset += n
+
+	if err != nil && n > 0 {
+		return buf.Bytes(), errUnexpectedEOF
+	}
+
+	return buf.Bytes(), err
+}
+
+func (lr *logReader) readLog() (*VertexLog, error) {
+	// the legacy records have the length only, the ones of the version 1 and 2 have no length checksum
+	headerLength := 12
+	if lr.version == 0 {
+		headerLength = 4
+	} else if lr.version < 3 {
+		headerLength = 8
+	}
+
+	header, err := lr.readBytes(headerLength)
+	if err != nil {
 		return nil, err
 	}
 
-	length := int(binary.LittleEndian.Uint32(lengthByte))
+	length := int(binary.LittleEndian.Uint32(header))
+	if lr.version >= 3 && binary.LittleEndian.Uint32(header[4:]) != crc32.Checksum(header[:4], crcTable) {
+		return nil, errInvalidMessage
+	}
 
 	logByte, err := lr.readBytes(length)
 	if err != nil {
@@ -135,6 +417,10 @@ func (lr *logReader) readLog() (*VertexLog, error) {
 		return nil, err
 	}
 
+	if length == 0 || (lr.version > 0 && binary.LittleEndian.Uint32(header[headerLength-4:]) != checksum(header[:4], logByte)) {
+		return nil, errInvalidMessage
+	}
+
 	v := &VertexLog{}
 
 	if err := proto.Unmarshal(logByte, v); err != nil {
@@ -143,3 +429,11 @@ func (lr *logReader) readLog() (*VertexLog, error) {
 
 	return v, nil
 }
+
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -81,3 +81,15 @@ func (p *PersistentFile) WriteString(s string) (int, error) {
 
 	return p.writer.WriteString(s)
 }
+
+// Sync flushes the buffered bytes and commits the file to the stable storage.
+func (p *PersistentFile) Sync() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+
+	return p.file.Sync()
+}
@@ -0,0 +1,409 @@
+package network
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// TestDisconnectBlocked closes the clients right after they pipeline a blocking pop behind the other requests,
+// the pop is cancelled by the disconnection handled after it, so no element is served to a gone client and
+// the requests deferred by the pop are never run.
+func TestDisconnectBlocked(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	const clients = 20
+
+	var sb strings.Builder
+	for idx := 0; idx < 200; idx++ {
+		sb.WriteString(respclient.Encode("ping"))
+	}
+	sb.WriteString(respclient.Encode("blpop", "queue", "0"))
+	sb.WriteString(respclient.Encode("subscribe", "channel"))
+
+	for idx := 0; idx < clients; idx++ {
+		c := dialTestServer(t, addr)
+		assert.Nil(t, c.Send(sb.String()))
+		_ = c.Close()
+	}
+
+	// wait for the disconnections to be handled
+	time.Sleep(500 * time.Millisecond)
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	args := []string{"rpush", "queue"}
+	for idx := 0; idx < clients; idx++ {
+		args = append(args, strconv.Itoa(idx))
+	}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode(args...), []interface{}{int64(clients)}},
+		{respclient.Encode("llen", "queue"), []interface{}{int64(clients)}},
+		{respclient.Encode("publish", "channel", "message"), []interface{}{int64(0)}},
+	})
+}
+
+// blockClient connects a client and sends the blocking request, it waits a while so the client is parked
+// before the ones blocked after it
+func blockClient(t *testing.T, addr string, args ...string) *respclient.Client {
+	c := dialTestServer(t, addr)
+	assert.Nil(t, c.Send(respclient.Encode(args...)))
+	time.Sleep(50 * time.Millisecond)
+
+	return c
+}
+
+// receiveReply reads the next reply of the client and checks it
+func receiveReply(t *testing.T, c *respclient.Client, expected interface{}) {
+	reply, err := c.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, expected, reply)
+}
+
+// TestBlockingPop serves the clients blocked on a list in the order they are blocked, by the pushes or the
+// inserts waking them
+func TestBlockingPop(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	first := blockClient(t, addr, "blpop", "queue", "0")
+	defer first.Close()
+	second := blockClient(t, addr, "brpop", "other", "queue", "0")
+	defer second.Close()
+	third := blockClient(t, addr, "blpop", "queue", "0")
+	defer third.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "queue", "a", "b"), []interface{}{int64(2)}},
+	})
+	receiveReply(t, first, []interface{}{"queue", "a"})
+	receiveReply(t, second, []interface{}{"queue", "b"})
+
+	// the requests after a blocked one are replied once it is served
+	assert.Nil(t, third.Send(respclient.Encode("ping")))
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("lpush", "queue", "c"), []interface{}{int64(1)}},
+		{respclient.Encode("exists", "queue"), []interface{}{int64(0)}},
+	})
+	receiveReply(t, third, []interface{}{"queue", "c"})
+	receiveReply(t, third, "PONG")
+
+	// a waiter of several keys is served by the first one pushed
+	assert.Nil(t, second.Send(respclient.Encode("brpop", "other", "queue", "0")))
+	time.Sleep(50 * time.Millisecond)
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "other", "d", "e"), []interface{}{int64(2)}},
+		{respclient.Encode("lrange", "other", "0", "-1"), []interface{}{[]interface{}{"d"}}},
+	})
+	receiveReply(t, second, []interface{}{"other", "e"})
+
+	// the waiter is served once the transaction is executed, with the element inserted by LINSERT
+	assert.Nil(t, first.Send(respclient.Encode("blpop", "queue", "0")))
+	time.Sleep(50 * time.Millisecond)
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "queue", "f"), []interface{}{"QUEUED"}},
+		{respclient.Encode("linsert", "queue", "before", "f", "g"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(1), int64(2)}}},
+		{respclient.Encode("lrange", "queue", "0", "-1"), []interface{}{[]interface{}{"f"}}},
+	})
+	receiveReply(t, first, []interface{}{"queue", "g"})
+
+	// a list is popped at once if it is not empty
+	runExchanges(t, first, []exchange{
+		{respclient.Encode("blpop", "nosuch", "queue", "0"), []interface{}{[]interface{}{"queue", "f"}}},
+	})
+}
+
+// TestBlockingTimeout replies nil to the clients whose timeout is reached, along with the requests after
+// them, and refuses the invalid timeouts
+func TestBlockingTimeout(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	start := time.Now()
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("blpop", "queue", "0.1") + respclient.Encode("ping"), []interface{}{nil, "PONG"}},
+		{respclient.Encode("brpop", "queue", "other", "0.1"), []interface{}{nil}},
+		{respclient.Encode("blmove", "queue", "other", "left", "right", "0.1"), []interface{}{nil}},
+		{respclient.Encode("brpoplpush", "queue", "other", "0.1"), []interface{}{nil}},
+	})
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("blpop", "queue", "abc"), []interface{}{
+			respclient.Error("ERR timeout is not a float or out of range"),
+		}},
+		{respclient.Encode("brpop", "queue", "-1"), []interface{}{respclient.Error("ERR timeout is negative")}},
+		{respclient.Encode("blmove", "queue", "other", "up", "right", "0"), []interface{}{
+			respclient.Error("ERR syntax error"),
+		}},
+		{respclient.Encode("exists", "queue", "other"), []interface{}{int64(0)}},
+	})
+}
+
+// TestBlockingMove chains the clients blocked by BLMOVE and BRPOPLPUSH, the element moved by one of them
+// serves the client blocked on its destination in the same round
+func TestBlockingMove(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	first := blockClient(t, addr, "blmove", "source", "middle", "left", "right", "0")
+	defer first.Close()
+	second := blockClient(t, addr, "brpoplpush", "middle", "destination", "0")
+	defer second.Close()
+	third := blockClient(t, addr, "blpop", "destination", "0")
+	defer third.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "source", "a", "b"), []interface{}{int64(2)}},
+	})
+	receiveReply(t, first, "a")
+	receiveReply(t, second, "a")
+	receiveReply(t, third, []interface{}{"destination", "a"})
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("lrange", "source", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+		{respclient.Encode("exists", "middle", "destination"), []interface{}{int64(0)}},
+		{respclient.Encode("set", "string", "value"), []interface{}{"OK"}},
+		{respclient.Encode("blmove", "source", "string", "left", "left", "0"), []interface{}{
+			respclient.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+		}},
+		{respclient.Encode("lrange", "source", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+	})
+}
+
+// TestBlockingRename wakes the clients blocked on a list which is given to the key by RENAME, RENAMENX,
+// MOVE or SWAPDB
+func TestBlockingRename(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	first := blockClient(t, addr, "blpop", "renamed", "0")
+	defer first.Close()
+	second := blockClient(t, addr, "blmove", "renamednx", "destination", "left", "left", "0")
+	defer second.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "list", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("rename", "list", "renamed"), []interface{}{"OK"}},
+	})
+	receiveReply(t, first, []interface{}{"renamed", "a"})
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "list", "b"), []interface{}{int64(1)}},
+		{respclient.Encode("renamenx", "list", "renamednx"), []interface{}{int64(1)}},
+	})
+	receiveReply(t, second, "b")
+
+	// the clients blocked in the db 1 are woken by the keys coming from the db 0
+	third := dialTestServer(t, addr)
+	defer third.Close()
+	fourth := dialTestServer(t, addr)
+	defer fourth.Close()
+
+	for _, blocked := range []struct {
+		c   *respclient.Client
+		key string
+	}{{third, "moved"}, {fourth, "swapped"}} {
+		runExchanges(t, blocked.c, []exchange{
+			{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		})
+		assert.Nil(t, blocked.c.Send(respclient.Encode("blpop", blocked.key, "0")))
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "moved", "c"), []interface{}{int64(1)}},
+		{respclient.Encode("move", "moved", "1"), []interface{}{int64(1)}},
+	})
+	receiveReply(t, third, []interface{}{"moved", "c"})
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "swapped", "d", "e"), []interface{}{int64(2)}},
+		{respclient.Encode("swapdb", "0", "1"), []interface{}{"OK"}},
+	})
+	receiveReply(t, fourth, []interface{}{"swapped", "d"})
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("exists", "swapped"), []interface{}{int64(0)}},
+		{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		{respclient.Encode("lrange", "swapped", "0", "-1"), []interface{}{[]interface{}{"e"}}},
+		{respclient.Encode("lrange", "destination", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+	})
+}
+
+// TestDisconnectWaiting closes a blocked client, the element pushed later is left in the list
+func TestDisconnectWaiting(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	blocked := blockClient(t, addr, "blpop", "queue", "0")
+	_ = blocked.Close()
+	time.Sleep(50 * time.Millisecond)
+
+	waiting := blockClient(t, addr, "blpop", "queue", "0")
+	defer waiting.Close()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "queue", "a", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("lrange", "queue", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+	})
+	receiveReply(t, waiting, []interface{}{"queue", "a"})
+}
+
+// TestBlockingLog logs the pops of the served lists as LPOP, RPOP and LMOVE, so the dataset rebuilt from the
+// file is the one the clients see
+func TestBlockingLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vertex.db")
+	s, addr := startFileServer(t, file, "always")
+
+	c := dialTestServer(t, addr)
+
+	first := blockClient(t, addr, "blpop", "queue", "0")
+	second := blockClient(t, addr, "brpop", "queue", "0")
+	third := blockClient(t, addr, "blmove", "queue", "other", "right", "left", "0")
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "queue", "a", "b", "c", "d"), []interface{}{int64(4)}},
+		{respclient.Encode("blpop", "nosuch", "0.01"), []interface{}{nil}},
+	})
+	receiveReply(t, first, []interface{}{"queue", "a"})
+	receiveReply(t, second, []interface{}{"queue", "d"})
+	receiveReply(t, third, "c")
+
+	_ = first.Close()
+	_ = second.Close()
+	_ = third.Close()
+	_ = c.Close()
+	s.Stop()
+
+	assert.Equal(t, []string{
+		"rpush queue a b c d",
+		"lpop queue",
+		"rpop queue",
+		"lmove queue other RIGHT LEFT",
+	}, readRecords(t, file))
+
+	s, addr = startFileServer(t, file, "always")
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("lrange", "queue", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+		{respclient.Encode("lrange", "other", "0", "-1"), []interface{}{[]interface{}{"c"}}},
+	})
+}
+
+// TestBlockingReplication propagates the pops of the served lists to the replica
+func TestBlockingReplication(t *testing.T) {
+	master, masterAddr, replAddr := startMaster(t, 0)
+	defer master.Stop()
+
+	replica, addr := startTestServer(t)
+	defer replica.Stop()
+
+	rc := dialReplTest(t, addr)
+	defer rc.Close()
+
+	host, port, err := net.SplitHostPort(replAddr)
+	assert.Nil(t, err)
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+	})
+	waitInfo(t, rc, "replication", "master_link_status:up\r\n")
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	first := blockClient(t, masterAddr, "blpop", "queue", "0")
+	defer first.Close()
+	second := blockClient(t, masterAddr, "brpoplpush", "queue", "other", "0")
+	defer second.Close()
+
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("rpush", "queue", "a", "b", "c"), []interface{}{int64(3)}},
+	})
+	receiveReply(t, first, []interface{}{"queue", "a"})
+	receiveReply(t, second, "c")
+
+	waitReply(t, rc, []interface{}{"c"}, "lrange", "other", "0", "-1")
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("lrange", "queue", "0", "-1"), []interface{}{[]interface{}{"b"}}},
+	})
+}
+
+// TestIdleTimeout closes the idle clients, but not the blocked or subscribed ones
+func TestIdleTimeout(t *testing.T) {
+	expireTime := defaultExpireTime
+	defaultExpireTime = 200 * time.Millisecond
+	defer func() {
+		defaultExpireTime = expireTime
+	}()
+
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	idle := dialTestServer(t, addr)
+	defer idle.Close()
+
+	blocked := blockClient(t, addr, "blpop", "queue", "0")
+	defer blocked.Close()
+
+	subscriber := dialTestServer(t, addr)
+	defer subscriber.Close()
+	runExchanges(t, subscriber, []exchange{
+		{respclient.Encode("subscribe", "news"), []interface{}{[]interface{}{"subscribe", "news", int64(1)}}},
+	})
+
+	time.Sleep(500 * time.Millisecond)
+
+	rest, err := idle.ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, "", rest)
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "queue", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("publish", "news", "message"), []interface{}{int64(1)}},
+	})
+	receiveReply(t, blocked, []interface{}{"queue", "a"})
+	receiveReply(t, subscriber, []interface{}{"message", "news", "message"})
+}
@@ -0,0 +1,424 @@
+package network
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// matcher checks a reply which is not known in advance
+type matcher func(reply interface{}) bool
+
+// isHelloMap matches the RESP3 reply of HELLO with the protocol version
+func isHelloMap(version int64) matcher {
+	return func(reply interface{}) bool {
+		m, ok := reply.(respclient.Map)
+		return ok && len(m) == 14 && m[0] == "server" && m[4] == "proto" && m[5] == version
+	}
+}
+
+// TestClientHandshakes replays the bytes sent by the real clients when they connect
+func TestClientHandshakes(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	testCases := map[string][]exchange{
+		// redis-cli 7 asks for the command docs for its hints, then the user types PING
+		"redis-cli": {
+			{"*2\r\n$7\r\nCOMMAND\r\n$4\r\nDOCS\r\n", []interface{}{[]interface{}{}}},
+			{"*1\r\n$4\r\nPING\r\n", []interface{}{"PONG"}},
+		},
+
+		// go-redis v9 negotiates RESP3 first, then pipelines the connection setup
+		"go-redis": {
+			{"*2\r\n$5\r\nHELLO\r\n$1\r\n3\r\n", []interface{}{isHelloMap(3)}},
+			{
+				"*2\r\n$6\r\nselect\r\n$1\r\n2\r\n" +
+					"*3\r\n$6\r\nclient\r\n$7\r\nsetname\r\n$6\r\nworker\r\n",
+				[]interface{}{"OK", "OK"},
+			},
+			{
+				"*4\r\n$6\r\nclient\r\n$7\r\nsetinfo\r\n$8\r\nLIB-NAME\r\n$19\r\ngo-redis(,go1.21.0)\r\n" +
+					"*4\r\n$6\r\nclient\r\n$7\r\nsetinfo\r\n$7\r\nLIB-VER\r\n$5\r\n9.2.1\r\n",
+				[]interface{}{"OK", "OK"},
+			},
+			{"*1\r\n$4\r\nping\r\n", []interface{}{"PONG"}},
+			{"*2\r\n$6\r\nclient\r\n$7\r\ngetname\r\n", []interface{}{"worker"}},
+		},
+
+		// telnet and nc users, and the health check scripts send the inline requests
+		"telnet": {
+			{"PING\r\n", []interface{}{"PONG"}},
+			{"set greeting \"hello world\"\r\n", []interface{}{"OK"}},
+			{"\r\nget greeting\n", []interface{}{"hello world"}},
+			{"echo 'it\\'s'\r\n*2\r\n$4\r\necho\r\n$2\r\nok\r\n", []interface{}{"it's", "ok"}},
+		},
+
+		// redis-py 5 reports the library and selects the db on connect, then checks the connection
+		"redis-py": {
+			{"*4\r\n$6\r\nCLIENT\r\n$7\r\nSETINFO\r\n$8\r\nLIB-NAME\r\n$8\r\nredis-py\r\n", []interface{}{"OK"}},
+			{"*4\r\n$6\r\nCLIENT\r\n$7\r\nSETINFO\r\n$7\r\nLIB-VER\r\n$5\r\n5.0.1\r\n", []interface{}{"OK"}},
+			{"*2\r\n$6\r\nSELECT\r\n$1\r\n1\r\n", []interface{}{"OK"}},
+			{"*1\r\n$4\r\nPING\r\n", []interface{}{"PONG"}},
+		},
+	}
+
+	for name, exchanges := range testCases {
+		t.Run(name, func(t *testing.T) {
+			c := dialTestServer(t, addr)
+			defer c.Close()
+
+			runExchanges(t, c, exchanges)
+		})
+	}
+}
+
+func TestHello(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	id, err := c.Do("client", "id")
+	assert.Nil(t, err)
+
+	reply, err := c.Do("hello", "2", "auth", "default", "secret", "setname", "app")
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		"server", "vertex",
+		"version", common.Version,
+		"proto", int64(2),
+		"id", id,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []interface{}{},
+	}, reply)
+
+	reply, err = c.Do("client", "getname")
+	assert.Nil(t, err)
+	assert.Equal(t, "app", reply)
+
+	reply, err = c.Do("hello", "3")
+	assert.Nil(t, err)
+	assert.Equal(t, respclient.Map{
+		"server", "vertex",
+		"version", common.Version,
+		"proto", int64(3),
+		"id", id,
+		"mode", "standalone",
+		"role", "master",
+		"modules", []interface{}{},
+	}, reply)
+
+	reply, err = c.Do("hello")
+	assert.Nil(t, err)
+	assert.True(t, isHelloMap(3)(reply))
+
+	reply, err = c.Do("hello", "4")
+	assert.Nil(t, err)
+	assert.Equal(t, respclient.Error("NOPROTO unsupported protocol version"), reply)
+
+	reply, err = c.Do("hello", "two")
+	assert.Nil(t, err)
+	assert.Equal(t, respclient.Error("ERR Protocol version is not an integer or out of range"), reply)
+
+	reply, err = c.Do("client", "setname", "bad name")
+	assert.Nil(t, err)
+	assert.Equal(t, respclient.Error("ERR Client names cannot contain spaces, newlines or special characters."), reply)
+}
+
+func TestConnectionCommands(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("ping", "hello"), []interface{}{"hello"}},
+		{respclient.Encode("echo", "hello world"), []interface{}{"hello world"}},
+		{respclient.Encode("client", "getname"), []interface{}{nil}},
+		{respclient.Encode("command", "info", "get", "nosuch"), []interface{}{[]interface{}{
+			[]interface{}{"get", int64(2), []interface{}{"readonly", "fast"}, int64(1), int64(1), int64(1)},
+			nil,
+		}}},
+	})
+
+	count, err := c.Do("command", "count")
+	assert.Nil(t, err)
+
+	all, err := c.Do("command")
+	assert.Nil(t, err)
+	assert.Equal(t, count, int64(len(all.([]interface{}))))
+}
+
+func TestPingInSubscribedMode(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("subscribe", "news"), []interface{}{[]interface{}{"subscribe", "news", int64(1)}}},
+		{respclient.Encode("ping"), []interface{}{[]interface{}{"pong", ""}}},
+		{respclient.Encode("ping", "hi"), []interface{}{[]interface{}{"pong", "hi"}}},
+	})
+}
+
+// TestOutputBufferLimit disconnects a subscriber reading too slow, while a normal client may pipeline the
+// requests with the responses over the limit
+func TestOutputBufferLimit(t *testing.T) {
+	cfg := common.NewConfig()
+	cfg.OutputBufferLimit = 64 * 1024
+	s, addr := startServerWith(t, cfg)
+	defer s.Stop()
+
+	value := strings.Repeat("v", 100*1024)
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	var sb strings.Builder
+	sb.WriteString(respclient.Encode("set", "big", value))
+	for idx := 0; idx < 50; idx++ {
+		sb.WriteString(respclient.Encode("get", "big"))
+	}
+	assert.Nil(t, c.Send(sb.String()))
+
+	// the responses are queued before the client reads any of them
+	time.Sleep(200 * time.Millisecond)
+
+	expected := []interface{}{"OK"}
+	for idx := 0; idx < 50; idx++ {
+		expected = append(expected, value)
+	}
+	runExchanges(t, c, []exchange{{"", expected}})
+
+	subscriber := dialTestServer(t, addr)
+	defer subscriber.Close()
+
+	runExchanges(t, subscriber, []exchange{
+		{respclient.Encode("subscribe", "news"), []interface{}{[]interface{}{"subscribe", "news", int64(1)}}},
+	})
+
+	for idx := 0; idx < 50; idx++ {
+		reply, err := c.Do("publish", "news", value)
+		assert.Nil(t, err)
+		if reply == int64(0) {
+			break
+		}
+	}
+	waitReply(t, c, int64(0), "publish", "news", "message")
+}
+
+// TestResp3 checks the native RESP3 replies, and the same commands keep the RESP2 encodings on a RESP2
+// connection
+func TestResp3(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	setup := []exchange{
+		{respclient.Encode("hset", "h", "f", "v"), []interface{}{int64(1)}},
+		{respclient.Encode("sadd", "s", "m"), []interface{}{int64(1)}},
+		{respclient.Encode("zadd", "z", "1.5", "m"), []interface{}{int64(1)}},
+	}
+
+	resp2 := dialTestServer(t, addr)
+	defer resp2.Close()
+	runExchanges(t, resp2, setup)
+	runExchanges(t, resp2, []exchange{
+		{respclient.Encode("hgetall", "h"), []interface{}{[]interface{}{"f", "v"}}},
+		{respclient.Encode("smembers", "s"), []interface{}{[]interface{}{"m"}}},
+		{respclient.Encode("zscore", "z", "m"), []interface{}{"1.5"}},
+		{respclient.Encode("zincrby", "z", "1", "m"), []interface{}{"2.5"}},
+		{respclient.Encode("get", "nosuch"), []interface{}{nil}},
+	})
+	reply, err := resp2.Do("zscore", "z", "nosuch")
+	assert.Nil(t, err)
+	assert.Nil(t, reply)
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("hello", "3"), []interface{}{isHelloMap(3)}},
+		{respclient.Encode("hgetall", "h"), []interface{}{respclient.Map{"f", "v"}}},
+		{respclient.Encode("smembers", "s"), []interface{}{respclient.Set{"m"}}},
+		{respclient.Encode("zscore", "z", "m"), []interface{}{2.5}},
+		{respclient.Encode("zmscore", "z", "m", "nosuch"), []interface{}{[]interface{}{2.5, nil}}},
+		{respclient.Encode("zincrby", "z", "1", "m"), []interface{}{3.5}},
+	})
+
+	// the nulls are sent as the RESP3 null
+	assert.Nil(t, c.Send(respclient.Encode("get", "nosuch")))
+	line, err := c.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, "_", line)
+
+	// HELLO 2 switches back to the RESP2 encodings
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("hello", "2"), []interface{}{isHelloArray(2)}},
+		{respclient.Encode("hgetall", "h"), []interface{}{[]interface{}{"f", "v"}}},
+	})
+}
+
+func isHelloArray(version int64) matcher {
+	return func(reply interface{}) bool {
+		a, ok := reply.([]interface{})
+		return ok && len(a) == 14 && a[0] == "server" && a[4] == "proto" && a[5] == version
+	}
+}
+
+// TestResp3PubSub checks that the messages are pushed, and a RESP3 subscriber may still send any command
+func TestResp3PubSub(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	sub := dialTestServer(t, addr)
+	defer sub.Close()
+
+	pub := dialTestServer(t, addr)
+	defer pub.Close()
+
+	runExchanges(t, sub, []exchange{
+		{respclient.Encode("hello", "3"), []interface{}{isHelloMap(3)}},
+		{respclient.Encode("subscribe", "news"), []interface{}{respclient.Push{"subscribe", "news", int64(1)}}},
+		{respclient.Encode("psubscribe", "n*"), []interface{}{respclient.Push{"psubscribe", "n*", int64(2)}}},
+		{respclient.Encode("set", "k", "v"), []interface{}{"OK"}},
+		{respclient.Encode("ping"), []interface{}{"PONG"}},
+	})
+
+	reply, err := pub.Do("publish", "news", "hi")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), reply)
+
+	runExchanges(t, sub, []exchange{
+		{"", []interface{}{
+			respclient.Push{"message", "news", "hi"},
+			respclient.Push{"pmessage", "n*", "news", "hi"},
+		}},
+		{respclient.Encode("get", "k"), []interface{}{"v"}},
+	})
+}
+
+// TestStreamedReplies checks the replies written straight from the containers, which are built in a
+// transaction since the later commands may change the containers
+func TestStreamedReplies(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	// a reply larger than the flush threshold
+	args := []string{"rpush", "l"}
+	var elements []interface{}
+	for idx := 0; idx < 20000; idx++ {
+		args = append(args, "element-"+strconv.Itoa(idx))
+		elements = append(elements, "element-"+strconv.Itoa(idx))
+	}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode(args...), []interface{}{int64(len(elements))}},
+		{respclient.Encode("lrange", "l", "0", "-1"), []interface{}{elements}},
+		{respclient.Encode("lrange", "l", "-2", "-1"), []interface{}{elements[len(elements)-2:]}},
+		{respclient.Encode("lrange", "l", "5", "1"), []interface{}{nil}},
+		{respclient.Encode("lrange", "nosuch", "0", "-1"), []interface{}{nil}},
+		{respclient.Encode("hset", "h", "f", "v"), []interface{}{int64(1)}},
+		{respclient.Encode("hgetall", "h"), []interface{}{[]interface{}{"f", "v"}}},
+		{
+			respclient.Encode("multi") +
+				respclient.Encode("lrange", "l", "0", "1") +
+				respclient.Encode("hgetall", "h") +
+				respclient.Encode("lpop", "l") +
+				respclient.Encode("hset", "h", "g", "w") +
+				respclient.Encode("lrange", "l", "0", "1") +
+				respclient.Encode("exec"),
+			[]interface{}{"OK", "QUEUED", "QUEUED", "QUEUED", "QUEUED", "QUEUED", []interface{}{
+				[]interface{}{"element-0", "element-1"},
+				[]interface{}{"f", "v"},
+				"element-0",
+				int64(1),
+				[]interface{}{"element-1", "element-2"},
+			}},
+		},
+		{respclient.Encode("hello", "3"), []interface{}{isHelloMap(3)}},
+		{respclient.Encode("hdel", "h", "g"), []interface{}{int64(1)}},
+		{respclient.Encode("hgetall", "h"), []interface{}{respclient.Map{"f", "v"}}},
+		{respclient.Encode("lrange", "l", "5", "1"), []interface{}{nil}},
+	})
+}
+
+// TestQuit checks that QUIT replies OK and the server closes the connection with nothing else written
+func TestQuit(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	assert.Nil(t, c.Send(respclient.Encode("set", "a", "1")+respclient.Encode("quit")+respclient.Encode("get", "a")))
+
+	rest, err := c.ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, "+OK\r\n+OK\r\n", rest)
+}
+
+// TestServerShutdown checks that the clients see a clean EOF when the server stops
+func TestServerShutdown(t *testing.T) {
+	s, addr := startTestServer(t)
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	reply, err := c.Do("ping")
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", reply)
+
+	s.Stop()
+
+	rest, err := c.ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, "", rest)
+}
+
+// TestProtocolError checks that a bad frame is refused with a protocol error after the requests before it
+// are replied, and the other clients are not disturbed
+func TestProtocolError(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	other := dialTestServer(t, addr)
+	defer other.Close()
+
+	testCases := map[string]string{
+		"*2147483647\r\n":          "-ERR Protocol error: invalid multibulk length\r\n",
+		"*1\r\n$2147483647\r\nabc": "-ERR Protocol error: invalid bulk length\r\n",
+		"*1\r\n$3\r\nabcde\r\n":    "-ERR Protocol error: invalid frame\r\n",
+		"set k \"v\r\n":            "-ERR Protocol error: unbalanced quotes in request\r\n",
+		strings.Repeat("a", 70000): "-ERR Protocol error: too big inline request\r\n",
+	}
+
+	for raw, expected := range testCases {
+		c := dialTestServer(t, addr)
+
+		assert.Nil(t, c.Send(respclient.Encode("set", "a", "1")+raw))
+		rest, err := c.ReadAll()
+		assert.Nil(t, err, "raw=%.20q", raw)
+		assert.Equal(t, "+OK\r\n"+expected, rest)
+
+		_ = c.Close()
+
+		reply, err := other.Do("get", "a")
+		assert.Nil(t, err)
+		assert.Equal(t, "1", reply)
+	}
+}
@@ -2,37 +2,83 @@ package network
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/lxdlam/vertex/pkg/common"
 
 	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/types"
 	"github.com/lxdlam/vertex/pkg/util"
 )
 
+// flushThreshold is the unflushed bytes which make the queued responses written without waiting for Flush,
+// so a long pipeline does not hold all its responses
+const flushThreshold = 64 * 1024
+
+// maxSpareSize is the max capacity of a written output buffer kept to be reused, so a conn does not hold
+// the memory of a huge response forever
+const maxSpareSize = 1024 * 1024
+
 var (
 	defaultExpireTime = 10 * time.Minute
-	closeMessage      = []byte("TTL expired")
 
 	// ErrConnIsClosed will be raised if do any operation on a closed conn
 	ErrConnIsClosed = errors.New("conn: conn is already closed")
+
+	// ErrOutputBufferLimit will be raised if the pending responses exceed the output buffer limit, the conn
+	// is closed then
+	ErrOutputBufferLimit = errors.New("conn: output buffer limit reached")
 )
 
 // Conn is a client connection object that will handle a expire time
 type Conn interface {
 	Read() (protocol.RedisObject, error)
+
+	// Write queues the response and returns at once, the responses are written in order by a background
+	// worker after Flush is called, or once the unflushed bytes reach flushThreshold. The conn of a
+	// subscribed session is closed if the queued bytes exceed the output buffer limit.
 	Write(string) error
+
+	// Writer returns the RESPWriter of the conn, which queues the responses as Write once it is flushed.
+	// It is not safe for concurrent use.
+	Writer() protocol.RESPWriter
+
+	// Flush wakes the write worker up to write all queued responses with one write call.
+	Flush()
+
+	// CloseAfterReply closes the conn once the queued responses are written, e.g., for QUIT. Nothing can be
+	// written after it.
+	CloseAfterReply()
+
+	// Discard drops the input until the conn is closed. Closing a conn with unread input resets it, which
+	// may lose the responses not read by the client yet, e.g., the error of a bad request.
+	Discard()
+
 	Close() error
 
+	// SetOutputBufferLimit sets the max bytes of the queued responses while the session is subscribed, no
+	// limit if it is not positive. The messages are pushed no matter whether the client reads them, while
+	// the responses of a normal client are bounded by its requests, so they are never limited.
+	SetOutputBufferLimit(int)
+
+	// SetLimits sets the limits of the requests, it should be called before the first Read.
+	SetLimits(protocol.Limits)
+
 	IsClosed() bool
 
 	Addr() string
 	ID() string
+
+	// Session returns the state of the connection, which is carried by every request of it.
+	Session() *types.Session
 }
 
 type conn struct {
@@ -42,8 +88,21 @@ type conn struct {
 	expireTime time.Duration
 	closed     int32
 	reader     protocol.RESPReader
+	writer     protocol.RESPWriter
+	session    *types.Session
 	resetChan  chan byte
 	closeChan  chan struct{}
+
+	// output queue, which is drained by the write worker. The buffer written last time is kept as spare
+	// to be the next output, so no buffer is allocated once they grow enough.
+	outputMutex  sync.Mutex
+	output       *bytes.Buffer
+	spare        *bytes.Buffer
+	outputBytes  int
+	unflushed    int
+	outputLimit  int
+	closing      bool
+	outputNotify chan struct{}
 }
 
 // NewConn equals NewConnWithExpire(conn, defaultExpireTime)
@@ -53,22 +112,32 @@ func NewConn(conn net.Conn) Conn {
 
 // NewConnWithExpire will return a new Conn with the expire time set to expireTime. The
 // expire time is global, what means if no operation happens from the last operation for
-// expire time, it will close the operation and do not serve anymore.
+// expire time, it will close the operation and do not serve anymore, unless the session
+// is blocked or subscribed.
 func NewConnWithExpire(tcpConn net.Conn, expireTime time.Duration) Conn {
+	id := util.GenNewUUID()
 	c := &conn{
-		id:         util.GenNewUUID(),
+		id:         id,
 		addr:       tcpConn.RemoteAddr().String(),
 		tcpConn:    tcpConn,
 		expireTime: expireTime,
 		closed:     0,
 		reader:     protocol.NewRESPReader(bufio.NewReader(tcpConn)),
+		session:    types.NewSession(id),
 		resetChan:  make(chan byte),
 		closeChan:  make(chan struct{}),
+
+		output:       &bytes.Buffer{},
+		outputNotify: make(chan struct{}, 1),
 	}
 
+	c.writer = protocol.NewRESPWriter(outputWriter{c})
+	c.session.SetReplier(c)
+
 	common.Infof("client %s is join", c.Addr())
 
 	c.startExpireWorker()
+	c.startWriteWorker()
 
 	return c
 }
@@ -92,27 +161,170 @@ func (c *conn) Read() (protocol.RedisObject, error) {
 	return obj, nil
 }
 
+// outputWriter queues the bytes written into the output of the conn
+type outputWriter struct {
+	c *conn
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	if err := w.c.queue(p, ""); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *conn) Writer() protocol.RESPWriter {
+	return c.writer
+}
+
 func (c *conn) Write(s string) error {
+	return c.queue(nil, s)
+}
+
+// queue queues the bytes or the string into the output
+func (c *conn) queue(p []byte, s string) error {
 	if c.IsClosed() {
 		return ErrConnIsClosed
 	}
 
-	c.resetChan <- 0
+	// the expire worker is gone once the conn is closed
+	select {
+	case c.resetChan <- 0:
+	case <-c.closeChan:
+		return ErrConnIsClosed
+	}
+
+	c.outputMutex.Lock()
+	if c.closing {
+		c.outputMutex.Unlock()
+		return ErrConnIsClosed
+	}
+
+	// the subscriptions are changed by the engine, which holds its mutex while replying
+	n := len(p) + len(s)
+	if c.outputLimit > 0 && c.outputBytes+n > c.outputLimit && c.session.Subscriptions() > 0 {
+		pending := c.outputBytes
+		c.outputMutex.Unlock()
+
+		_ = c.Close()
+		return fmt.Errorf("conn: client reads too slow. conn.id=%s, conn.tcpConn.addr=%s, pending=%d, limit=%d, err={%w}", c.id, c.addr, pending, c.outputLimit, ErrOutputBufferLimit)
+	}
+
+	c.output.Write(p)
+	c.output.WriteString(s)
+	c.outputBytes += n
+	c.unflushed += n
+	full := c.unflushed >= flushThreshold
+	c.outputMutex.Unlock()
+
+	if full {
+		c.Flush()
+	}
+
+	return nil
+}
+
+func (c *conn) Flush() {
+	select {
+	case c.outputNotify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *conn) CloseAfterReply() {
+	c.outputMutex.Lock()
+	c.closing = true
+	c.outputMutex.Unlock()
+
+	c.Flush()
+}
+
+// drained reports whether the conn is closing after reply and all responses are written
+func (c *conn) drained() bool {
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+
+	return c.closing && c.output.Len() == 0
+}
+
+func (c *conn) Discard() {
+	_, _ = io.Copy(ioutil.Discard, c.tcpConn)
+}
+
+func (c *conn) SetLimits(limits protocol.Limits) {
+	c.reader = protocol.NewRESPReaderWithLimits(c.tcpConn, limits)
+}
+
+func (c *conn) SetOutputBufferLimit(limit int) {
+	c.outputMutex.Lock()
+	defer c.outputMutex.Unlock()
+
+	c.outputLimit = limit
+}
+
+// flush writes all queued responses with one write call
+func (c *conn) flush() error {
+	c.outputMutex.Lock()
+	buf := c.output
+	if buf.Len() == 0 {
+		c.outputMutex.Unlock()
+		return nil
+	}
+
+	c.output = c.spare
+	if c.output == nil {
+		c.output = &bytes.Buffer{}
+	}
+	c.spare = nil
+	c.unflushed = 0
+	c.outputMutex.Unlock()
+
+	n, err := c.tcpConn.Write(buf.Bytes())
+	size := buf.Len()
+	buf.Reset()
 
-	n, err := c.tcpConn.Write([]byte(s))
+	// the bytes are released only after they are written, so a stuck client keeps counting
+	c.outputMutex.Lock()
+	c.outputBytes -= size
+	if buf.Cap() <= maxSpareSize {
+		c.spare = buf
+	}
+	c.outputMutex.Unlock()
 
 	if err != nil {
-		return fmt.Errorf("conn: write a response met an error. s=%s, conn.id=%s, conn.tcpConn.addr=%s, err={%w}", s, c.id, c.addr, err)
-	} else if n != len(s) {
-		return fmt.Errorf("conn: not all bytes writes to the tcpConn. s=%s, len(s)=%d, n=%d, conn.id=%s, conn.tcpConn.addr=%s, err={%w}", s, len(s), n, c.id, c.addr, err)
+		return fmt.Errorf("conn: write a response met an error. conn.id=%s, conn.tcpConn.addr=%s, err={%w}", c.id, c.addr, err)
+	} else if n != size {
+		return fmt.Errorf("conn: not all bytes writes to the tcpConn. len=%d, n=%d, conn.id=%s, conn.tcpConn.addr=%s", size, n, c.id, c.addr)
 	}
 
 	return nil
 }
 
+func (c *conn) startWriteWorker() {
+	go func() {
+		for {
+			select {
+			case <-c.outputNotify:
+				if err := c.flush(); err != nil {
+					common.Warnf("conn: flush output failed. err=%s", err.Error())
+					_ = c.Close()
+					return
+				}
+
+				if c.drained() {
+					_ = c.Close()
+					return
+				}
+			case <-c.closeChan:
+				return
+			}
+		}
+	}()
+}
+
 func (c *conn) Close() error {
 	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
-		_, _ = c.tcpConn.Write(closeMessage)
 		// discard all streams
 		_ = c.tcpConn.Close()
 		close(c.closeChan)
@@ -134,6 +346,10 @@ func (c *conn) ID() string {
 	return c.id
 }
 
+func (c *conn) Session() *types.Session {
+	return c.session
+}
+
 func (c *conn) startExpireWorker() {
 	go func() {
 	Outer:
@@ -144,6 +360,11 @@ func (c *conn) startExpireWorker() {
 			case <-c.closeChan:
 				break Outer
 			case <-time.After(c.expireTime):
+				// a blocked or subscribed client is waiting for the server, not idle
+				if c.session.Waiting() {
+					break
+				}
+
 				_ = c.Close()
 				break Outer
 			}
@@ -0,0 +1,129 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// TestMove moves a key with its value and its expiry to another db, unless the key is missing or the
+// target db holds it already
+func TestMove(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "key", "v", "ex", "100"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "list", "a", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("move", "key", "1"), []interface{}{int64(1)}},
+		{respclient.Encode("move", "list", "1"), []interface{}{int64(1)}},
+		{respclient.Encode("exists", "key", "list"), []interface{}{int64(0)}},
+		{respclient.Encode("move", "missing", "1"), []interface{}{int64(0)}},
+		{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key"), []interface{}{"v"}},
+		{respclient.Encode("ttl", "key"), []interface{}{matcher(func(reply interface{}) bool {
+			ttl, ok := reply.(int64)
+			return ok && ttl > 0 && ttl <= 100
+		})}},
+		{respclient.Encode("lrange", "list", "0", "-1"), []interface{}{[]interface{}{"a", "b"}}},
+		// the existing key in the target db is kept
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "other"), []interface{}{"OK"}},
+		{respclient.Encode("move", "key", "1"), []interface{}{int64(0)}},
+		{respclient.Encode("get", "key"), []interface{}{"other"}},
+		{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key"), []interface{}{"v"}},
+		// the invalid targets
+		{respclient.Encode("move", "key", "1"), []interface{}{
+			respclient.Error("ERR source and destination objects are the same"),
+		}},
+		{respclient.Encode("move", "key", "16"), []interface{}{respclient.Error("ERR DB index is out of range")}},
+		{respclient.Encode("move", "key", "-1"), []interface{}{respclient.Error("ERR DB index is out of range")}},
+		{respclient.Encode("move", "key", "one"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("move", "key"), []interface{}{respclient.Error("ERR invalid argument")}},
+	})
+}
+
+// TestSwapDB swaps the keys of two dbs, the clients which select either db see the keys of the other at once
+func TestSwapDB(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	other := dialTestServer(t, addr)
+	defer other.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "first", "0"), []interface{}{"OK"}},
+		{respclient.Encode("set", "volatile", "0", "ex", "100"), []interface{}{"OK"}},
+		{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		{respclient.Encode("set", "second", "1"), []interface{}{"OK"}},
+	})
+	runExchanges(t, other, []exchange{
+		{respclient.Encode("swapdb", "0", "1"), []interface{}{"OK"}},
+		{respclient.Encode("keys", "*"), []interface{}{[]interface{}{"second"}}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+		{respclient.Encode("get", "first"), []interface{}{"0"}},
+		{respclient.Encode("ttl", "volatile"), []interface{}{matcher(func(reply interface{}) bool {
+			ttl, ok := reply.(int64)
+			return ok && ttl > 0 && ttl <= 100
+		})}},
+		{respclient.Encode("exists", "second"), []interface{}{int64(0)}},
+		// swapping a db with itself or an empty db
+		{respclient.Encode("swapdb", "1", "1"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+		{respclient.Encode("swapdb", "1", "2"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+		// the invalid dbs
+		{respclient.Encode("swapdb", "0", "16"), []interface{}{respclient.Error("ERR DB index is out of range")}},
+		{respclient.Encode("swapdb", "-1", "0"), []interface{}{respclient.Error("ERR DB index is out of range")}},
+		{respclient.Encode("swapdb", "0", "one"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("swapdb", "0"), []interface{}{respclient.Error("ERR invalid argument")}},
+	})
+}
+
+// TestFlush drops the keys of the selected db by FLUSHDB and of every db by FLUSHALL, with either the ASYNC
+// or the SYNC flag
+func TestFlush(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("mset", "a", "1", "b", "2"), []interface{}{"OK"}},
+		{respclient.Encode("select", "1"), []interface{}{"OK"}},
+		{respclient.Encode("mset", "a", "1", "b", "2"), []interface{}{"OK"}},
+		{respclient.Encode("flushdb", "async"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+		// the flushed db is usable at once
+		{respclient.Encode("flushdb", "SYNC"), []interface{}{"OK"}},
+		{respclient.Encode("set", "c", "3"), []interface{}{"OK"}},
+		{respclient.Encode("get", "c"), []interface{}{"3"}},
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "list", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("flushall", "ASYNC"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("set", "d", "4"), []interface{}{"OK"}},
+		{respclient.Encode("flushall", "sync"), []interface{}{"OK"}},
+		{respclient.Encode("flushall"), []interface{}{"OK"}},
+		{respclient.Encode("exists", "d"), []interface{}{int64(0)}},
+		// the invalid modes
+		{respclient.Encode("flushdb", "lazy"), []interface{}{respclient.Error("ERR syntax error")}},
+		{respclient.Encode("flushall", "async", "sync"), []interface{}{respclient.Error("ERR invalid argument")}},
+	})
+}
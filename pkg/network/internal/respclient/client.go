@@ -0,0 +1,222 @@
+// Package respclient is a minimal RESP2 and RESP3 client used by the integration tests. It shares no code with
+// pkg/protocol, so the replies of the server are checked by an independent decoder as a real client does.
+package respclient
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+// Map is a RESP3 map reply, the keys and values are in turn
+type Map []interface{}
+
+// Set is a RESP3 set reply
+type Set []interface{}
+
+// Push is a RESP3 push reply
+type Push []interface{}
+
+// Attribute is a RESP3 attribute reply, the keys and values are in turn
+type Attribute []interface{}
+
+// BigNumber is a RESP3 big number reply
+type BigNumber string
+
+// Verbatim is a RESP3 verbatim string reply
+type Verbatim struct {
+	Format string
+	Text   string
+}
+
+// ErrProtocol will be raised if the server replies a malformed frame
+var ErrProtocol = errors.New("respclient: malformed reply")
+
+// Client is a connection to the server. A reply is decoded into a string for the simple and bulk
+// strings, an int64 for the integers, an Error for the errors, a []interface{} for the arrays and nil for
+// the nulls. The RESP3 replies are decoded into float64, bool and the types above.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to the server, every operation of the client times out after timeout
+func Dial(addr string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	return &Client{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// Encode encodes the arguments as a request
+func Encode(args ...string) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		sb.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg))
+	}
+
+	return sb.String()
+}
+
+// Do sends a request and receives its reply
+func (c *Client) Do(args ...string) (interface{}, error) {
+	if err := c.Send(Encode(args...)); err != nil {
+		return nil, err
+	}
+
+	return c.Receive()
+}
+
+// Send writes the raw bytes to the server
+func (c *Client) Send(raw string) error {
+	_, err := io.WriteString(c.conn, raw)
+	return err
+}
+
+// Receive reads a reply
+func (c *Client) Receive() (interface{}, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 {
+		return nil, ErrProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+
+		return n, nil
+	case '$', '=':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, ErrProtocol
+		} else if n == -1 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		} else if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, ErrProtocol
+		}
+
+		if line[0] == '$' {
+			return string(buf[:n]), nil
+		} else if n < 4 || buf[3] != ':' {
+			return nil, ErrProtocol
+		}
+
+		return Verbatim{Format: string(buf[:3]), Text: string(buf[4:n])}, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, ErrProtocol
+		} else if n == -1 {
+			return nil, nil
+		}
+
+		if line[0] == '%' || line[0] == '|' {
+			n *= 2
+		}
+
+		ret := make([]interface{}, n)
+		for idx := range ret {
+			if ret[idx], err = c.Receive(); err != nil {
+				return nil, err
+			}
+		}
+
+		switch line[0] {
+		case '~':
+			return Set(ret), nil
+		case '>':
+			return Push(ret), nil
+		case '%':
+			return Map(ret), nil
+		case '|':
+			return Attribute(ret), nil
+		}
+
+		return ret, nil
+	case ',':
+		f, err := strconv.ParseFloat(line[1:], 64)
+		if err != nil {
+			return nil, ErrProtocol
+		}
+
+		return f, nil
+	case '#':
+		switch line[1:] {
+		case "t":
+			return true, nil
+		case "f":
+			return false, nil
+		}
+	case '_':
+		if len(line) == 1 {
+			return nil, nil
+		}
+	case '(':
+		return BigNumber(line[1:]), nil
+	}
+
+	return nil, ErrProtocol
+}
+
+// ReadAll reads everything until the server closes the connection
+func (c *Client) ReadAll() (string, error) {
+	buf, err := ioutil.ReadAll(c.reader)
+	return string(buf), err
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ReadLine reads a raw line of the reply without the delimiter
+func (c *Client) ReadLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", ErrProtocol
+	}
+
+	return line[:len(line)-2], nil
+}
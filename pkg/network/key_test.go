@@ -0,0 +1,128 @@
+package network
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// isKeys matches an array of the keys in any order
+func isKeys(keys ...string) matcher {
+	return func(reply interface{}) bool {
+		arr, ok := reply.([]interface{})
+		if !ok || len(arr) != len(keys) {
+			return false
+		}
+
+		var got []string
+		for _, key := range arr {
+			str, ok := key.(string)
+			if !ok {
+				return false
+			}
+
+			got = append(got, str)
+		}
+
+		sort.Strings(got)
+		sort.Strings(keys)
+
+		return assert.ObjectsAreEqual(keys, got)
+	}
+}
+
+// TestKeyCommands replays the commands on the keys of any type, the missing keys are counted as nothing
+func TestKeyCommands(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("randomkey"), []interface{}{nil}},
+		{respclient.Encode("keys", "*"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("set", "string", "v"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "list", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("hset", "hash", "f", "v"), []interface{}{int64(1)}},
+		{respclient.Encode("sadd", "set", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("zadd", "zset", "1", "a"), []interface{}{int64(1)}},
+		{respclient.Encode("dbsize"), []interface{}{int64(5)}},
+		// TYPE
+		{respclient.Encode("type", "string"), []interface{}{"string"}},
+		{respclient.Encode("type", "list"), []interface{}{"list"}},
+		{respclient.Encode("type", "hash"), []interface{}{"hash"}},
+		{respclient.Encode("type", "set"), []interface{}{"set"}},
+		{respclient.Encode("type", "zset"), []interface{}{"zset"}},
+		{respclient.Encode("type", "missing"), []interface{}{"none"}},
+		// KEYS
+		{respclient.Encode("keys", "*"), []interface{}{isKeys("string", "list", "hash", "set", "zset")}},
+		{respclient.Encode("keys", "*s*"), []interface{}{isKeys("string", "list", "hash", "set", "zset")}},
+		{respclient.Encode("keys", "?set"), []interface{}{isKeys("zset")}},
+		{respclient.Encode("keys", "[hl]*"), []interface{}{isKeys("list", "hash")}},
+		{respclient.Encode("keys", "[^hl]*t"), []interface{}{isKeys("set", "zset")}},
+		{respclient.Encode("keys", "nothing*"), []interface{}{[]interface{}{}}},
+		// TOUCH
+		{respclient.Encode("touch", "string", "list", "missing", "string"), []interface{}{int64(3)}},
+		{respclient.Encode("touch", "missing"), []interface{}{int64(0)}},
+		// RANDOMKEY
+		{respclient.Encode("randomkey"), []interface{}{matcher(func(reply interface{}) bool {
+			key, ok := reply.(string)
+			return ok && (key == "string" || key == "list" || key == "hash" || key == "set" || key == "zset")
+		})}},
+		// RENAMENX
+		{respclient.Encode("renamenx", "string", "list"), []interface{}{int64(0)}},
+		{respclient.Encode("get", "string"), []interface{}{"v"}},
+		{respclient.Encode("renamenx", "string", "renamed"), []interface{}{int64(1)}},
+		{respclient.Encode("get", "renamed"), []interface{}{"v"}},
+		{respclient.Encode("exists", "string"), []interface{}{int64(0)}},
+		{respclient.Encode("renamenx", "missing", "other"), []interface{}{respclient.Error("ERR no such key")}},
+		// DEL and UNLINK
+		{respclient.Encode("del", "renamed", "missing", "list"), []interface{}{int64(2)}},
+		{respclient.Encode("unlink", "hash", "hash", "missing"), []interface{}{int64(1)}},
+		{respclient.Encode("del", "missing"), []interface{}{int64(0)}},
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+		{respclient.Encode("keys", "*"), []interface{}{isKeys("set", "zset")}},
+		{respclient.Encode("unlink", "set", "zset"), []interface{}{int64(2)}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+		{respclient.Encode("randomkey"), []interface{}{nil}},
+		// the invalid arguments
+		{respclient.Encode("del"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("touch"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("dbsize", "x"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("keys"), []interface{}{respclient.Error("ERR invalid argument")}},
+	})
+}
+
+// TestKeyExpired counts none of the expired keys, whether they are removed yet or not
+func TestKeyExpired(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "key", "v"), []interface{}{"OK"}},
+		{respclient.Encode("set", "volatile", "v", "px", "50"), []interface{}{"OK"}},
+		{respclient.Encode("type", "volatile"), []interface{}{"string"}},
+	})
+
+	assert.Eventually(t, func() bool {
+		reply, err := c.Do("type", "volatile")
+		return err == nil && reply == "none"
+	}, time.Second, 10*time.Millisecond)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("keys", "*"), []interface{}{[]interface{}{"key"}}},
+		{respclient.Encode("touch", "volatile"), []interface{}{int64(0)}},
+		{respclient.Encode("del", "volatile"), []interface{}{int64(0)}},
+		{respclient.Encode("randomkey"), []interface{}{"key"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(1)}},
+	})
+}
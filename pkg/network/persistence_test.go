@@ -0,0 +1,1032 @@
+package network
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/rdb"
+	"github.com/lxdlam/vertex/pkg/snapshot"
+)
+
+const (
+	appendClients  = 4
+	appendRequests = 200
+)
+
+// startFileServer starts a server on a random port with the database file and the fsync policy
+func startFileServer(t *testing.T, file string, policy string) (Server, string) {
+	c := common.NewConfig()
+	c.DatabaseFile = file
+	c.AppendFsync = policy
+
+	return startServerWith(t, c)
+}
+
+func countLogs(t *testing.T, file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open database file failed. err=%s", err)
+	}
+	defer f.Close()
+
+	logs, err := log.ParseLog(bufio.NewReader(f))
+	assert.Nil(t, err)
+
+	return len(logs)
+}
+
+// TestAppendFsync pipelines the pushes from several clients for each policy. RPUSH replies the position
+// the element is pushed to, so the list rebuilt from the file tells whether the logs are written in the
+// order the commands are executed.
+func TestAppendFsync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, policy := range []string{log.FsyncAlways, log.FsyncEverySec, log.FsyncNo} {
+		file := filepath.Join(dir, policy+".vpf")
+		s, addr := startFileServer(t, file, policy)
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		positions := make(map[int64]string)
+
+		for idx := 0; idx < appendClients; idx++ {
+			wg.Add(1)
+
+			go func(id int) {
+				defer wg.Done()
+
+				c := dialTestServer(t, addr)
+				defer c.Close()
+
+				var sb strings.Builder
+				for i := 0; i < appendRequests; i++ {
+					sb.WriteString(respclient.Encode("rpush", "list", fmt.Sprintf("%d:%d", id, i)))
+				}
+				assert.Nil(t, c.Send(sb.String()))
+
+				for i := 0; i < appendRequests; i++ {
+					reply, err := c.Receive()
+					assert.Nil(t, err)
+
+					mutex.Lock()
+					positions[reply.(int64)] = fmt.Sprintf("%d:%d", id, i)
+					mutex.Unlock()
+				}
+			}(idx)
+		}
+
+		wg.Wait()
+
+		// the replies are sent only after the logs are committed
+		if policy == log.FsyncAlways {
+			assert.Equal(t, appendClients*appendRequests, countLogs(t, file))
+		}
+
+		c := dialTestServer(t, addr)
+		reply, err := c.Do("info", "persistence")
+		assert.Nil(t, err)
+
+		info := reply.(string)
+		assert.True(t, strings.HasPrefix(info, "# Persistence\r\n"), info)
+		assert.Contains(t, info, "aof_enabled:1\r\n")
+		assert.Contains(t, info, "appendfsync:"+policy+"\r\n")
+		assert.Contains(t, info, "aof_last_write_status:ok\r\n")
+		assert.NotContains(t, info, "aof_pending_error")
+		if policy == log.FsyncAlways {
+			assert.NotContains(t, info, "aof_last_fsync_time:-1\r\n")
+		}
+
+		_ = c.Close()
+		s.Stop()
+
+		assert.Equal(t, appendClients*appendRequests, countLogs(t, file), "policy=%s", policy)
+
+		// rebuild the list from the file
+		s, addr = startFileServer(t, file, policy)
+		c = dialTestServer(t, addr)
+
+		reply, err = c.Do("lrange", "list", "0", "-1")
+		assert.Nil(t, err)
+
+		elements := reply.([]interface{})
+		assert.Equal(t, appendClients*appendRequests, len(elements))
+		for idx, element := range elements {
+			assert.Equal(t, positions[int64(idx+1)], element, "policy=%s, position=%d", policy, idx+1)
+		}
+
+		_ = c.Close()
+		s.Stop()
+	}
+}
+
+// readRecords reads the records of the database file, each as its name followed by the arguments
+func readRecords(t *testing.T, file string) []string {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open database file failed. err=%s", err)
+	}
+	defer f.Close()
+
+	logs, err := log.ParseLog(bufio.NewReader(f))
+	assert.Nil(t, err)
+
+	var records []string
+	for _, vl := range logs {
+		record := []string{vl.Name}
+		for _, arg := range vl.Args {
+			record = append(record, string(arg))
+		}
+		records = append(records, strings.Join(record, " "))
+	}
+
+	return records
+}
+
+// TestExpireLog writes a DEL once a key is expired, either lazily by the request accessing it or by the
+// active expire cycle, so the key is removed as well when the log is replayed
+func TestExpireLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vertex.db")
+	s, addr := startFileServer(t, file, "always")
+
+	c := dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "lazy", "value", "px", "100"), []interface{}{"OK"}},
+		{respclient.Encode("set", "active", "value", "px", "100"), []interface{}{"OK"}},
+		{respclient.Encode("set", "kept", "value"), []interface{}{"OK"}},
+	})
+
+	time.Sleep(150 * time.Millisecond)
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("get", "lazy"), []interface{}{nil}},
+	})
+
+	// wait for the active expire cycle
+	time.Sleep(300 * time.Millisecond)
+
+	_ = c.Close()
+	s.Stop()
+
+	records := readRecords(t, file)
+	assert.Equal(t, 5, len(records), records)
+	assert.ElementsMatch(t, []string{"del lazy", "del active"}, records[len(records)-2:])
+
+	s, addr = startFileServer(t, file, "always")
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("exists", "lazy", "active", "kept"), []interface{}{int64(1)}},
+	})
+}
+
+// TestPopLog logs the members popped by SPOP as SREM, so the replay removes the same ones
+func TestPopLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "vertex.db")
+	s, addr := startFileServer(t, file, "always")
+
+	c := dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("sadd", "set", "a", "b", "c", "d"), []interface{}{int64(4)}},
+		{respclient.Encode("spop", "nosuch"), []interface{}{nil}},
+	})
+
+	first, err := c.Do("spop", "set")
+	assert.Nil(t, err)
+	rest, err := c.Do("spop", "set", "2")
+	assert.Nil(t, err)
+	members := rest.([]interface{})
+
+	reply, err := c.Do("smembers", "set")
+	assert.Nil(t, err)
+	left := reply.([]interface{})
+
+	_ = c.Close()
+	s.Stop()
+
+	assert.Equal(t, []string{
+		"sadd set a b c d",
+		fmt.Sprintf("srem set %s", first),
+		fmt.Sprintf("srem set %s %s", members[0], members[1]),
+	}, readRecords(t, file))
+
+	s, addr = startFileServer(t, file, "always")
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("smembers", "set"), []interface{}{left}},
+	})
+}
+
+func TestInfo(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	reply, err := c.Do("info")
+	assert.Nil(t, err)
+
+	info := reply.(string)
+	assert.True(t, strings.HasPrefix(info, "# Server\r\n"), info)
+	assert.Contains(t, info, "redis_version:"+common.Version+"\r\n")
+	assert.Contains(t, info, "\r\n\r\n# Persistence\r\nloading:0\r\nrdb_bgsave_in_progress:0\r\n")
+	assert.Contains(t, info, "aof_enabled:0\r\n")
+
+	reply, err = c.Do("info", "unknown")
+	assert.Nil(t, err)
+	assert.Equal(t, "", reply)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("hello", "3"), []interface{}{isHelloMap(3)}},
+		{respclient.Encode("info", "server"), []interface{}{matcher(func(reply interface{}) bool {
+			v, ok := reply.(respclient.Verbatim)
+			return ok && v.Format == "txt" && strings.HasPrefix(v.Text, "# Server\r\n")
+		})}},
+	})
+}
+
+// waitRewrite polls INFO until the rewrite is finished and returns the persistence section
+func waitRewrite(t *testing.T, c *respclient.Client) string {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		reply, err := c.Do("info", "persistence")
+		assert.Nil(t, err)
+
+		info := reply.(string)
+		if strings.Contains(info, "aof_rewrite_in_progress:0\r\n") {
+			return info
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("rewrite is not finished")
+	return ""
+}
+
+// TestRewriteLog rewrites a file of many increments, the writes during the rewrite are kept, and the
+// dataset is rebuilt from the new file with every type and the deadlines
+func TestRewriteLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "rewrite.vpf")
+	s, addr := startFileServer(t, file, log.FsyncEverySec)
+
+	c := dialTestServer(t, addr)
+
+	var sb strings.Builder
+	sb.WriteString(respclient.Encode("set", "counter", "0"))
+	for idx := 0; idx < 1000; idx++ {
+		sb.WriteString(respclient.Encode("incr", "counter"))
+	}
+	for idx := 0; idx < 150; idx++ {
+		sb.WriteString(respclient.Encode("rpush", "list", strconv.Itoa(idx)))
+	}
+	assert.Nil(t, c.Send(sb.String()))
+	for idx := 0; idx < 1151; idx++ {
+		_, err := c.Receive()
+		assert.Nil(t, err)
+	}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("hset", "hash", "f1", "v1", "f2", "v2"), []interface{}{int64(2)}},
+		{respclient.Encode("sadd", "set", "a", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("zadd", "zset", "1.5", "a", "-inf", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("set", "empty", ""), []interface{}{"OK"}},
+		{respclient.Encode("set", "volatile", "v"), []interface{}{"OK"}},
+		{respclient.Encode("expire", "volatile", "1000"), []interface{}{int64(1)}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("set", "other", "db"), []interface{}{"OK"}},
+		{respclient.Encode("set", "counter", "0"), []interface{}{"OK"}},
+		{respclient.Encode("bgrewriteaof"), []interface{}{"Background append only file rewriting started"}},
+		// the writes during the rewrite are appended to the new file
+		{respclient.Encode("incr", "counter"), []interface{}{int64(1)}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "counter"), []interface{}{int64(1001)}},
+	})
+
+	info := waitRewrite(t, c)
+	assert.Contains(t, info, "aof_last_bgrewrite_status:ok\r\n")
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "list", "after"), []interface{}{int64(151)}},
+	})
+
+	_ = c.Close()
+	s.Stop()
+
+	// 2 counters, 3 batches of list, hash, set, zset, empty, volatile with its deadline, other, the 2
+	// increments during the rewrite and the push after it
+	assert.Equal(t, 15, countLogs(t, file))
+	_, err = os.Stat(file + ".rewrite")
+	assert.True(t, os.IsNotExist(err))
+
+	s, addr = startFileServer(t, file, log.FsyncEverySec)
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	var elements []interface{}
+	for idx := 0; idx < 150; idx++ {
+		elements = append(elements, strconv.Itoa(idx))
+	}
+	elements = append(elements, "after")
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("get", "counter"), []interface{}{"1001"}},
+		{respclient.Encode("lrange", "list", "0", "-1"), []interface{}{elements}},
+		{respclient.Encode("hgetall", "hash"), []interface{}{matcher(func(reply interface{}) bool {
+			fields, ok := reply.([]interface{})
+			return ok && len(fields) == 4
+		})}},
+		{respclient.Encode("smembers", "set"), []interface{}{matcher(func(reply interface{}) bool {
+			members, ok := reply.([]interface{})
+			return ok && len(members) == 2
+		})}},
+		{respclient.Encode("zrange", "zset", "0", "-1", "withscores"), []interface{}{[]interface{}{"b", "-inf", "a", "1.5"}}},
+		{respclient.Encode("get", "empty"), []interface{}{""}},
+		{respclient.Encode("ttl", "volatile"), []interface{}{matcher(func(reply interface{}) bool {
+			ttl, ok := reply.(int64)
+			return ok && ttl > 990 && ttl <= 1000
+		})}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("get", "other"), []interface{}{"db"}},
+		{respclient.Encode("get", "counter"), []interface{}{"1"}},
+	})
+}
+
+// TestRewriteLogRounds rewrites enough keys to be dumped in several rounds, the keys modified right after
+// BGREWRITEAOF are dumped as they are before, so the records appended meanwhile are replayed on them once
+func TestRewriteLogRounds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "rounds.vpf")
+	s, addr := startFileServer(t, file, log.FsyncEverySec)
+
+	c := dialTestServer(t, addr)
+
+	var sb strings.Builder
+	for idx := 0; idx < 1000; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), strconv.Itoa(idx)))
+	}
+	sb.WriteString(respclient.Encode("select", "3"))
+	sb.WriteString(respclient.Encode("set", "other", "db"))
+	sb.WriteString(respclient.Encode("select", "0"))
+	assert.Nil(t, c.Send(sb.String()))
+	for idx := 0; idx < 1003; idx++ {
+		_, err := c.Receive()
+		assert.Nil(t, err)
+	}
+
+	sb.Reset()
+	sb.WriteString(respclient.Encode("bgrewriteaof"))
+	sb.WriteString(respclient.Encode("incr", "key:999"))
+	sb.WriteString(respclient.Encode("append", "key:500", "x"))
+	sb.WriteString(respclient.Encode("del", "key:0"))
+	sb.WriteString(respclient.Encode("swapdb", "0", "3"))
+	assert.Nil(t, c.Send(sb.String()))
+
+	for _, expected := range []interface{}{"Background append only file rewriting started", int64(1000), int64(4), int64(1), "OK"} {
+		receiveReply(t, c, expected)
+	}
+
+	info := waitRewrite(t, c)
+	assert.Contains(t, info, "aof_last_bgrewrite_status:ok\r\n")
+
+	_ = c.Close()
+	s.Stop()
+
+	s, addr = startFileServer(t, file, log.FsyncEverySec)
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("dbsize"), []interface{}{int64(1)}},
+		{respclient.Encode("get", "other"), []interface{}{"db"}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(999)}},
+		{respclient.Encode("get", "key:999"), []interface{}{"1000"}},
+		{respclient.Encode("get", "key:500"), []interface{}{"500x"}},
+		{respclient.Encode("get", "key:0"), []interface{}{nil}},
+	})
+}
+
+// TestAutoRewriteLog checks that the file is rewritten once it grows by the percentage
+func TestAutoRewriteLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "auto.vpf")
+
+	cfg := common.NewConfig()
+	cfg.DatabaseFile = file
+	cfg.AutoAOFRewritePercentage = 100
+	cfg.AutoAOFRewriteMinSize = 4096
+
+	s, addr := startServerWith(t, cfg)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	var sb strings.Builder
+	sb.WriteString(respclient.Encode("set", "counter", "0"))
+	for idx := 0; idx < 200; idx++ {
+		sb.WriteString(respclient.Encode("incr", "counter"))
+	}
+	assert.Nil(t, c.Send(sb.String()))
+	for idx := 0; idx < 201; idx++ {
+		_, err := c.Receive()
+		assert.Nil(t, err)
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && countLogs(t, file) != 1 {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	assert.Equal(t, 1, countLogs(t, file))
+
+	info := waitRewrite(t, c)
+	assert.Contains(t, info, "aof_last_bgrewrite_status:ok\r\n")
+	assert.NotContains(t, info, "aof_base_size:0\r\n")
+}
+
+// waitSave polls INFO until the background save is finished and returns the persistence section
+func waitSave(t *testing.T, c *respclient.Client) string {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for time.Now().Before(deadline) {
+		reply, err := c.Do("info", "persistence")
+		assert.Nil(t, err)
+
+		info := reply.(string)
+		if strings.Contains(info, "rdb_bgsave_in_progress:0\r\n") {
+			return info
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("background save is not finished")
+	return ""
+}
+
+// readSnapshot reads all entries of the snapshot file by their dbs and keys
+func readSnapshot(t *testing.T, file string) map[string]*snapshot.Entry {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("open snapshot file failed. err=%s", err)
+	}
+	defer f.Close()
+
+	r, err := snapshot.NewReader(f)
+	assert.Nil(t, err)
+
+	entries := make(map[string]*snapshot.Entry)
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		entries[fmt.Sprintf("%d:%s", e.DB, e.Key)] = e
+	}
+
+	return entries
+}
+
+// TestSnapshot saves the dataset in the background while it is modified, the snapshot holds the keys as
+// they are when BGSAVE is called, and the dataset is restored from the snapshot and the records after it
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "snapshot.vpf")
+	snapshotFile := filepath.Join(dir, "snapshot.vss")
+
+	cfg := common.NewConfig()
+	cfg.DatabaseFile = file
+	cfg.SnapshotFile = snapshotFile
+
+	s, addr := startServerWith(t, cfg)
+	c := dialTestServer(t, addr)
+
+	// enough keys to be dumped in several rounds
+	var sb strings.Builder
+	for idx := 0; idx < 1000; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), strconv.Itoa(idx)))
+	}
+	assert.Nil(t, c.Send(sb.String()))
+	for idx := 0; idx < 1000; idx++ {
+		_, err := c.Receive()
+		assert.Nil(t, err)
+	}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("rpush", "list", "a", "b", "c"), []interface{}{int64(3)}},
+		{respclient.Encode("hset", "hash", "f1", "v1", "f2", "v2"), []interface{}{int64(2)}},
+		{respclient.Encode("sadd", "set", "a", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("zadd", "zset", "1.5", "a", "-inf", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("set", "volatile", "v"), []interface{}{"OK"}},
+		{respclient.Encode("expire", "volatile", "1000"), []interface{}{int64(1)}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("set", "other", "db"), []interface{}{"OK"}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("lastsave"), []interface{}{matcher(func(reply interface{}) bool {
+			_, ok := reply.(int64)
+			return ok
+		})}},
+	})
+
+	// the modifications right after BGSAVE are not in the snapshot
+	sb.Reset()
+	sb.WriteString(respclient.Encode("bgsave"))
+	sb.WriteString(respclient.Encode("set", "key:999", "changed"))
+	sb.WriteString(respclient.Encode("del", "list"))
+	sb.WriteString(respclient.Encode("sadd", "set", "c"))
+	sb.WriteString(respclient.Encode("swapdb", "0", "3"))
+	sb.WriteString(respclient.Encode("set", "new", "key"))
+	assert.Nil(t, c.Send(sb.String()))
+
+	for _, expected := range []interface{}{"Background saving started", "OK", int64(1), int64(1), "OK", "OK"} {
+		reply, err := c.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, reply)
+	}
+
+	info := waitSave(t, c)
+	assert.Contains(t, info, "rdb_last_bgsave_status:ok\r\n")
+
+	entries := readSnapshot(t, snapshotFile)
+	assert.Equal(t, 1006, len(entries))
+	assert.Equal(t, []string{"999"}, entries["0:key:999"].Values)
+	assert.Equal(t, []string{"a", "b", "c"}, entries["0:list"].Values)
+	assert.Equal(t, 2, len(entries["0:set"].Values))
+	assert.Equal(t, []string{"db"}, entries["3:other"].Values)
+	assert.NotEqual(t, snapshot.NoDeadline, entries["0:volatile"].Deadline)
+	assert.Nil(t, entries["3:new"])
+
+	// a save in a transaction is taken after it
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "counter", "0"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("save"), []interface{}{"QUEUED"}},
+		{respclient.Encode("incr", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(1), "OK", int64(2)}}},
+		{respclient.Encode("incr", "counter"), []interface{}{int64(3)}},
+	})
+
+	entries = readSnapshot(t, snapshotFile)
+	assert.Equal(t, []string{"2"}, entries["0:counter"].Values)
+
+	_ = c.Close()
+	s.Stop()
+
+	// the snapshot is loaded, then the records after it are replayed
+	s, addr = startServerWith(t, cfg)
+	c = dialTestServer(t, addr)
+
+	// the dbs are swapped after BGSAVE
+	expected := []exchange{
+		{respclient.Encode("get", "new"), []interface{}{"key"}},
+		{respclient.Encode("get", "counter"), []interface{}{"3"}},
+		{respclient.Encode("get", "other"), []interface{}{"db"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(3)}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key:999"), []interface{}{"changed"}},
+		{respclient.Encode("exists", "list"), []interface{}{int64(0)}},
+		{respclient.Encode("smembers", "set"), []interface{}{matcher(func(reply interface{}) bool {
+			members, ok := reply.([]interface{})
+			return ok && len(members) == 3
+		})}},
+	}
+
+	runExchanges(t, c, expected)
+
+	_ = c.Close()
+	s.Stop()
+
+	// the snapshot alone holds the dataset when it is taken
+	cfg.DatabaseFile = ""
+	s, addr = startServerWith(t, cfg)
+	c = dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("dbsize"), []interface{}{int64(3)}},
+		{respclient.Encode("get", "counter"), []interface{}{"2"}},
+		{respclient.Encode("select", "3"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(1004)}},
+		{respclient.Encode("get", "key:999"), []interface{}{"changed"}},
+		{respclient.Encode("zrange", "zset", "0", "-1", "withscores"), []interface{}{[]interface{}{"b", "-inf", "a", "1.5"}}},
+		{respclient.Encode("hget", "hash", "f2"), []interface{}{"v2"}},
+		{respclient.Encode("ttl", "volatile"), []interface{}{matcher(func(reply interface{}) bool {
+			ttl, ok := reply.(int64)
+			return ok && ttl > 990 && ttl <= 1000
+		})}},
+	})
+
+	_ = c.Close()
+	s.Stop()
+
+	// a corrupted snapshot is ignored, and the dataset is rebuilt from the records
+	data, err := ioutil.ReadFile(snapshotFile)
+	assert.Nil(t, err)
+	data[len(data)/2] ^= 0xff
+	assert.Nil(t, ioutil.WriteFile(snapshotFile, data, 0644))
+
+	cfg.DatabaseFile = file
+	s, addr = startServerWith(t, cfg)
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, expected)
+}
+
+func TestSaveWithoutFile(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("save"), []interface{}{respclient.Error("ERR no snapshot file is configured")}},
+		{respclient.Encode("bgsave"), []interface{}{respclient.Error("ERR no snapshot file is configured")}},
+		{respclient.Encode("bgsave", "unknown"), []interface{}{respclient.Error("ERR syntax error")}},
+	})
+}
+
+// TestImportRDB imports a rdb file of redis into the database file, then exports the dataset when the
+// server stops
+func TestImportRDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "import.vpf")
+	exported := filepath.Join(dir, "dump.rdb")
+
+	cfg := common.NewConfig()
+	cfg.DatabaseFile = file
+	cfg.ImportRDBFile = filepath.Join("..", "rdb", "testdata", "v6.rdb")
+	cfg.ExportRDBFile = exported
+
+	s, addr := startServerWith(t, cfg)
+	c := dialTestServer(t, addr)
+
+	expected := []exchange{
+		{respclient.Encode("get", "string"), []interface{}{"hello"}},
+		{respclient.Encode("lrange", "quicklist", "0", "2"), []interface{}{[]interface{}{"a", "0", "12"}}},
+		{respclient.Encode("zrange", "zset1", "0", "-1", "withscores"), []interface{}{[]interface{}{"z", "-inf", "x", "1.5", "y", "inf"}}},
+		{respclient.Encode("smembers", "intset"), []interface{}{matcher(func(reply interface{}) bool {
+			members, ok := reply.([]interface{})
+			return ok && len(members) == 3
+		})}},
+		{respclient.Encode("exists", "expired"), []interface{}{int64(0)}},
+		{respclient.Encode("pttl", "volatile"), []interface{}{matcher(func(reply interface{}) bool {
+			ttl, ok := reply.(int64)
+			return ok && ttl > 0
+		})}},
+		{respclient.Encode("dbsize"), []interface{}{int64(11)}},
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("hget", "hash", "b"), []interface{}{"2"}},
+	}
+
+	runExchanges(t, c, expected)
+
+	_ = c.Close()
+	s.Stop()
+
+	// the imported keys are in the database file
+	cfg.ImportRDBFile = ""
+	cfg.ExportRDBFile = ""
+	s, addr = startServerWith(t, cfg)
+	c = dialTestServer(t, addr)
+
+	runExchanges(t, c, expected)
+
+	_ = c.Close()
+	s.Stop()
+
+	f, err := os.Open(exported)
+	if err != nil {
+		t.Fatalf("open exported file failed. err=%s", err)
+	}
+	defer f.Close()
+
+	keyspaces := make(map[int]container.Containers)
+	result, err := rdb.Load(f, func(index int) (container.Containers, error) {
+		if _, ok := keyspaces[index]; !ok {
+			keyspaces[index] = container.NewContainers()
+		}
+		return keyspaces[index], nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, rdb.DumpVersion, result.Version)
+	assert.Equal(t, 12, result.Keys)
+	assert.Equal(t, 11, keyspaces[0].Len())
+}
+
+// writeKeys starts a server on the file, sets the keys k0 to k(count-1), then stops it and returns the bytes
+// of the file
+func writeKeys(t *testing.T, file string, count int) []byte {
+	s, addr := startFileServer(t, file, log.FsyncAlways)
+	c := dialTestServer(t, addr)
+
+	for idx := 0; idx < count; idx++ {
+		reply, err := c.Do("set", fmt.Sprintf("k%d", idx), strconv.Itoa(idx))
+		assert.Nil(t, err)
+		assert.Equal(t, "OK", reply)
+	}
+
+	_ = c.Close()
+	s.Stop()
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// TestLoadTruncated damages the tail of the database file, the server truncates it only if
+// aof_load_truncated is set, and never starts with a corrupted record in the middle
+func TestLoadTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "truncated.vpf")
+	data := writeKeys(t, file, 10)
+	assert.True(t, strings.HasPrefix(string(data), "VLOG"))
+
+	_, info, err := log.ReadLog(strings.NewReader(string(data)))
+	assert.Nil(t, err)
+	assert.Equal(t, log.LogVersion, info.Version)
+	assert.Equal(t, int64(0), info.Dropped())
+
+	flipped := func(pos int) []byte {
+		ret := append([]byte(nil), data...)
+		ret[pos] ^= 0x10
+		return ret
+	}
+
+	cases := []struct {
+		name    string
+		damaged []byte
+		keys    int64
+	}{
+		{"torn", data[:len(data)-3], 9},
+		{"flipped", flipped(len(data) - 1), 9},
+		{"garbage", append(append([]byte(nil), data...), 1, 2, 3, 4, 5), 10},
+	}
+
+	for _, tc := range cases {
+		assert.Nil(t, ioutil.WriteFile(file, tc.damaged, 0755))
+
+		cfg := common.NewConfig()
+		cfg.Port = 0
+		cfg.DatabaseFile = file
+		cfg.AOFLoadTruncated = false
+		assert.False(t, NewServer().Init(*cfg), "case=%s", tc.name)
+
+		after, _ := ioutil.ReadFile(file)
+		assert.Equal(t, tc.damaged, after, "case=%s", tc.name)
+
+		cfg.AOFLoadTruncated = true
+		s, addr := startServerWith(t, cfg)
+		c := dialTestServer(t, addr)
+
+		reply, err := c.Do("dbsize")
+		assert.Nil(t, err)
+		assert.Equal(t, tc.keys, reply, "case=%s", tc.name)
+
+		reply, err = c.Do("set", "after", "repair")
+		assert.Nil(t, err)
+		assert.Equal(t, "OK", reply)
+
+		_ = c.Close()
+		s.Stop()
+
+		assert.Equal(t, int(tc.keys)+1, countLogs(t, file), "case=%s", tc.name)
+	}
+
+	// a corrupted record followed by the intact ones is never truncated
+	data = writeKeys(t, file, 10)
+	assert.Nil(t, ioutil.WriteFile(file, flipped(20), 0755))
+
+	cfg := common.NewConfig()
+	cfg.Port = 0
+	cfg.DatabaseFile = file
+	assert.False(t, NewServer().Init(*cfg))
+
+	_, err = log.ParseLog(strings.NewReader(string(flipped(20))))
+	assert.True(t, errors.Is(err, log.ErrLogCorrupted), "err=%v", err)
+
+	// a corrupted length running past the end is not a torn tail
+	assert.Nil(t, ioutil.WriteFile(file, flipped(log.HeaderSize+3), 0755))
+	cfg.AOFLoadTruncated = true
+	assert.False(t, NewServer().Init(*cfg))
+
+	after, _ := ioutil.ReadFile(file)
+	assert.Equal(t, flipped(log.HeaderSize+3), after)
+
+	_, err = log.ParseLog(strings.NewReader(string(after)))
+	assert.True(t, errors.Is(err, log.ErrLogCorrupted), "err=%v", err)
+}
+
+// legacyLog returns a set request logged before the version 2 of the database file
+func legacyLog(key string, value string) *log.VertexLog {
+	objects := []protocol.RedisObject{protocol.NewBulkRedisString("set"), protocol.NewBulkRedisString(key),
+		protocol.NewBulkRedisString(value)}
+
+	return &log.VertexLog{
+		Id:         key,
+		Time:       time.Now().UnixNano(),
+		Name:       "set",
+		Arguments:  []string{objects[1].String(), objects[2].String()},
+		RawRequest: protocol.NewRedisArray(objects).String(),
+	}
+}
+
+// TestUpgradeLog starts a server on the database files of the older versions, the legacy one has no header
+// and no checksum and logs every request in the db 1, the version 1 keeps the arguments in RESP, and the
+// version 2 has no length checksum. The files are rewritten in the current version.
+func TestUpgradeLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, version := range []int{0, 1, 2} {
+		file := filepath.Join(dir, fmt.Sprintf("v%d.vpf", version))
+
+		var data []byte
+		if version > 0 {
+			data = append([]byte("VLOG"), byte(version), 0, 0, 0)
+		}
+
+		for idx := 0; idx < 5; idx++ {
+			vl := legacyLog(fmt.Sprintf("k%d", idx), strconv.Itoa(idx))
+			if version == 0 {
+				vl.Index = 1
+			} else if version == 2 {
+				vl = log.NewLog("set", 0, []protocol.RedisObject{protocol.NewBulkRedisString("set"),
+					protocol.NewBulkRedisString(fmt.Sprintf("k%d", idx)), protocol.NewBulkRedisString(strconv.Itoa(idx))})
+			}
+
+			record, err := log.PackLog(vl)
+			assert.Nil(t, err)
+
+			if version == 0 {
+				// drop the checksums of the record
+				record = append(record[:4], record[12:]...)
+			} else {
+				// drop the length checksum of the record
+				record = append(record[:4], record[8:]...)
+			}
+			data = append(data, record...)
+		}
+		assert.Nil(t, ioutil.WriteFile(file, data, 0755))
+
+		logs, info, err := log.ReadLog(strings.NewReader(string(data)))
+		assert.Nil(t, err)
+		assert.Equal(t, version, info.Version)
+		assert.Equal(t, [][]byte{[]byte("k4"), []byte("4")}, logs[4].Args)
+		assert.Empty(t, logs[4].RawRequest)
+		assert.Equal(t, int32(0), logs[4].Index)
+
+		s, addr := startFileServer(t, file, log.FsyncAlways)
+		c := dialTestServer(t, addr)
+
+		runExchanges(t, c, []exchange{
+			{respclient.Encode("get", "k4"), []interface{}{"4"}},
+			{respclient.Encode("dbsize"), []interface{}{int64(5)}},
+			{respclient.Encode("set", "k5", "5"), []interface{}{"OK"}},
+		})
+
+		_ = c.Close()
+		s.Stop()
+
+		data, err = ioutil.ReadFile(file)
+		assert.Nil(t, err)
+
+		_, info, err = log.ReadLog(strings.NewReader(string(data)))
+		assert.Nil(t, err)
+		assert.Equal(t, log.LogVersion, info.Version)
+		assert.Equal(t, 6, countLogs(t, file))
+	}
+}
+
+// TestBinaryLog replays the values which are not valid utf-8 and the ones looking like RESP
+func TestBinaryLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "binary.vpf")
+	values := []string{"\xff\xfe\x00\x80", "$3\r\nabc\r\n", "*1\r\n$1\r\nx\r\n", ""}
+
+	s, addr := startFileServer(t, file, log.FsyncAlways)
+	c := dialTestServer(t, addr)
+
+	for idx, value := range values {
+		runExchanges(t, c, []exchange{
+			{respclient.Encode("set", fmt.Sprintf("k%d", idx), value), []interface{}{"OK"}},
+		})
+	}
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "list", values[0], values[1]), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(2)}}},
+	})
+
+	_ = c.Close()
+	s.Stop()
+
+	s, addr = startFileServer(t, file, log.FsyncAlways)
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	for idx, value := range values {
+		runExchanges(t, c, []exchange{
+			{respclient.Encode("get", fmt.Sprintf("k%d", idx)), []interface{}{value}},
+		})
+	}
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("lrange", "list", "0", "-1"), []interface{}{[]interface{}{values[0], values[1]}}},
+	})
+}
@@ -0,0 +1,154 @@
+package network
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// TestPubSubIntrospect counts the subscribers of the channels and the patterns by PUBSUB, the counts follow
+// the unsubscriptions and the disconnections
+func TestPubSubIntrospect(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	first := dialTestServer(t, addr)
+	defer first.Close()
+
+	second := dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("pubsub", "channels"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("pubsub", "numsub"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("pubsub", "numsub", "news"), []interface{}{[]interface{}{"news", int64(0)}}},
+		{respclient.Encode("pubsub", "numpat"), []interface{}{int64(0)}},
+	})
+	runExchanges(t, first, []exchange{
+		{respclient.Encode("subscribe", "news", "sports"), []interface{}{
+			[]interface{}{"subscribe", "news", int64(1)},
+			[]interface{}{"subscribe", "sports", int64(2)},
+		}},
+		{respclient.Encode("psubscribe", "news.*"), []interface{}{[]interface{}{"psubscribe", "news.*", int64(3)}}},
+	})
+	runExchanges(t, second, []exchange{
+		{respclient.Encode("subscribe", "news", "weather"), []interface{}{
+			[]interface{}{"subscribe", "news", int64(1)},
+			[]interface{}{"subscribe", "weather", int64(2)},
+		}},
+		{respclient.Encode("psubscribe", "news.*", "*"), []interface{}{
+			[]interface{}{"psubscribe", "news.*", int64(3)},
+			[]interface{}{"psubscribe", "*", int64(4)},
+		}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("pubsub", "channels"), []interface{}{[]interface{}{"news", "sports", "weather"}}},
+		{respclient.Encode("pubsub", "channels", "*s"), []interface{}{[]interface{}{"news", "sports"}}},
+		{respclient.Encode("pubsub", "channels", "[^n]*"), []interface{}{[]interface{}{"sports", "weather"}}},
+		{respclient.Encode("pubsub", "numsub", "news", "sports", "missing"), []interface{}{
+			[]interface{}{"news", int64(2), "sports", int64(1), "missing", int64(0)},
+		}},
+		// the patterns are counted once each, and never as the channels
+		{respclient.Encode("pubsub", "numpat"), []interface{}{int64(2)}},
+		{respclient.Encode("pubsub", "numsub", "news.*"), []interface{}{[]interface{}{"news.*", int64(0)}}},
+	})
+
+	runExchanges(t, first, []exchange{
+		{respclient.Encode("unsubscribe", "sports"), []interface{}{[]interface{}{"unsubscribe", "sports", int64(2)}}},
+	})
+	runExchanges(t, second, []exchange{
+		{respclient.Encode("punsubscribe", "*"), []interface{}{[]interface{}{"punsubscribe", "*", int64(3)}}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("pubsub", "channels"), []interface{}{[]interface{}{"news", "weather"}}},
+		{respclient.Encode("pubsub", "numpat"), []interface{}{int64(1)}},
+	})
+
+	_ = second.Close()
+
+	assert.Eventually(t, func() bool {
+		reply, err := c.Do("pubsub", "numsub", "news", "weather")
+		return err == nil && assert.ObjectsAreEqual([]interface{}{"news", int64(1), "weather", int64(0)}, reply)
+	}, time.Second, 10*time.Millisecond)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("pubsub", "channels"), []interface{}{[]interface{}{"news"}}},
+		{respclient.Encode("pubsub", "numpat"), []interface{}{int64(1)}},
+		// the invalid subcommands
+		{respclient.Encode("pubsub"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("pubsub", "channels", "a", "b"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("pubsub", "numpat", "a"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("pubsub", "nosuch"), []interface{}{respclient.Error("ERR syntax error")}},
+	})
+}
+
+// TestSlowSubscriber disconnects a subscriber which does not read its messages once they exceed the output
+// buffer limit, its subscriptions are dropped and the other subscribers keep receiving
+func TestSlowSubscriber(t *testing.T) {
+	cfg := common.NewConfig()
+	cfg.OutputBufferLimit = 64 * 1024
+	s, addr := startServerWith(t, cfg)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	slow := dialTestServer(t, addr)
+	defer slow.Close()
+
+	fast := dialTestServer(t, addr)
+	defer fast.Close()
+
+	runExchanges(t, slow, []exchange{
+		{respclient.Encode("subscribe", "news"), []interface{}{[]interface{}{"subscribe", "news", int64(1)}}},
+	})
+	runExchanges(t, fast, []exchange{
+		{respclient.Encode("subscribe", "news"), []interface{}{[]interface{}{"subscribe", "news", int64(1)}}},
+	})
+
+	value := strings.Repeat("v", 8*1024)
+
+	// the fast subscriber reads every message, the slow one reads none
+	published := 0
+	for ; published < 10000; published++ {
+		_, err := c.Do("publish", "news", value)
+		assert.Nil(t, err)
+
+		reply, err := fast.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, []interface{}{"message", "news", value}, reply)
+
+		if n, _ := c.Do("pubsub", "numsub", "news"); assert.ObjectsAreEqual([]interface{}{"news", int64(1)}, n) {
+			break
+		}
+	}
+	assert.True(t, published < 10000, "the slow subscriber is never disconnected")
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("publish", "news", "last"), []interface{}{int64(1)}},
+	})
+	runExchanges(t, fast, []exchange{
+		{"", []interface{}{[]interface{}{"message", "news", "last"}}},
+	})
+
+	// the slow subscriber reads at most the messages under the limit, then its connection is closed
+	for idx := 0; idx <= published+1; idx++ {
+		reply, err := slow.Receive()
+		if err != nil {
+			netErr, ok := err.(net.Error)
+			assert.False(t, ok && netErr.Timeout(), "the slow subscriber is not closed")
+			return
+		}
+
+		assert.Equal(t, []interface{}{"message", "news", value}, reply)
+	}
+	t.Fatal("the slow subscriber receives every message")
+}
@@ -0,0 +1,208 @@
+package network
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// raftConfigs returns the configs of a raft cluster of the size on the free ports, and the addresses of
+// their clients
+func raftConfigs(t *testing.T, dir string, size int) ([]*common.Config, []string) {
+	var peers []string
+	var addrs []string
+	var ports []int
+
+	for idx := 0; idx < size; idx++ {
+		port := freePort(t)
+		addr := fmt.Sprintf("127.0.0.1:%d", port)
+		peers = append(peers, fmt.Sprintf("n%d 127.0.0.1:%d %s", idx, freePort(t), addr))
+		addrs = append(addrs, addr)
+		ports = append(ports, port)
+	}
+
+	var configs []*common.Config
+	for idx := 0; idx < size; idx++ {
+		c := common.NewConfig()
+		c.Port = ports[idx]
+		c.RaftNodeID = fmt.Sprintf("n%d", idx)
+		c.RaftPeers = peers
+		c.RaftDir = filepath.Join(dir, c.RaftNodeID)
+		configs = append(configs, c)
+	}
+
+	return configs, addrs
+}
+
+// startRaftServer starts a server on the port of the config, so the others know its address in advance
+func startRaftServer(t *testing.T, c *common.Config) Server {
+	s := NewServer()
+	if !s.Init(*c) {
+		t.Fatal("init server failed")
+	}
+
+	go s.Serve()
+
+	return s
+}
+
+// raftLeader waits until one of the servers is the leader known by all of them, and returns its index
+func raftLeader(t *testing.T, addrs []string, alive []int) int {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		leader := -1
+		known := make(map[string]bool)
+
+		for _, idx := range alive {
+			c := dialReplTest(t, addrs[idx])
+			reply, err := c.Do("info", "raft")
+			_ = c.Close()
+			assert.Nil(t, err)
+
+			info, _ := reply.(string)
+			if strings.Contains(info, "raft_state:leader\r\n") {
+				leader = idx
+			}
+
+			for _, line := range strings.Split(info, "\r\n") {
+				if strings.HasPrefix(line, "raft_leader_addr:") {
+					known[strings.TrimPrefix(line, "raft_leader_addr:")] = true
+				}
+			}
+		}
+
+		if leader >= 0 && len(known) == 1 && known[addrs[leader]] {
+			return leader
+		} else if time.Now().After(deadline) {
+			t.Fatalf("wait raft leader timeout. alive=%v", alive)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// TestRaftCluster runs a cluster of three servers, the followers redirect the writes to the leader and apply
+// the ones committed. Once the leader is stopped, the others elect a new one, and the old one catches up
+// after it restarts.
+func TestRaftCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configs, addrs := raftConfigs(t, dir, 3)
+
+	servers := make([]Server, len(configs))
+	for idx, c := range configs {
+		servers[idx] = startRaftServer(t, c)
+	}
+	defer func() {
+		for _, s := range servers {
+			s.Stop()
+		}
+	}()
+
+	leader := raftLeader(t, addrs, []int{0, 1, 2})
+	var followers []int
+	for idx := range servers {
+		if idx != leader {
+			followers = append(followers, idx)
+		}
+	}
+
+	lc := dialReplTest(t, addrs[leader])
+	defer lc.Close()
+
+	moved := respclient.Error("MOVED 0 " + addrs[leader])
+	for _, idx := range followers {
+		c := dialReplTest(t, addrs[idx])
+		runExchanges(t, c, []exchange{
+			{respclient.Encode("set", "key", "value"), []interface{}{moved}},
+			{respclient.Encode("get", "key"), []interface{}{nil}},
+		})
+		_ = c.Close()
+	}
+
+	runExchanges(t, lc, []exchange{
+		{respclient.Encode("set", "key", "value"), []interface{}{"OK"}},
+		{respclient.Encode("rpush", "list", "a", "b"), []interface{}{int64(2)}},
+		{respclient.Encode("set", "counter", "0"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("select", "2"), []interface{}{"QUEUED"}},
+		{respclient.Encode("set", "other", "db"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(1), "OK", "OK"}}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("replicaof", "127.0.0.1", "6379"), []interface{}{
+			respclient.Error("ERR REPLICAOF not allowed in raft mode"),
+		}},
+	})
+
+	// the pipelined writes are replied in order once they are committed
+	var sb strings.Builder
+	for idx := 0; idx < 100; idx++ {
+		sb.WriteString(respclient.Encode("incr", "counter"))
+		sb.WriteString(respclient.Encode("get", "counter"))
+	}
+	assert.Nil(t, lc.Send(sb.String()))
+	for idx := 0; idx < 100; idx++ {
+		reply, err := lc.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(idx+2), reply)
+
+		reply, err = lc.Receive()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprint(idx+2), reply)
+	}
+
+	for _, idx := range followers {
+		c := dialReplTest(t, addrs[idx])
+		waitReply(t, c, "101", "get", "counter")
+		runExchanges(t, c, []exchange{
+			{respclient.Encode("get", "key"), []interface{}{"value"}},
+			{respclient.Encode("lrange", "list", "0", "-1"), []interface{}{[]interface{}{"a", "b"}}},
+			{respclient.Encode("select", "2"), []interface{}{"OK"}},
+			{respclient.Encode("get", "other"), []interface{}{"db"}},
+		})
+		waitInfo(t, c, "raft", "raft_leader_addr:"+addrs[leader]+"\r\n")
+		_ = c.Close()
+	}
+
+	// the others elect a new leader once the leader stops
+	servers[leader].Stop()
+	next := raftLeader(t, addrs, followers)
+	assert.NotEqual(t, leader, next)
+
+	nc := dialReplTest(t, addrs[next])
+	defer nc.Close()
+
+	waitReply(t, nc, "OK", "set", "key", "failover")
+	for _, idx := range followers {
+		c := dialReplTest(t, addrs[idx])
+		waitReply(t, c, "failover", "get", "key")
+		_ = c.Close()
+	}
+
+	// the old leader restarts from its raft state and follows the new one
+	servers[leader] = startRaftServer(t, configs[leader])
+
+	oc := dialReplTest(t, addrs[leader])
+	defer oc.Close()
+
+	waitReply(t, oc, "failover", "get", "key")
+	waitReply(t, oc, "101", "get", "counter")
+	runExchanges(t, oc, []exchange{
+		{respclient.Encode("set", "key", "value"), []interface{}{respclient.Error("MOVED 0 " + addrs[next])}},
+	})
+}
@@ -0,0 +1,667 @@
+package network
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// freePort returns a port which is free to listen on
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// replProxy forwards the conns of the replicas to the master, so the test breaks them at will and counts
+// the bytes sent by the master
+type replProxy struct {
+	listener net.Listener
+	target   string
+	received int64
+	limit    int64
+	down     int32
+
+	mutex sync.Mutex
+	conns []net.Conn
+}
+
+func newReplProxy(t *testing.T, target string) *replProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &replProxy{listener: l, target: target}
+	go p.serve()
+
+	return p
+}
+
+func (p *replProxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *replProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if atomic.LoadInt32(&p.down) == 1 {
+			_ = conn.Close()
+			continue
+		}
+
+		upstream, err := net.Dial("tcp", p.target)
+		if err != nil {
+			_ = conn.Close()
+			continue
+		}
+
+		p.mutex.Lock()
+		p.conns = append(p.conns, conn, upstream)
+		p.mutex.Unlock()
+
+		go func() {
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.Close()
+		}()
+
+		go func() {
+			_, _ = io.Copy(&countWriter{writer: conn, proxy: p}, upstream)
+			_ = conn.Close()
+		}()
+	}
+}
+
+// countWriter counts the bytes sent by the master, and breaks the conns once the limit is reached
+type countWriter struct {
+	writer io.Writer
+	proxy  *replProxy
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	received := atomic.AddInt64(&w.proxy.received, int64(n))
+
+	if limit := atomic.LoadInt64(&w.proxy.limit); limit > 0 && received >= limit &&
+		atomic.CompareAndSwapInt64(&w.proxy.limit, limit, 0) {
+		w.proxy.breakConns()
+	}
+
+	return n, err
+}
+
+// cutAfter breaks the conns once the bytes received reach the total, the new conns are accepted as usual
+func (p *replProxy) cutAfter(total int64) {
+	atomic.StoreInt64(&p.limit, total)
+}
+
+// cut breaks the conns, and refuses the new ones until resume
+func (p *replProxy) cut() {
+	atomic.StoreInt32(&p.down, 1)
+	p.breakConns()
+}
+
+func (p *replProxy) breakConns() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, conn := range p.conns {
+		_ = conn.Close()
+	}
+	p.conns = nil
+}
+
+func (p *replProxy) resume() {
+	atomic.StoreInt32(&p.down, 0)
+}
+
+func (p *replProxy) close() {
+	p.cut()
+	_ = p.listener.Close()
+}
+
+// startMaster starts a server accepting the replicas, it returns the address of the clients and the one of
+// the replicas
+func startMaster(t *testing.T, backlog int) (Server, string, string) {
+	c := common.NewConfig()
+	c.EnableReplica = true
+	c.ReplicaPort = freePort(t)
+	c.ReplBacklogSize = backlog
+
+	s, addr := startServerWith(t, c)
+
+	return s, addr, fmt.Sprintf("127.0.0.1:%d", c.ReplicaPort)
+}
+
+// dialReplTest connects to the server, the conn lasts longer than the ones of dialTestServer since the
+// replicas take a while to sync
+func dialReplTest(t *testing.T, addr string) *respclient.Client {
+	c, err := respclient.Dial(addr, 30*time.Second)
+	if err != nil {
+		t.Fatalf("dial server failed. addr=%s, err=%s", addr, err)
+	}
+
+	return c
+}
+
+// waitReply sends the request until the reply is expected
+func waitReply(t *testing.T, c *respclient.Client, expected interface{}, args ...string) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		reply, err := c.Do(args...)
+		assert.Nil(t, err)
+
+		if assert.ObjectsAreEqual(expected, reply) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("wait reply timeout. request=%v, expected=%v, reply=%v", args, expected, reply)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitInfo requests INFO of the section until it contains the field
+func waitInfo(t *testing.T, c *respclient.Client, section string, field string) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		reply, err := c.Do("info", section)
+		assert.Nil(t, err)
+
+		if info, ok := reply.(string); ok && strings.Contains(info, field) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("wait info timeout. section=%s, field=%s, info=%v", section, field, reply)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestReplication syncs a replica with the dataset of the master, then streams the modifications to it.
+// The replica resumes from its offset after a short break, and is synced again once the bytes it missed
+// are out of the backlog.
+func TestReplication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	master, masterAddr, replAddr := startMaster(t, 64*1024)
+	defer master.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	var sb strings.Builder
+	for idx := 0; idx < 1000; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), strings.Repeat("v", 100)))
+	}
+	assert.Nil(t, mc.Send(sb.String()))
+	for idx := 0; idx < 1000; idx++ {
+		_, err := mc.Receive()
+		assert.Nil(t, err)
+	}
+
+	proxy := newReplProxy(t, replAddr)
+	defer proxy.close()
+
+	cfg := common.NewConfig()
+	cfg.DatabaseFile = filepath.Join(dir, "replica.vpf")
+	cfg.MasterAddress = proxy.addr()
+
+	replica, replicaAddr := startServerWith(t, cfg)
+	defer replica.Stop()
+
+	rc := dialReplTest(t, replicaAddr)
+	defer rc.Close()
+
+	waitReply(t, rc, int64(1000), "dbsize")
+	assert.True(t, atomic.LoadInt64(&proxy.received) > 100*1000)
+
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("rpush", "list", "a", "\x00\xff"), []interface{}{int64(2)}},
+		{respclient.Encode("set", "counter", "0"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("select", "2"), []interface{}{"QUEUED"}},
+		{respclient.Encode("set", "other", "db"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(1), "OK", "OK"}}},
+		{respclient.Encode("select", "0"), []interface{}{"OK"}},
+		{respclient.Encode("del", "key:0"), []interface{}{int64(1)}},
+	})
+
+	waitReply(t, rc, "1", "get", "counter")
+	waitReply(t, rc, []interface{}{"a", "\x00\xff"}, "lrange", "list", "0", "-1")
+	waitReply(t, rc, int64(1001), "dbsize")
+
+	// a short break is resumed with the bytes missed only
+	proxy.cut()
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "missed", "1"), []interface{}{"OK"}},
+	})
+	waitInfo(t, rc, "replication", "master_link_status:down\r\n")
+
+	received := atomic.LoadInt64(&proxy.received)
+	proxy.resume()
+
+	waitReply(t, rc, "1", "get", "missed")
+	waitInfo(t, rc, "replication", "master_link_status:up\r\n")
+	assert.True(t, atomic.LoadInt64(&proxy.received)-received < 1000, "received=%d",
+		atomic.LoadInt64(&proxy.received)-received)
+
+	// the bytes missed are more than the backlog, so the dataset is sent again
+	proxy.cut()
+	sb.Reset()
+	for idx := 0; idx < 1000; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), strings.Repeat("w", 100)))
+	}
+	assert.Nil(t, mc.Send(sb.String()))
+	for idx := 0; idx < 1000; idx++ {
+		_, err := mc.Receive()
+		assert.Nil(t, err)
+	}
+
+	received = atomic.LoadInt64(&proxy.received)
+	proxy.resume()
+
+	waitReply(t, rc, strings.Repeat("w", 100), "get", "key:999")
+	assert.True(t, atomic.LoadInt64(&proxy.received)-received > 100*1000)
+
+	waitReply(t, rc, int64(1003), "dbsize")
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("get", "other"), []interface{}{"db"}},
+	})
+}
+
+// TestSyncWhileWriting syncs a replica while the master increments its keys, the records of the dataset are
+// packed round by round, and the increments streamed after them are applied once
+func TestSyncWhileWriting(t *testing.T) {
+	master, masterAddr, replAddr := startMaster(t, 1024*1024)
+	defer master.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	const keys = 5000
+
+	var sb strings.Builder
+	for idx := 0; idx < keys; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), "0"))
+	}
+	assert.Nil(t, mc.Send(sb.String()))
+	for idx := 0; idx < keys; idx++ {
+		_, err := mc.Receive()
+		assert.Nil(t, err)
+	}
+
+	// the keys are incremented from the last one while the dataset is packed from the first one, until the
+	// replica is synced
+	stop := make(chan struct{})
+	rounds := make(chan int)
+	go func() {
+		round := 0
+		for ; ; round++ {
+			select {
+			case <-stop:
+				rounds <- round
+				return
+			default:
+			}
+
+			sb.Reset()
+			for idx := keys - 1; idx >= 0; idx-- {
+				sb.WriteString(respclient.Encode("incr", fmt.Sprintf("key:%d", idx)))
+			}
+			assert.Nil(t, mc.Send(sb.String()))
+			for idx := 0; idx < keys; idx++ {
+				_, err := mc.Receive()
+				assert.Nil(t, err)
+			}
+		}
+	}()
+
+	replica, addr := startTestServer(t)
+	defer replica.Stop()
+
+	rc := dialReplTest(t, addr)
+	defer rc.Close()
+
+	host, port, err := net.SplitHostPort(replAddr)
+	assert.Nil(t, err)
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+	})
+	waitInfo(t, rc, "replication", "master_link_status:up\r\n")
+
+	close(stop)
+	expected := strconv.Itoa(<-rounds)
+
+	args := []string{"mget"}
+	var values []interface{}
+	for idx := 0; idx < keys; idx++ {
+		args = append(args, fmt.Sprintf("key:%d", idx))
+		values = append(values, expected)
+	}
+	waitReply(t, rc, values, args...)
+}
+
+// TestReplicaOf turns a server without a database file into a replica at runtime, which refuses the
+// modifications of its clients, then turns it back into a master
+func TestReplicaOf(t *testing.T) {
+	master, masterAddr, replAddr := startMaster(t, 0)
+	defer master.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "key", "value"), []interface{}{"OK"}},
+	})
+
+	reply, err := mc.Do("role")
+	assert.Nil(t, err)
+	offset := reply.([]interface{})[1].(int64)
+	assert.True(t, offset > 0)
+	assert.Equal(t, []interface{}{"master", offset, []interface{}{}}, reply)
+
+	s, addr := startServerWith(t, common.NewConfig())
+	defer s.Stop()
+
+	rc := dialReplTest(t, addr)
+	defer rc.Close()
+
+	host, port, err := net.SplitHostPort(replAddr)
+	assert.Nil(t, err)
+	portNumber, err := strconv.Atoi(port)
+	assert.Nil(t, err)
+
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, "0"), []interface{}{respclient.Error("ERR Invalid master port")}},
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+		{respclient.Encode("slaveof", host, port), []interface{}{"OK Already connected to specified master"}},
+	})
+
+	waitReply(t, rc, []interface{}{"slave", host, int64(portNumber), "connected", offset}, "role")
+	waitReply(t, rc, "value", "get", "key")
+
+	reply, err = rc.Do("info", "replication")
+	assert.Nil(t, err)
+	info := reply.(string)
+	assert.Contains(t, info, "role:slave\r\nmaster_host:"+host+"\r\nmaster_port:"+port+"\r\n")
+	assert.Contains(t, info, "master_link_status:up\r\n")
+	assert.Contains(t, info, fmt.Sprintf("slave_repl_offset:%d\r\n", offset))
+
+	// the offset of a replica is the one it acknowledges
+	waitInfo(t, mc, "replication", fmt.Sprintf(",state=online,offset=%d,lag=", offset))
+	reply, err = mc.Do("info", "replication")
+	assert.Nil(t, err)
+	info = reply.(string)
+	assert.Contains(t, info, "role:master\r\nconnected_slaves:1\r\nslave0:ip=127.0.0.1,")
+	assert.Contains(t, info, fmt.Sprintf("master_repl_offset:%d\r\nrepl_backlog_active:1\r\n", offset))
+
+	readonly := respclient.Error("READONLY You can't write against a read only replica.")
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("set", "key", "other"), []interface{}{readonly}},
+		{respclient.Encode("flushall"), []interface{}{readonly}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key"), []interface{}{"QUEUED"}},
+		{respclient.Encode("del", "key"), []interface{}{readonly}},
+		{respclient.Encode("exec"), []interface{}{
+			respclient.Error("EXECABORT Transaction discarded because of previous errors."),
+		}},
+	})
+
+	// the modifications of the master are still applied
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "key", "next"), []interface{}{"OK"}},
+	})
+	waitReply(t, rc, "next", "get", "key")
+
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", "no", "one"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "own"), []interface{}{"OK"}},
+		{respclient.Encode("role"), []interface{}{[]interface{}{"master", int64(0), []interface{}{}}}},
+		{respclient.Encode("replicaof", "NO", "ONE"), []interface{}{"OK"}},
+	})
+
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("get", "key"), []interface{}{"next"}},
+	})
+
+	reply, err = mc.Do("role")
+	assert.Nil(t, err)
+	waitReply(t, mc, []interface{}{"master", reply.([]interface{})[1], []interface{}{}}, "role")
+}
+
+// TestReplicaExpire keeps the expired keys on a replica until the DEL of its master arrives, while the
+// clients of the replica see them missing
+func TestReplicaExpire(t *testing.T) {
+	master, masterAddr, replAddr := startMaster(t, 64*1024)
+	defer master.Stop()
+
+	proxy := newReplProxy(t, replAddr)
+	defer proxy.close()
+
+	cfg := common.NewConfig()
+	cfg.MasterAddress = proxy.addr()
+
+	replica, replicaAddr := startServerWith(t, cfg)
+	defer replica.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+	rc := dialReplTest(t, replicaAddr)
+	defer rc.Close()
+
+	waitInfo(t, rc, "replication", "master_link_status:up\r\n")
+
+	deadline := time.Now().Add(time.Second)
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "kept", "value"), []interface{}{"OK"}},
+		{respclient.Encode("set", "volatile", "value", "px", "1000"), []interface{}{"OK"}},
+	})
+	waitReply(t, rc, "value", "get", "volatile")
+
+	// the DEL of the master is held back by the break
+	proxy.cut()
+	waitInfo(t, rc, "replication", "master_link_status:down\r\n")
+	time.Sleep(time.Until(deadline) + 300*time.Millisecond)
+
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("get", "volatile"), []interface{}{nil}},
+		{respclient.Encode("exists", "volatile", "kept"), []interface{}{int64(1)}},
+		{respclient.Encode("ttl", "volatile"), []interface{}{int64(-2)}},
+		{respclient.Encode("keys", "*"), []interface{}{[]interface{}{"kept"}}},
+		{respclient.Encode("dbsize"), []interface{}{int64(2)}},
+	})
+
+	proxy.resume()
+	waitReply(t, rc, int64(1), "dbsize")
+}
+
+// TestWait blocks the clients of WAIT until the replica acknowledges the modifications before it, and
+// refuses the modifications while fewer good replicas than min_replicas_to_write are connected
+func TestWait(t *testing.T) {
+	c := common.NewConfig()
+	c.EnableReplica = true
+	c.ReplicaPort = freePort(t)
+	c.MinReplicasToWrite = 1
+	c.MinReplicasMaxLag = 3
+
+	master, masterAddr := startServerWith(t, c)
+	defer master.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	noReplicas := respclient.Error("NOREPLICAS Not enough good replicas to write.")
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "key", "value"), []interface{}{noReplicas}},
+		{respclient.Encode("wait", "0", "0"), []interface{}{int64(0)}},
+		{respclient.Encode("wait", "1", "10"), []interface{}{int64(0)}},
+		{respclient.Encode("wait", "one", "0"), []interface{}{
+			respclient.Error("ERR value is not an integer or out of range"),
+		}},
+		{respclient.Encode("wait", "1", "-1"), []interface{}{respclient.Error("ERR timeout is negative")}},
+	})
+
+	s, addr := startServerWith(t, common.NewConfig())
+	defer s.Stop()
+
+	rc := dialReplTest(t, addr)
+	defer rc.Close()
+
+	proxy := newReplProxy(t, fmt.Sprintf("127.0.0.1:%d", c.ReplicaPort))
+	defer proxy.close()
+
+	host, port, err := net.SplitHostPort(proxy.addr())
+	assert.Nil(t, err)
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+	})
+	waitInfo(t, rc, "replication", "master_link_status:up\r\n")
+	waitReply(t, mc, "OK", "set", "key", "value")
+
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("wait", "1", "0"), []interface{}{int64(1)}},
+	})
+	waitReply(t, rc, "value", "get", "key")
+	waitInfo(t, mc, "replication", "min_slaves_good_slaves:1\r\n")
+
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("wait", "1", "0"), []interface{}{
+			respclient.Error("ERR WAIT cannot be used with replica instances."),
+		}},
+	})
+
+	// the other clients are served while one waits, and the requests after WAIT are replied after it
+	other := dialReplTest(t, masterAddr)
+	defer other.Close()
+
+	start := time.Now()
+	assert.Nil(t, mc.Send(respclient.Encode("wait", "2", "300")+respclient.Encode("ping")))
+	runExchanges(t, other, []exchange{
+		{respclient.Encode("get", "key"), []interface{}{"value"}},
+	})
+	assert.True(t, time.Since(start) < 300*time.Millisecond, "elapsed=%s", time.Since(start))
+
+	reply, err := mc.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), reply)
+	assert.True(t, time.Since(start) >= 300*time.Millisecond, "elapsed=%s", time.Since(start))
+
+	reply, err = mc.Receive()
+	assert.Nil(t, err)
+	assert.Equal(t, "PONG", reply)
+
+	// the modifications are refused once the replica is gone, and accepted again once it is back
+	proxy.cut()
+	waitReply(t, mc, noReplicas, "set", "key", "other")
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("wait", "1", "10"), []interface{}{int64(0)}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("del", "key"), []interface{}{noReplicas}},
+		{respclient.Encode("exec"), []interface{}{
+			respclient.Error("EXECABORT Transaction discarded because of previous errors."),
+		}},
+	})
+
+	proxy.resume()
+	waitReply(t, mc, "OK", "set", "key", "again")
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("wait", "1", "0"), []interface{}{int64(1)}},
+	})
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("get", "key"), []interface{}{"again"}},
+	})
+}
+
+// TestResumeTransfer breaks the transfer of the dataset in the middle, the replica resumes it from the
+// records it has applied instead of receiving the whole dataset again
+func TestResumeTransfer(t *testing.T) {
+	master, masterAddr, replAddr := startMaster(t, 0)
+	defer master.Stop()
+
+	mc := dialReplTest(t, masterAddr)
+	defer mc.Close()
+
+	var sb strings.Builder
+	for idx := 0; idx < 10000; idx++ {
+		sb.WriteString(respclient.Encode("set", fmt.Sprintf("key:%d", idx), strings.Repeat("v", 100)))
+	}
+	assert.Nil(t, mc.Send(sb.String()))
+	for idx := 0; idx < 10000; idx++ {
+		_, err := mc.Receive()
+		assert.Nil(t, err)
+	}
+
+	s, addr := startServerWith(t, common.NewConfig())
+	defer s.Stop()
+
+	rc := dialReplTest(t, addr)
+	defer rc.Close()
+
+	// the bytes of a whole transfer
+	whole := newReplProxy(t, replAddr)
+	defer whole.close()
+
+	host, port, err := net.SplitHostPort(whole.addr())
+	assert.Nil(t, err)
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+	})
+	reply, err := mc.Do("role")
+	assert.Nil(t, err)
+	waitReply(t, rc, []interface{}{"slave", host, int64(whole.listener.Addr().(*net.TCPAddr).Port), "connected",
+		reply.([]interface{})[1]}, "role")
+	size := atomic.LoadInt64(&whole.received)
+	assert.True(t, size > 1000*1000, "size=%d", size)
+
+	proxy := newReplProxy(t, replAddr)
+	defer proxy.close()
+	proxy.cutAfter(size / 2)
+
+	host, port, err = net.SplitHostPort(proxy.addr())
+	assert.Nil(t, err)
+	runExchanges(t, rc, []exchange{
+		{respclient.Encode("replicaof", host, port), []interface{}{"OK"}},
+	})
+	runExchanges(t, mc, []exchange{
+		{respclient.Encode("set", "after", "1"), []interface{}{"OK"}},
+	})
+
+	waitReply(t, rc, "1", "get", "after")
+	waitReply(t, rc, int64(10001), "dbsize")
+
+	// a restart receives the half before the break and the whole dataset again
+	assert.Equal(t, int64(0), atomic.LoadInt64(&proxy.limit), "the transfer is never broken")
+	received := atomic.LoadInt64(&proxy.received)
+	assert.True(t, received < size+size/4, "received=%d, size=%d", received, size)
+}
@@ -7,7 +7,6 @@ import (
 	"net"
 	"os"
 	"os/signal"
-	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -15,20 +14,20 @@ import (
 	"time"
 
 	"github.com/lxdlam/vertex/pkg/log"
-	"github.com/lxdlam/vertex/pkg/replication"
 
 	"github.com/lxdlam/vertex/pkg/db"
 
-	"github.com/lxdlam/vertex/pkg/types"
-
 	"github.com/lxdlam/vertex/pkg/common"
-	"github.com/lxdlam/vertex/pkg/concurrency"
 	"github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/lxdlam/vertex/pkg/raft"
 )
 
 var (
 	// FatalResponse will be returned if we met any fatal error when we answering the client
 	FatalResponse = protocol.NewRedisError("fatal error by vertex. check server log.")
+
+	// ErrInvalidRaftConfig will be raised if the raft mode is configured incorrectly
+	ErrInvalidRaftConfig = errors.New("server: invalid raft config")
 )
 
 // Server is the main service of vertex.
@@ -47,29 +46,32 @@ type Server interface {
 
 // server load will be high, so we use sync.Map, the memory overhead should be profiled
 type server struct {
-	tcpListener      *net.TCPListener
-	addr             *net.TCPAddr
-	eventBus         concurrency.EventBus
-	sigChan          chan os.Signal
-	shutChan         chan struct{}
-	shutdown         int32
-	responseReceiver concurrency.Receiver
-	cleanUpHandles   []func()
-	clients          sync.Map
-	engine           db.Engine
+	tcpListener    *net.TCPListener
+	addr           *net.TCPAddr
+	sigChan        chan os.Signal
+	shutChan       chan struct{}
+	shutdown       int32
+	cleanUpHandles []func()
+	clients        sync.Map
+	engine         db.Engine
+	outputLimit    int
+	limits         protocol.Limits
+	appendFsync    string
+	loadTruncated  bool
+	exportRDBFile  string
+	raftTransport  *raft.TCPTransport
+	raftStorage    raft.Storage
 }
 
 // NewServer will returns a new server instance
 func NewServer() Server {
 	return &server{
-		tcpListener:      nil,
-		eventBus:         concurrency.GetEventBus(),
-		shutChan:         nil,
-		shutdown:         0,
-		responseReceiver: nil,
-		cleanUpHandles:   nil,
-		clients:          sync.Map{},
-		engine:           nil,
+		tcpListener:    nil,
+		shutChan:       nil,
+		shutdown:       0,
+		cleanUpHandles: nil,
+		clients:        sync.Map{},
+		engine:         nil,
 	}
 }
 
@@ -85,6 +87,18 @@ func (s *server) Init(c common.Config) bool {
 		}
 	}()
 
+	s.outputLimit = c.OutputBufferLimit
+	s.limits = protocol.Limits{
+		MaxBulkLen:      c.ProtoMaxBulkLen,
+		MaxMultibulkLen: c.MaxMultibulkLen,
+		MaxInlineSize:   c.MaxInlineSize,
+	}
+
+	s.appendFsync, err = log.ParseFsyncPolicy(c.AppendFsync)
+	if err != nil {
+		return false
+	}
+
 	s.addr = &net.TCPAddr{
 		IP:   []byte{0, 0, 0, 0},
 		Port: c.Port,
@@ -97,27 +111,37 @@ func (s *server) Init(c common.Config) bool {
 		return false
 	}
 
-	s.eventBus.NewTopic("request")
-	s.eventBus.NewTopic("response")
-
-	// Set buffer size to 100
-	s.responseReceiver, err = s.eventBus.SubscribeWithOptions("response", "server", 100, 10*time.Millisecond)
-	if err != nil {
-		common.Fatalf("init server in subscribe response channel failed. err=%s", err)
-		return false
-	}
-
 	if c.EnableReplica {
 		if c.ReplicaPort <= 0 || c.Port == c.ReplicaPort {
-			s.engine = db.NewEngine(c.Port + 1)
+			s.engine = db.NewEngine(c.Port+1, c.Databases)
 		} else {
-			s.engine = db.NewEngine(c.ReplicaPort)
+			s.engine = db.NewEngine(c.ReplicaPort, c.Databases)
 		}
 	} else {
-		s.engine = db.NewEngine(-1)
+		s.engine = db.NewEngine(-1, c.Databases)
+	}
+
+	s.engine.SetAutoRewrite(c.AutoAOFRewritePercentage, c.AutoAOFRewriteMinSize)
+	s.engine.SetReplBacklogSize(c.ReplBacklogSize)
+	s.engine.SetMinReplicas(c.MinReplicasToWrite, time.Duration(c.MinReplicasMaxLag)*time.Second)
+	s.engine.SetSnapshotFile(c.SnapshotFile)
+	s.loadTruncated = c.AOFLoadTruncated
+	if c.RaftNodeID != "" {
+		err = s.initRaft(c)
+	} else {
+		err = s.syncExternal(c.DatabaseFile, c.MasterAddress)
+	}
+
+	if err != nil {
+		_ = s.tcpListener.Close()
+
+		return false
 	}
 
-	s.syncExternal(c.DatabaseFile, c.MasterAddress)
+	if c.ImportRDBFile != "" {
+		s.importRDB(c.ImportRDBFile)
+	}
+	s.exportRDBFile = c.ExportRDBFile
 
 	s.shutChan = make(chan struct{})
 	s.sigChan = make(chan os.Signal)
@@ -133,7 +157,7 @@ func (s *server) Init(c common.Config) bool {
 
 func (s *server) Serve() {
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(1)
 
 	// Currently we just spawn one goroutine
 	// If it's needed, add more workers
@@ -163,11 +187,6 @@ func (s *server) Serve() {
 		wg.Done()
 	}()
 
-	go func() {
-		s.responseWorker()
-		wg.Done()
-	}()
-
 	go s.engine.Start()
 
 	_, _ = fmt.Fprintf(os.Stderr, "Server is listening on %s\n", s.addr.String())
@@ -185,113 +204,61 @@ func (s *server) Stop() {
 
 func (s *server) newConn(conn net.Conn) {
 	c := NewConn(conn)
+	c.SetOutputBufferLimit(s.outputLimit)
+	c.SetLimits(s.limits)
 	s.clients.Store(c.ID(), c)
 
 	go func() {
+		defer s.clients.Delete(c.ID())
+		defer s.engine.Disconnect(c.Session())
+
 		for {
 			request, err := c.Read()
+			var protocolErr *protocol.ProtocolError
 			if errors.Is(err, ErrConnIsClosed) {
 				common.Infof("client %s is leaving", c.Addr())
 				return
+			} else if errors.As(err, &protocolErr) {
+				// the error is replied after the submitted requests, then the conn is closed
+				s.engine.Reject(c.Session(), err)
+				c.Discard()
+				return
 			} else if err != nil {
 				common.Warnf("server: new conn with error. addr=%s, err=%s", c.Addr(), err.Error())
 				return
 			}
 
-			dataMap := types.NewSimpleDataMap()
-			dataMap.Set("id", c.ID())
-			dataMap.Set("request", request)
-
-			concurrency.GetEventBus().Publish("request", dataMap, nil)
+			// the requests of a conn are submitted in order by this goroutine, and the engine replies them
+			// in the same order
+			s.engine.Submit(c.Session(), request)
 		}
 	}()
 }
 
-func parseResponse(data types.DataMap) (id string, obj protocol.RedisObject, ok bool) {
-	i, ok := data.Get("id")
-	if !ok {
-		return
-	}
-
-	id, ok = i.(string)
-	if !ok {
-		return
-	}
-
-	response, ok := data.Get("response")
-	if !ok {
-		return
-	}
-
-	obj, ok = response.(protocol.RedisObject)
-	return
-}
-
-func (s *server) responseWorker() {
-Outer:
-	for {
-		select {
-		case <-s.shutChan:
-			go s.stop()
-			break Outer
-		default:
-			event, err := s.responseReceiver.Receive()
-
-			eventErr := event.Error()
-			if eventErr != nil {
-				if errors.Is(eventErr, concurrency.ErrTopicRemoved) {
-					break Outer
-				}
-			}
-
-			if err != nil {
-				if errors.Is(err, concurrency.ErrChannelClosed) {
-					break Outer
-				} else {
-					common.Warnf("unexpected error. event_id=%s, err=%+v", event.ID(), err)
-					break
-				}
-			}
-
-			data, ok := event.Data().(types.DataMap)
-			if !ok {
-				_ = common.Errorf("data from event is not an DataMap. event_id=%s, raw_type=%s", event.ID(), reflect.TypeOf(data).String())
-				break
-			}
+func (s *server) stop() {
+	if atomic.CompareAndSwapInt32(&s.shutdown, 0, 1) {
+		_ = s.tcpListener.Close()
 
-			id, obj, ok := parseResponse(data)
+		close(s.shutChan)
 
-			if !ok {
-				_ = common.Errorf("parse data from DataMap failed. event_id=%s, raw_data=%+v", event.ID(), data)
-				break
+		if s.exportRDBFile != "" {
+			if err := s.engine.ExportRDB(s.exportRDBFile); err != nil {
+				_ = common.Errorf("export rdb failed. file=%s, err=%s", s.exportRDBFile, err.Error())
 			}
+		}
 
-			conn, ok := s.clients.Load(id)
+		s.engine.Stop()
 
-			if ok {
-				if err := conn.(Conn).Write(obj.String()); err != nil {
-					_ = common.Errorf("send response to client failed. client.addr=%s, object=%s", conn.(Conn).Addr(), obj.String())
-					break
-				}
-			} else {
-				_ = common.Errorf("conn not exist. id=%s", id)
+		// the node is stopped along with the engine, so nothing is stored any more
+		if s.raftTransport != nil {
+			s.raftTransport.Close()
+			if err := s.raftStorage.Close(); err != nil {
+				common.Warnf("close raft storage failed. err=%s", err.Error())
 			}
 		}
-	}
-}
-
-func (s *server) stop() {
-	if atomic.CompareAndSwapInt32(&s.shutdown, 0, 1) {
-		s.eventBus.RemoveTopic("request")
-		s.eventBus.RemoveTopic("response")
-
-		_ = s.tcpListener.Close()
-
-		close(s.shutChan)
-		s.engine.Stop()
 
 		s.clients.Range(func(key, value interface{}) bool {
-			c, ok := value.(conn)
+			c, ok := value.(Conn)
 			if ok {
 				_ = c.Close()
 			}
@@ -302,55 +269,174 @@ func (s *server) stop() {
 	}
 }
 
-func (s *server) syncExternal(file string, master string) {
-	// Init order: first init file, then master to keep the version match
+func (s *server) syncExternal(file string, master string) error {
+	// Init order: first init file, then master, which replaces the dataset once synced
 	if file != "" {
-		s.fromFile(file)
-
-		// Current implementation depends on a database file
-		if master != "" {
-			s.fromMaster(master)
+		if err := s.fromFile(file); err != nil {
+			return err
 		}
+	} else {
+		s.engine.Restore(nil)
 	}
+
+	if master != "" {
+		s.fromMaster(master)
+	}
+
+	return nil
 }
 
-func (s *server) fromMaster(addr string) {
-	conn, err := net.Dial("tcp", addr)
+// initRaft starts the raft node of the server, the dataset is rebuilt from the raft state in the directory
+// once the engine starts. The database file, the replication and the snapshot file conflict with the raft
+// mode, since the raft log persists and replicates the dataset itself.
+func (s *server) initRaft(c common.Config) error {
+	if c.DatabaseFile != "" || c.EnableReplica || c.MasterAddress != "" || c.SnapshotFile != "" || c.ImportRDBFile != "" {
+		return fmt.Errorf("database_file, enable_replica, master_address, snapshot_file and import_rdb_file "+
+			"conflict with the raft mode. err={%w}", ErrInvalidRaftConfig)
+	}
+
+	var addr string
+	var ids []string
+	peers := make(map[string]string)
+	clients := make(map[string]string)
+
+	for _, peer := range c.RaftPeers {
+		fields := strings.Fields(peer)
+		if len(fields) != 3 {
+			return fmt.Errorf("parse raft peer failed. peer=%q, err={%w}", peer, ErrInvalidRaftConfig)
+		} else if _, ok := clients[fields[0]]; ok {
+			return fmt.Errorf("duplicate raft peer. id=%s, err={%w}", fields[0], ErrInvalidRaftConfig)
+		}
+
+		clients[fields[0]] = fields[2]
+		if fields[0] == c.RaftNodeID {
+			addr = fields[1]
+		} else {
+			ids = append(ids, fields[0])
+			peers[fields[0]] = fields[1]
+		}
+	}
+
+	if addr == "" {
+		return fmt.Errorf("raft node is not one of the peers. id=%s, err={%w}", c.RaftNodeID, ErrInvalidRaftConfig)
+	}
+
+	storage, err := raft.NewFileStorage(c.RaftDir)
 	if err != nil {
-		common.Warnf("sync with master failed. addr=%s, err=%s", addr, err.Error())
-		return
+		return err
 	}
 
-	r := replication.NewReplica(conn)
-	logs, err := r.Receive()
+	transport, err := raft.NewTCPTransport(addr, peers)
+	if err != nil {
+		_ = storage.Close()
+		return err
+	}
+
+	config := raft.Config{
+		ID:    c.RaftNodeID,
+		Peers: ids,
+	}
+
+	node, err := s.engine.EnableRaft(config, storage, transport, clients)
+	if err == nil {
+		err = transport.Serve(node)
+	}
 
 	if err != nil {
-		common.Warnf("receive log from master failed. addr=%s, err=%s", addr, err.Error())
-		return // No incomplete log here
+		transport.Close()
+		_ = storage.Close()
+		return err
 	}
 
-	if len(logs) > 0 {
-		s.engine.BuildFromLog(logs)
+	s.raftTransport = transport
+	s.raftStorage = storage
+	common.Infof("raft mode enabled. id=%s, addr=%s, peers=%v", c.RaftNodeID, addr, peers)
+
+	return nil
+}
+
+func (s *server) fromMaster(addr string) {
+	// the replica syncs with the master in the background, and reconnects once the conn is broken
+	s.engine.ReplicaOf(addr)
+}
+
+func (s *server) importRDB(file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		common.Warnf("open rdb file failed. file=%s, err=%s", file, err.Error())
+		return
+	}
+	defer f.Close()
+
+	if err := s.engine.ImportRDB(bufio.NewReader(f)); err != nil {
+		_ = common.Errorf("import rdb failed. file=%s, err=%s", file, err.Error())
 	}
 }
 
-func (s *server) fromFile(file string) {
+// fromFile opens the database file and replays it. A torn tail is truncated if aof_load_truncated is set,
+// and a file in the legacy format is upgraded to the current one before the records are appended.
+func (s *server) fromFile(file string) error {
 	f, err := os.OpenFile(file, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0755)
 	if err != nil {
 		common.Warnf("open file failed. file=%s, err=%s", file, err.Error())
-		return
+		return nil
 	}
 
-	s.engine.SetFile(f, file)
-	reader := bufio.NewReader(f)
-	logs, err := log.ParseLog(reader)
+	f, logs, err := s.repairFile(f, file)
+	if err != nil {
+		return err
+	}
 
+	s.engine.SetFile(f, file, s.appendFsync)
+
+	// the snapshot is loaded even if the file is empty
+	s.engine.Restore(logs)
+
+	return nil
+}
+
+func (s *server) repairFile(f *os.File, file string) (*os.File, []*log.VertexLog, error) {
+	logs, info, err := log.ReadLog(bufio.NewReader(f))
 	if err != nil {
-		common.Warnf("parse log failed. file=%s, err=%s", file, err.Error())
-		// May contains incomplete log here, so we not return
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("read database file failed. file=%s, err={%w}", file, err)
+	}
+
+	if dropped := info.Dropped(); dropped > 0 {
+		if !s.loadTruncated {
+			_ = f.Close()
+			return nil, nil, fmt.Errorf("database file has a torn tail, set aof_load_truncated to truncate it. "+
+				"file=%s, offset=%d, dropped=%d, err={%w}", file, info.ValidSize, dropped, log.ErrLogTruncated)
+		}
+
+		if err := f.Truncate(info.ValidSize); err != nil {
+			_ = f.Close()
+			return nil, nil, fmt.Errorf("truncate database file failed. file=%s, err={%w}", file, err)
+		}
+
+		common.Warnf("the torn tail of the database file is truncated. file=%s, offset=%d, dropped=%d", file,
+			info.ValidSize, dropped)
 	}
 
-	if len(logs) > 0 {
-		s.engine.BuildFromLog(logs)
+	if info.ValidSize == 0 {
+		_, err = f.Write(log.Header())
+		if err == nil {
+			err = f.Sync()
+		}
+	} else if info.Version < log.LogVersion {
+		var upgraded *os.File
+		if upgraded, err = log.UpgradeLog(file, logs); err == nil {
+			_ = f.Close()
+			f = upgraded
+			common.Infof("database file is upgraded. file=%s, from=%d, to=%d, logs=%d", file, info.Version,
+				log.LogVersion, len(logs))
+		}
 	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, fmt.Errorf("prepare database file failed. file=%s, err={%w}", file, err)
+	}
+
+	return f, logs, nil
 }
@@ -0,0 +1,159 @@
+package network
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+	"github.com/lxdlam/vertex/pkg/protocol"
+)
+
+const (
+	stressClients  = 8
+	stressRequests = 100000
+)
+
+// exchange is a raw request sent by a client and the replies it expects, a reply may be checked by a
+// matcher if it is not known in advance
+type exchange struct {
+	request string
+	replies []interface{}
+}
+
+// startTestServer starts a server on a random port and returns its address
+func startTestServer(t *testing.T) (Server, string) {
+	c := common.NewConfig()
+
+	return startServerWith(t, c)
+}
+
+// startServerWith starts a server with the config on a random port and returns its address
+func startServerWith(t *testing.T, c *common.Config) (Server, string) {
+	c.Port = 0
+
+	s := NewServer()
+	if !s.Init(*c) {
+		t.Fatal("init server failed")
+	}
+
+	go s.Serve()
+
+	return s, s.(*server).tcpListener.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string) *respclient.Client {
+	c, err := respclient.Dial(addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("dial server failed. addr=%s, err=%s", addr, err)
+	}
+
+	return c
+}
+
+func runExchanges(t *testing.T, c *respclient.Client, exchanges []exchange) {
+	for idx, e := range exchanges {
+		assert.Nil(t, c.Send(e.request))
+
+		for _, expected := range e.replies {
+			reply, err := c.Receive()
+			assert.Nil(t, err, "exchange %d", idx)
+
+			if m, ok := expected.(matcher); ok {
+				assert.True(t, m(reply), "exchange %d, request=%q, reply=%v", idx, e.request, reply)
+			} else {
+				assert.Equal(t, expected, reply, "exchange %d, request=%q", idx, e.request)
+			}
+		}
+	}
+}
+
+func newRequest(args ...string) string {
+	var objs []protocol.RedisObject
+	for _, arg := range args {
+		objs = append(objs, protocol.NewBulkRedisString(arg))
+	}
+
+	return protocol.NewRedisArray(objs).String()
+}
+
+// TestPipelineStress pipelines the requests from several clients at once, every client increments and reads
+// its own counter alternately, so each reply tells whether it is lost or out of order.
+func TestPipelineStress(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	var wg sync.WaitGroup
+	errChan := make(chan error, stressClients)
+
+	for idx := 0; idx < stressClients; idx++ {
+		wg.Add(1)
+
+		go func(id int) {
+			defer wg.Done()
+			errChan <- runPipelineClient(addr, fmt.Sprintf("counter:%d", id), stressRequests/stressClients)
+		}(idx)
+	}
+
+	wg.Wait()
+	close(errChan)
+
+	for err := range errChan {
+		assert.Nil(t, err)
+	}
+}
+
+func runPipelineClient(addr string, key string, count int) error {
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	_ = c.SetDeadline(time.Now().Add(time.Minute))
+
+	go func() {
+		w := bufio.NewWriter(c)
+		_, _ = w.WriteString(newRequest("set", key, "0"))
+		for idx := 0; idx < count; idx++ {
+			if idx%2 == 0 {
+				_, _ = w.WriteString(newRequest("incr", key))
+			} else {
+				_, _ = w.WriteString(newRequest("get", key))
+			}
+		}
+		_ = w.Flush()
+	}()
+
+	reader := protocol.NewRESPReader(bufio.NewReader(c))
+	if obj, err := reader.ReadObject(); err != nil || obj.String() != "+OK\r\n" {
+		return fmt.Errorf("set counter failed. key=%s, reply=%+v, err=%v", key, obj, err)
+	}
+
+	for idx := 0; idx < count; idx++ {
+		obj, err := reader.ReadObject()
+		if err != nil {
+			return fmt.Errorf("read reply %d failed. key=%s, err={%w}", idx, key, err)
+		}
+
+		expected := int64(idx/2 + 1)
+		if idx%2 == 0 {
+			if i, ok := obj.(protocol.RedisInteger); !ok || i.Data() != expected {
+				return fmt.Errorf("unexpected reply %d. key=%s, reply=%q", idx, key, obj.String())
+			}
+		} else {
+			if str, ok := obj.(protocol.RedisString); !ok || str.Data() != strconv.FormatInt(expected, 10) {
+				return fmt.Errorf("unexpected reply %d. key=%s, reply=%q", idx, key, obj.String())
+			}
+		}
+	}
+
+	return nil
+}
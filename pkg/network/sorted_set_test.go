@@ -0,0 +1,264 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// TestZAdd replays ZADD with its flags, NX and XX decide which members are touched, GT and LT which scores
+// are updated, CH counts the updated members and INCR replies the new score
+func TestZAdd(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	syntaxErr := []interface{}{respclient.Error("ERR syntax error")}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zadd", "z", "1", "a", "2", "b"), []interface{}{int64(2)}},
+		// only the new members
+		{respclient.Encode("zadd", "z", "nx", "10", "a", "3", "c"), []interface{}{int64(1)}},
+		// only the existing members
+		{respclient.Encode("zadd", "z", "xx", "5", "a", "4", "d"), []interface{}{int64(0)}},
+		{respclient.Encode("zadd", "z", "xx", "ch", "5", "a", "6", "b", "7", "e"), []interface{}{int64(1)}},
+		{respclient.Encode("zrange", "z", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"c", "3", "a", "5", "b", "6"},
+		}},
+		// only the greater or the less scores, the new members are still added
+		{respclient.Encode("zadd", "z", "gt", "ch", "4", "a", "7", "b", "1", "f"), []interface{}{int64(2)}},
+		{respclient.Encode("zadd", "z", "lt", "ch", "4", "a", "8", "b"), []interface{}{int64(1)}},
+		{respclient.Encode("zadd", "z", "xx", "gt", "ch", "1", "f", "9", "f"), []interface{}{int64(1)}},
+		{respclient.Encode("zrange", "z", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"c", "3", "a", "4", "b", "7", "f", "9"},
+		}},
+		// INCR replies the new score, or null if the member is skipped
+		{respclient.Encode("zadd", "z", "incr", "2.5", "a"), []interface{}{"6.5"}},
+		{respclient.Encode("zadd", "z", "incr", "1", "new"), []interface{}{"1"}},
+		{respclient.Encode("zadd", "z", "nx", "incr", "1", "a"), []interface{}{nil}},
+		{respclient.Encode("zadd", "z", "xx", "incr", "1", "missing"), []interface{}{nil}},
+		{respclient.Encode("zadd", "z", "gt", "incr", "-1", "a"), []interface{}{nil}},
+		{respclient.Encode("zadd", "z", "lt", "incr", "-1", "a"), []interface{}{"5.5"}},
+		{respclient.Encode("zadd", "z", "incr", "-inf", "a"), []interface{}{"-inf"}},
+		{respclient.Encode("zadd", "z", "incr", "inf", "a"), []interface{}{
+			respclient.Error("ERR resulting score is not a number (NaN)"),
+		}},
+		{respclient.Encode("zscore", "z", "a"), []interface{}{"-inf"}},
+		// the conflicting flags
+		{respclient.Encode("zadd", "z", "nx", "xx", "1", "a"), syntaxErr},
+		{respclient.Encode("zadd", "z", "gt", "lt", "1", "a"), syntaxErr},
+		{respclient.Encode("zadd", "z", "nx", "gt", "1", "a"), syntaxErr},
+		{respclient.Encode("zadd", "z", "nx", "lt", "1", "a"), syntaxErr},
+		{respclient.Encode("zadd", "z", "incr", "1", "a", "2", "b"), syntaxErr},
+		{respclient.Encode("zadd", "z", "1", "a", "2"), syntaxErr},
+		{respclient.Encode("zadd", "z", "ch"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("zadd", "z", "one", "a"), []interface{}{respclient.Error("ERR value is not a valid float")}},
+		{respclient.Encode("zadd", "z", "nan", "a"), []interface{}{respclient.Error("ERR value is not a valid float")}},
+		{respclient.Encode("zcard", "z"), []interface{}{int64(5)}},
+	})
+}
+
+// TestZRange replays the unified ZRANGE and its older forms, by rank, by score and by lex, with the
+// exclusive and the infinite bounds
+func TestZRange(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zadd", "z", "-inf", "ninf", "1", "a", "2", "b", "2", "c", "3", "d", "inf", "pinf"),
+			[]interface{}{int64(6)}},
+		{respclient.Encode("zadd", "lex", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e"), []interface{}{int64(5)}},
+		// by rank
+		{respclient.Encode("zrange", "z", "1", "2"), []interface{}{[]interface{}{"a", "b"}}},
+		{respclient.Encode("zrange", "z", "-2", "-1", "withscores"), []interface{}{[]interface{}{"d", "3", "pinf", "inf"}}},
+		{respclient.Encode("zrange", "z", "0", "1", "rev"), []interface{}{[]interface{}{"pinf", "d"}}},
+		{respclient.Encode("zrange", "z", "5", "1"), []interface{}{[]interface{}{}}},
+		// by score
+		{respclient.Encode("zrange", "z", "-inf", "+inf", "byscore"), []interface{}{
+			[]interface{}{"ninf", "a", "b", "c", "d", "pinf"},
+		}},
+		{respclient.Encode("zrange", "z", "(1", "3", "byscore"), []interface{}{[]interface{}{"b", "c", "d"}}},
+		{respclient.Encode("zrange", "z", "1", "(3", "byscore", "withscores"), []interface{}{
+			[]interface{}{"a", "1", "b", "2", "c", "2"},
+		}},
+		{respclient.Encode("zrange", "z", "(-inf", "(inf", "byscore"), []interface{}{[]interface{}{"a", "b", "c", "d"}}},
+		{respclient.Encode("zrange", "z", "(2", "(2", "byscore"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("zrange", "z", "+inf", "(1", "byscore", "rev"), []interface{}{
+			[]interface{}{"pinf", "d", "c", "b"},
+		}},
+		{respclient.Encode("zrange", "z", "-inf", "+inf", "byscore", "limit", "1", "2"), []interface{}{
+			[]interface{}{"a", "b"},
+		}},
+		{respclient.Encode("zrange", "z", "-inf", "+inf", "byscore", "limit", "4", "-1"), []interface{}{
+			[]interface{}{"d", "pinf"},
+		}},
+		{respclient.Encode("zrange", "z", "3", "-inf", "byscore", "rev", "limit", "1", "2", "withscores"),
+			[]interface{}{[]interface{}{"c", "2", "b", "2"}}},
+		{respclient.Encode("zrangebyscore", "z", "(1", "+inf", "limit", "0", "2"), []interface{}{[]interface{}{"b", "c"}}},
+		{respclient.Encode("zcount", "z", "(1", "(inf"), []interface{}{int64(3)}},
+		// by lex
+		{respclient.Encode("zrange", "lex", "-", "+", "bylex"), []interface{}{[]interface{}{"a", "b", "c", "d", "e"}}},
+		{respclient.Encode("zrange", "lex", "[b", "(d", "bylex"), []interface{}{[]interface{}{"b", "c"}}},
+		{respclient.Encode("zrange", "lex", "(b", "+", "bylex", "limit", "1", "2"), []interface{}{[]interface{}{"d", "e"}}},
+		{respclient.Encode("zrange", "lex", "+", "[c", "bylex", "rev"), []interface{}{[]interface{}{"e", "d", "c"}}},
+		{respclient.Encode("zrange", "lex", "(c", "(c", "bylex"), []interface{}{[]interface{}{}}},
+		// the invalid bounds and options
+		{respclient.Encode("zrange", "z", "(one", "2", "byscore"), []interface{}{
+			respclient.Error("ERR min or max is not a float"),
+		}},
+		{respclient.Encode("zrange", "lex", "b", "d", "bylex"), []interface{}{
+			respclient.Error("ERR min or max not valid string range item"),
+		}},
+		{respclient.Encode("zrange", "z", "0", "1", "limit", "0", "1"), []interface{}{respclient.Error("ERR syntax error")}},
+		{respclient.Encode("zrange", "z", "0", "1", "byscore", "bylex"), []interface{}{respclient.Error("ERR syntax error")}},
+		{respclient.Encode("zrange", "lex", "-", "+", "bylex", "withscores"), []interface{}{respclient.Error("ERR syntax error")}},
+	})
+}
+
+// TestZStore replays ZUNIONSTORE and ZINTERSTORE with the weights and the aggregates, a plain set counts
+// its members with the score 1
+func TestZStore(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zadd", "z1", "1", "a", "2", "b", "3", "c"), []interface{}{int64(3)}},
+		{respclient.Encode("zadd", "z2", "10", "b", "20", "c", "30", "d"), []interface{}{int64(3)}},
+		{respclient.Encode("sadd", "set", "a", "d"), []interface{}{int64(2)}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2"), []interface{}{int64(4)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"a", "1", "b", "12", "c", "23", "d", "30"},
+		}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2", "weights", "2", "0.5"), []interface{}{int64(4)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"a", "2", "b", "9", "d", "15", "c", "16"},
+		}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2", "aggregate", "max"), []interface{}{int64(4)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"a", "1", "b", "10", "c", "20", "d", "30"},
+		}},
+		{respclient.Encode("zinterstore", "out", "2", "z1", "z2"), []interface{}{int64(2)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"b", "12", "c", "23"},
+		}},
+		{respclient.Encode("zinterstore", "out", "2", "z1", "z2", "weights", "-1", "1", "aggregate", "min"),
+			[]interface{}{int64(2)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"c", "-3", "b", "-2"},
+		}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "set", "aggregate", "sum"), []interface{}{int64(4)}},
+		{respclient.Encode("zrange", "out", "0", "-1", "withscores"), []interface{}{
+			[]interface{}{"d", "1", "a", "2", "b", "2", "c", "3"},
+		}},
+		// an empty result removes the destination
+		{respclient.Encode("zinterstore", "out", "2", "z1", "missing"), []interface{}{int64(0)}},
+		{respclient.Encode("exists", "out"), []interface{}{int64(0)}},
+		// the invalid options
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2", "weights", "1"), []interface{}{
+			respclient.Error("ERR syntax error"),
+		}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2", "weights", "1", "x"), []interface{}{
+			respclient.Error("ERR value is not a valid float"),
+		}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "z2", "aggregate", "avg"), []interface{}{
+			respclient.Error("ERR syntax error"),
+		}},
+		{respclient.Encode("zunionstore", "out", "3", "z1", "z2"), []interface{}{respclient.Error("ERR invalid argument")}},
+		{respclient.Encode("set", "string", "v"), []interface{}{"OK"}},
+		{respclient.Encode("zunionstore", "out", "2", "z1", "string"), []interface{}{
+			respclient.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+		}},
+	})
+}
+
+// TestZPop pops the members with the lowest or the highest scores, as many as the count
+func TestZPop(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zadd", "z", "1", "a", "2", "b", "3", "c", "4", "d", "5", "e"), []interface{}{int64(5)}},
+		{respclient.Encode("zpopmin", "z"), []interface{}{[]interface{}{"a", "1"}}},
+		{respclient.Encode("zpopmax", "z"), []interface{}{[]interface{}{"e", "5"}}},
+		{respclient.Encode("zpopmin", "z", "2"), []interface{}{[]interface{}{"b", "2", "c", "3"}}},
+		{respclient.Encode("zpopmax", "z", "0"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("zpopmax", "z", "10"), []interface{}{[]interface{}{"d", "4"}}},
+		{respclient.Encode("exists", "z"), []interface{}{int64(0)}},
+		{respclient.Encode("zpopmin", "z"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("zpopmin", "z", "-1"), []interface{}{
+			respclient.Error("ERR invalid argument"),
+		}},
+	})
+}
+
+// TestZRandMember picks distinct members for a positive count, and may repeat them for a negative one
+func TestZRandMember(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	scores := map[interface{}]interface{}{"a": "1", "b": "2", "c": "3"}
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zrandmember", "z"), []interface{}{nil}},
+		{respclient.Encode("zrandmember", "z", "2"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("zadd", "z", "1", "a", "2", "b", "3", "c"), []interface{}{int64(3)}},
+		{respclient.Encode("zrandmember", "z", "0"), []interface{}{[]interface{}{}}},
+	})
+
+	reply, err := c.Do("zrandmember", "z")
+	assert.Nil(t, err)
+	assert.Contains(t, scores, reply)
+
+	// distinct members, at most all of them
+	reply, err = c.Do("zrandmember", "z", "5")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []interface{}{"a", "b", "c"}, reply)
+
+	reply, err = c.Do("zrandmember", "z", "2", "withscores")
+	assert.Nil(t, err)
+	pairs := reply.([]interface{})
+	assert.Equal(t, 4, len(pairs))
+	assert.NotEqual(t, pairs[0], pairs[2])
+	for idx := 0; idx < len(pairs); idx += 2 {
+		assert.Equal(t, scores[pairs[idx]], pairs[idx+1])
+	}
+
+	// exactly the count of members which may repeat
+	reply, err = c.Do("zrandmember", "z", "-10", "withscores")
+	assert.Nil(t, err)
+	pairs = reply.([]interface{})
+	assert.Equal(t, 20, len(pairs))
+	seen := make(map[interface{}]bool)
+	for idx := 0; idx < len(pairs); idx += 2 {
+		assert.Equal(t, scores[pairs[idx]], pairs[idx+1])
+		seen[pairs[idx]] = true
+	}
+	assert.True(t, len(seen) < 10)
+
+	reply, err = c.Do("zrandmember", "z", "-2")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(reply.([]interface{})))
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("zrandmember", "z", "1", "scores"), []interface{}{respclient.Error("ERR syntax error")}},
+		{respclient.Encode("zrandmember", "z", "one"), []interface{}{
+			respclient.Error("ERR invalid argument"),
+		}},
+	})
+}
@@ -0,0 +1,230 @@
+package network
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/log"
+	"github.com/lxdlam/vertex/pkg/network/internal/respclient"
+)
+
+// TestTransaction replies QUEUED for the requests in a transaction and an array of their replies by EXEC,
+// the error of a request is replied in place and does not stop the rest
+func TestTransaction(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("exec"), []interface{}{respclient.Error("ERR EXEC without MULTI")}},
+		{respclient.Encode("discard"), []interface{}{respclient.Error("ERR DISCARD without MULTI")}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{respclient.Error("ERR MULTI calls can not be nested")}},
+		{respclient.Encode("watch", "key"), []interface{}{respclient.Error("ERR WATCH inside MULTI is not allowed")}},
+		{respclient.Encode("set", "key", "1"), []interface{}{"QUEUED"}},
+		{respclient.Encode("incr", "key"), []interface{}{"QUEUED"}},
+		{respclient.Encode("lpush", "key", "x"), []interface{}{"QUEUED"}},
+		{respclient.Encode("get", "key"), []interface{}{"QUEUED"}},
+		{respclient.Encode("lrange", "nosuch", "0", "-1"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{
+			"OK",
+			int64(2),
+			respclient.Error("WRONGTYPE Operation against a key holding the wrong kind of value"),
+			"2",
+			nil,
+		}}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{}}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "discarded"), []interface{}{"QUEUED"}},
+		{respclient.Encode("discard"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key"), []interface{}{"2"}},
+	})
+}
+
+// TestExecAbort discards the whole transaction by EXEC once a request fails to queue
+func TestExecAbort(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "key", "before"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "after"), []interface{}{"QUEUED"}},
+		{respclient.Encode("nosuch", "key"), []interface{}{respclient.Error("ERR no such command")}},
+		{respclient.Encode("rpush", "list", "a"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{
+			respclient.Error("EXECABORT Transaction discarded because of previous errors."),
+		}},
+		{respclient.Encode("get", "key"), []interface{}{"before"}},
+		{respclient.Encode("exists", "list"), []interface{}{int64(0)}},
+		// the next transaction starts clean
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "after"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{"OK"}}},
+	})
+}
+
+// TestWatch aborts EXEC with a null reply once a watched key is modified by another client or expires,
+// and runs it once the watched keys are untouched
+func TestWatch(t *testing.T) {
+	s, addr := startTestServer(t)
+	defer s.Stop()
+
+	c := dialTestServer(t, addr)
+	defer c.Close()
+
+	other := dialTestServer(t, addr)
+	defer other.Close()
+
+	// modified by another client
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "key", "1"), []interface{}{"OK"}},
+		{respclient.Encode("watch", "key"), []interface{}{"OK"}},
+	})
+	runExchanges(t, other, []exchange{
+		{respclient.Encode("incr", "key"), []interface{}{int64(2)}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "overwritten"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{nil}},
+		{respclient.Encode("get", "key"), []interface{}{"2"}},
+	})
+
+	// a missing key is modified once it is created
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("watch", "missing"), []interface{}{"OK"}},
+	})
+	runExchanges(t, other, []exchange{
+		{respclient.Encode("set", "missing", "created"), []interface{}{"OK"}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("del", "missing"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{nil}},
+		{respclient.Encode("get", "missing"), []interface{}{"created"}},
+	})
+
+	// expired while it is watched
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "volatile", "v", "px", "100"), []interface{}{"OK"}},
+		{respclient.Encode("watch", "volatile"), []interface{}{"OK"}},
+	})
+	time.Sleep(150 * time.Millisecond)
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("set", "key", "expired"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{nil}},
+		{respclient.Encode("get", "key"), []interface{}{"2"}},
+	})
+
+	// untouched, or unwatched before the modification
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("watch", "key", "missing"), []interface{}{"OK"}},
+		{respclient.Encode("get", "key"), []interface{}{"2"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "key"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(3)}}},
+		{respclient.Encode("watch", "key"), []interface{}{"OK"}},
+		{respclient.Encode("unwatch"), []interface{}{"OK"}},
+	})
+	runExchanges(t, other, []exchange{
+		{respclient.Encode("incr", "key"), []interface{}{int64(4)}},
+	})
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "key"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(5)}}},
+	})
+}
+
+// TestTransactionLog writes a transaction as one exec record holding its modifications, the record is
+// replayed as a whole, and a torn one is dropped as a whole
+func TestTransactionLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "transaction.vpf")
+	s, addr := startFileServer(t, file, log.FsyncAlways)
+
+	c := dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("set", "counter", "0"), []interface{}{"OK"}},
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("incr", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("get", "counter"), []interface{}{"QUEUED"}},
+		{respclient.Encode("select", "2"), []interface{}{"QUEUED"}},
+		{respclient.Encode("set", "other", "db"), []interface{}{"QUEUED"}},
+		{respclient.Encode("rpush", "list", "a", "b"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{int64(1), "1", "OK", "OK", int64(2)}}},
+		// a transaction without any modification writes nothing
+		{respclient.Encode("multi"), []interface{}{"OK"}},
+		{respclient.Encode("get", "other"), []interface{}{"QUEUED"}},
+		{respclient.Encode("exec"), []interface{}{[]interface{}{"db"}}},
+	})
+
+	_ = c.Close()
+	s.Stop()
+
+	assert.Equal(t, []string{
+		"set counter 0",
+		strings.Join([]string{
+			"exec",
+			respclient.Encode("incr", "counter"),
+			respclient.Encode("select", "2"),
+			respclient.Encode("set", "other", "db"),
+			respclient.Encode("rpush", "list", "a", "b"),
+		}, " "),
+	}, readRecords(t, file))
+
+	s, addr = startFileServer(t, file, log.FsyncAlways)
+	c = dialTestServer(t, addr)
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("get", "counter"), []interface{}{"1"}},
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("get", "other"), []interface{}{"db"}},
+		{respclient.Encode("lrange", "list", "0", "-1"), []interface{}{[]interface{}{"a", "b"}}},
+	})
+
+	_ = c.Close()
+	s.Stop()
+
+	// none of the transaction is applied once its record is torn
+	data, err := ioutil.ReadFile(file)
+	assert.Nil(t, err)
+	assert.Nil(t, ioutil.WriteFile(file, data[:len(data)-3], 0755))
+
+	cfg := common.NewConfig()
+	cfg.DatabaseFile = file
+	cfg.AOFLoadTruncated = true
+
+	s, addr = startServerWith(t, cfg)
+	defer s.Stop()
+
+	c = dialTestServer(t, addr)
+	defer c.Close()
+
+	runExchanges(t, c, []exchange{
+		{respclient.Encode("get", "counter"), []interface{}{"0"}},
+		{respclient.Encode("select", "2"), []interface{}{"OK"}},
+		{respclient.Encode("dbsize"), []interface{}{int64(0)}},
+	})
+}
@@ -0,0 +1,37 @@
+//go:build gofuzz
+// +build gofuzz
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Fuzz is the go-fuzz target of Parse, the seed corpus is in testdata/fuzz/corpus:
+//
+//	go-fuzz-build github.com/lxdlam/vertex/pkg/protocol
+//	go-fuzz -bin=protocol-fuzz.zip -workdir=pkg/protocol/testdata/fuzz
+//
+// Parse must fail with a ProtocolError or an EOF on a bad input, and an object parsed must be parsed back
+// from its own encoding.
+func Fuzz(data []byte) int {
+	obj, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		var protocolErr *ProtocolError
+		if !errors.As(err, &protocolErr) && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			panic("unexpected error: " + err.Error())
+		}
+		return 0
+	}
+
+	again, err := Parse(bytes.NewReader(obj.Byte()))
+	if err != nil {
+		panic("parse the encoding failed: " + err.Error())
+	} else if again.String() != obj.String() {
+		panic("the encoding is changed: " + obj.String() + " != " + again.String())
+	}
+
+	return 1
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var (
+	// ErrUnbalancedQuotes will be raised if a quoted argument of an inline request is not closed
+	ErrUnbalancedQuotes = &ProtocolError{reason: "unbalanced quotes in request"}
+
+	// ErrInlineTooLong will be raised if an inline request is longer than MaxInlineSize
+	ErrInlineTooLong = &ProtocolError{reason: "too big inline request"}
+)
+
+// SplitArgs splits a line into arguments by the quoting rules of redis, which are used by both the inline
+// requests and the command line client:
+//
+// - the arguments are separated by spaces, tabs, newlines and NUL
+// - in a double quoted argument, \n, \r, \t, \b, \a, \\, \" and \xHH are escaped
+// - in a single quoted argument, only \' is escaped
+// - a closing quote must be followed by a separator or the end of the line
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+	idx := 0
+
+	for {
+		for idx < len(line) && isSeparator(line[idx]) {
+			idx++
+		}
+
+		if idx == len(line) {
+			return args, nil
+		}
+
+		var buf bytes.Buffer
+		inDoubleQuotes, inSingleQuotes := false, false
+
+		for done := false; !done; idx++ {
+			if idx == len(line) {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+
+			c := line[idx]
+			switch {
+			case inDoubleQuotes:
+				if c == '\\' && idx+3 < len(line) && line[idx+1] == 'x' && isHexDigit(line[idx+2]) && isHexDigit(line[idx+3]) {
+					buf.WriteByte(hexValue(line[idx+2])<<4 | hexValue(line[idx+3]))
+					idx += 3
+				} else if c == '\\' && idx+1 < len(line) {
+					idx++
+					buf.WriteByte(unescape(line[idx]))
+				} else if c == '"' {
+					// the closing quote must be followed by a separator
+					if idx+1 < len(line) && !isSeparator(line[idx+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					buf.WriteByte(c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && idx+1 < len(line) && line[idx+1] == '\'' {
+					idx++
+					buf.WriteByte('\'')
+				} else if c == '\'' {
+					if idx+1 < len(line) && !isSeparator(line[idx+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					buf.WriteByte(c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					buf.WriteByte(c)
+				}
+			}
+		}
+
+		args = append(args, buf.String())
+	}
+}
+
+// NewInlineRequest splits an inline request line into a RedisArray of bulk strings, which is the same as
+// the request sent by the multi bulk protocol. An empty line results in an empty array.
+func NewInlineRequest(line string) (RedisArray, error) {
+	args, err := SplitArgs(line)
+	if err != nil {
+		return nil, fmt.Errorf("split inline request failed. line=%q, err={%w}", line, err)
+	}
+
+	objects := make([]RedisObject, 0, len(args))
+	for _, arg := range args {
+		objects = append(objects, NewBulkRedisString(arg))
+	}
+
+	return NewRedisArray(objects), nil
+}
+
+func isSeparator(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == 0
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+
+	return c - 'A' + 10
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+
+	return c
+}
@@ -0,0 +1,94 @@
+package protocol_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	. "github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArgs(t *testing.T) {
+	testCases := map[string][]string{
+		"":                            nil,
+		"  \t ":                       nil,
+		"PING":                        {"PING"},
+		"set  key\tvalue":             {"set", "key", "value"},
+		`set "hello world" ""`:        {"set", "hello world", ""},
+		`"a\nb\r\t\\\"\x41\x7a\q"`:    {"a\nb\r\t\\\"Azq"},
+		`"\x4"`:                       {"x4"},
+		`'it\'s' '\n'`:                {"it's", `\n`},
+		`key"quoted" plain'quoted'`:   {"keyquoted", "plainquoted"},
+		"echo \"multi\nline\"\x00end": {"echo", "multi\nline", "end"},
+	}
+
+	for line, expected := range testCases {
+		args, err := SplitArgs(line)
+		assert.Nil(t, err, "line=%q", line)
+		assert.Equal(t, expected, args, "line=%q", line)
+	}
+}
+
+func TestSplitArgsUnbalanced(t *testing.T) {
+	testCases := []string{
+		`"open`,
+		`'open`,
+		`"closed"next`,
+		`'closed'next`,
+		`"escaped\"`,
+	}
+
+	for _, line := range testCases {
+		_, err := SplitArgs(line)
+		assert.True(t, errors.Is(err, ErrUnbalancedQuotes), "line=%q", line)
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	testCases := map[string]string{
+		"PING\r\n":                 "*1\r\n$4\r\nPING\r\n",
+		"PING\n":                   "*1\r\n$4\r\nPING\r\n",
+		"\r\n\n  \r\nget key\r\n":  "*2\r\n$3\r\nget\r\n$3\r\nkey\r\n",
+		"set k \"a b\"\r\n":        "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$3\r\na b\r\n",
+		"echo \"\\r\\n\"\r\n":      "*2\r\n$4\r\necho\r\n$2\r\n\r\n\r\n",
+		"  lpush   l  'x y'  \r\n": "*3\r\n$5\r\nlpush\r\n$1\r\nl\r\n$3\r\nx y\r\n",
+	}
+
+	for raw, expected := range testCases {
+		obj, err := Parse(strings.NewReader(raw))
+		assert.Nil(t, err, "raw=%q", raw)
+		assert.Equal(t, expected, obj.String(), "raw=%q", raw)
+	}
+}
+
+func TestReadInlineAndMultiBulk(t *testing.T) {
+	r := NewRESPReader(strings.NewReader("PING\r\n*2\r\n$4\r\necho\r\n$2\r\nhi\r\nget a\n"))
+
+	for _, expected := range []string{
+		"*1\r\n$4\r\nPING\r\n",
+		"*2\r\n$4\r\necho\r\n$2\r\nhi\r\n",
+		"*2\r\n$3\r\nget\r\n$1\r\na\r\n",
+	} {
+		obj, err := r.ReadObject()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, obj.String())
+	}
+}
+
+func TestParseInvalidInline(t *testing.T) {
+	_, err := Parse(strings.NewReader("set k \"v\r\n"))
+	assert.True(t, errors.Is(err, ErrUnbalancedQuotes))
+
+	// the line is refused without being read as a whole
+	_, err = Parse(strings.NewReader("set k " + strings.Repeat("v", DefaultMaxInlineSize) + "\r\n"))
+	assert.True(t, errors.Is(err, ErrInlineTooLong))
+
+	_, err = Parse(strings.NewReader(strings.Repeat("v", 10*DefaultMaxInlineSize)))
+	assert.True(t, errors.Is(err, ErrInlineTooLong))
+
+	// the limit counts the line without the delimiter
+	obj, err := Parse(strings.NewReader("e " + strings.Repeat("v", DefaultMaxInlineSize-2) + "\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(obj.(RedisArray).Data()))
+}
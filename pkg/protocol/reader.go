@@ -3,15 +3,77 @@ package protocol
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 const (
 	delimiter byte = '\n'
+
+	// maxNestingDepth is the max depth of the nested aggregates, so a deep frame can not exhaust the stack
+	maxNestingDepth = 128
+)
+
+// The default limits of a request, which are the same as redis
+const (
+	DefaultMaxBulkLen      = 512 * 1024 * 1024
+	DefaultMaxMultibulkLen = 1024 * 1024
+	DefaultMaxInlineSize   = 64 * 1024
+)
+
+// ProtocolError is raised if the input is not a valid RESP frame or it exceeds the limits. The rest of the
+// input can not be framed after it, so the connection sending it should be closed.
+type ProtocolError struct {
+	reason string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.reason
+}
+
+var (
+	// ErrInvalidFrame will be raised if a frame is malformed
+	ErrInvalidFrame = &ProtocolError{reason: "invalid frame"}
+
+	// ErrInvalidBulkLength will be raised if the length of a string is invalid or exceeds MaxBulkLen
+	ErrInvalidBulkLength = &ProtocolError{reason: "invalid bulk length"}
+
+	// ErrInvalidMultibulkLength will be raised if the length of an aggregate is invalid or exceeds
+	// MaxMultibulkLen
+	ErrInvalidMultibulkLength = &ProtocolError{reason: "invalid multibulk length"}
+
+	// ErrLineTooLong will be raised if the line of a frame exceeds MaxInlineSize before its delimiter
+	ErrLineTooLong = &ProtocolError{reason: "too big count string"}
+
+	// ErrTooDeep will be raised if the aggregates are nested deeper than maxNestingDepth
+	ErrTooDeep = &ProtocolError{reason: "too deep nested aggregates"}
 )
 
+// Limits are the max sizes of the frames accepted by a RESPReader, a frame exceeding them is refused with
+// a ProtocolError. A limit is not checked if it is not positive.
+type Limits struct {
+	// MaxBulkLen is the max length of a string, i.e., proto-max-bulk-len of redis
+	MaxBulkLen int64
+
+	// MaxMultibulkLen is the max count of the elements of an aggregate
+	MaxMultibulkLen int64
+
+	// MaxInlineSize is the max length of an inline request and a line of a frame
+	MaxInlineSize int
+}
+
+// DefaultLimits returns the default limits
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBulkLen:      DefaultMaxBulkLen,
+		MaxMultibulkLen: DefaultMaxMultibulkLen,
+		MaxInlineSize:   DefaultMaxInlineSize,
+	}
+}
+
 // RESPReader interface allow reuse a io.Reader objects to avoid
 // object waste.
 type RESPReader interface {
@@ -21,13 +83,34 @@ type RESPReader interface {
 type respReader struct {
 	reader *bufio.Reader
 	token  string
+	limits Limits
+	depth  int
+}
+
+// readLine reads until '\n', the line is refused with errTooLong if it exceeds limit before the '\n'
+func (r *respReader) readLine(limit int, errTooLong error) (string, error) {
+	var buf bytes.Buffer
+
+	for {
+		line, err := r.reader.ReadSlice(delimiter)
+		if limit > 0 && buf.Len()+len(line) > limit+2 {
+			return "", fmt.Errorf("read line failed. limit=%d, err={%w}", limit, errTooLong)
+		}
+
+		buf.Write(line)
+		if err == nil {
+			return buf.String(), nil
+		} else if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", fmt.Errorf("read line failed. read=%d, err={%w}", buf.Len(), err)
+		}
+	}
 }
 
 func (r *respReader) readToken() error {
 	var buf bytes.Buffer
 
 	for {
-		cur, err := r.reader.ReadString(delimiter)
+		cur, err := r.readLine(r.limits.MaxInlineSize, ErrLineTooLong)
 
 		if err != nil {
 			return fmt.Errorf("read token failed. buf=%s, err={%w}", buf.String(), err)
@@ -36,8 +119,10 @@ func (r *respReader) readToken() error {
 		buf.WriteString(cur)
 		l := len(cur)
 
-		if l <= 1 {
-			return fmt.Errorf("read a single '\\n' or empty token. buf=%s", buf.String())
+		if r.limits.MaxInlineSize > 0 && buf.Len() > r.limits.MaxInlineSize+2 {
+			return fmt.Errorf("read token failed. limit=%d, err={%w}", r.limits.MaxInlineSize, ErrLineTooLong)
+		} else if l <= 1 {
+			return fmt.Errorf("read a single '\\n' or empty token. buf=%s, err={%w}", buf.String(), ErrInvalidFrame)
 		} else if cur[l-2] == '\r' {
 			// We may have abc\ndef\r\n, so we just try to check if we do meet an delimeter
 			break
@@ -48,27 +133,39 @@ func (r *respReader) readToken() error {
 	return nil
 }
 
-func (r *respReader) readBytes(count int) error {
+// readBytes reads count bytes, the buffer grows with the bytes read so a bogus length does not allocate
+// at once
+func (r *respReader) readBytes(count int64) error {
 	var buf bytes.Buffer
 
 	if count < 0 {
-		return fmt.Errorf("invalid count. count=%d", count)
+		return fmt.Errorf("invalid count. count=%d, err={%w}", count, ErrInvalidFrame)
 	}
 
-	for i := 0; i < count; i++ {
-		b, err := r.reader.ReadByte()
-
-		if err != nil {
-			return fmt.Errorf("read byte failed. buf=%s, err={%w}", buf.String(), err)
+	if _, err := io.CopyN(&buf, r.reader, count); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
 		}
-
-		buf.WriteByte(b)
+		return fmt.Errorf("read bytes failed. count=%d, read=%d, err={%w}", count, buf.Len(), err)
 	}
 
 	r.token = buf.String()
 	return nil
 }
 
+// readBulk reads a string of n bytes followed by the delimiter
+func (r *respReader) readBulk(n int64) (string, error) {
+	if err := r.readBytes(n + 2); err != nil {
+		return "", err
+	}
+
+	if !strings.HasSuffix(r.token, Delimiter) {
+		return "", fmt.Errorf("bulk string is not ended by the delimiter. length=%d, err={%w}", n, ErrInvalidFrame)
+	}
+
+	return r.token[:n], nil
+}
+
 func (r *respReader) peek() string {
 	if len(r.token) <= 0 {
 		return ""
@@ -87,21 +184,21 @@ func (r *respReader) readString() (RedisString, error) {
 		curToken := r.token
 		l := len(r.token)
 		sLen, err := strconv.ParseInt(curToken[1:l-2], 10, 64)
-		if err != nil {
-			return nil, fmt.Errorf("invalid bulk string length. token=%s, err={%w}", curToken, err)
+		if err != nil || sLen < -1 || (r.limits.MaxBulkLen > 0 && sLen > r.limits.MaxBulkLen) {
+			return nil, fmt.Errorf("invalid bulk string length. token=%s, err={%w}", curToken, ErrInvalidBulkLength)
 		}
 
 		// real string section
 		if sLen == -1 {
 			return NewNullBulkRedisString(), nil
-		} else if err := r.readBytes(int(sLen) + 2); err == nil {
-			return NewBulkRedisString(r.token[:sLen]), nil
+		} else if data, err := r.readBulk(sLen); err == nil {
+			return NewBulkRedisString(data), nil
 		} else {
-			return nil, fmt.Errorf("read real string failed. length=%d, token=%s, err={%w}", sLen, r.token, err)
+			return nil, fmt.Errorf("read real string failed. length=%d, token=%s, err={%w}", sLen, curToken, err)
 		}
 	}
 
-	return nil, fmt.Errorf("unknown string type. token=%s", r.token)
+	return nil, fmt.Errorf("unknown string type. token=%s, err={%w}", r.token, ErrInvalidFrame)
 }
 
 func (r *respReader) readError() (RedisError, error) {
@@ -114,7 +211,7 @@ func (r *respReader) readInteger() (RedisInteger, error) {
 	num, err := strconv.ParseInt(r.token[1:l-2], 10, 64)
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid integer. token=%s, err={%w}", r.token, ErrInvalidFrame)
 	}
 	return NewRedisInteger(num), nil
 }
@@ -124,8 +221,8 @@ func (r *respReader) readArray() (RedisArray, error) {
 	curToken := r.token
 	l := len(r.token)
 	aLen, err := strconv.ParseInt(curToken[1:l-2], 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid array length. token=%s, err={%w}", curToken, err)
+	if err != nil || aLen < -1 || (r.limits.MaxMultibulkLen > 0 && aLen > r.limits.MaxMultibulkLen) {
+		return nil, fmt.Errorf("invalid array length. token=%s, err={%w}", curToken, ErrInvalidMultibulkLength)
 	}
 
 	if aLen == -1 {
@@ -133,8 +230,13 @@ func (r *respReader) readArray() (RedisArray, error) {
 		return NewNullRedisArray(), nil
 	}
 
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
 	var objects []RedisObject
-	for i := 0; i < int(aLen); i++ {
+	for i := int64(0); i < aLen; i++ {
 		obj, err := r.readObject()
 		if err != nil {
 			return nil, fmt.Errorf("read array object failed. index=%d, err={%w}", i, err)
@@ -145,6 +247,132 @@ func (r *respReader) readArray() (RedisArray, error) {
 	return NewRedisArray(objects), nil
 }
 
+// readLength parses the length section of the current token, which is refused with errInvalid if it is
+// negative or exceeds limit
+func (r *respReader) readLength(limit int64, errInvalid error) (int64, error) {
+	l := len(r.token)
+	n, err := strconv.ParseInt(r.token[1:l-2], 10, 64)
+	if err != nil || n < 0 || (limit > 0 && n > limit) {
+		return 0, fmt.Errorf("invalid length. token=%s, err={%w}", r.token, errInvalid)
+	}
+
+	return n, nil
+}
+
+// enter goes into a nested aggregate
+func (r *respReader) enter() error {
+	if r.depth >= maxNestingDepth {
+		return fmt.Errorf("nested too deep. depth=%d, err={%w}", r.depth, ErrTooDeep)
+	}
+
+	r.depth++
+	return nil
+}
+
+func (r *respReader) leave() {
+	r.depth--
+}
+
+// readAggregate reads the elements of a RESP3 aggregate type, the maps and the attributes have two
+// elements for each entry
+func (r *respReader) readAggregate() (RedisObject, error) {
+	subType := r.peek()
+	n, err := r.readLength(r.limits.MaxMultibulkLen, ErrInvalidMultibulkLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if subType == MapType || subType == AttributeType {
+		n *= 2
+	}
+
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	var objects []RedisObject
+	for i := int64(0); i < n; i++ {
+		obj, err := r.readObject()
+		if err != nil {
+			return nil, fmt.Errorf("read aggregate object failed. type=%s, index=%d, err={%w}", subType, i, err)
+		}
+		objects = append(objects, obj)
+	}
+
+	switch subType {
+	case MapType:
+		return NewRedisMap(objects), nil
+	case SetType:
+		return NewRedisSet(objects), nil
+	case PushType:
+		return NewRedisPush(objects), nil
+	}
+
+	return NewRedisAttribute(objects), nil
+}
+
+func (r *respReader) readDouble() (RedisDouble, error) {
+	l := len(r.token)
+	f, err := strconv.ParseFloat(r.token[1:l-2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid double. token=%s, err={%w}", r.token, ErrInvalidFrame)
+	}
+
+	return NewRedisDouble(f), nil
+}
+
+func (r *respReader) readBoolean() (RedisBoolean, error) {
+	switch r.token {
+	case "#t\r\n":
+		return NewRedisBoolean(true), nil
+	case "#f\r\n":
+		return NewRedisBoolean(false), nil
+	}
+
+	return nil, fmt.Errorf("invalid boolean. token=%s, err={%w}", r.token, ErrInvalidFrame)
+}
+
+func (r *respReader) readNull() (RedisObject, error) {
+	if r.token != NullLiteral {
+		return nil, fmt.Errorf("invalid null. token=%s, err={%w}", r.token, ErrInvalidFrame)
+	}
+
+	return NewRedisNull(), nil
+}
+
+func (r *respReader) readBigNumber() (RedisBigNumber, error) {
+	l := len(r.token)
+	data := r.token[1 : l-2]
+
+	digits := strings.TrimPrefix(strings.TrimPrefix(data, "-"), "+")
+	if len(digits) == 0 || strings.TrimLeft(digits, "0123456789") != "" {
+		return nil, fmt.Errorf("invalid big number. token=%s, err={%w}", r.token, ErrInvalidFrame)
+	}
+
+	return NewRedisBigNumber(data), nil
+}
+
+func (r *respReader) readVerbatimString() (RedisVerbatimString, error) {
+	curToken := r.token
+	n, err := r.readLength(r.limits.MaxBulkLen, ErrInvalidBulkLength)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := r.readBulk(n)
+	if err != nil {
+		return nil, fmt.Errorf("read verbatim string failed. length=%d, token=%s, err={%w}", n, curToken, err)
+	}
+
+	// the format is exactly three bytes followed by a colon
+	if n < 4 || data[3] != ':' {
+		return nil, fmt.Errorf("invalid verbatim string. token=%s, err={%w}", curToken, ErrInvalidFrame)
+	}
+
+	return NewRedisVerbatimString(data[:3], data[4:]), nil
+}
+
 func (r *respReader) readObject() (RedisObject, error) {
 	if err := r.readToken(); err == nil {
 		switch r.peek() {
@@ -156,31 +384,103 @@ func (r *respReader) readObject() (RedisObject, error) {
 			return r.readInteger()
 		case ArrayType:
 			return r.readArray()
+		case MapType, SetType, PushType, AttributeType:
+			return r.readAggregate()
+		case DoubleType:
+			return r.readDouble()
+		case BooleanType:
+			return r.readBoolean()
+		case NullType:
+			return r.readNull()
+		case BigNumberType:
+			return r.readBigNumber()
+		case VerbatimStringType:
+			return r.readVerbatimString()
 		default:
-			return nil, fmt.Errorf("invalid token. token=%s", r.token)
+			return nil, fmt.Errorf("invalid token. token=%s, err={%w}", r.token, ErrInvalidFrame)
 		}
 	} else {
 		return nil, fmt.Errorf("peek front meet error. token=%s, err={%w}", r.token, err)
 	}
 }
 
-func (r *respReader) ReadObject() (RedisObject, error) {
+// readInline reads an inline request line, which is ended by '\n' with an optional '\r'. The empty lines
+// are skipped as redis does.
+func (r *respReader) readInline() (RedisArray, error) {
+	for {
+		line, err := r.readLine(r.limits.MaxInlineSize, ErrInlineTooLong)
+		if err != nil {
+			return nil, fmt.Errorf("read inline request failed. err={%w}", err)
+		}
+
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		request, err := NewInlineRequest(line)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(request.Data()) > 0 {
+			return request, nil
+		}
+	}
+}
+
+// isTyped checks if the next object starts with a type byte, otherwise it is an inline request
+func (r *respReader) isTyped() (bool, error) {
+	b, err := r.reader.Peek(1)
+	if err != nil {
+		return false, fmt.Errorf("peek front meet error. err={%w}", err)
+	}
+
+	switch string(b) {
+	case SimpleStringType, ErrorType, IntegerType, BulkStringType, ArrayType, MapType, SetType, DoubleType,
+		BooleanType, NullType, BigNumberType, VerbatimStringType, PushType, AttributeType:
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// readTopLevel reads a whole object, which may be an inline request
+func (r *respReader) readTopLevel() (RedisObject, error) {
+	typed, err := r.isTyped()
+	if err != nil {
+		return nil, err
+	} else if !typed {
+		return r.readInline()
+	}
+
 	return r.readObject()
 }
 
-// Parse takes an io.Reader and try to parse a RedisObject from it.
+func (r *respReader) ReadObject() (RedisObject, error) {
+	return r.readTopLevel()
+}
+
+// Parse takes an io.Reader and try to parse a RedisObject from it. A line which does not start with a
+// type byte is parsed as an inline request, e.g., "PING\r\n" results in the same RedisArray as
+// "*1\r\n$4\r\nPING\r\n".
 //
-// If any error raised, a wrapped ErrInvalidRESP error will be returned
+// The default limits are applied. If the input is malformed or exceeds the limits, an error wrapping a
+// ProtocolError will be returned.
 func Parse(reader io.Reader) (RedisObject, error) {
 	r := &respReader{
 		reader: bufio.NewReader(reader),
+		limits: DefaultLimits(),
 	}
 
-	return r.readObject()
+	return r.readTopLevel()
 }
 
+// NewRESPReader equals NewRESPReaderWithLimits(reader, DefaultLimits())
 func NewRESPReader(reader io.Reader) RESPReader {
+	return NewRESPReaderWithLimits(reader, DefaultLimits())
+}
+
+// NewRESPReaderWithLimits returns a RESPReader which refuses the frames exceeding the limits
+func NewRESPReaderWithLimits(reader io.Reader, limits Limits) RESPReader {
 	return &respReader{
 		reader: bufio.NewReader(reader),
+		limits: limits,
 	}
 }
@@ -1,6 +1,11 @@
 package protocol_test
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -45,3 +50,78 @@ func testSimpleParse(t *testing.T, raw string) bool {
 
 	return assert.Nil(t, err) && assert.Equal(t, raw, obj.String())
 }
+
+func TestParseLimits(t *testing.T) {
+	limits := Limits{MaxBulkLen: 4, MaxMultibulkLen: 2, MaxInlineSize: 16}
+	testCases := map[string]error{
+		"$5\r\nhello\r\n":                         ErrInvalidBulkLength,
+		"=9\r\ntxt:hello\r\n":                     ErrInvalidBulkLength,
+		"*3\r\n:1\r\n:2\r\n:3\r\n":                ErrInvalidMultibulkLength,
+		"%2\r\n:1\r\n:2\r\n:3\r\n:4\r\n":          nil,
+		"~3\r\n:1\r\n:2\r\n:3\r\n":                ErrInvalidMultibulkLength,
+		"+" + strings.Repeat("a", 20) + "\r\n":    ErrLineTooLong,
+		"get " + strings.Repeat("k", 20) + "\r\n": ErrInlineTooLong,
+		"$4\r\nabcd\r\n":                          nil,
+		"*2\r\n$4\r\nabcd\r\n$-1\r\n":             nil,
+		"get " + strings.Repeat("k", 12) + "\r\n": nil,
+	}
+
+	for raw, expected := range testCases {
+		_, err := NewRESPReaderWithLimits(strings.NewReader(raw), limits).ReadObject()
+		if expected == nil {
+			assert.Nil(t, err, "raw=%q", raw)
+		} else {
+			assert.True(t, errors.Is(err, expected), "raw=%q, err=%v", raw, err)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	testCases := map[string]error{
+		"*2147483647\r\n":             ErrInvalidMultibulkLength,
+		"*-2\r\n":                     ErrInvalidMultibulkLength,
+		"*x\r\n":                      ErrInvalidMultibulkLength,
+		"$2147483647\r\nabc":          ErrInvalidBulkLength,
+		"$-5\r\n":                     ErrInvalidBulkLength,
+		"$3\r\nabcde":                 ErrInvalidFrame,
+		":12a\r\n":                    ErrInvalidFrame,
+		"*1\r\n!1\r\n":                ErrInvalidFrame,
+		strings.Repeat("*1\r\n", 200): ErrTooDeep,
+		"*1\r\n" + strings.Repeat("a", DefaultMaxInlineSize+10): ErrLineTooLong,
+	}
+
+	for raw, expected := range testCases {
+		_, err := Parse(strings.NewReader(raw))
+		assert.True(t, errors.Is(err, expected), "raw=%q, err=%v", raw, err)
+
+		var protocolErr *ProtocolError
+		assert.True(t, errors.As(err, &protocolErr))
+	}
+
+	// a truncated frame is not a protocol error, the rest may still come
+	_, err := Parse(strings.NewReader("$5\r\nhel"))
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
+
+// TestFuzzCorpus checks the seed corpus of the fuzz target is parsed without an unexpected error
+func TestFuzzCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/fuzz/corpus/*")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		assert.Nil(t, err)
+
+		obj, err := Parse(bytes.NewReader(data))
+		var protocolErr *ProtocolError
+		if err != nil {
+			assert.True(t, errors.As(err, &protocolErr) || errors.Is(err, io.ErrUnexpectedEOF), "file=%s, err=%v", file, err)
+			continue
+		}
+
+		again, err := Parse(bytes.NewReader(obj.Byte()))
+		assert.Nil(t, err, "file=%s", file)
+		assert.Equal(t, obj.String(), again.String(), "file=%s", file)
+	}
+}
@@ -0,0 +1,396 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// The RESP3 types, see https://github.com/redis/redis-specifications/blob/master/protocol/RESP3.md
+const (
+	MapType            = "%"
+	SetType            = "~"
+	DoubleType         = ","
+	BooleanType        = "#"
+	NullType           = "_"
+	BigNumberType      = "("
+	VerbatimStringType = "="
+	PushType           = ">"
+	AttributeType      = "|"
+
+	NullLiteral = "_\r\n"
+)
+
+// The protocol versions negotiated by HELLO
+const (
+	Resp2 = 2
+	Resp3 = 3
+)
+
+// RedisMap is the RESP3 map interface.
+type RedisMap interface {
+	RedisObject
+
+	// Data returns the keys and values in turn, i.e., k1, v1, k2, v2...
+	Data() []RedisObject
+}
+
+// RedisSet is the RESP3 set interface.
+type RedisSet interface {
+	RedisObject
+
+	// Data returns the members of the set
+	Data() []RedisObject
+}
+
+// RedisDouble is the RESP3 double interface.
+type RedisDouble interface {
+	RedisObject
+
+	// Data returns the float, which may be an infinity or NaN
+	Data() float64
+}
+
+// RedisBoolean is the RESP3 boolean interface.
+type RedisBoolean interface {
+	RedisObject
+
+	// Data returns the boolean
+	Data() bool
+}
+
+// RedisBigNumber is the RESP3 big number interface.
+type RedisBigNumber interface {
+	RedisObject
+
+	// Data returns the decimal representation of the number
+	Data() string
+}
+
+// RedisVerbatimString is the RESP3 verbatim string interface.
+type RedisVerbatimString interface {
+	RedisObject
+
+	// Format returns the three bytes format of the string, e.g., `txt` or `mkd`
+	Format() string
+
+	// Data returns the string without the format
+	Data() string
+}
+
+// RedisPush is the RESP3 push interface, which is sent out of the request-response order.
+type RedisPush interface {
+	RedisObject
+
+	// Data returns the elements of the push, the first is the kind of it
+	Data() []RedisObject
+}
+
+// RedisAttribute is the RESP3 attribute interface, which is an auxiliary map sent before a reply.
+type RedisAttribute interface {
+	RedisObject
+
+	// Data returns the keys and values in turn, i.e., k1, v1, k2, v2...
+	Data() []RedisObject
+}
+
+// FormatDouble formats a float in the shortest representation which parses back to the same value, as
+// redis replies the scores. The exponent form is only used for very large or small values.
+func FormatDouble(f float64) string {
+	if math.IsInf(f, 1) {
+		return "inf"
+	} else if math.IsInf(f, -1) {
+		return "-inf"
+	} else if math.IsNaN(f) {
+		return "nan"
+	}
+
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// aggregate is the shared implementation of the RESP3 aggregate types
+type aggregate struct {
+	data      []RedisObject
+	subType   string
+	stringRep string
+	byteRep   []byte
+}
+
+func newAggregate(subType string, count int, data []RedisObject) *aggregate {
+	var buf bytes.Buffer
+
+	a := &aggregate{
+		data:    data,
+		subType: subType,
+	}
+
+	buf.WriteString(fmt.Sprintf("%s%d%s", subType, count, Delimiter))
+	for _, obj := range data {
+		buf.Write(obj.Byte())
+	}
+
+	a.byteRep = buf.Bytes()
+	a.stringRep = buf.String()
+
+	return a
+}
+
+func (a *aggregate) Byte() []byte {
+	return a.byteRep
+}
+
+func (a *aggregate) String() string {
+	return a.stringRep
+}
+
+func (a *aggregate) Type() string {
+	return a.subType
+}
+
+func (a *aggregate) Data() []RedisObject {
+	return a.data
+}
+
+// NewRedisMap takes the keys and values in turn, return a new RedisMap instance
+func NewRedisMap(data []RedisObject) RedisMap {
+	return newAggregate(MapType, len(data)/2, data)
+}
+
+// NewRedisSet takes the members, return a new RedisSet instance
+func NewRedisSet(data []RedisObject) RedisSet {
+	return newAggregate(SetType, len(data), data)
+}
+
+// NewRedisPush takes the elements, return a new RedisPush instance
+func NewRedisPush(data []RedisObject) RedisPush {
+	return newAggregate(PushType, len(data), data)
+}
+
+// NewRedisAttribute takes the keys and values in turn, return a new RedisAttribute instance
+func NewRedisAttribute(data []RedisObject) RedisAttribute {
+	return newAggregate(AttributeType, len(data)/2, data)
+}
+
+// scalar is the shared implementation of the RESP3 simple types
+type scalar struct {
+	subType   string
+	stringRep string
+	byteRep   []byte
+}
+
+func newScalar(subType string, s string) scalar {
+	return scalar{
+		subType:   subType,
+		stringRep: s,
+		byteRep:   []byte(s),
+	}
+}
+
+func (s *scalar) Byte() []byte {
+	return s.byteRep
+}
+
+func (s *scalar) String() string {
+	return s.stringRep
+}
+
+func (s *scalar) Type() string {
+	return s.subType
+}
+
+type redisDouble struct {
+	scalar
+	data float64
+}
+
+// NewRedisDouble takes a float64, return a new RedisDouble instance
+func NewRedisDouble(data float64) RedisDouble {
+	return &redisDouble{
+		scalar: newScalar(DoubleType, fmt.Sprintf("%s%s%s", DoubleType, FormatDouble(data), Delimiter)),
+		data:   data,
+	}
+}
+
+func (rd *redisDouble) Data() float64 {
+	return rd.data
+}
+
+type redisBoolean struct {
+	scalar
+	data bool
+}
+
+// NewRedisBoolean takes a bool, return a new RedisBoolean instance
+func NewRedisBoolean(data bool) RedisBoolean {
+	s := "#f\r\n"
+	if data {
+		s = "#t\r\n"
+	}
+
+	return &redisBoolean{
+		scalar: newScalar(BooleanType, s),
+		data:   data,
+	}
+}
+
+func (rb *redisBoolean) Data() bool {
+	return rb.data
+}
+
+type redisNull struct {
+	scalar
+}
+
+// NewRedisNull returns the RESP3 null, i.e., "_\r\n"
+func NewRedisNull() RedisObject {
+	return &redisNull{
+		scalar: newScalar(NullType, NullLiteral),
+	}
+}
+
+type redisBigNumber struct {
+	scalar
+	data string
+}
+
+// NewRedisBigNumber takes the decimal representation of a number, return a new RedisBigNumber instance
+func NewRedisBigNumber(data string) RedisBigNumber {
+	return &redisBigNumber{
+		scalar: newScalar(BigNumberType, fmt.Sprintf("%s%s%s", BigNumberType, data, Delimiter)),
+		data:   data,
+	}
+}
+
+func (rb *redisBigNumber) Data() string {
+	return rb.data
+}
+
+type redisVerbatimString struct {
+	scalar
+	format string
+	data   string
+}
+
+// NewRedisVerbatimString takes the three bytes format and the string, return a new RedisVerbatimString
+// instance
+func NewRedisVerbatimString(format string, data string) RedisVerbatimString {
+	return &redisVerbatimString{
+		scalar: newScalar(VerbatimStringType, fmt.Sprintf("%s%d%s%s:%s%s", VerbatimStringType, len(format)+1+len(data), Delimiter, format, data, Delimiter)),
+		format: format,
+		data:   data,
+	}
+}
+
+func (rv *redisVerbatimString) Format() string {
+	return rv.format
+}
+
+func (rv *redisVerbatimString) Data() string {
+	return rv.data
+}
+
+// ConvertTo returns the object in the encoding of the protocol version. For RESP2, the RESP3 types are
+// replaced by their RESP2 forms as redis does, e.g., a map becomes a flat array and a double becomes a
+// bulk string, and the attributes are dropped. For RESP3, the RESP2 nulls become the RESP3 null. The
+// object itself is returned if nothing is changed, and nil is returned if the object is dropped.
+func ConvertTo(obj RedisObject, version int) RedisObject {
+	ret, _ := convert(obj, version)
+	return ret
+}
+
+func convert(obj RedisObject, version int) (RedisObject, bool) {
+	switch obj.Type() {
+	case StreamType:
+		ret, _ := convert(obj.(RedisStream).Build(), version)
+		return ret, true
+	case BulkStringType:
+		if version == Resp3 && obj.String() == NullBulkStringLiteral {
+			return NewRedisNull(), true
+		}
+	case ArrayType:
+		if obj.String() == NullArrayLiteral {
+			if version == Resp3 {
+				return NewRedisNull(), true
+			}
+			return obj, false
+		}
+
+		data, changed := convertAll(obj.(RedisArray).Data(), version)
+		if changed {
+			return NewRedisArray(data), true
+		}
+	case MapType, SetType, PushType, AttributeType:
+		data, changed := convertAll(obj.(interface{ Data() []RedisObject }).Data(), version)
+		if version == Resp3 {
+			if changed {
+				count := len(data)
+				if obj.Type() == MapType || obj.Type() == AttributeType {
+					count /= 2
+				}
+				return newAggregate(obj.Type(), count, data), true
+			}
+		} else if obj.Type() == AttributeType {
+			return nil, true
+		} else {
+			return NewRedisArray(data), true
+		}
+	case DoubleType:
+		if version == Resp2 {
+			return NewBulkRedisString(FormatDouble(obj.(RedisDouble).Data())), true
+		}
+	case BooleanType:
+		if version == Resp2 {
+			if obj.(RedisBoolean).Data() {
+				return NewRedisInteger(1), true
+			}
+			return NewRedisInteger(0), true
+		}
+	case NullType:
+		if version == Resp2 {
+			return NewNullBulkRedisString(), true
+		}
+	case BigNumberType:
+		if version == Resp2 {
+			return NewBulkRedisString(obj.(RedisBigNumber).Data()), true
+		}
+	case VerbatimStringType:
+		if version == Resp2 {
+			return NewBulkRedisString(obj.(RedisVerbatimString).Data()), true
+		}
+	}
+
+	return obj, false
+}
+
+// convertAll converts the elements, the dropped ones are removed
+func convertAll(objs []RedisObject, version int) ([]RedisObject, bool) {
+	var ret []RedisObject
+	changed := false
+
+	for idx, obj := range objs {
+		converted, ok := convert(obj, version)
+		if ok && !changed {
+			changed = true
+			ret = append([]RedisObject{}, objs[:idx]...)
+		}
+
+		if changed && converted != nil {
+			ret = append(ret, converted)
+		}
+	}
+
+	if !changed {
+		return objs, false
+	}
+
+	if ret == nil {
+		ret = []RedisObject{}
+	}
+
+	return ret, true
+}
@@ -0,0 +1,137 @@
+package protocol_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMap(t *testing.T) {
+	obj := NewRedisMap([]RedisObject{
+		NewBulkRedisString("first"),
+		NewRedisInteger(1),
+		NewBulkRedisString("second"),
+		NewRedisInteger(2),
+	})
+
+	assert.Equal(t, "%2\r\n$5\r\nfirst\r\n:1\r\n$6\r\nsecond\r\n:2\r\n", obj.String())
+}
+
+func TestSet(t *testing.T) {
+	obj := NewRedisSet([]RedisObject{NewBulkRedisString("a"), NewBulkRedisString("b")})
+
+	assert.Equal(t, "~2\r\n$1\r\na\r\n$1\r\nb\r\n", obj.String())
+}
+
+func TestDouble(t *testing.T) {
+	assert.Equal(t, ",1.5\r\n", NewRedisDouble(1.5).String())
+	assert.Equal(t, ",10\r\n", NewRedisDouble(10).String())
+	assert.Equal(t, ",inf\r\n", NewRedisDouble(math.Inf(1)).String())
+	assert.Equal(t, ",-inf\r\n", NewRedisDouble(math.Inf(-1)).String())
+	assert.Equal(t, ",nan\r\n", NewRedisDouble(math.NaN()).String())
+}
+
+func TestBooleanAndNull(t *testing.T) {
+	assert.Equal(t, "#t\r\n", NewRedisBoolean(true).String())
+	assert.Equal(t, "#f\r\n", NewRedisBoolean(false).String())
+	assert.Equal(t, "_\r\n", NewRedisNull().String())
+}
+
+func TestBigNumber(t *testing.T) {
+	obj := NewRedisBigNumber("3492890328409238509324850943850943825024385")
+
+	assert.Equal(t, "(3492890328409238509324850943850943825024385\r\n", obj.String())
+}
+
+func TestVerbatimString(t *testing.T) {
+	obj := NewRedisVerbatimString("txt", "Some string")
+
+	assert.Equal(t, "=15\r\ntxt:Some string\r\n", obj.String())
+}
+
+func TestPushAndAttribute(t *testing.T) {
+	push := NewRedisPush([]RedisObject{NewBulkRedisString("message"), NewBulkRedisString("ch"), NewBulkRedisString("hi")})
+	attribute := NewRedisAttribute([]RedisObject{NewSimpleRedisString("ttl"), NewRedisInteger(3)})
+
+	assert.Equal(t, ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n", push.String())
+	assert.Equal(t, "|1\r\n+ttl\r\n:3\r\n", attribute.String())
+}
+
+func TestParseResp3(t *testing.T) {
+	testCases := []string{
+		"%2\r\n$5\r\nfirst\r\n:1\r\n$6\r\nsecond\r\n:2\r\n", // Map
+		"%0\r\n",                       // EmptyMap
+		"~2\r\n$1\r\na\r\n$1\r\nb\r\n", // Set
+		",1.5\r\n",                     // Double
+		",-inf\r\n",                    // Negative infinity
+		"#t\r\n",                       // True
+		"#f\r\n",                       // False
+		"_\r\n",                        // Null
+		"(-3492890328409238509324850943850943825024385\r\n", // BigNumber
+		"=15\r\ntxt:Some string\r\n",                        // VerbatimString
+		">2\r\n$7\r\nmessage\r\n_\r\n",                      // Push
+		"|1\r\n+ttl\r\n:3\r\n",                              // Attribute
+		// Nested aggregates
+		"*2\r\n%1\r\n+k\r\n~1\r\n,0.5\r\n#f\r\n",
+	}
+
+	for idx, testCase := range testCases {
+		if !testSimpleParse(t, testCase) {
+			t.Fatalf("test %d failed. case=%s", idx, testCase)
+		}
+	}
+}
+
+func TestParseInvalidResp3(t *testing.T) {
+	testCases := []string{
+		",one\r\n",        // Double
+		"#x\r\n",          // Boolean
+		"_x\r\n",          // Null
+		"(12a\r\n",        // BigNumber
+		"=5\r\ntxtab\r\n", // Verbatim string without the colon
+		"%-1\r\n",         // Negative length
+		"~2\r\n:1\r\n",    // Short set
+	}
+
+	for idx, testCase := range testCases {
+		_, err := Parse(strings.NewReader(testCase))
+		assert.NotNil(t, err, "test %d, case=%q", idx, testCase)
+	}
+}
+
+func TestConvertToResp2(t *testing.T) {
+	obj := NewRedisArray([]RedisObject{
+		NewRedisMap([]RedisObject{NewBulkRedisString("k"), NewRedisDouble(2.5)}),
+		NewRedisSet([]RedisObject{NewBulkRedisString("a")}),
+		NewRedisBoolean(true),
+		NewRedisNull(),
+		NewRedisBigNumber("12345678901234567890"),
+		NewRedisVerbatimString("txt", "hi"),
+		NewRedisAttribute([]RedisObject{NewSimpleRedisString("ttl"), NewRedisInteger(3)}),
+		NewRedisPush([]RedisObject{NewBulkRedisString("message")}),
+	})
+
+	assert.Equal(t, "*7\r\n*2\r\n$1\r\nk\r\n$3\r\n2.5\r\n*1\r\n$1\r\na\r\n:1\r\n$-1\r\n$20\r\n12345678901234567890\r\n$2\r\nhi\r\n*1\r\n$7\r\nmessage\r\n", ConvertTo(obj, Resp2).String())
+	assert.Nil(t, ConvertTo(NewRedisAttribute(nil), Resp2))
+
+	// the RESP2 objects are returned as is
+	plain := NewRedisArray([]RedisObject{NewBulkRedisString("a"), NewNullBulkRedisString()})
+	assert.True(t, plain == ConvertTo(plain, Resp2))
+}
+
+func TestConvertToResp3(t *testing.T) {
+	obj := NewRedisArray([]RedisObject{
+		NewNullBulkRedisString(),
+		NewNullRedisArray(),
+		NewRedisMap([]RedisObject{NewBulkRedisString("k"), NewNullBulkRedisString()}),
+		NewRedisDouble(1),
+	})
+
+	assert.Equal(t, "*4\r\n_\r\n_\r\n%1\r\n$1\r\nk\r\n_\r\n,1\r\n", ConvertTo(obj, Resp3).String())
+
+	native := NewRedisMap([]RedisObject{NewBulkRedisString("k"), NewRedisDouble(1)})
+	assert.True(t, native == ConvertTo(native, Resp3))
+}
@@ -0,0 +1,326 @@
+package protocol
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// StreamType is the type of a RedisStream, which is not a type on the wire
+const StreamType = "stream"
+
+// RESPWriter writes the objects into a buffered io.Writer in its protocol version. The objects are
+// converted while they are written, e.g., a map is written as a flat array for RESP2, so nothing is built
+// for the conversion. The aggregates can be written piece by piece, i.e., a header and then its elements
+// one at a time. Nothing reaches the underlying writer before Flush if the buffer is not full.
+type RESPWriter interface {
+	// SetVersion sets the protocol version, which is RESP2 by default
+	SetVersion(int)
+
+	WriteObject(RedisObject) error
+
+	WriteSimpleString(string) error
+	WriteError(string) error
+	WriteInteger(int64) error
+	WriteBulkString(string) error
+	WriteDouble(float64) error
+
+	// WriteNull writes the null, i.e., the null bulk string for RESP2
+	WriteNull() error
+
+	// WriteNullArray writes the null array, i.e., the null for RESP3
+	WriteNullArray() error
+
+	// WriteArrayHeader writes the header of an array of n elements, which should be written next
+	WriteArrayHeader(int) error
+
+	// WriteMapHeader writes the header of a map of n entries, i.e., an array of 2n elements for RESP2. The
+	// keys and values should be written next in turn.
+	WriteMapHeader(int) error
+
+	// WriteSetHeader writes the header of a set of n members, i.e., an array for RESP2
+	WriteSetHeader(int) error
+
+	// Flush writes the buffered bytes to the underlying writer
+	Flush() error
+}
+
+// RedisStream is a reply written straight into a RESPWriter, e.g., the elements of a large list, so the
+// reply is never built as a whole. It reads the containers when it is written, so it must be written right
+// after the command is executed with the containers unchanged. Build builds the reply if it has to be held,
+// e.g., in the replies of EXEC, and String, Byte and Type are the ones of the built reply except that Type
+// returns StreamType.
+type RedisStream interface {
+	RedisObject
+
+	WriteTo(RESPWriter) error
+	Build() RedisObject
+}
+
+type respWriter struct {
+	writer  *bufio.Writer
+	version int
+	scratch [32]byte
+}
+
+// NewRESPWriter returns a RESPWriter writes into the writer in RESP2, the writer is buffered if it is not
+// a *bufio.Writer
+func NewRESPWriter(writer io.Writer) RESPWriter {
+	w, ok := writer.(*bufio.Writer)
+	if !ok {
+		w = bufio.NewWriter(writer)
+	}
+
+	return &respWriter{
+		writer:  w,
+		version: Resp2,
+	}
+}
+
+func (w *respWriter) SetVersion(version int) {
+	w.version = version
+}
+
+// writeLine writes the type, the line and the delimiter
+func (w *respWriter) writeLine(t byte, line string) error {
+	_ = w.writer.WriteByte(t)
+	_, _ = w.writer.WriteString(line)
+	_, err := w.writer.WriteString(Delimiter)
+	return err
+}
+
+// writeNumber writes the type, the number and the delimiter without an allocation
+func (w *respWriter) writeNumber(t byte, n int64) error {
+	buf := append(w.scratch[:0], t)
+	buf = strconv.AppendInt(buf, n, 10)
+	buf = append(buf, Delimiter...)
+
+	_, err := w.writer.Write(buf)
+	return err
+}
+
+func (w *respWriter) WriteSimpleString(s string) error {
+	return w.writeLine(SimpleStringType[0], s)
+}
+
+func (w *respWriter) WriteError(s string) error {
+	return w.writeLine(ErrorType[0], s)
+}
+
+func (w *respWriter) WriteInteger(n int64) error {
+	return w.writeNumber(IntegerType[0], n)
+}
+
+func (w *respWriter) WriteBulkString(s string) error {
+	_ = w.writeNumber(BulkStringType[0], int64(len(s)))
+	_, _ = w.writer.WriteString(s)
+	_, err := w.writer.WriteString(Delimiter)
+	return err
+}
+
+func (w *respWriter) WriteDouble(f float64) error {
+	if w.version == Resp2 {
+		return w.WriteBulkString(FormatDouble(f))
+	}
+
+	return w.writeLine(DoubleType[0], FormatDouble(f))
+}
+
+func (w *respWriter) WriteNull() error {
+	if w.version == Resp3 {
+		_, err := w.writer.WriteString(NullLiteral)
+		return err
+	}
+
+	_, err := w.writer.WriteString(NullBulkStringLiteral)
+	return err
+}
+
+func (w *respWriter) WriteNullArray() error {
+	if w.version == Resp3 {
+		_, err := w.writer.WriteString(NullLiteral)
+		return err
+	}
+
+	_, err := w.writer.WriteString(NullArrayLiteral)
+	return err
+}
+
+func (w *respWriter) WriteArrayHeader(n int) error {
+	return w.writeNumber(ArrayType[0], int64(n))
+}
+
+func (w *respWriter) WriteMapHeader(n int) error {
+	if w.version == Resp2 {
+		return w.writeNumber(ArrayType[0], int64(2*n))
+	}
+
+	return w.writeNumber(MapType[0], int64(n))
+}
+
+func (w *respWriter) WriteSetHeader(n int) error {
+	if w.version == Resp2 {
+		return w.writeNumber(ArrayType[0], int64(n))
+	}
+
+	return w.writeNumber(SetType[0], int64(n))
+}
+
+// WriteObject writes the object in the version of the writer as ConvertTo does. The encoding of the object
+// is written as is if it is the same in the version.
+func (w *respWriter) WriteObject(obj RedisObject) error {
+	if obj.Type() == StreamType {
+		return obj.(RedisStream).WriteTo(w)
+	} else if !needsConversion(obj, w.version) {
+		_, err := w.writer.Write(obj.Byte())
+		return err
+	}
+
+	switch obj.Type() {
+	case BulkStringType:
+		return w.WriteNull()
+	case ArrayType:
+		if obj.String() == NullArrayLiteral {
+			return w.WriteNullArray()
+		}
+
+		data := obj.(RedisArray).Data()
+		_ = w.WriteArrayHeader(len(data))
+		return w.writeAll(data)
+	case MapType:
+		data := obj.(RedisMap).Data()
+		_ = w.WriteMapHeader(len(data) / 2)
+		return w.writeAll(data)
+	case SetType:
+		data := obj.(RedisSet).Data()
+		_ = w.WriteSetHeader(len(data))
+		return w.writeAll(data)
+	case PushType:
+		data := obj.(RedisPush).Data()
+		if w.version == Resp2 {
+			_ = w.WriteArrayHeader(len(data))
+		} else {
+			_ = w.writeNumber(PushType[0], int64(len(data)))
+		}
+		return w.writeAll(data)
+	case AttributeType:
+		// the attributes are dropped for RESP2
+		if w.version == Resp2 {
+			return nil
+		}
+
+		data := obj.(RedisAttribute).Data()
+		_ = w.writeNumber(AttributeType[0], int64(len(data)/2))
+		return w.writeAll(data)
+	case DoubleType:
+		return w.WriteDouble(obj.(RedisDouble).Data())
+	case BooleanType:
+		if obj.(RedisBoolean).Data() {
+			return w.WriteInteger(1)
+		}
+		return w.WriteInteger(0)
+	case NullType:
+		return w.WriteNull()
+	case BigNumberType:
+		return w.WriteBulkString(obj.(RedisBigNumber).Data())
+	case VerbatimStringType:
+		return w.WriteBulkString(obj.(RedisVerbatimString).Data())
+	}
+
+	_, err := w.writer.Write(obj.Byte())
+	return err
+}
+
+// needsConversion checks if the encoding of the object is different in the version without building
+// anything
+func needsConversion(obj RedisObject, version int) bool {
+	switch obj.Type() {
+	case StreamType:
+		return true
+	case BulkStringType:
+		return version == Resp3 && obj.String() == NullBulkStringLiteral
+	case ArrayType:
+		if obj.String() == NullArrayLiteral {
+			return version == Resp3
+		}
+		return anyNeedsConversion(obj.(RedisArray).Data(), version)
+	case MapType, SetType, PushType, AttributeType:
+		return version == Resp2 || anyNeedsConversion(obj.(interface{ Data() []RedisObject }).Data(), version)
+	case DoubleType, BooleanType, NullType, BigNumberType, VerbatimStringType:
+		return version == Resp2
+	}
+
+	return false
+}
+
+func anyNeedsConversion(objs []RedisObject, version int) bool {
+	for _, obj := range objs {
+		if needsConversion(obj, version) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *respWriter) writeAll(objs []RedisObject) error {
+	for _, obj := range objs {
+		if err := w.WriteObject(obj); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *respWriter) Flush() error {
+	if err := w.writer.Flush(); err != nil {
+		return fmt.Errorf("flush resp writer failed. err={%w}", err)
+	}
+
+	return nil
+}
+
+type redisStream struct {
+	write func(RESPWriter) error
+	build func() RedisObject
+	built RedisObject
+}
+
+// NewRedisStream takes the function writing the reply into a RESPWriter and the one building it, return a
+// new RedisStream instance
+func NewRedisStream(write func(RESPWriter) error, build func() RedisObject) RedisStream {
+	return &redisStream{
+		write: write,
+		build: build,
+	}
+}
+
+func (rs *redisStream) WriteTo(w RESPWriter) error {
+	if rs.built != nil {
+		return w.WriteObject(rs.built)
+	}
+
+	return rs.write(w)
+}
+
+func (rs *redisStream) Build() RedisObject {
+	if rs.built == nil {
+		rs.built = rs.build()
+	}
+
+	return rs.built
+}
+
+func (rs *redisStream) Byte() []byte {
+	return rs.Build().Byte()
+}
+
+func (rs *redisStream) String() string {
+	return rs.Build().String()
+}
+
+func (rs *redisStream) Type() string {
+	return StreamType
+}
@@ -0,0 +1,196 @@
+package protocol_test
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/lxdlam/vertex/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeObject(t *testing.T, obj RedisObject, version int) string {
+	var buf bytes.Buffer
+
+	w := NewRESPWriter(&buf)
+	w.SetVersion(version)
+	assert.Nil(t, w.WriteObject(obj))
+	assert.Nil(t, w.Flush())
+
+	return buf.String()
+}
+
+func TestWriteObject(t *testing.T) {
+	testCases := []RedisObject{
+		NewSimpleRedisString("OK"),
+		NewRedisError("ERR message"),
+		NewRedisInteger(-10),
+		NewBulkRedisString("hello"),
+		NewNullBulkRedisString(),
+		NewNullRedisArray(),
+		NewRedisArray([]RedisObject{NewBulkRedisString("a"), NewNullBulkRedisString(), NewRedisInteger(1)}),
+		NewRedisMap([]RedisObject{NewBulkRedisString("k"), NewRedisDouble(2.5)}),
+		NewRedisSet([]RedisObject{NewBulkRedisString("a")}),
+		NewRedisPush([]RedisObject{NewBulkRedisString("message"), NewBulkRedisString("ch")}),
+		NewRedisAttribute([]RedisObject{NewSimpleRedisString("ttl"), NewRedisInteger(3)}),
+		NewRedisDouble(1.5),
+		NewRedisBoolean(true),
+		NewRedisBoolean(false),
+		NewRedisNull(),
+		NewRedisBigNumber("12345678901234567890"),
+		NewRedisVerbatimString("txt", "hi"),
+		// Nested
+		NewRedisArray([]RedisObject{
+			NewRedisMap([]RedisObject{NewBulkRedisString("k"), NewRedisSet([]RedisObject{NewRedisNull()})}),
+			NewRedisArray([]RedisObject{NewRedisBoolean(true)}),
+		}),
+	}
+
+	for idx, obj := range testCases {
+		for _, version := range []int{Resp2, Resp3} {
+			expected := ""
+			if converted := ConvertTo(obj, version); converted != nil {
+				expected = converted.String()
+			}
+
+			assert.Equal(t, expected, writeObject(t, obj, version), "test %d, version=%d, obj=%q", idx, version, obj.String())
+		}
+	}
+}
+
+func TestWriteHeaders(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewRESPWriter(&buf)
+
+	for _, version := range []int{Resp2, Resp3} {
+		w.SetVersion(version)
+		assert.Nil(t, w.WriteMapHeader(1))
+		assert.Nil(t, w.WriteBulkString("k"))
+		assert.Nil(t, w.WriteSetHeader(2))
+		assert.Nil(t, w.WriteDouble(0.5))
+		assert.Nil(t, w.WriteNull())
+		assert.Nil(t, w.WriteNullArray())
+	}
+
+	assert.Nil(t, w.WriteArrayHeader(2))
+	assert.Nil(t, w.WriteSimpleString("OK"))
+	assert.Nil(t, w.WriteError("ERR e"))
+	assert.Nil(t, w.WriteInteger(-1))
+
+	assert.Equal(t, "", buf.String(), "nothing is written before flush")
+	assert.Nil(t, w.Flush())
+	assert.Equal(t,
+		"*2\r\n$1\r\nk\r\n*2\r\n$3\r\n0.5\r\n$-1\r\n*-1\r\n"+
+			"%1\r\n$1\r\nk\r\n~2\r\n,0.5\r\n_\r\n_\r\n"+
+			"*2\r\n+OK\r\n-ERR e\r\n:-1\r\n", buf.String())
+}
+
+func TestRedisStream(t *testing.T) {
+	items := []string{"a", "b", "c"}
+	built := 0
+
+	stream := NewRedisStream(func(w RESPWriter) error {
+		_ = w.WriteSetHeader(len(items))
+		for _, item := range items {
+			_ = w.WriteBulkString(item)
+		}
+		return nil
+	}, func() RedisObject {
+		built++
+
+		var objs []RedisObject
+		for _, item := range items {
+			objs = append(objs, NewBulkRedisString(item))
+		}
+		return NewRedisSet(objs)
+	})
+
+	assert.Equal(t, StreamType, stream.Type())
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", writeObject(t, stream, Resp2))
+	assert.Equal(t, "~3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", writeObject(t, stream, Resp3))
+	assert.Equal(t, 0, built, "a stream is not built to be written")
+
+	// the built reply is held once it is built
+	assert.Equal(t, "~3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", stream.String())
+	items = append(items, "d")
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", writeObject(t, stream, Resp2))
+	assert.Equal(t, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n", ConvertTo(stream, Resp2).String())
+	assert.Equal(t, 1, built)
+}
+
+func TestWriteWithoutAllocation(t *testing.T) {
+	w := NewRESPWriter(ioutil.Discard)
+	obj := NewRedisArray([]RedisObject{NewBulkRedisString("a"), NewRedisInteger(1)})
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = w.WriteArrayHeader(3)
+		_ = w.WriteBulkString("element")
+		_ = w.WriteInteger(12345)
+		_ = w.WriteObject(obj)
+		_ = w.WriteNull()
+		_ = w.Flush()
+	})
+
+	assert.Equal(t, float64(0), allocs)
+}
+
+var benchmarkElements = func() []string {
+	var ret []string
+	for idx := 0; idx < 10000; idx++ {
+		ret = append(ret, "element-"+strconv.Itoa(idx))
+	}
+	return ret
+}()
+
+// BenchmarkReplyBuilt is the path before RESPWriter: the reply is built as objects, converted for the
+// protocol version and queued as a string.
+func BenchmarkReplyBuilt(b *testing.B) {
+	w := bufio.NewWriter(ioutil.Discard)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var objs []RedisObject
+		for _, item := range benchmarkElements {
+			objs = append(objs, NewBulkRedisString(item))
+		}
+
+		var sb strings.Builder
+		sb.WriteString(ConvertTo(NewRedisArray(objs), Resp2).String())
+
+		_, _ = w.WriteString(sb.String())
+		_ = w.Flush()
+	}
+}
+
+// BenchmarkReplyObject writes a built reply by RESPWriter
+func BenchmarkReplyObject(b *testing.B) {
+	w := NewRESPWriter(ioutil.Discard)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		var objs []RedisObject
+		for _, item := range benchmarkElements {
+			objs = append(objs, NewBulkRedisString(item))
+		}
+
+		_ = w.WriteObject(NewRedisArray(objs))
+		_ = w.Flush()
+	}
+}
+
+// BenchmarkReplyStreamed streams the elements one at a time as LRANGE does
+func BenchmarkReplyStreamed(b *testing.B) {
+	w := NewRESPWriter(ioutil.Discard)
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = w.WriteArrayHeader(len(benchmarkElements))
+		for _, item := range benchmarkElements {
+			_ = w.WriteBulkString(item)
+		}
+		_ = w.Flush()
+	}
+}
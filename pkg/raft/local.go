@@ -0,0 +1,165 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+)
+
+// LocalNetwork connects the nodes in the process by calling their handlers directly, so the tests run a
+// cluster in one process and simulate the partitions. A request or its reply is lost if the link between
+// the two nodes is cut.
+type LocalNetwork struct {
+	mutex    sync.Mutex
+	handlers map[string]Handler
+	cut      map[string]map[string]bool
+}
+
+// NewLocalNetwork returns a network without any node
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{
+		handlers: make(map[string]Handler),
+		cut:      make(map[string]map[string]bool),
+	}
+}
+
+// Register serves the requests to the id by the handler, a nil handler makes the node unreachable, e.g.,
+// it crashes.
+func (l *LocalNetwork) Register(id string, handler Handler) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if handler == nil {
+		delete(l.handlers, id)
+	} else {
+		l.handlers[id] = handler
+	}
+}
+
+// Transport returns the transport of the node of the id
+func (l *LocalNetwork) Transport(id string) Transport {
+	return &localTransport{network: l, from: id}
+}
+
+// Partition cuts the links between the nodes in different groups, the links in a group are kept as they are
+func (l *LocalNetwork) Partition(groups ...[]string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for i := range groups {
+		for j := range groups {
+			if i == j {
+				continue
+			}
+
+			for _, from := range groups[i] {
+				for _, to := range groups[j] {
+					l.setCut(from, to, true)
+				}
+			}
+		}
+	}
+}
+
+// Isolate cuts all links of the node
+func (l *LocalNetwork) Isolate(id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for other := range l.handlers {
+		if other != id {
+			l.setCut(id, other, true)
+			l.setCut(other, id, true)
+		}
+	}
+}
+
+// Heal restores all links
+func (l *LocalNetwork) Heal() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.cut = make(map[string]map[string]bool)
+}
+
+// setCut cuts or restores the link from one node to another, the mutex should be held
+func (l *LocalNetwork) setCut(from string, to string, cut bool) {
+	if _, ok := l.cut[from]; !ok {
+		l.cut[from] = make(map[string]bool)
+	}
+
+	l.cut[from][to] = cut
+}
+
+// reach returns the handler of the node if it is reachable from the other
+func (l *LocalNetwork) reach(from string, to string) (Handler, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	handler, ok := l.handlers[to]
+	if !ok || l.cut[from][to] {
+		return nil, fmt.Errorf("reach node failed. from=%s, to=%s, err={%w}", from, to, ErrUnreachable)
+	}
+
+	return handler, nil
+}
+
+// localTransport sends the requests of a node over the network, the reply is lost if the link back is cut
+// meanwhile
+type localTransport struct {
+	network *LocalNetwork
+	from    string
+}
+
+func (t *localTransport) RequestVote(peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	handler, err := t.network.reach(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := handler.RequestVote(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := t.network.reach(peer, t.from); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (t *localTransport) AppendEntries(peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	handler, err := t.network.reach(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := handler.AppendEntries(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := t.network.reach(peer, t.from); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
+
+func (t *localTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	handler, err := t.network.reach(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := handler.InstallSnapshot(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := t.network.reach(peer, t.from); err != nil {
+		return nil, err
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,752 @@
+// Package raft replicates a log of opaque entries among a cluster of nodes by the raft consensus algorithm,
+// see https://raft.github.io/raft.pdf. It elects a leader, replicates the entries proposed to it, advances
+// the commit index once a majority stores them, and compacts the log by the snapshots of the state machine,
+// which are installed on the followers falling behind them.
+//
+// The leader applies an entry to its state machine before it is proposed, so the effect of a request is
+// known at once and only the effect is replicated. The followers apply the entries once they are committed.
+// A node rebuilds its state machine from the snapshot and the committed entries once the entries it has
+// applied ahead of the commit index are dropped, i.e., it loses the leadership before they are committed.
+package raft
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+)
+
+// The defaults of the config if it is not set
+const (
+	DefaultElectionTimeout   = time.Second
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultSnapshotThreshold = 10000
+	DefaultMaxEntries        = 256
+)
+
+// The states of a node
+const (
+	StateFollower  = "follower"
+	StateCandidate = "candidate"
+	StateLeader    = "leader"
+)
+
+var (
+	// ErrNotLeader will be raised if an entry is proposed to a node which is not the leader
+	ErrNotLeader = errors.New("raft: node is not the leader")
+
+	// ErrNotReady will be raised if an entry is proposed to a leader which has not applied its log yet
+	ErrNotReady = errors.New("raft: leader is applying the log")
+
+	// ErrLeadershipLost resolves the entries proposed if the leader steps down before they are committed,
+	// they may still be committed by the next leader
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry is committed")
+
+	// ErrUnreachable will be raised if a node can not be reached
+	ErrUnreachable = errors.New("raft: node is unreachable")
+
+	// ErrStorageFailed will be raised by a node which fails to store its state, it refuses the proposals and
+	// the requests of the others until it is restarted from what is stored
+	ErrStorageFailed = errors.New("raft: storage failed")
+)
+
+// Config is the config of a node.
+type Config struct {
+	// ID is the id of the node, and Peers are the ids of the other nodes in the cluster.
+	ID    string
+	Peers []string
+
+	// ElectionTimeout is the min time a follower waits for the leader before it starts an election, the
+	// actual one is random in [ElectionTimeout, 2*ElectionTimeout). A leader steps down once it does not
+	// reach a majority within it. HeartbeatInterval is the interval the leader contacts an idle follower.
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+
+	// SnapshotThreshold is the count of the entries applied since the last snapshot which triggers a new
+	// one, a negative one disables the compaction.
+	SnapshotThreshold int
+
+	// MaxEntries is the max count of the entries sent in one AppendEntries.
+	MaxEntries int
+}
+
+// Entry is an entry of the log, the data of the one starting a term is empty.
+type Entry struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// StateMachine is what the log is applied to. Except Ready, its methods are called by Node.Apply, which is
+// called by the state machine with its lock held, so no entry is proposed in between.
+type StateMachine interface {
+	// Ready is called once there are entries to apply or proposals resolved, or the state machine should
+	// be rebuilt. It should call Node.Apply, no lock of the node is held.
+	Ready()
+
+	// Apply applies a committed entry which is not proposed by the node.
+	Apply(Entry)
+
+	// Resolve reports the outcome of an entry proposed by the node, the error is nil once it is committed.
+	Resolve(uint64, error)
+
+	// Snapshot returns the state applied, and Restore rebuilds the state from one, which is nil if the log
+	// is never compacted.
+	Snapshot() ([]byte, error)
+	Restore([]byte) error
+}
+
+// Node is a node of the cluster.
+type Node interface {
+	Start()
+	Stop()
+
+	// Propose appends the data to the log on the leader, and returns the index of the entry. The data
+	// should be applied to the state machine before it is proposed with its lock held, so the state machine
+	// is rebuilt by the next Apply if the proposal is refused.
+	Propose([]byte) (uint64, error)
+
+	// Apply calls the state machine with what is ready, it should be called with the lock of the state
+	// machine held.
+	Apply()
+
+	// Status returns the state of the node.
+	Status() Status
+
+	Handler
+}
+
+// Status is the state of a node.
+type Status struct {
+	ID    string
+	State string
+	Term  uint64
+
+	// Leader is the id of the leader known by the node, empty if unknown.
+	Leader string
+
+	CommitIndex   uint64
+	LastApplied   uint64
+	LastIndex     uint64
+	SnapshotIndex uint64
+}
+
+// resolution is the outcome of an entry proposed
+type resolution struct {
+	index uint64
+	err   error
+}
+
+type node struct {
+	config    Config
+	sm        StateMachine
+	storage   Storage
+	transport Transport
+
+	mutex    sync.Mutex
+	state    string
+	term     uint64
+	votedFor string
+	leader   string
+
+	// snapshot holds the entries compacted, and entries are the ones after it
+	snapshot Snapshot
+	entries  []Entry
+
+	commitIndex uint64
+	lastApplied uint64
+
+	// restore reports if the state machine should be rebuilt from the snapshot
+	restore bool
+
+	// failed is the error storing the state, the node stops taking part in the cluster once it is set, as
+	// a vote or an entry it has replied may be lost after a restart
+	failed error
+
+	// proposals are the entries proposed and not committed yet, and resolved are the outcomes to report
+	proposals []uint64
+	resolved  []resolution
+
+	// the states of the leader, signals wake the replicators of the peers, and leaderDone is closed once
+	// the leadership ends
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time
+	signals     map[string]chan struct{}
+	leaderDone  chan struct{}
+
+	electionDeadline time.Time
+
+	readyChan chan struct{}
+	shutChan  chan struct{}
+	stopped   bool
+	wg        sync.WaitGroup
+}
+
+// NewNode returns a node with the state loaded from the storage, the nodes are reached by the transport
+func NewNode(config Config, sm StateMachine, storage Storage, transport Transport) (Node, error) {
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = DefaultElectionTimeout
+	}
+
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultMaxEntries
+	}
+
+	state, snapshot, entries, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &node{
+		config:      config,
+		sm:          sm,
+		storage:     storage,
+		transport:   transport,
+		state:       StateFollower,
+		term:        state.Term,
+		votedFor:    state.VotedFor,
+		snapshot:    snapshot,
+		entries:     entries,
+		commitIndex: snapshot.Index,
+		lastApplied: 0,
+		restore:     true,
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		lastContact: make(map[string]time.Time),
+		signals:     make(map[string]chan struct{}),
+		readyChan:   make(chan struct{}, 1),
+		shutChan:    make(chan struct{}),
+	}
+
+	return n, nil
+}
+
+func (n *node) Start() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	common.Infof("start raft node. id=%s, peers=%v, term=%d, snapshot=%d, last=%d", n.config.ID, n.config.Peers,
+		n.term, n.snapshot.Index, n.lastIndex())
+
+	n.resetElection()
+
+	n.wg.Add(2)
+	go n.tickLoop()
+	go n.readyLoop()
+
+	// the state machine is rebuilt from the snapshot loaded
+	n.notify()
+}
+
+func (n *node) Stop() {
+	n.mutex.Lock()
+	if n.stopped {
+		n.mutex.Unlock()
+		return
+	}
+
+	n.stopped = true
+	close(n.shutChan)
+	if n.state == StateLeader {
+		n.becomeFollower(n.term)
+	}
+	n.mutex.Unlock()
+
+	n.wg.Wait()
+}
+
+func (n *node) Propose(data []byte) (uint64, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.failed != nil {
+		n.rebuild()
+		return 0, n.failure()
+	} else if n.state != StateLeader || n.stopped {
+		n.rebuild()
+		return 0, ErrNotLeader
+	} else if n.lastApplied != n.lastIndex() {
+		n.rebuild()
+		return 0, ErrNotReady
+	}
+
+	index := n.lastIndex() + 1
+	if err := n.append([]Entry{{Index: index, Term: n.term, Data: data}}); err != nil {
+		n.fail(err)
+		n.rebuild()
+		return 0, n.failure()
+	}
+
+	n.lastApplied = index
+	n.proposals = append(n.proposals, index)
+
+	for _, signal := range n.signals {
+		wake(signal)
+	}
+
+	// a single node commits it at once
+	n.advanceCommit()
+
+	return index, nil
+}
+
+func (n *node) Apply() {
+	n.mutex.Lock()
+	if n.restore {
+		n.restore = false
+		n.lastApplied = n.snapshot.Index
+		data := n.snapshot.Data
+		n.mutex.Unlock()
+
+		if err := n.sm.Restore(data); err != nil {
+			_ = common.Errorf("restore raft snapshot failed. id=%s, index=%d, err=%s", n.config.ID,
+				n.snapshot.Index, err.Error())
+		}
+
+		n.mutex.Lock()
+	}
+
+	var entries []Entry
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entries = append(entries, n.entry(n.lastApplied))
+	}
+
+	resolved := n.resolved
+	n.resolved = nil
+
+	index := n.lastApplied
+	compact := !n.restore && n.config.SnapshotThreshold > 0 && index == n.commitIndex &&
+		index-n.snapshot.Index >= uint64(n.config.SnapshotThreshold)
+	n.mutex.Unlock()
+
+	for _, e := range entries {
+		if len(e.Data) > 0 {
+			n.sm.Apply(e)
+		}
+	}
+
+	for _, r := range resolved {
+		n.sm.Resolve(r.index, r.err)
+	}
+
+	if compact {
+		data, err := n.sm.Snapshot()
+		if err != nil {
+			_ = common.Errorf("take raft snapshot failed. id=%s, index=%d, err=%s", n.config.ID, index, err.Error())
+			return
+		}
+
+		n.compact(index, data)
+	}
+}
+
+func (n *node) Status() Status {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return Status{
+		ID:            n.config.ID,
+		State:         n.state,
+		Term:          n.term,
+		Leader:        n.leader,
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		LastIndex:     n.lastIndex(),
+		SnapshotIndex: n.snapshot.Index,
+	}
+}
+
+// tickLoop starts the elections and checks the quorum of the leader
+func (n *node) tickLoop() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.shutChan:
+			return
+		case <-ticker.C:
+			n.tick()
+		}
+	}
+}
+
+func (n *node) tick() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped || n.failed != nil {
+		return
+	}
+
+	now := time.Now()
+	if n.state != StateLeader {
+		if now.After(n.electionDeadline) {
+			n.startElection()
+		}
+		return
+	}
+
+	// a leader in the minority steps down, so the clients of it fail instead of waiting for the partition
+	contacted := 1
+	for _, peer := range n.config.Peers {
+		if now.Sub(n.lastContact[peer]) < n.config.ElectionTimeout {
+			contacted++
+		}
+	}
+
+	if !n.isMajority(contacted) {
+		common.Warnf("raft leader steps down without a majority. id=%s, term=%d", n.config.ID, n.term)
+		n.becomeFollower(n.term)
+		n.leader = ""
+	}
+}
+
+// readyLoop calls the state machine once anything is ready
+func (n *node) readyLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.shutChan:
+			return
+		case <-n.readyChan:
+			n.sm.Ready()
+		}
+	}
+}
+
+// notify wakes readyLoop, the mutex should be held
+func (n *node) notify() {
+	wake(n.readyChan)
+}
+
+// wake sends to the channel of one slot without blocking
+func wake(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
+
+// rebuild rebuilds the state machine from the snapshot and the committed entries by the next Apply, the
+// mutex should be held
+func (n *node) rebuild() {
+	n.restore = true
+	n.notify()
+}
+
+// resetElection sets the random deadline of the next election, the mutex should be held
+func (n *node) resetElection() {
+	timeout := n.config.ElectionTimeout
+	n.electionDeadline = time.Now().Add(timeout + time.Duration(rand.Int63n(int64(timeout))))
+}
+
+// isMajority reports if the count of the nodes is a majority of the cluster
+func (n *node) isMajority(count int) bool {
+	return count*2 > len(n.config.Peers)+1
+}
+
+// persistState saves the term and the vote, the mutex should be held
+func (n *node) persistState() error {
+	if err := n.storage.SaveState(State{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		return fmt.Errorf("save raft state failed. term=%d, vote=%s, err={%w}", n.term, n.votedFor, err)
+	}
+
+	return nil
+}
+
+// fail stops the node from taking part in the cluster since its state can not be stored, it steps down
+// and never starts an election again. The mutex should be held.
+func (n *node) fail(err error) {
+	if n.failed != nil {
+		return
+	}
+
+	_ = common.Errorf("raft node stops for the storage failure. id=%s, err=%s", n.config.ID, err.Error())
+
+	n.failed = err
+	n.becomeFollower(n.term)
+	n.leader = ""
+}
+
+// failure returns the error refusing the requests once the node fails, the mutex should be held
+func (n *node) failure() error {
+	return fmt.Errorf("raft node failed. id=%s, cause={%s}, err={%w}", n.config.ID, n.failed, ErrStorageFailed)
+}
+
+// startElection votes for the node itself in a new term and requests the votes of the peers, the mutex
+// should be held
+func (n *node) startElection() {
+	n.state = StateCandidate
+	n.term++
+	n.votedFor = n.config.ID
+	n.leader = ""
+	if err := n.persistState(); err != nil {
+		n.fail(err)
+		return
+	}
+	n.resetElection()
+
+	common.Infof("start raft election. id=%s, term=%d", n.config.ID, n.term)
+
+	if n.isMajority(1) {
+		n.becomeLeader()
+		return
+	}
+
+	args := &RequestVoteArgs{
+		Term:         n.term,
+		Candidate:    n.config.ID,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+
+	votes := 1
+	for _, peer := range n.config.Peers {
+		n.wg.Add(1)
+		go func(peer string) {
+			defer n.wg.Done()
+
+			reply, err := n.transport.RequestVote(peer, args)
+			if err != nil {
+				return
+			}
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term)
+				return
+			} else if n.state != StateCandidate || n.term != args.Term || !reply.Granted {
+				return
+			}
+
+			votes++
+			if n.isMajority(votes) {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+// becomeFollower follows the term, the proposals are resolved if the node was the leader, the mutex should
+// be held
+func (n *node) becomeFollower(term uint64) {
+	var err error
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leader = ""
+		err = n.persistState()
+	}
+
+	if n.state == StateLeader {
+		close(n.leaderDone)
+		n.signals = make(map[string]chan struct{})
+
+		for _, index := range n.proposals {
+			n.resolved = append(n.resolved, resolution{index: index, err: ErrLeadershipLost})
+		}
+		n.proposals = nil
+		n.notify()
+	}
+
+	n.state = StateFollower
+	n.resetElection()
+
+	if err != nil {
+		n.fail(err)
+	}
+}
+
+// becomeLeader appends the entry starting the term and starts the replicators, the mutex should be held
+func (n *node) becomeLeader() {
+	// an entry of the term commits the ones of the former terms along with it, see 5.4.2
+	next := n.lastIndex() + 1
+	if err := n.append([]Entry{{Index: next, Term: n.term}}); err != nil {
+		n.fail(err)
+		return
+	}
+
+	n.state = StateLeader
+	n.leader = n.config.ID
+
+	common.Infof("become raft leader. id=%s, term=%d", n.config.ID, n.term)
+
+	now := time.Now()
+	for _, peer := range n.config.Peers {
+		n.nextIndex[peer] = next
+		n.matchIndex[peer] = 0
+		n.lastContact[peer] = now
+	}
+
+	n.leaderDone = make(chan struct{})
+	for _, peer := range n.config.Peers {
+		signal := make(chan struct{}, 1)
+		n.signals[peer] = signal
+
+		n.wg.Add(1)
+		go n.replicate(peer, n.term, signal, n.leaderDone)
+	}
+
+	n.advanceCommit()
+}
+
+// advanceCommit commits the last entry of the term stored by a majority, the mutex should be held
+func (n *node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		// only the entries of the current term are committed by counting, see 5.4.2
+		if term, _ := n.termAt(index); term != n.term {
+			return
+		}
+
+		count := 1
+		for _, peer := range n.config.Peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+
+		if n.isMajority(count) {
+			n.setCommit(index)
+
+			// the followers learn the commit index at once
+			for _, signal := range n.signals {
+				wake(signal)
+			}
+			return
+		}
+	}
+}
+
+// setCommit advances the commit index and resolves the proposals committed, the mutex should be held
+func (n *node) setCommit(index uint64) {
+	if index <= n.commitIndex {
+		return
+	}
+
+	n.commitIndex = index
+
+	var pending []uint64
+	for _, proposal := range n.proposals {
+		if proposal <= index {
+			n.resolved = append(n.resolved, resolution{index: proposal})
+		} else {
+			pending = append(pending, proposal)
+		}
+	}
+	n.proposals = pending
+
+	n.notify()
+}
+
+// lastIndex returns the index of the last entry, the mutex should be held
+func (n *node) lastIndex() uint64 {
+	return n.snapshot.Index + uint64(len(n.entries))
+}
+
+// lastTerm returns the term of the last entry, the mutex should be held
+func (n *node) lastTerm() uint64 {
+	if len(n.entries) > 0 {
+		return n.entries[len(n.entries)-1].Term
+	}
+
+	return n.snapshot.Term
+}
+
+// termAt returns the term of the entry, false if it is compacted or absent. The mutex should be held.
+func (n *node) termAt(index uint64) (uint64, bool) {
+	if index == n.snapshot.Index {
+		return n.snapshot.Term, true
+	} else if index < n.snapshot.Index || index > n.lastIndex() {
+		return 0, false
+	}
+
+	return n.entry(index).Term, true
+}
+
+// entry returns the entry after the snapshot, the mutex should be held
+func (n *node) entry(index uint64) Entry {
+	return n.entries[index-n.snapshot.Index-1]
+}
+
+// append stores the entries and appends them to the log, the mutex should be held
+func (n *node) append(entries []Entry) error {
+	if err := n.storage.Append(entries); err != nil {
+		return fmt.Errorf("append raft entries failed. first=%d, count=%d, err={%w}", entries[0].Index,
+			len(entries), err)
+	}
+
+	n.entries = append(n.entries, entries...)
+
+	return nil
+}
+
+// truncate drops the entries from the index, the state machine is rebuilt if any of them is applied. The
+// mutex should be held.
+func (n *node) truncate(index uint64) error {
+	n.entries = append([]Entry(nil), n.entries[:index-n.snapshot.Index-1]...)
+
+	if index <= n.lastApplied {
+		n.rebuild()
+	}
+
+	if err := n.storage.SetEntries(n.entries); err != nil {
+		return fmt.Errorf("truncate raft entries failed. index=%d, err={%w}", index, err)
+	}
+
+	return nil
+}
+
+// compact replaces the entries up to the index by the snapshot of the state machine applied them
+func (n *node) compact(index uint64, data []byte) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	term, ok := n.termAt(index)
+	if index <= n.snapshot.Index || !ok || n.failed != nil {
+		return
+	}
+
+	err := n.installSnapshot(Snapshot{Index: index, Term: term, Data: data},
+		append([]Entry(nil), n.entries[index-n.snapshot.Index:]...))
+	if err != nil {
+		n.fail(err)
+		return
+	}
+
+	common.Infof("raft log compacted. id=%s, index=%d, term=%d, size=%d", n.config.ID, index, term, len(data))
+}
+
+// installSnapshot replaces the snapshot and the entries after it, and stores them. The mutex should be held.
+func (n *node) installSnapshot(snapshot Snapshot, entries []Entry) error {
+	n.snapshot = snapshot
+	n.entries = entries
+
+	if err := n.storage.SaveSnapshot(snapshot); err != nil {
+		return fmt.Errorf("save raft snapshot failed. index=%d, err={%w}", snapshot.Index, err)
+	}
+
+	if err := n.storage.SetEntries(entries); err != nil {
+		return fmt.Errorf("save raft entries failed. index=%d, err={%w}", snapshot.Index, err)
+	}
+
+	return nil
+}
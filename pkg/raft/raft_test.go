@@ -0,0 +1,583 @@
+package raft_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/lxdlam/vertex/pkg/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// kvMachine is a map of strings applying the entries of "key=value"
+type kvMachine struct {
+	mutex    sync.Mutex
+	node     Node
+	data     map[string]string
+	resolved map[uint64]error
+}
+
+func newKVMachine() *kvMachine {
+	return &kvMachine{
+		data:     make(map[string]string),
+		resolved: make(map[uint64]error),
+	}
+}
+
+func (m *kvMachine) Ready() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.node.Apply()
+}
+
+func (m *kvMachine) Apply(e Entry) {
+	m.set(string(e.Data))
+}
+
+func (m *kvMachine) Resolve(index uint64, err error) {
+	m.resolved[index] = err
+}
+
+func (m *kvMachine) Snapshot() ([]byte, error) {
+	return json.Marshal(m.data)
+}
+
+func (m *kvMachine) Restore(data []byte) error {
+	m.data = make(map[string]string)
+	if data == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, &m.data)
+}
+
+// set applies the entry, the mutex should be held
+func (m *kvMachine) set(entry string) {
+	kv := strings.SplitN(entry, "=", 2)
+	m.data[kv[0]] = kv[1]
+}
+
+// propose applies the entry then proposes it as the leader does
+func (m *kvMachine) propose(key string, value string) (uint64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry := key + "=" + value
+	m.set(entry)
+
+	return m.node.Propose([]byte(entry))
+}
+
+func (m *kvMachine) get(key string) (string, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	value, ok := m.data[key]
+	return value, ok
+}
+
+// outcome returns the outcome of the proposal, false if it is not resolved yet
+func (m *kvMachine) outcome(index uint64) (error, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	err, ok := m.resolved[index]
+	return err, ok
+}
+
+// testCluster runs the nodes over a local network, a node keeps its storage after it is stopped
+type testCluster struct {
+	t         *testing.T
+	network   *LocalNetwork
+	ids       []string
+	threshold int
+	storages  map[string]Storage
+	machines  map[string]*kvMachine
+	nodes     map[string]Node
+}
+
+func newTestCluster(t *testing.T, size int, threshold int) *testCluster {
+	c := &testCluster{
+		t:         t,
+		network:   NewLocalNetwork(),
+		threshold: threshold,
+		storages:  make(map[string]Storage),
+		machines:  make(map[string]*kvMachine),
+		nodes:     make(map[string]Node),
+	}
+
+	for idx := 0; idx < size; idx++ {
+		id := fmt.Sprintf("n%d", idx+1)
+		c.ids = append(c.ids, id)
+		c.storages[id] = NewMemoryStorage()
+	}
+
+	for _, id := range c.ids {
+		c.start(id)
+	}
+
+	return c
+}
+
+func (c *testCluster) start(id string) {
+	var peers []string
+	for _, other := range c.ids {
+		if other != id {
+			peers = append(peers, other)
+		}
+	}
+
+	config := Config{
+		ID:                id,
+		Peers:             peers,
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotThreshold: c.threshold,
+		MaxEntries:        16,
+	}
+
+	m := newKVMachine()
+	node, err := NewNode(config, m, c.storages[id], c.network.Transport(id))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	m.node = node
+	c.machines[id] = m
+	c.nodes[id] = node
+	c.network.Register(id, node)
+	node.Start()
+}
+
+func (c *testCluster) stop(id string) {
+	c.network.Register(id, nil)
+	c.nodes[id].Stop()
+}
+
+func (c *testCluster) close() {
+	for _, id := range c.ids {
+		c.stop(id)
+	}
+}
+
+// waitFor checks the condition until it holds
+func waitFor(t *testing.T, message string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("wait timeout. %s", message)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// leader waits until one of the nodes is the leader known by all of them, and returns it
+func (c *testCluster) leader(ids ...string) string {
+	var leader string
+
+	waitFor(c.t, fmt.Sprintf("no leader elected among %v", ids), func() bool {
+		leader = ""
+		for _, id := range ids {
+			status := c.nodes[id].Status()
+			if status.Leader == "" || (leader != "" && status.Leader != leader) {
+				return false
+			}
+			leader = status.Leader
+		}
+
+		for _, id := range ids {
+			if id == leader {
+				return c.nodes[leader].Status().State == StateLeader
+			}
+		}
+
+		return false
+	})
+
+	return leader
+}
+
+// waitValue waits until the nodes apply the value of the key
+func (c *testCluster) waitValue(key string, value string, ids ...string) {
+	for _, id := range ids {
+		waitFor(c.t, fmt.Sprintf("node %s does not apply %s=%s", id, key, value), func() bool {
+			v, ok := c.machines[id].get(key)
+			return ok && v == value
+		})
+	}
+}
+
+// waitOutcome waits until the proposal is resolved, and returns its outcome
+func (c *testCluster) waitOutcome(id string, index uint64) error {
+	var outcome error
+
+	waitFor(c.t, fmt.Sprintf("proposal %d of node %s is not resolved", index, id), func() bool {
+		var ok bool
+		outcome, ok = c.machines[id].outcome(index)
+		return ok
+	})
+
+	return outcome
+}
+
+func without(ids []string, excluded ...string) []string {
+	var ret []string
+
+	for _, id := range ids {
+		keep := true
+		for _, other := range excluded {
+			keep = keep && id != other
+		}
+
+		if keep {
+			ret = append(ret, id)
+		}
+	}
+
+	return ret
+}
+
+func TestElection(t *testing.T) {
+	c := newTestCluster(t, 3, -1)
+	defer c.close()
+
+	leader := c.leader(c.ids...)
+	term := c.nodes[leader].Status().Term
+
+	for _, id := range without(c.ids, leader) {
+		_, err := c.machines[id].propose("key", "value")
+		assert.Equal(t, ErrNotLeader, err)
+	}
+
+	// the others elect a new leader, and the old one follows it once the partition heals
+	c.network.Isolate(leader)
+	others := without(c.ids, leader)
+	next := c.leader(others...)
+	assert.NotEqual(t, leader, next)
+	assert.True(t, c.nodes[next].Status().Term > term)
+
+	waitFor(t, "the isolated leader does not step down", func() bool {
+		return c.nodes[leader].Status().State != StateLeader
+	})
+
+	c.network.Heal()
+	final := c.leader(c.ids...)
+	assert.NotEqual(t, leader, final)
+}
+
+func TestReplicate(t *testing.T) {
+	c := newTestCluster(t, 3, -1)
+	defer c.close()
+
+	leader := c.leader(c.ids...)
+
+	var indexes []uint64
+	for idx := 0; idx < 100; idx++ {
+		index, err := c.machines[leader].propose(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx))
+		assert.Nil(t, err)
+		indexes = append(indexes, index)
+	}
+
+	for idx := 1; idx < len(indexes); idx++ {
+		assert.Equal(t, indexes[idx-1]+1, indexes[idx])
+	}
+
+	for _, index := range indexes {
+		assert.Nil(t, c.waitOutcome(leader, index))
+	}
+
+	for idx := 0; idx < 100; idx++ {
+		c.waitValue(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx), c.ids...)
+	}
+
+	last := indexes[len(indexes)-1]
+	for _, id := range c.ids {
+		waitFor(t, "the commit index is not replicated", func() bool {
+			status := c.nodes[id].Status()
+			return status.CommitIndex == last && status.LastApplied == last && status.LastIndex == last
+		})
+	}
+}
+
+// TestPartition writes on both sides of a partition, only the majority commits, and the minority drops the
+// entries it has applied once the partition heals
+func TestPartition(t *testing.T) {
+	c := newTestCluster(t, 5, -1)
+	defer c.close()
+
+	leader := c.leader(c.ids...)
+	index, err := c.machines[leader].propose("key", "before")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(leader, index))
+	c.waitValue("key", "before", c.ids...)
+
+	others := without(c.ids, leader)
+	minority := []string{leader, others[0]}
+	majority := others[1:]
+	c.network.Partition(minority, majority)
+
+	// the leader in the minority applies the entry but never commits it, and steps down
+	index, err = c.machines[leader].propose("key", "minority")
+	assert.Nil(t, err)
+	c.waitValue("key", "minority", leader)
+	assert.Equal(t, ErrLeadershipLost, c.waitOutcome(leader, index))
+
+	next := c.leader(majority...)
+	index, err = c.machines[next].propose("key", "majority")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(next, index))
+	c.waitValue("key", "majority", majority...)
+
+	v, _ := c.machines[others[0]].get("key")
+	assert.Equal(t, "before", v)
+
+	c.network.Heal()
+	c.waitValue("key", "majority", c.ids...)
+
+	final := c.leader(c.ids...)
+	index, err = c.machines[final].propose("key", "after")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(final, index))
+	c.waitValue("key", "after", c.ids...)
+}
+
+// TestSnapshot compacts the log while a follower is isolated, which is caught up by the snapshot
+func TestSnapshot(t *testing.T) {
+	c := newTestCluster(t, 3, 10)
+	defer c.close()
+
+	leader := c.leader(c.ids...)
+	lagging := without(c.ids, leader)[0]
+	c.network.Isolate(lagging)
+
+	var last uint64
+	for idx := 0; idx < 50; idx++ {
+		index, err := c.machines[leader].propose(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx))
+		assert.Nil(t, err)
+		last = index
+	}
+	assert.Nil(t, c.waitOutcome(leader, last))
+
+	waitFor(t, "the leader does not compact its log", func() bool {
+		return c.nodes[leader].Status().SnapshotIndex >= 40
+	})
+
+	c.network.Heal()
+	for idx := 0; idx < 50; idx++ {
+		c.waitValue(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx), lagging)
+	}
+
+	assert.True(t, c.nodes[lagging].Status().SnapshotIndex >= 40)
+}
+
+// TestRestart restarts all nodes with their storages, the state is rebuilt from the snapshot and the log
+func TestRestart(t *testing.T) {
+	c := newTestCluster(t, 3, 10)
+
+	leader := c.leader(c.ids...)
+	var last uint64
+	for idx := 0; idx < 25; idx++ {
+		index, err := c.machines[leader].propose(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx))
+		assert.Nil(t, err)
+		last = index
+	}
+	assert.Nil(t, c.waitOutcome(leader, last))
+	c.waitValue("key:24", "24", c.ids...)
+	c.close()
+
+	for _, id := range c.ids {
+		c.start(id)
+	}
+	defer c.close()
+
+	c.leader(c.ids...)
+	for idx := 0; idx < 25; idx++ {
+		c.waitValue(fmt.Sprintf("key:%d", idx), fmt.Sprint(idx), c.ids...)
+	}
+}
+
+// failingStorage fails the writes while it is broken
+type failingStorage struct {
+	Storage
+
+	mutex  sync.Mutex
+	broken bool
+}
+
+var errBroken = errors.New("storage is broken")
+
+func (f *failingStorage) setBroken(broken bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.broken = broken
+}
+
+func (f *failingStorage) check() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.broken {
+		return errBroken
+	}
+
+	return nil
+}
+
+func (f *failingStorage) SaveState(state State) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	return f.Storage.SaveState(state)
+}
+
+func (f *failingStorage) SaveSnapshot(snapshot Snapshot) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	return f.Storage.SaveSnapshot(snapshot)
+}
+
+func (f *failingStorage) Append(entries []Entry) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	return f.Storage.Append(entries)
+}
+
+func (f *failingStorage) SetEntries(entries []Entry) error {
+	if err := f.check(); err != nil {
+		return err
+	}
+
+	return f.Storage.SetEntries(entries)
+}
+
+// TestStorageFailure breaks the storage of a follower then the one of the leader, the node stops taking
+// part in the cluster, and the others go on without it until it restarts with a working storage
+func TestStorageFailure(t *testing.T) {
+	c := newTestCluster(t, 3, -1)
+	c.close()
+
+	storages := make(map[string]*failingStorage)
+	for _, id := range c.ids {
+		storages[id] = &failingStorage{Storage: c.storages[id]}
+		c.storages[id] = storages[id]
+		c.start(id)
+	}
+	defer c.close()
+
+	leader := c.leader(c.ids...)
+	others := without(c.ids, leader)
+	failed := others[0]
+	storages[failed].setBroken(true)
+
+	index, err := c.machines[leader].propose("key", "value")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(leader, index))
+	c.waitValue("key", "value", leader, others[1])
+
+	waitFor(t, "the follower does not fail", func() bool {
+		_, err := c.machines[failed].propose("probe", "value")
+		return errors.Is(err, ErrStorageFailed)
+	})
+
+	// the failed follower never starts an election
+	term := c.nodes[failed].Status().Term
+	time.Sleep(500 * time.Millisecond)
+	status := c.nodes[failed].Status()
+	assert.Equal(t, term, status.Term)
+	assert.Equal(t, StateFollower, status.State)
+	assert.Equal(t, leader, c.leader(leader, others[1]))
+
+	index, err = c.machines[leader].propose("key", "without")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(leader, index))
+
+	// it catches up once it restarts with a working storage
+	storages[failed].setBroken(false)
+	c.stop(failed)
+	c.start(failed)
+	c.waitValue("key", "without", failed)
+
+	// a failed leader refuses the proposals and steps down, the others elect a new one
+	leader = c.leader(c.ids...)
+	storages[leader].setBroken(true)
+
+	_, err = c.machines[leader].propose("key", "refused")
+	assert.True(t, errors.Is(err, ErrStorageFailed))
+	assert.NotEqual(t, StateLeader, c.nodes[leader].Status().State)
+
+	next := c.leader(without(c.ids, leader)...)
+	assert.NotEqual(t, leader, next)
+
+	index, err = c.machines[next].propose("key", "next")
+	assert.Nil(t, err)
+	assert.Nil(t, c.waitOutcome(next, index))
+	c.waitValue("key", "next", without(c.ids, leader)...)
+}
+
+func TestFileStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStorage(dir)
+	assert.Nil(t, err)
+
+	state, snapshot, entries, err := s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, State{}, state)
+	assert.Equal(t, Snapshot{}, snapshot)
+	assert.Empty(t, entries)
+
+	assert.Nil(t, s.SaveState(State{Term: 3, VotedFor: "n2"}))
+	assert.Nil(t, s.Append([]Entry{{Index: 1, Term: 1}, {Index: 2, Term: 1, Data: []byte("a=1")}}))
+	assert.Nil(t, s.Append([]Entry{{Index: 3, Term: 2, Data: []byte("b=2")}}))
+	assert.Nil(t, s.SaveSnapshot(Snapshot{Index: 1, Term: 1, Data: []byte("{}")}))
+	assert.Nil(t, s.Close())
+
+	// a torn entry at the tail is dropped
+	f, err := os.OpenFile(filepath.Join(dir, "raft.log"), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{4, 0, 0, 0, 0, 0, 0, 0, 2})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = NewFileStorage(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	state, snapshot, entries, err = s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, State{Term: 3, VotedFor: "n2"}, state)
+	assert.Equal(t, Snapshot{Index: 1, Term: 1, Data: []byte("{}")}, snapshot)
+	assert.Equal(t, []Entry{{Index: 2, Term: 1, Data: []byte("a=1")}, {Index: 3, Term: 2, Data: []byte("b=2")}}, entries)
+
+	// the entries appended after the load follow the ones kept
+	assert.Nil(t, s.Append([]Entry{{Index: 4, Term: 3, Data: []byte("c=3")}}))
+	_, _, entries, err = s.Load()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, uint64(4), entries[2].Index)
+
+	// a corrupted state is refused
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "raft.state"), []byte("broken"), 0644))
+	_, _, _, err = s.Load()
+	assert.True(t, err != nil && strings.Contains(err.Error(), ErrStorageCorrupted.Error()))
+}
@@ -0,0 +1,375 @@
+package raft
+
+import (
+	"time"
+)
+
+// Handler handles the requests of the other nodes. A stopped node ignores them, so its storage can be closed.
+// A request fails if the node can not store what it replies, see ErrStorageFailed.
+type Handler interface {
+	RequestVote(*RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(*AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(*InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+// Transport sends the requests to the other nodes by their ids, an error is returned if the node can not
+// be reached or the reply is lost.
+type Transport interface {
+	RequestVote(string, *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(string, *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(string, *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+// RequestVoteArgs is sent by a candidate to gather the votes.
+type RequestVoteArgs struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+}
+
+// RequestVoteReply is the vote of a node.
+type RequestVoteReply struct {
+	Term    uint64
+	Granted bool
+}
+
+// AppendEntriesArgs is sent by the leader to replicate the entries after the previous one, which is a
+// heartbeat if there is no entry.
+type AppendEntriesArgs struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []Entry
+	LeaderCommit uint64
+}
+
+// AppendEntriesReply reports if the previous entry matches, ConflictIndex is the index the leader retries
+// from if not.
+type AppendEntriesReply struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64
+}
+
+// InstallSnapshotArgs is sent by the leader to a follower which needs the entries compacted.
+type InstallSnapshotArgs struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64
+	LastTerm  uint64
+	Data      []byte
+}
+
+// InstallSnapshotReply is the reply of InstallSnapshot.
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+func (n *node) RequestVote(args *RequestVoteArgs) (*RequestVoteReply, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	reply := &RequestVoteReply{Term: n.term}
+	if n.failed != nil {
+		return nil, n.failure()
+	} else if n.stopped || args.Term < n.term {
+		return reply, nil
+	} else if args.Term > n.term {
+		n.becomeFollower(args.Term)
+		if n.failed != nil {
+			return nil, n.failure()
+		}
+		reply.Term = n.term
+	}
+
+	// the candidate should hold all committed entries, see 5.4.1
+	upToDate := args.LastLogTerm > n.lastTerm() ||
+		(args.LastLogTerm == n.lastTerm() && args.LastLogIndex >= n.lastIndex())
+
+	if (n.votedFor == "" || n.votedFor == args.Candidate) && upToDate {
+		n.votedFor = args.Candidate
+		if err := n.persistState(); err != nil {
+			n.fail(err)
+			return nil, n.failure()
+		}
+		n.resetElection()
+		reply.Granted = true
+	}
+
+	return reply, nil
+}
+
+func (n *node) AppendEntries(args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	reply := &AppendEntriesReply{Term: n.term}
+	if n.failed != nil {
+		return nil, n.failure()
+	} else if n.stopped || args.Term < n.term {
+		return reply, nil
+	}
+
+	if !n.follow(args.Term, args.Leader) {
+		return nil, n.failure()
+	}
+	reply.Term = n.term
+
+	// the entries in the snapshot are committed, so they match the ones of the leader
+	prev, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	if prev < n.snapshot.Index {
+		skip := n.snapshot.Index - prev
+		if skip >= uint64(len(entries)) {
+			entries = nil
+		} else {
+			entries = entries[skip:]
+		}
+		prev, prevTerm = n.snapshot.Index, n.snapshot.Term
+	}
+
+	if prev > n.lastIndex() {
+		reply.ConflictIndex = n.lastIndex() + 1
+		return reply, nil
+	}
+
+	// the leader retries from the first entry of the conflicting term, see 5.3
+	if term, _ := n.termAt(prev); term != prevTerm {
+		index := prev
+		for index > n.snapshot.Index+1 && n.entry(index-1).Term == term {
+			index--
+		}
+
+		reply.ConflictIndex = index
+		return reply, nil
+	}
+
+	// the entries matching are skipped, the log is truncated from the first conflict
+	for idx, e := range entries {
+		if e.Index > n.lastIndex() {
+			if err := n.append(entries[idx:]); err != nil {
+				n.fail(err)
+				return nil, n.failure()
+			}
+			break
+		}
+
+		if term, _ := n.termAt(e.Index); term != e.Term {
+			err := n.truncate(e.Index)
+			if err == nil {
+				err = n.append(entries[idx:])
+			}
+			if err != nil {
+				n.fail(err)
+				return nil, n.failure()
+			}
+			break
+		}
+	}
+
+	match := args.PrevLogIndex + uint64(len(args.Entries))
+	if args.LeaderCommit > n.commitIndex {
+		commit := args.LeaderCommit
+		if commit > match {
+			commit = match
+		}
+		n.setCommit(commit)
+	}
+
+	reply.Success = true
+	return reply, nil
+}
+
+func (n *node) InstallSnapshot(args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	reply := &InstallSnapshotReply{Term: n.term}
+	if n.failed != nil {
+		return nil, n.failure()
+	} else if n.stopped || args.Term < n.term {
+		return reply, nil
+	}
+
+	if !n.follow(args.Term, args.Leader) {
+		return nil, n.failure()
+	}
+	reply.Term = n.term
+
+	if args.LastIndex <= n.snapshot.Index {
+		return reply, nil
+	}
+
+	// the entries after the snapshot are kept if the log matches it
+	var entries []Entry
+	if term, ok := n.termAt(args.LastIndex); ok && term == args.LastTerm {
+		entries = append(entries, n.entries[args.LastIndex-n.snapshot.Index:]...)
+	}
+
+	err := n.installSnapshot(Snapshot{Index: args.LastIndex, Term: args.LastTerm, Data: args.Data}, entries)
+	if n.commitIndex < args.LastIndex {
+		n.commitIndex = args.LastIndex
+	}
+	n.rebuild()
+
+	if err != nil {
+		n.fail(err)
+		return nil, n.failure()
+	}
+
+	return reply, nil
+}
+
+// follow follows the leader of the term, it reports false if the term can not be stored. The mutex should
+// be held.
+func (n *node) follow(term uint64, leader string) bool {
+	if term > n.term || n.state != StateFollower {
+		n.becomeFollower(term)
+		if n.failed != nil {
+			return false
+		}
+	}
+
+	n.leader = leader
+	n.resetElection()
+
+	return true
+}
+
+// replicate sends the entries to the peer while the node is the leader of the term, the entries compacted
+// are sent by the snapshot. It sends the next batch at once while the peer is behind, and sends a heartbeat
+// if nothing is sent for HeartbeatInterval.
+func (n *node) replicate(peer string, term uint64, signal chan struct{}, done chan struct{}) {
+	defer n.wg.Done()
+
+	for {
+		n.mutex.Lock()
+		if n.state != StateLeader || n.term != term || n.stopped {
+			n.mutex.Unlock()
+			return
+		}
+
+		var more bool
+		var err error
+		if next := n.nextIndex[peer]; next <= n.snapshot.Index {
+			args := &InstallSnapshotArgs{
+				Term:      n.term,
+				Leader:    n.config.ID,
+				LastIndex: n.snapshot.Index,
+				LastTerm:  n.snapshot.Term,
+				Data:      n.snapshot.Data,
+			}
+			n.mutex.Unlock()
+
+			more, err = n.sendSnapshot(peer, args)
+		} else {
+			prevTerm, _ := n.termAt(next - 1)
+			last := n.lastIndex()
+			if last-next+1 > uint64(n.config.MaxEntries) {
+				last = next + uint64(n.config.MaxEntries) - 1
+			}
+
+			args := &AppendEntriesArgs{
+				Term:         n.term,
+				Leader:       n.config.ID,
+				PrevLogIndex: next - 1,
+				PrevLogTerm:  prevTerm,
+				LeaderCommit: n.commitIndex,
+			}
+
+			for index := next; index <= last; index++ {
+				args.Entries = append(args.Entries, n.entry(index))
+			}
+			n.mutex.Unlock()
+
+			more, err = n.sendEntries(peer, args)
+		}
+
+		if more && err == nil {
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case <-n.shutChan:
+			return
+		case <-signal:
+		case <-time.After(n.config.HeartbeatInterval):
+		}
+	}
+}
+
+// sendEntries sends AppendEntries to the peer, it reports if there are more entries to send
+func (n *node) sendEntries(peer string, args *AppendEntriesArgs) (bool, error) {
+	reply, err := n.transport.AppendEntries(peer, args)
+	if err != nil {
+		return false, err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.checkReply(peer, args.Term, reply.Term) {
+		return false, nil
+	}
+
+	if !reply.Success {
+		next := reply.ConflictIndex
+		if next > args.PrevLogIndex {
+			next = args.PrevLogIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+
+		n.nextIndex[peer] = next
+		return true, nil
+	}
+
+	if match := args.PrevLogIndex + uint64(len(args.Entries)); match > n.matchIndex[peer] {
+		n.matchIndex[peer] = match
+		n.nextIndex[peer] = match + 1
+		n.advanceCommit()
+	}
+
+	return n.nextIndex[peer] <= n.lastIndex(), nil
+}
+
+// sendSnapshot sends InstallSnapshot to the peer, it reports if there are more entries to send
+func (n *node) sendSnapshot(peer string, args *InstallSnapshotArgs) (bool, error) {
+	reply, err := n.transport.InstallSnapshot(peer, args)
+	if err != nil {
+		return false, err
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if !n.checkReply(peer, args.Term, reply.Term) {
+		return false, nil
+	}
+
+	if args.LastIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = args.LastIndex
+		n.nextIndex[peer] = args.LastIndex + 1
+		n.advanceCommit()
+	}
+
+	return n.nextIndex[peer] <= n.lastIndex(), nil
+}
+
+// checkReply follows a newer term of the reply, and records the contact of the peer. It reports if the node
+// is still the leader of the term of the request. The mutex should be held.
+func (n *node) checkReply(peer string, term uint64, replyTerm uint64) bool {
+	if replyTerm > n.term {
+		n.becomeFollower(replyTerm)
+		return false
+	} else if n.state != StateLeader || n.term != term {
+		return false
+	}
+
+	n.lastContact[peer] = time.Now()
+	return true
+}
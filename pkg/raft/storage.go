@@ -0,0 +1,358 @@
+package raft
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The files of FileStorage in its directory
+const (
+	stateFileName    = "raft.state"
+	snapshotFileName = "raft.snapshot"
+	entriesFileName  = "raft.log"
+)
+
+// maxEntryLen is the max length of the data of an entry read, so a corrupted length does not allocate too
+// much
+const maxEntryLen = 512 * 1024 * 1024
+
+var storageTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrStorageCorrupted will be raised if a file of the storage mismatches its checksum
+var ErrStorageCorrupted = errors.New("raft: storage is corrupted")
+
+// State is the term and the vote of a node, which are stored before it replies any request.
+type State struct {
+	Term     uint64
+	VotedFor string
+}
+
+// Snapshot is the state machine applied the entries up to the index, the data is nil if there is none.
+type Snapshot struct {
+	Index uint64
+	Term  uint64
+	Data  []byte
+}
+
+// Storage stores the state, the snapshot and the entries after it of a node.
+type Storage interface {
+	// Load returns what is stored, the entries covered by the snapshot are dropped.
+	Load() (State, Snapshot, []Entry, error)
+
+	SaveState(State) error
+	SaveSnapshot(Snapshot) error
+
+	// Append appends the entries, and SetEntries replaces all of them.
+	Append([]Entry) error
+	SetEntries([]Entry) error
+
+	Close() error
+}
+
+// memoryStorage keeps everything in memory, so a node restarted with it keeps its state as long as the
+// process lives
+type memoryStorage struct {
+	mutex    sync.Mutex
+	state    State
+	snapshot Snapshot
+	entries  []Entry
+}
+
+// NewMemoryStorage returns a storage in memory
+func NewMemoryStorage() Storage {
+	return &memoryStorage{}
+}
+
+func (m *memoryStorage) Load() (State, Snapshot, []Entry, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.state, m.snapshot, trimEntries(m.snapshot, m.entries), nil
+}
+
+func (m *memoryStorage) SaveState(state State) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.state = state
+	return nil
+}
+
+func (m *memoryStorage) SaveSnapshot(snapshot Snapshot) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.snapshot = snapshot
+	return nil
+}
+
+func (m *memoryStorage) Append(entries []Entry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = append(m.entries, entries...)
+	return nil
+}
+
+func (m *memoryStorage) SetEntries(entries []Entry) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries = append([]Entry(nil), entries...)
+	return nil
+}
+
+func (m *memoryStorage) Close() error {
+	return nil
+}
+
+// trimEntries returns the entries continuing the snapshot, the ones covered by it are dropped
+func trimEntries(snapshot Snapshot, entries []Entry) []Entry {
+	var ret []Entry
+
+	next := snapshot.Index + 1
+	for _, e := range entries {
+		if e.Index < next && len(ret) == 0 {
+			continue
+		} else if e.Index != next {
+			break
+		}
+
+		ret = append(ret, e)
+		next++
+	}
+
+	return ret
+}
+
+// fileStorage stores the state and the snapshot in the files replaced as a whole, and appends the entries
+// to the log file, which is rewritten once the entries are truncated or compacted. An entry is:
+//
+//	represent: | index | term | length | checksum | data |
+//	bytes:        ^8      ^8      ^4        ^4      ^length
+//
+// The checksum is the CRC32C of the data. A torn entry at the tail is dropped as it is never acknowledged.
+type fileStorage struct {
+	dir  string
+	file *os.File
+}
+
+// NewFileStorage returns a storage in the directory, which is created if absent
+func NewFileStorage(dir string) (Storage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create raft dir failed. dir=%s, err={%w}", dir, err)
+	}
+
+	return &fileStorage{dir: dir}, nil
+}
+
+func (f *fileStorage) Load() (State, Snapshot, []Entry, error) {
+	var state State
+	var snapshot Snapshot
+
+	if buf, err := readChecked(filepath.Join(f.dir, stateFileName)); err != nil {
+		return state, snapshot, nil, err
+	} else if len(buf) >= 8 {
+		state.Term = binary.LittleEndian.Uint64(buf)
+		state.VotedFor = string(buf[8:])
+	}
+
+	if buf, err := readChecked(filepath.Join(f.dir, snapshotFileName)); err != nil {
+		return state, snapshot, nil, err
+	} else if len(buf) >= 16 {
+		snapshot.Index = binary.LittleEndian.Uint64(buf)
+		snapshot.Term = binary.LittleEndian.Uint64(buf[8:])
+		snapshot.Data = buf[16:]
+	}
+
+	entries, err := f.readEntries()
+	if err != nil {
+		return state, snapshot, nil, err
+	}
+
+	entries = trimEntries(snapshot, entries)
+	if err := f.SetEntries(entries); err != nil {
+		return state, snapshot, nil, err
+	}
+
+	return state, snapshot, entries, nil
+}
+
+// readEntries reads the log file until its end or a torn entry
+func (f *fileStorage) readEntries() ([]Entry, error) {
+	file, err := os.Open(filepath.Join(f.dir, entriesFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open raft log failed. err={%w}", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	reader := bufio.NewReader(file)
+	header := make([]byte, 24)
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return entries, nil
+		}
+
+		length := binary.LittleEndian.Uint32(header[16:])
+		if length > maxEntryLen {
+			return entries, nil
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return entries, nil
+		} else if binary.LittleEndian.Uint32(header[20:]) != crc32.Checksum(data, storageTable) {
+			return entries, nil
+		}
+
+		if length == 0 {
+			data = nil
+		}
+
+		entries = append(entries, Entry{
+			Index: binary.LittleEndian.Uint64(header),
+			Term:  binary.LittleEndian.Uint64(header[8:]),
+			Data:  data,
+		})
+	}
+}
+
+func (f *fileStorage) SaveState(state State) error {
+	buf := make([]byte, 8, 8+len(state.VotedFor))
+	binary.LittleEndian.PutUint64(buf, state.Term)
+	buf = append(buf, state.VotedFor...)
+
+	return writeChecked(filepath.Join(f.dir, stateFileName), buf)
+}
+
+func (f *fileStorage) SaveSnapshot(snapshot Snapshot) error {
+	buf := make([]byte, 16, 16+len(snapshot.Data))
+	binary.LittleEndian.PutUint64(buf, snapshot.Index)
+	binary.LittleEndian.PutUint64(buf[8:], snapshot.Term)
+	buf = append(buf, snapshot.Data...)
+
+	return writeChecked(filepath.Join(f.dir, snapshotFileName), buf)
+}
+
+func (f *fileStorage) Append(entries []Entry) error {
+	if f.file == nil {
+		return fmt.Errorf("append raft log failed. err={%w}", os.ErrClosed)
+	}
+
+	if _, err := f.file.Write(packEntries(entries)); err != nil {
+		return fmt.Errorf("append raft log failed. err={%w}", err)
+	}
+
+	return f.file.Sync()
+}
+
+func (f *fileStorage) SetEntries(entries []Entry) error {
+	path := filepath.Join(f.dir, entriesFileName)
+	if err := writeFile(path, packEntries(entries)); err != nil {
+		return err
+	}
+
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		f.file = nil
+		return fmt.Errorf("open raft log failed. err={%w}", err)
+	}
+
+	f.file = file
+	return nil
+}
+
+func (f *fileStorage) Close() error {
+	if f.file == nil {
+		return nil
+	}
+
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// packEntries packs the entries in the format of the log file
+func packEntries(entries []Entry) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+
+	for _, e := range entries {
+		binary.LittleEndian.PutUint64(header, e.Index)
+		binary.LittleEndian.PutUint64(header[8:], e.Term)
+		binary.LittleEndian.PutUint32(header[16:], uint32(len(e.Data)))
+		binary.LittleEndian.PutUint32(header[20:], crc32.Checksum(e.Data, storageTable))
+
+		buf.Write(header)
+		buf.Write(e.Data)
+	}
+
+	return buf.Bytes()
+}
+
+// writeChecked replaces the file by the content with its CRC32C ahead
+func writeChecked(path string, content []byte) error {
+	buf := make([]byte, 4, 4+len(content))
+	binary.LittleEndian.PutUint32(buf, crc32.Checksum(content, storageTable))
+
+	return writeFile(path, append(buf, content...))
+}
+
+// readChecked reads the file written by writeChecked, it returns nil if the file is absent
+func readChecked(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("read raft file failed. path=%s, err={%w}", path, err)
+	}
+
+	if len(buf) < 4 || binary.LittleEndian.Uint32(buf) != crc32.Checksum(buf[4:], storageTable) {
+		return nil, fmt.Errorf("read raft file failed. path=%s, err={%w}", path, ErrStorageCorrupted)
+	}
+
+	return buf[4:], nil
+}
+
+// writeFile replaces the file by a temporary one synced, so a crash leaves either the old or the new one
+func writeFile(path string, content []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create raft file failed. path=%s, err={%w}", tmp, err)
+	}
+
+	if _, err := file.Write(content); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("write raft file failed. path=%s, err={%w}", tmp, err)
+	} else if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("sync raft file failed. path=%s, err={%w}", tmp, err)
+	} else if err := file.Close(); err != nil {
+		return fmt.Errorf("close raft file failed. path=%s, err={%w}", tmp, err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename raft file failed. path=%s, err={%w}", path, err)
+	}
+
+	return nil
+}
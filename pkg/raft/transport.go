@@ -0,0 +1,240 @@
+package raft
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+)
+
+const (
+	// tcpDialTimeout is the timeout of connecting a node
+	tcpDialTimeout = time.Second
+
+	// tcpCallTimeout is the timeout of a request, a snapshot may take a while
+	tcpCallTimeout = 10 * time.Second
+
+	// rpcServiceName is the name of the requests registered to net/rpc
+	rpcServiceName = "Raft"
+)
+
+// TCPTransport sends the requests by net/rpc over TCP, and serves the ones of the other nodes. The conn to
+// a node is dialed again once a request fails.
+type TCPTransport struct {
+	listener net.Listener
+	peers    map[string]string
+
+	mutex   sync.Mutex
+	clients map[string]*rpc.Client
+	conns   map[net.Conn]struct{}
+	closed  bool
+}
+
+// NewTCPTransport listens on the address for the other nodes, which are reached by the addresses of their
+// ids in peers
+func NewTCPTransport(addr string, peers map[string]string) (*TCPTransport, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen raft address failed. addr=%s, err={%w}", addr, err)
+	}
+
+	return &TCPTransport{
+		listener: l,
+		peers:    peers,
+		clients:  make(map[string]*rpc.Client),
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// rpcService is the receiver registered to net/rpc
+type rpcService struct {
+	handler Handler
+}
+
+func (s *rpcService) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	ret, err := s.handler.RequestVote(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = *ret
+	return nil
+}
+
+func (s *rpcService) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	ret, err := s.handler.AppendEntries(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = *ret
+	return nil
+}
+
+func (s *rpcService) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	ret, err := s.handler.InstallSnapshot(args)
+	if err != nil {
+		return err
+	}
+
+	*reply = *ret
+	return nil
+}
+
+// Serve serves the requests of the other nodes by the handler in the background until Close
+func (t *TCPTransport) Serve(handler Handler) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(rpcServiceName, &rpcService{handler: handler}); err != nil {
+		return fmt.Errorf("register raft service failed. err={%w}", err)
+	}
+
+	go func() {
+		for {
+			conn, err := t.listener.Accept()
+			if err != nil {
+				if !strings.HasSuffix(err.Error(), "use of closed network connection") {
+					common.Warnf("raft listen error. err=%s", err)
+				}
+				return
+			}
+
+			t.mutex.Lock()
+			if t.closed {
+				t.mutex.Unlock()
+				_ = conn.Close()
+				return
+			}
+			t.conns[conn] = struct{}{}
+			t.mutex.Unlock()
+
+			go func() {
+				server.ServeConn(conn)
+
+				t.mutex.Lock()
+				delete(t.conns, conn)
+				t.mutex.Unlock()
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// Addr returns the address listened
+func (t *TCPTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops serving and closes the conns from and to the other nodes, so they dial again once the node
+// restarts
+func (t *TCPTransport) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.closed {
+		return
+	}
+
+	t.closed = true
+	_ = t.listener.Close()
+	for _, client := range t.clients {
+		_ = client.Close()
+	}
+	t.clients = make(map[string]*rpc.Client)
+
+	for conn := range t.conns {
+		_ = conn.Close()
+	}
+}
+
+func (t *TCPTransport) RequestVote(peer string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	reply := &RequestVoteReply{}
+	return reply, t.call(peer, "RequestVote", args, reply)
+}
+
+func (t *TCPTransport) AppendEntries(peer string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	reply := &AppendEntriesReply{}
+	return reply, t.call(peer, "AppendEntries", args, reply)
+}
+
+func (t *TCPTransport) InstallSnapshot(peer string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	reply := &InstallSnapshotReply{}
+	return reply, t.call(peer, "InstallSnapshot", args, reply)
+}
+
+// call sends the request to the peer, the conn is dropped if it fails or times out
+func (t *TCPTransport) call(peer string, method string, args interface{}, reply interface{}) error {
+	client, err := t.client(peer)
+	if err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(tcpCallTimeout)
+	defer timer.Stop()
+
+	call := client.Go(rpcServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-timer.C:
+		err = fmt.Errorf("call raft node timeout. peer=%s, method=%s, err={%w}", peer, method, ErrUnreachable)
+	}
+
+	if err != nil {
+		t.drop(peer, client)
+	}
+
+	return err
+}
+
+// client returns the conn to the peer, which is dialed if absent
+func (t *TCPTransport) client(peer string) (*rpc.Client, error) {
+	t.mutex.Lock()
+	client, ok := t.clients[peer]
+	addr, known := t.peers[peer]
+	closed := t.closed
+	t.mutex.Unlock()
+
+	if ok {
+		return client, nil
+	} else if !known || closed {
+		return nil, fmt.Errorf("dial raft node failed. peer=%s, err={%w}", peer, ErrUnreachable)
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, tcpDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("dial raft node failed. peer=%s, addr=%s, err={%w}", peer, addr, err)
+	}
+
+	client = rpc.NewClient(conn)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// another request may dial it meanwhile
+	if existing, ok := t.clients[peer]; ok || t.closed {
+		_ = client.Close()
+		if !ok {
+			return nil, fmt.Errorf("dial raft node failed. peer=%s, err={%w}", peer, ErrUnreachable)
+		}
+		return existing, nil
+	}
+
+	t.clients[peer] = client
+	return client, nil
+}
+
+// drop closes the conn to the peer, so the next request dials again
+func (t *TCPTransport) drop(peer string, client *rpc.Client) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.clients[peer] == client {
+		delete(t.clients, peer)
+	}
+	_ = client.Close()
+}
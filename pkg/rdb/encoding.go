@@ -0,0 +1,340 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// lzfDecompress decompresses the LZF compressed data into length bytes
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 32 {
+			// a literal run of ctrl+1 bytes
+			ctrl++
+			if ip+ctrl > len(in) || len(out)+ctrl > length {
+				return nil, ErrInvalidFile
+			}
+
+			out = append(out, in[ip:ip+ctrl]...)
+			ip += ctrl
+			continue
+		}
+
+		// a back reference of n+2 bytes
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(in) {
+				return nil, ErrInvalidFile
+			}
+			n += int(in[ip])
+			ip++
+		}
+
+		if ip >= len(in) {
+			return nil, ErrInvalidFile
+		}
+
+		ref := len(out) - ((ctrl & 0x1f) << 8) - int(in[ip]) - 1
+		ip++
+		n += 2
+
+		if ref < 0 || len(out)+n > length {
+			return nil, ErrInvalidFile
+		}
+
+		// the reference may overlap the bytes being copied
+		for idx := 0; idx < n; idx++ {
+			out = append(out, out[ref+idx])
+		}
+	}
+
+	if len(out) != length {
+		return nil, ErrInvalidFile
+	}
+
+	return out, nil
+}
+
+// parseZiplist returns the entries of a ziplist, the integers are formatted in decimal:
+//
+//	represent: | zlbytes | zltail | zllen | entry ... | 0xff |
+//	bytes:          ^4        ^4      ^2
+//
+// An entry is the length of the previous entry in 1 or 5 bytes, its encoding, then its data.
+func parseZiplist(data []byte) ([]string, error) {
+	if len(data) < 11 || int(binary.LittleEndian.Uint32(data)) != len(data) {
+		return nil, fmt.Errorf("parse ziplist failed. err={%w}", ErrInvalidFile)
+	}
+
+	var entries []string
+	pos := 10
+
+	for pos < len(data) && data[pos] != 0xff {
+		// skip the length of the previous entry
+		if data[pos] < 254 {
+			pos++
+		} else {
+			pos += 5
+		}
+
+		entry, n, err := parseZiplistEntry(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("parse ziplist failed. offset=%d, err={%w}", pos, err)
+		}
+
+		entries = append(entries, entry)
+		pos += n
+	}
+
+	if pos != len(data)-1 {
+		return nil, fmt.Errorf("parse ziplist failed. err={%w}", ErrInvalidFile)
+	}
+
+	return entries, nil
+}
+
+// parseZiplistEntry returns an entry of a ziplist from its encoding and the bytes it takes
+func parseZiplistEntry(data []byte) (string, int, error) {
+	if len(data) == 0 {
+		return "", 0, ErrInvalidFile
+	}
+
+	b := data[0]
+	str := func(header int, length int) (string, int, error) {
+		if header+length > len(data) {
+			return "", 0, ErrInvalidFile
+		}
+		return string(data[header : header+length]), header + length, nil
+	}
+
+	switch {
+	case b>>6 == 0:
+		return str(1, int(b&0x3f))
+	case b>>6 == 1:
+		if len(data) < 2 {
+			return "", 0, ErrInvalidFile
+		}
+		return str(2, int(b&0x3f)<<8|int(data[1]))
+	case b == 0x80:
+		if len(data) < 5 {
+			return "", 0, ErrInvalidFile
+		}
+		return str(5, int(binary.BigEndian.Uint32(data[1:5])))
+	case b == 0xc0:
+		return ziplistInt(data, 2)
+	case b == 0xd0:
+		return ziplistInt(data, 4)
+	case b == 0xe0:
+		return ziplistInt(data, 8)
+	case b == 0xf0:
+		return ziplistInt(data, 3)
+	case b == 0xfe:
+		return ziplistInt(data, 1)
+	case b >= 0xf1 && b <= 0xfd:
+		return strconv.Itoa(int(b&0x0f) - 1), 1, nil
+	}
+
+	return "", 0, ErrInvalidFile
+}
+
+func ziplistInt(data []byte, size int) (string, int, error) {
+	if len(data) < 1+size {
+		return "", 0, ErrInvalidFile
+	}
+
+	return strconv.FormatInt(littleEndianInt(data[1:1+size]), 10), 1 + size, nil
+}
+
+// littleEndianInt returns the signed integer in the little endian bytes
+func littleEndianInt(data []byte) int64 {
+	var v uint64
+	for idx := len(data) - 1; idx >= 0; idx-- {
+		v = v<<8 | uint64(data[idx])
+	}
+
+	// extend the sign
+	shift := uint(64 - 8*len(data))
+	return int64(v<<shift) >> shift
+}
+
+// parseListpack returns the entries of a listpack, the integers are formatted in decimal:
+//
+//	represent: | total bytes | count | entry ... | 0xff |
+//	bytes:            ^4         ^2
+//
+// An entry is its encoding, its data, then the length of both in 1 to 5 bytes.
+func parseListpack(data []byte) ([]string, error) {
+	if len(data) < 7 || int(binary.LittleEndian.Uint32(data)) != len(data) {
+		return nil, fmt.Errorf("parse listpack failed. err={%w}", ErrInvalidFile)
+	}
+
+	var entries []string
+	pos := 6
+
+	for pos < len(data) && data[pos] != 0xff {
+		entry, n, err := parseListpackEntry(data[pos:])
+		if err != nil {
+			return nil, fmt.Errorf("parse listpack failed. offset=%d, err={%w}", pos, err)
+		}
+
+		entries = append(entries, entry)
+		pos += n + backlenSize(n)
+	}
+
+	if pos != len(data)-1 {
+		return nil, fmt.Errorf("parse listpack failed. err={%w}", ErrInvalidFile)
+	}
+
+	return entries, nil
+}
+
+// backlenSize returns the bytes taken by the length of an entry of a listpack
+func backlenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+
+	return 5
+}
+
+// parseListpackEntry returns an entry of a listpack and the bytes taken by its encoding and data
+func parseListpackEntry(data []byte) (string, int, error) {
+	if len(data) == 0 {
+		return "", 0, ErrInvalidFile
+	}
+
+	b := data[0]
+	str := func(header int, length int) (string, int, error) {
+		if header+length > len(data) {
+			return "", 0, ErrInvalidFile
+		}
+		return string(data[header : header+length]), header + length, nil
+	}
+
+	switch {
+	case b>>7 == 0:
+		return strconv.Itoa(int(b)), 1, nil
+	case b>>6 == 2:
+		return str(1, int(b&0x3f))
+	case b>>5 == 6:
+		if len(data) < 2 {
+			return "", 0, ErrInvalidFile
+		}
+
+		// a 13 bits signed integer
+		v := int(b&0x1f)<<8 | int(data[1])
+		if v >= 1<<12 {
+			v -= 1 << 13
+		}
+		return strconv.Itoa(v), 2, nil
+	case b>>4 == 14:
+		if len(data) < 2 {
+			return "", 0, ErrInvalidFile
+		}
+		return str(2, int(b&0x0f)<<8|int(data[1]))
+	case b == 0xf0:
+		if len(data) < 5 {
+			return "", 0, ErrInvalidFile
+		}
+		return str(5, int(binary.LittleEndian.Uint32(data[1:5])))
+	case b == 0xf1:
+		return ziplistInt(data, 2)
+	case b == 0xf2:
+		return ziplistInt(data, 3)
+	case b == 0xf3:
+		return ziplistInt(data, 4)
+	case b == 0xf4:
+		return ziplistInt(data, 8)
+	}
+
+	return "", 0, ErrInvalidFile
+}
+
+// parseIntset returns the members of an intset formatted in decimal:
+//
+//	represent: | encoding | length | member ... |
+//	bytes:          ^4        ^4      ^encoding
+func parseIntset(data []byte) ([]string, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("parse intset failed. err={%w}", ErrInvalidFile)
+	}
+
+	size := int(binary.LittleEndian.Uint32(data))
+	length := int(binary.LittleEndian.Uint32(data[4:]))
+
+	if (size != 2 && size != 4 && size != 8) || len(data) != 8+size*length {
+		return nil, fmt.Errorf("parse intset failed. encoding=%d, length=%d, err={%w}", size, length, ErrInvalidFile)
+	}
+
+	members := make([]string, 0, length)
+	for pos := 8; pos < len(data); pos += size {
+		members = append(members, strconv.FormatInt(littleEndianInt(data[pos:pos+size]), 10))
+	}
+
+	return members, nil
+}
+
+// parseZipmap returns the fields and values in turn of a zipmap, which is written by the versions before
+// 2.6 but may still be found in a file converted by redis-check-rdb:
+//
+//	represent: | count | length | field | length | free | value | free bytes | ... | 0xff |
+//	bytes:        ^1      ^1/5              ^1/5     ^1
+func parseZipmap(data []byte) ([]string, error) {
+	var items []string
+	pos := 1
+
+	readLen := func() (int, error) {
+		if pos >= len(data) {
+			return 0, ErrInvalidFile
+		}
+
+		if b := data[pos]; b < 254 {
+			pos++
+			return int(b), nil
+		} else if b == 254 && pos+5 <= len(data) {
+			n := int(binary.LittleEndian.Uint32(data[pos+1:]))
+			pos += 5
+			return n, nil
+		}
+
+		return 0, ErrInvalidFile
+	}
+
+	for pos < len(data) && data[pos] != 0xff {
+		n, err := readLen()
+		if err != nil || pos+n > len(data) {
+			return nil, fmt.Errorf("parse zipmap failed. err={%w}", ErrInvalidFile)
+		}
+		field := string(data[pos : pos+n])
+		pos += n
+
+		n, err = readLen()
+		if err != nil || pos+1+n > len(data) {
+			return nil, fmt.Errorf("parse zipmap failed. err={%w}", ErrInvalidFile)
+		}
+		free := int(data[pos])
+		value := string(data[pos+1 : pos+1+n])
+		pos += 1 + n + free
+
+		items = append(items, field, value)
+	}
+
+	if pos != len(data)-1 {
+		return nil, fmt.Errorf("parse zipmap failed. err={%w}", ErrInvalidFile)
+	}
+
+	return items, nil
+}
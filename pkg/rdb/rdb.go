@@ -0,0 +1,97 @@
+// Package rdb reads and writes the RDB dump files of redis, so a dataset can be moved between redis and
+// vertex. The files of version 6 to 10 are read, i.e., the ones written by redis 3.2 to 7.0, with the
+// strings, lists, hashes, sets and sorted sets in any of their encodings. The modules, streams and functions
+// can not be loaded. The files are written in version 9, which is read by redis 5.0 and later.
+package rdb
+
+import (
+	"errors"
+	"hash/crc64"
+)
+
+const (
+	// MinVersion and MaxVersion are the versions that can be read
+	MinVersion = 6
+	MaxVersion = 10
+
+	// DumpVersion is the version written
+	DumpVersion = 9
+
+	magic = "REDIS"
+)
+
+// The opcodes before a key or at the end of a db
+const (
+	opFunction2    = 245
+	opFunction     = 246
+	opModuleAux    = 247
+	opIdle         = 248
+	opFreq         = 249
+	opAux          = 250
+	opResizeDB     = 251
+	opExpireTimeMS = 252
+	opExpireTime   = 253
+	opSelectDB     = 254
+	opEOF          = 255
+)
+
+// The types of the values
+const (
+	typeString          = 0
+	typeList            = 1
+	typeSet             = 2
+	typeZSet            = 3
+	typeHash            = 4
+	typeZSet2           = 5
+	typeModule          = 6
+	typeModule2         = 7
+	typeHashZipmap      = 9
+	typeListZiplist     = 10
+	typeSetIntset       = 11
+	typeZSetZiplist     = 12
+	typeHashZiplist     = 13
+	typeListQuicklist   = 14
+	typeStreamListpacks = 15
+	typeHashListpack    = 16
+	typeZSetListpack    = 17
+	typeListQuicklist2  = 18
+	typeStreamListpack2 = 19
+)
+
+// The encodings of a string, which are told by the two high bits of the length are 11
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// The containers of a node of quicklist 2
+const (
+	quicklistPlain  = 1
+	quicklistPacked = 2
+)
+
+var (
+	// ErrInvalidFile will be raised if the file is not a rdb file, or is corrupted
+	ErrInvalidFile = errors.New("rdb: invalid rdb file")
+
+	// ErrUnsupportedVersion will be raised if the version of the file is not in [MinVersion, MaxVersion]
+	ErrUnsupportedVersion = errors.New("rdb: unsupported version")
+
+	// ErrUnsupportedType will be raised if the file holds a value that can not be loaded, e.g., a stream
+	ErrUnsupportedType = errors.New("rdb: unsupported value type")
+
+	// ErrChecksumMismatch will be raised if the checksum at the end is not the one of the content
+	ErrChecksumMismatch = errors.New("rdb: checksum mismatch")
+)
+
+// crcTable is the table of the crc64 used by redis, the Jones polynomial in the reversed form without the
+// initial and final inversions
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// updateCRC updates the crc64 of redis with the bytes
+func updateCRC(crc uint64, p []byte) uint64 {
+	// crc64.Update inverts the crc before and after, which cancels out
+	return ^crc64.Update(^crc, crcTable, p)
+}
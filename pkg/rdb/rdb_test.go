@@ -0,0 +1,267 @@
+package rdb_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/container"
+	. "github.com/lxdlam/vertex/pkg/rdb"
+	"github.com/stretchr/testify/assert"
+)
+
+type keyspaces map[int]container.Containers
+
+func (k keyspaces) get(index int) (container.Containers, error) {
+	if _, ok := k[index]; !ok {
+		k[index] = container.NewContainers()
+	}
+
+	return k[index], nil
+}
+
+func loadFile(t *testing.T, name string) (keyspaces, *Result) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k := make(keyspaces)
+	result, err := Load(bytes.NewReader(data), k.get)
+	assert.Nil(t, err, "file=%s", name)
+
+	return k, result
+}
+
+func stringOf(t *testing.T, ks container.Containers, key string) string {
+	s, ok := ks.Get(key).(*container.StringContainer)
+	if !ok {
+		t.Fatalf("key is not a string. key=%s", key)
+	}
+
+	return s.String()
+}
+
+func listOf(t *testing.T, ks container.Containers, key string) []string {
+	l := ks.GetList(key)
+	if l == nil {
+		t.Fatalf("key is not a list. key=%s", key)
+	}
+
+	elements, _ := l.Range(0, l.Len()-1)
+	return stringsOf(elements)
+}
+
+func hashOf(t *testing.T, ks container.Containers, key string) map[string]string {
+	h := ks.GetHash(key)
+	if h == nil {
+		t.Fatalf("key is not a hash. key=%s", key)
+	}
+
+	ret := make(map[string]string)
+	fields, values := h.Entries()
+	for idx := range fields {
+		ret[fields[idx].String()] = values[idx].String()
+	}
+
+	return ret
+}
+
+func setOf(t *testing.T, ks container.Containers, key string) []string {
+	s := ks.GetSet(key)
+	if s == nil {
+		t.Fatalf("key is not a set. key=%s", key)
+	}
+
+	members := stringsOf(s.Members())
+	sort.Strings(members)
+	return members
+}
+
+func zsetOf(t *testing.T, ks container.Containers, key string) ([]string, []float64) {
+	z := ks.GetSortedSet(key)
+	if z == nil {
+		t.Fatalf("key is not a sorted set. key=%s", key)
+	}
+
+	members, scores := z.RangeByRank(0, z.Len()-1)
+	return stringsOf(members), scores
+}
+
+func stringsOf(items []*container.StringContainer) []string {
+	var ret []string
+	for _, item := range items {
+		ret = append(ret, item.String())
+	}
+
+	return ret
+}
+
+func repeat(c string, n int) string {
+	return string(bytes.Repeat([]byte(c), n))
+}
+
+func TestLoadVersion6(t *testing.T) {
+	k, result := loadFile(t, "v6.rdb")
+
+	assert.Equal(t, 6, result.Version)
+	assert.Equal(t, "3.2.12", result.Aux["redis-ver"])
+	assert.Equal(t, 12, result.Keys)
+	assert.Equal(t, 1, result.Expired)
+
+	ks := k[0]
+	assert.Equal(t, "hello", stringOf(t, ks, "string"))
+	assert.Equal(t, "-12345", stringOf(t, ks, "int"))
+	assert.Equal(t, "7", stringOf(t, ks, "small"))
+	assert.Equal(t, repeat("abc", 10), stringOf(t, ks, "compressed"))
+
+	assert.Equal(t, []string{"a", "0", "12", "-1", "200", "-30000", "1048576", "-1073741824", "1099511627776",
+		repeat("x", 100), repeat("y", 5000), "z"}, listOf(t, ks, "quicklist"))
+	assert.Equal(t, map[string]string{"f1": "v1", "f2": "2"}, hashOf(t, ks, "hash"))
+	assert.Equal(t, []string{"-2", "1", "70000"}, setOf(t, ks, "intset"))
+	assert.Equal(t, []string{"m1", "m2"}, setOf(t, ks, "set"))
+
+	members, scores := zsetOf(t, ks, "zset")
+	assert.Equal(t, []string{"c", "a", "b"}, members)
+	assert.Equal(t, []float64{-3, 1, 2.5}, scores)
+
+	members, scores = zsetOf(t, ks, "zset1")
+	assert.Equal(t, []string{"z", "x", "y"}, members)
+	assert.Equal(t, []float64{math.Inf(-1), 1.5, math.Inf(1)}, scores)
+
+	assert.Equal(t, "v", stringOf(t, ks, "volatile"))
+	deadline, ok := ks.Deadline("volatile")
+	assert.True(t, ok)
+	assert.Equal(t, int64(4102444800000), deadline)
+	assert.Nil(t, ks.Get("expired"))
+
+	assert.Equal(t, map[string]string{"a": "1", "b": "2"}, hashOf(t, k[2], "hash"))
+}
+
+func TestLoadVersion9(t *testing.T) {
+	k, result := loadFile(t, "v9.rdb")
+
+	assert.Equal(t, 9, result.Version)
+	assert.Equal(t, "1700000000", result.Aux["ctime"])
+	assert.Equal(t, 6, result.Keys)
+
+	ks := k[0]
+	members, scores := zsetOf(t, ks, "zset2")
+	assert.Equal(t, []string{"a", "b"}, members)
+	assert.Equal(t, []float64{-0.5, math.Inf(1)}, scores)
+
+	assert.Equal(t, []string{"1", "2", "three"}, listOf(t, ks, "ziplist"))
+	assert.Equal(t, []string{"l1", "l2"}, listOf(t, ks, "list"))
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "value2"}, hashOf(t, ks, "zipmap"))
+	assert.Equal(t, []string{"-5", "3"}, setOf(t, ks, "intset16"))
+	assert.Equal(t, []string{"1099511627776"}, setOf(t, ks, "intset64"))
+}
+
+func TestLoadVersion10(t *testing.T) {
+	k, result := loadFile(t, "v10.rdb")
+
+	assert.Equal(t, 10, result.Version)
+	assert.Equal(t, 1, result.Functions)
+	assert.Equal(t, 3, result.Keys)
+
+	ks := k[3]
+	assert.Equal(t, map[string]string{"f1": "v1", "n": "-100", "big": "100000", "long": repeat("w", 200)},
+		hashOf(t, ks, "hash"))
+
+	members, scores := zsetOf(t, ks, "zset")
+	assert.Equal(t, []string{"c", "b", "a", "d"}, members)
+	assert.Equal(t, []float64{-4000, 0.25, 1, 5000000000}, scores)
+
+	assert.Equal(t, []string{"a", "1", "-1", "300", repeat("y", 5000), "tail"}, listOf(t, ks, "list"))
+}
+
+func TestLoadCorrupted(t *testing.T) {
+	for _, name := range []string{"v6.rdb", "v9.rdb", "v10.rdb"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// every truncation fails
+		for idx := 0; idx < len(data); idx++ {
+			_, err := Load(bytes.NewReader(data[:idx]), make(keyspaces).get)
+			assert.NotNil(t, err, "file=%s, len=%d", name, idx)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "v6.rdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the value of the key "string" is changed
+	corrupted := bytes.Replace(data, []byte("hello"), []byte("jello"), 1)
+	_, err = Load(bytes.NewReader(corrupted), make(keyspaces).get)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch), "err=%v", err)
+
+	corrupted = append([]byte(nil), data...)
+	copy(corrupted, "REDIS0011")
+	_, err = Load(bytes.NewReader(corrupted), make(keyspaces).get)
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+
+	_, err = Load(bytes.NewReader([]byte("*1\r\n$4\r\nping\r\n")), make(keyspaces).get)
+	assert.True(t, errors.Is(err, ErrInvalidFile))
+
+	// a stream after the header
+	stream := append([]byte("REDIS0009"), 15, 1, 's')
+	_, err = Load(bytes.NewReader(stream), make(keyspaces).get)
+	assert.True(t, errors.Is(err, ErrUnsupportedType), "err=%v", err)
+}
+
+func TestDump(t *testing.T) {
+	k := make(keyspaces)
+
+	ks, _ := k.get(0)
+	assert.Nil(t, ks.Global().Set(
+		[]*container.StringContainer{container.NewString("string"), container.NewString("int"), container.NewString("large")},
+		[]*container.StringContainer{container.NewString("value"), container.NewString("-70000"), container.NewString("12345678901")}))
+	_, _ = ks.GetOrCreateList("list").PushTail([]*container.StringContainer{container.NewString("a"), container.NewString(repeat("b", 20000))})
+	_, _ = ks.GetOrCreateHash("hash").Set([]*container.StringContainer{container.NewString("f")}, []*container.StringContainer{container.NewString("v")})
+	ks.GetOrCreateSet("set").Add([]*container.StringContainer{container.NewString("1"), container.NewString("m")})
+	_, _ = ks.GetOrCreateSortedSet("zset").Add([]float64{math.Inf(-1), 0.1}, []*container.StringContainer{container.NewString("a"), container.NewString("b")})
+
+	deadline := time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond)
+	ks.SetDeadline("string", deadline)
+
+	other, _ := k.get(9)
+	assert.Nil(t, other.Global().Set([]*container.StringContainer{container.NewString("")}, []*container.StringContainer{container.NewString("empty key")}))
+
+	var buf bytes.Buffer
+	assert.Nil(t, Dump(&buf, 16, func(index int) container.Containers {
+		return k[index]
+	}))
+	assert.True(t, bytes.HasPrefix(buf.Bytes(), []byte("REDIS0009")))
+
+	loaded := make(keyspaces)
+	result, err := Load(&buf, loaded.get)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, result.Keys)
+
+	ks = loaded[0]
+	assert.Equal(t, "value", stringOf(t, ks, "string"))
+	assert.Equal(t, "-70000", stringOf(t, ks, "int"))
+	assert.Equal(t, "12345678901", stringOf(t, ks, "large"))
+	assert.Equal(t, []string{"a", repeat("b", 20000)}, listOf(t, ks, "list"))
+	assert.Equal(t, map[string]string{"f": "v"}, hashOf(t, ks, "hash"))
+	assert.Equal(t, []string{"1", "m"}, setOf(t, ks, "set"))
+
+	members, scores := zsetOf(t, ks, "zset")
+	assert.Equal(t, []string{"a", "b"}, members)
+	assert.Equal(t, []float64{math.Inf(-1), 0.1}, scores)
+
+	d, ok := ks.Deadline("string")
+	assert.True(t, ok)
+	assert.Equal(t, deadline, d)
+
+	assert.Equal(t, "empty key", stringOf(t, loaded[9], ""))
+}
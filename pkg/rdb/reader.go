@@ -0,0 +1,588 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/container"
+)
+
+// maxStringLen is the max length of a string read, so a corrupted length does not allocate too much
+const maxStringLen = 512 * 1024 * 1024
+
+// Result is the summary of a loaded file
+type Result struct {
+	Version int
+
+	// Aux is the auxiliary fields, e.g., redis-ver and ctime
+	Aux map[string]string
+
+	// Keys is the count of the loaded keys, and Expired is the count of the keys skipped as they are expired
+	Keys    int
+	Expired int
+
+	// Functions is the count of the functions skipped, as they can not be run
+	Functions int
+}
+
+// value is a value read from the file, the items are the elements of a list or a set, the fields and
+// values in turn of a hash, or the members of a sorted set whose scores are in scores
+type value struct {
+	kind   container.ContainerType
+	items  []string
+	scores []float64
+}
+
+type decoder struct {
+	reader *bufio.Reader
+	crc    uint64
+	buf    [8]byte
+}
+
+// Read reads from the file and updates the checksum
+func (d *decoder) Read(p []byte) (int, error) {
+	n, err := d.reader.Read(p)
+	d.crc = updateCRC(d.crc, p[:n])
+	return n, err
+}
+
+func (d *decoder) readByte() (byte, error) {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return 0, unexpected(err)
+	}
+
+	d.buf[0] = b
+	d.crc = updateCRC(d.crc, d.buf[:1])
+
+	return b, nil
+}
+
+func (d *decoder) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d, buf); err != nil {
+		return nil, unexpected(err)
+	}
+
+	return buf, nil
+}
+
+// unexpected reports an unexpected end as an invalid file
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrInvalidFile
+	}
+
+	return err
+}
+
+// readLength reads a length, or the encoding of a string if encoded is true:
+//
+//	00xxxxxx: 6 bits, 01xxxxxx xxxxxxxx: 14 bits, 0x80: 32 bits, 0x81: 64 bits, 11xxxxxx: encoding
+//
+// The integers of 14, 32 and 64 bits are big endian.
+func (d *decoder) readLength() (uint64, bool, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3f), false, nil
+	case 1:
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	if b == 0x80 {
+		buf, err := d.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	} else if b == 0x81 {
+		buf, err := d.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+
+	return 0, false, ErrInvalidFile
+}
+
+// readLen reads a length that is not an encoding
+func (d *decoder) readLen() (int, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return 0, err
+	} else if encoded || n > maxStringLen {
+		return 0, ErrInvalidFile
+	}
+
+	return int(n), nil
+}
+
+// readString reads a string, which may be encoded as an integer or compressed by LZF
+func (d *decoder) readString() (string, error) {
+	n, encoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+
+	if !encoded {
+		if n > maxStringLen {
+			return "", ErrInvalidFile
+		}
+
+		buf, err := d.readFull(int(n))
+		return string(buf), err
+	}
+
+	switch n {
+	case encInt8, encInt16, encInt32:
+		buf, err := d.readFull(1 << n)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(littleEndianInt(buf), 10), nil
+	case encLZF:
+		compressed, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+
+		length, err := d.readLen()
+		if err != nil {
+			return "", err
+		}
+
+		buf, err := d.readFull(compressed)
+		if err != nil {
+			return "", err
+		}
+
+		data, err := lzfDecompress(buf, length)
+		return string(data), err
+	}
+
+	return "", ErrInvalidFile
+}
+
+// readStrings reads n strings
+func (d *decoder) readStrings(n int) ([]string, error) {
+	var ret []string
+	for idx := 0; idx < n; idx++ {
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+
+	return ret, nil
+}
+
+// readDoubleString reads a score of the sorted sets in version 1, which is its text with the length in a
+// byte, or 253, 254 and 255 for nan, +inf and -inf
+func (d *decoder) readDoubleString() (float64, error) {
+	n, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+
+	buf, err := d.readFull(int(n))
+	if err != nil {
+		return 0, err
+	}
+
+	return parseScore(string(buf))
+}
+
+func parseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse score failed. score=%q, err={%w}", s, ErrInvalidFile)
+	}
+
+	return score, nil
+}
+
+// readValue reads the value of the type
+func (d *decoder) readValue(t byte) (*value, error) {
+	switch t {
+	case typeString:
+		s, err := d.readString()
+		return &value{kind: container.StringType, items: []string{s}}, err
+	case typeList, typeSet, typeHash:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+
+		v := &value{kind: container.LinkedListType}
+		if t == typeSet {
+			v.kind = container.SetType
+		} else if t == typeHash {
+			v.kind = container.HashType
+			n *= 2
+		}
+
+		v.items, err = d.readStrings(n)
+		return v, err
+	case typeZSet, typeZSet2:
+		n, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+
+		v := &value{kind: container.SortedSetType}
+		for idx := 0; idx < n; idx++ {
+			member, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+
+			var score float64
+			if t == typeZSet {
+				score, err = d.readDoubleString()
+			} else if _, err = io.ReadFull(d, d.buf[:8]); err == nil {
+				score = math.Float64frombits(binary.LittleEndian.Uint64(d.buf[:8]))
+			}
+
+			if err != nil {
+				return nil, unexpected(err)
+			}
+
+			v.items = append(v.items, member)
+			v.scores = append(v.scores, score)
+		}
+
+		return v, nil
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZSetZiplist, typeHashZiplist, typeHashListpack, typeZSetListpack:
+		return d.readPacked(t)
+	case typeListQuicklist, typeListQuicklist2:
+		return d.readQuicklist(t)
+	case typeModule, typeModule2, typeStreamListpacks, typeStreamListpack2:
+		return nil, fmt.Errorf("type=%d, err={%w}", t, ErrUnsupportedType)
+	}
+
+	return nil, fmt.Errorf("type=%d, err={%w}", t, ErrInvalidFile)
+}
+
+// readPacked reads a value packed into a string
+func (d *decoder) readPacked(t byte) (*value, error) {
+	blob, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte(blob)
+	v := &value{}
+
+	switch t {
+	case typeHashZipmap:
+		v.kind = container.HashType
+		v.items, err = parseZipmap(data)
+	case typeListZiplist:
+		v.kind = container.LinkedListType
+		v.items, err = parseZiplist(data)
+	case typeSetIntset:
+		v.kind = container.SetType
+		v.items, err = parseIntset(data)
+	case typeHashZiplist, typeHashListpack:
+		v.kind = container.HashType
+		if t == typeHashZiplist {
+			v.items, err = parseZiplist(data)
+		} else {
+			v.items, err = parseListpack(data)
+		}
+
+		if err == nil && len(v.items)%2 != 0 {
+			err = ErrInvalidFile
+		}
+	case typeZSetZiplist, typeZSetListpack:
+		var entries []string
+		if t == typeZSetZiplist {
+			entries, err = parseZiplist(data)
+		} else {
+			entries, err = parseListpack(data)
+		}
+
+		if err == nil && len(entries)%2 != 0 {
+			err = ErrInvalidFile
+		}
+
+		v.kind = container.SortedSetType
+		for idx := 0; err == nil && idx < len(entries); idx += 2 {
+			var score float64
+			if score, err = parseScore(entries[idx+1]); err == nil {
+				v.items = append(v.items, entries[idx])
+				v.scores = append(v.scores, score)
+			}
+		}
+	}
+
+	return v, err
+}
+
+// readQuicklist reads a list of nodes, each is a ziplist in version 1. In version 2 each is a listpack, or
+// a plain element if it is large.
+func (d *decoder) readQuicklist(t byte) (*value, error) {
+	n, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &value{kind: container.LinkedListType}
+	for idx := 0; idx < n; idx++ {
+		packed := t == typeListQuicklist
+		if t == typeListQuicklist2 {
+			c, err := d.readLen()
+			if err != nil {
+				return nil, err
+			} else if c != quicklistPlain && c != quicklistPacked {
+				return nil, fmt.Errorf("quicklist container=%d, err={%w}", c, ErrInvalidFile)
+			}
+			packed = c == quicklistPacked
+		}
+
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		if !packed {
+			v.items = append(v.items, blob)
+			continue
+		}
+
+		var items []string
+		if t == typeListQuicklist {
+			items, err = parseZiplist([]byte(blob))
+		} else {
+			items, err = parseListpack([]byte(blob))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		v.items = append(v.items, items...)
+	}
+
+	return v, nil
+}
+
+// readHeader reads the magic and the version
+func (d *decoder) readHeader() (int, error) {
+	buf, err := d.readFull(9)
+	if err != nil {
+		return 0, err
+	} else if string(buf[:5]) != magic {
+		return 0, ErrInvalidFile
+	}
+
+	version, err := strconv.Atoi(string(buf[5:]))
+	if err != nil {
+		return 0, ErrInvalidFile
+	} else if version < MinVersion || version > MaxVersion {
+		return version, fmt.Errorf("version=%d, err={%w}", version, ErrUnsupportedVersion)
+	}
+
+	return version, nil
+}
+
+// Load reads a rdb file into the keyspaces of the dbs, which are given by the function. The keys already
+// in the keyspaces are overwritten, and the expired keys are skipped. The keys loaded before an error are
+// kept.
+func Load(reader io.Reader, keyspace func(int) (container.Containers, error)) (*Result, error) {
+	d := &decoder{
+		reader: bufio.NewReader(reader),
+	}
+
+	result := &Result{
+		Aux: make(map[string]string),
+	}
+
+	var err error
+	if result.Version, err = d.readHeader(); err != nil {
+		return result, fmt.Errorf("read rdb header failed. err={%w}", err)
+	}
+
+	if err := d.load(result, keyspace); err != nil {
+		return result, fmt.Errorf("load rdb failed. err={%w}", err)
+	}
+
+	return result, nil
+}
+
+func (d *decoder) load(result *Result, keyspace func(int) (container.Containers, error)) error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	deadline := int64(-1)
+
+	var current container.Containers
+	index := 0
+
+	for {
+		op, err := d.readByte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opEOF:
+			return d.verify()
+		case opSelectDB:
+			if index, err = d.readLen(); err != nil {
+				return err
+			}
+			current = nil
+		case opResizeDB:
+			if _, err = d.readLen(); err == nil {
+				_, err = d.readLen()
+			}
+		case opAux:
+			var key, value string
+			if key, err = d.readString(); err == nil {
+				value, err = d.readString()
+			}
+			result.Aux[key] = value
+		case opExpireTimeMS:
+			if _, err = io.ReadFull(d, d.buf[:8]); err == nil {
+				deadline = int64(binary.LittleEndian.Uint64(d.buf[:8]))
+			}
+		case opExpireTime:
+			if _, err = io.ReadFull(d, d.buf[:4]); err == nil {
+				deadline = int64(int32(binary.LittleEndian.Uint32(d.buf[:4]))) * 1000
+			}
+		case opIdle:
+			_, err = d.readLen()
+		case opFreq:
+			_, err = d.readByte()
+		case opFunction2:
+			if _, err = d.readString(); err == nil {
+				result.Functions++
+			}
+		case opFunction, opModuleAux:
+			return fmt.Errorf("opcode=%d, err={%w}", op, ErrUnsupportedType)
+		default:
+			key, err := d.readString()
+			if err != nil {
+				return err
+			}
+
+			v, err := d.readValue(op)
+			if err != nil {
+				return fmt.Errorf("read value failed. key=%s, err={%w}", key, err)
+			}
+
+			if deadline >= 0 && deadline <= now {
+				result.Expired++
+				deadline = -1
+				continue
+			}
+
+			if current == nil {
+				if current, err = keyspace(index); err != nil {
+					return fmt.Errorf("select db failed. index=%d, err={%w}", index, err)
+				}
+			}
+
+			if err := apply(current, key, v, deadline); err != nil {
+				return fmt.Errorf("load key failed. key=%s, err={%w}", key, err)
+			}
+
+			result.Keys++
+			deadline = -1
+		}
+
+		if err != nil {
+			return unexpected(err)
+		}
+	}
+}
+
+// verify reads the checksum after the end, a checksum of 0 means it is disabled
+func (d *decoder) verify() error {
+	expected := d.crc
+
+	if _, err := io.ReadFull(d.reader, d.buf[:8]); err != nil {
+		return unexpected(err)
+	}
+
+	if actual := binary.LittleEndian.Uint64(d.buf[:8]); actual != 0 && actual != expected {
+		return fmt.Errorf("checksum=%016x, expected=%016x, err={%w}", actual, expected, ErrChecksumMismatch)
+	}
+
+	return nil
+}
+
+// apply sets the key to the value in the keyspace
+func apply(keyspace container.Containers, key string, v *value, deadline int64) error {
+	keyspace.Remove(key)
+
+	values := make([]*container.StringContainer, 0, len(v.items))
+	for _, item := range v.items {
+		values = append(values, container.NewString(item))
+	}
+
+	var err error
+
+	switch v.kind {
+	case container.StringType:
+		err = keyspace.Global().Set([]*container.StringContainer{container.NewString(key)}, values)
+	case container.LinkedListType:
+		if l := keyspace.GetOrCreateList(key); l != nil {
+			_, err = l.PushTail(values)
+		}
+	case container.HashType:
+		if h := keyspace.GetOrCreateHash(key); h != nil {
+			var fields, items []*container.StringContainer
+			for idx := 0; idx+1 < len(values); idx += 2 {
+				fields = append(fields, values[idx])
+				items = append(items, values[idx+1])
+			}
+			_, err = h.Set(fields, items)
+		}
+	case container.SetType:
+		if s := keyspace.GetOrCreateSet(key); s != nil {
+			s.Add(values)
+		}
+	case container.SortedSetType:
+		if z := keyspace.GetOrCreateSortedSet(key); z != nil {
+			_, err = z.Add(v.scores, values)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	keyspace.RemoveIfEmpty(key)
+	if deadline >= 0 {
+		keyspace.SetDeadline(key, deadline)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+	"github.com/lxdlam/vertex/pkg/container"
+)
+
+type encoder struct {
+	writer *bufio.Writer
+	crc    uint64
+	buf    [9]byte
+	err    error
+}
+
+// write writes the bytes and updates the checksum, the first error is kept and the writes after it are
+// ignored
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+
+	e.crc = updateCRC(e.crc, p)
+	_, e.err = e.writer.Write(p)
+}
+
+func (e *encoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.write(e.buf[:1])
+}
+
+// writeLength writes a length in the shortest form
+func (e *encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.buf[0], e.buf[1] = byte(n>>8)|0x40, byte(n)
+		e.write(e.buf[:2])
+	case n <= math.MaxUint32:
+		e.buf[0] = 0x80
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(n))
+		e.write(e.buf[:5])
+	default:
+		e.buf[0] = 0x81
+		binary.BigEndian.PutUint64(e.buf[1:], n)
+		e.write(e.buf[:9])
+	}
+}
+
+// writeString writes a string, the ones that are integers in 32 bits are written as the integers
+func (e *encoder) writeString(s string) {
+	if v, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(v, 10) == s {
+		switch {
+		case v >= math.MinInt8 && v <= math.MaxInt8:
+			e.buf[0], e.buf[1] = 0xc0|encInt8, byte(v)
+			e.write(e.buf[:2])
+		case v >= math.MinInt16 && v <= math.MaxInt16:
+			e.buf[0] = 0xc0 | encInt16
+			binary.LittleEndian.PutUint16(e.buf[1:], uint16(v))
+			e.write(e.buf[:3])
+		default:
+			e.buf[0] = 0xc0 | encInt32
+			binary.LittleEndian.PutUint32(e.buf[1:], uint32(v))
+			e.write(e.buf[:5])
+		}
+		return
+	}
+
+	e.writeLength(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) writeStrings(items []*container.StringContainer) {
+	e.writeLength(uint64(len(items)))
+	for _, item := range items {
+		e.writeString(item.String())
+	}
+}
+
+func (e *encoder) writeAux(key string, value string) {
+	e.writeByte(opAux)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+// writeKey writes the key with its value and deadline, false if the key is not exist
+func (e *encoder) writeKey(keyspace container.Containers, key string) bool {
+	obj := keyspace.Get(key)
+	if obj == nil {
+		return false
+	}
+
+	if deadline, ok := keyspace.Deadline(key); ok {
+		e.buf[0] = opExpireTimeMS
+		binary.LittleEndian.PutUint64(e.buf[1:], uint64(deadline))
+		e.write(e.buf[:9])
+	}
+
+	switch obj := obj.(type) {
+	case *container.StringContainer:
+		e.writeByte(typeString)
+		e.writeString(key)
+		e.writeString(obj.String())
+	case container.ListContainer:
+		elements, _ := obj.Range(0, obj.Len()-1)
+		e.writeByte(typeList)
+		e.writeString(key)
+		e.writeStrings(elements)
+	case container.HashContainer:
+		fields, values := obj.Entries()
+		e.writeByte(typeHash)
+		e.writeString(key)
+		e.writeLength(uint64(len(fields)))
+		for idx := range fields {
+			e.writeString(fields[idx].String())
+			e.writeString(values[idx].String())
+		}
+	case container.SetContainer:
+		e.writeByte(typeSet)
+		e.writeString(key)
+		e.writeStrings(obj.Members())
+	case container.SortedSetContainer:
+		members, scores := obj.RangeByRank(0, obj.Len()-1)
+		e.writeByte(typeZSet2)
+		e.writeString(key)
+		e.writeLength(uint64(len(members)))
+		for idx := range members {
+			e.writeString(members[idx].String())
+			binary.LittleEndian.PutUint64(e.buf[:8], math.Float64bits(scores[idx]))
+			e.write(e.buf[:8])
+		}
+	}
+
+	return true
+}
+
+// Dump writes the keyspaces of the dbs in [0, databases) into a rdb file, the function returns nil for an
+// empty db. The expired keys are skipped.
+func Dump(writer io.Writer, databases int, keyspace func(int) container.Containers) error {
+	e := &encoder{
+		writer: bufio.NewWriter(writer),
+	}
+
+	e.write([]byte(fmt.Sprintf("%s%04d", magic, DumpVersion)))
+	e.writeAux("redis-ver", common.Version)
+	e.writeAux("redis-bits", strconv.Itoa(32<<(^uint(0)>>63)))
+	e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+
+	for index := 0; index < databases; index++ {
+		ks := keyspace(index)
+		if ks == nil {
+			continue
+		}
+
+		keys := ks.Keys("*")
+		if len(keys) == 0 {
+			continue
+		}
+
+		expires := 0
+		for _, key := range keys {
+			if _, ok := ks.Deadline(key); ok {
+				expires++
+			}
+		}
+
+		e.writeByte(opSelectDB)
+		e.writeLength(uint64(index))
+		e.writeByte(opResizeDB)
+		e.writeLength(uint64(len(keys)))
+		e.writeLength(uint64(expires))
+
+		for _, key := range keys {
+			e.writeKey(ks, key)
+		}
+	}
+
+	e.writeByte(opEOF)
+
+	// the checksum is not a part of itself
+	binary.LittleEndian.PutUint64(e.buf[:8], e.crc)
+	e.write(e.buf[:8])
+
+	if e.err == nil {
+		e.err = e.writer.Flush()
+	}
+
+	if e.err != nil {
+		return fmt.Errorf("dump rdb failed. err={%w}", e.err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package replication
+
+// backlog keeps the last bytes of the replication stream in a circular buffer, so a replica reconnected
+// shortly resumes from its offset instead of a full resync
+type backlog struct {
+	buf []byte
+
+	// pos is where the next byte is written, and size is the bytes held
+	pos  int
+	size int
+
+	// offset is the replication offset of the byte after the last one written
+	offset int64
+}
+
+func newBacklog(size int, offset int64) *backlog {
+	return &backlog{
+		buf:    make([]byte, size),
+		offset: offset,
+	}
+}
+
+// write appends the bytes, the oldest ones are overwritten once the buffer is full
+func (b *backlog) write(p []byte) {
+	b.offset += int64(len(p))
+
+	// only the tail of a write larger than the buffer is kept
+	if len(p) > len(b.buf) {
+		p = p[len(p)-len(b.buf):]
+	}
+
+	for len(p) > 0 {
+		n := copy(b.buf[b.pos:], p)
+		p = p[n:]
+		b.pos = (b.pos + n) % len(b.buf)
+		b.size += n
+	}
+
+	if b.size > len(b.buf) {
+		b.size = len(b.buf)
+	}
+}
+
+// since returns the bytes after the offset, false if some of them are not held any more
+func (b *backlog) since(offset int64) ([]byte, bool) {
+	if offset > b.offset || offset < b.offset-int64(b.size) {
+		return nil, false
+	}
+
+	n := int(b.offset - offset)
+	ret := make([]byte, 0, n)
+
+	start := (b.pos - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		ret = append(ret, b.buf[start:start+n]...)
+	} else {
+		ret = append(ret, b.buf[start:]...)
+		ret = append(ret, b.buf[:n-(len(b.buf)-start)]...)
+	}
+
+	return ret, true
+}
@@ -1,46 +1,185 @@
 package replication
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/binary"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lxdlam/vertex/pkg/common"
-	"github.com/lxdlam/vertex/pkg/log"
-	"github.com/lxdlam/vertex/pkg/util"
+	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
-func init() {
-	// Ensure the dir is exist
-	_ = os.Mkdir("/tmp/vertex", 0755)
+// replicaBufferLimit is the max bytes pending to be sent to a replica, a replica falling behind it is
+// disconnected and resyncs later
+const replicaBufferLimit = 256 * 1024 * 1024
+
+// syncTransferKeep is how long the spill of the last full resync is kept after it is sent, so a replica
+// interrupted in the transfer resumes it
+const syncTransferKeep = time.Minute
+
+var (
+	// ErrInvalidSyncRequest will be raised if a replica sends anything but PSYNC replid offset
+	ErrInvalidSyncRequest = errors.New("replication: invalid sync request")
+
+	// ErrReplicaTooSlow will be raised if the bytes pending to a replica exceed the limit
+	ErrReplicaTooSlow = errors.New("replication: replica buffer limit reached")
+
+	// ErrReplicaClosed will be raised if feed a replica which is disconnected
+	ErrReplicaClosed = errors.New("replication: replica is closed")
+)
+
+// Dataset builds the records of the whole dataset for a full resync
+type Dataset interface {
+	// SyncSnapshot gives the records rebuilding the dataset after the header of the file to the write round
+	// by round. The attach is called with the modifications blocked at the moment the records are a
+	// snapshot of, so the stream fed after it follows them exactly. The dump stops once the write fails.
+	SyncSnapshot(attach func(), write func([]byte) error) error
+
+	// Acknowledged is called once a replica acknowledges the offset it has applied, the mutex of the
+	// master is not held.
+	Acknowledged()
 }
 
+// Master sends the dataset to the replicas, then streams the records fed to it. Every byte fed advances the
+// replication offset, and the last bytes are kept in a backlog, so a replica reconnected with the
+// replication id and an offset in the backlog only receives the bytes it missed.
+//
+// The dataset is sent as a log file in checksummed chunks as it is dumped, see chunkWriter. The last one
+// sent is spilled and kept for a while, so a replica interrupted in the transfer resumes it from the position
+// it has applied, as long as the stream after it is still in the backlog. The sync request of a replica and
+// its replies are:
+//
+//	request:  PSYNC <replid> <offset>, which is PSYNC ? -1 for a new replica
+//	          PSYNC <replid> <offset> <position>, which resumes the transfer of the dataset at the offset
+//	reply:    +FULLRESYNC <replid> <offset> -1\r\n, then the chunks and the stream from the offset, the size
+//	          is unknown until the dump ends
+//	          +RESUMESYNC <replid> <offset> <size> <position>\r\n, then the chunks from the position and the
+//	          stream from the offset
+//	          +CONTINUE <replid>\r\n, then the stream from the offset of the request
+//
+// Once the stream starts, the replica sends REPLCONF ACK <offset> with the offset it has applied, after the
+// records received at once are applied and every replicaAckInterval.
 type Master interface {
 	Start()
 	Stop()
 
-	SetFile(*log.PersistentFile, string)
+	// Feed appends the packed records to the stream, it should be called in the order they are executed.
+	Feed([]byte)
+
+	// SetBacklogSize sets the size of the backlog, the bytes kept are dropped.
+	SetBacklogSize(int)
+
+	// Status returns the position of the stream, the backlog and the replicas connected.
+	Status() MasterStatus
+
+	// AckedReplicas returns the count of the replicas which acknowledge the offset.
+	AckedReplicas(int64) int
+
+	// GoodReplicas returns the count of the replicas receiving the stream, whose last acknowledgement is
+	// within the lag.
+	GoodReplicas(time.Duration) int
 
 	master()
 }
 
+// The states of a replica connected, named as the ones in INFO of redis
+const (
+	// ConnectedStateSendBulk means the dataset is being sent to the replica
+	ConnectedStateSendBulk = "send_bulk"
+
+	// ConnectedStateOnline means the replica is receiving the stream
+	ConnectedStateOnline = "online"
+)
+
+// MasterStatus is the state of the stream of a master.
+type MasterStatus struct {
+	// ReplicationID and Offset are the position of the stream.
+	ReplicationID string
+	Offset        int64
+
+	// BacklogSize is the size of the backlog, BacklogHistlen is the bytes held, and BacklogFirstOffset is
+	// the offset of the first byte held, which counts from 1 as redis does.
+	BacklogSize        int
+	BacklogFirstOffset int64
+	BacklogHistlen     int
+
+	Replicas []ConnectedReplica
+}
+
+// ConnectedReplica is the state of a replica connected to the master.
+type ConnectedReplica struct {
+	// Addr is the remote address of the conn.
+	Addr string
+
+	// State is one of the ConnectedState constants.
+	State string
+
+	// AckOffset is the offset acknowledged by the replica, and AckTime is the time of the acknowledgement,
+	// which is the time the stream starts before the first one.
+	AckOffset int64
+	AckTime   time.Time
+}
+
 type master struct {
 	listener *net.TCPListener
-	conn     map[string]net.Conn
+	dataset  Dataset
 	mutex    sync.Mutex
-	file     *log.PersistentFile
-	filePath string
+	replID   string
+	offset   int64
+	backlog  *backlog
+	replicas map[string]*replicaConn
+	transfer *syncTransfer
 	started  int32
 	shutdown int32
 }
 
-func NewMaster(addr *net.TCPAddr) Master {
+// syncTransfer is the dataset sent by the last full resync, which is built at the offset. The done is closed
+// once the dump ends, then the spill holds the whole dataset unless it is dropped.
+type syncTransfer struct {
+	replID   string
+	offset   int64
+	spill    *syncSpill
+	done     chan struct{}
+	deadline time.Time
+}
+
+// syncStream writes the records of a full resync to the replica as they are dumped, and spills them for
+// resuming. The dump goes on for the spill once the replica is gone, until the spill is dropped.
+type syncStream struct {
+	r        *replicaConn
+	transfer *syncTransfer
+	writer   *chunkWriter
+	err      error
+}
+
+// replicaConn sends the stream to a replica by a dedicated goroutine, the bytes fed meanwhile are buffered
+type replicaConn struct {
+	conn   net.Conn
+	addr   string
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	buf    *bytes.Buffer
+	closed bool
+
+	// state and the acknowledgement are guarded by the mutex of the master
+	state     string
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// NewMaster returns a master listening on addr for the replicas, which syncs them from the dataset
+func NewMaster(addr *net.TCPAddr, dataset Dataset) Master {
 	if addr == nil {
 		return nil
 	}
@@ -52,16 +191,29 @@ func NewMaster(addr *net.TCPAddr) Master {
 
 	return &master{
 		listener: l,
-		conn:     make(map[string]net.Conn),
-		file:     nil,
+		dataset:  dataset,
+		replID:   newReplicationID(),
+		offset:   0,
+		backlog:  newBacklog(common.DefaultReplBacklogSize, 0),
+		replicas: make(map[string]*replicaConn),
 		started:  0,
 		shutdown: 0,
 	}
 }
 
+// newReplicationID returns 40 random hex characters as redis does
+func newReplicationID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		common.Warnf("generate replication id failed. err=%s", err.Error())
+	}
+
+	return hex.EncodeToString(b)
+}
+
 func (m *master) start() {
 	if atomic.CompareAndSwapInt32(&m.started, 0, 1) && atomic.LoadInt32(&m.shutdown) != 1 {
-		common.Infof("start master. addr=%s", m.listener.Addr().String())
+		common.Infof("start master. addr=%s, replid=%s", m.listener.Addr().String(), m.replID)
 	Outer:
 		for {
 			conn, err := m.listener.Accept()
@@ -82,98 +234,417 @@ func (m *master) start() {
 
 func (m *master) stop() {
 	if atomic.CompareAndSwapInt32(&m.shutdown, 0, 1) {
+		_ = m.listener.Close()
+
 		m.mutex.Lock()
+		replicas := m.replicas
+		m.replicas = make(map[string]*replicaConn)
+		if m.transfer != nil {
+			m.transfer.spill.drop()
+			m.transfer = nil
+		}
 		m.mutex.Unlock()
 
-		for _, conn := range m.conn {
-			_ = conn.Close()
+		for _, r := range replicas {
+			r.close()
 		}
 	}
 }
 
 func (m *master) handleConn(conn net.Conn) {
-	if m.file == nil {
-		_ = common.Errorf("no file provided.")
+	addr := conn.RemoteAddr().String()
+	reader := bufio.NewReader(conn)
+
+	replID, offset, position, err := readSyncRequest(reader)
+	if err != nil {
+		common.Warnf("read sync request failed. addr=%s, err=%s", addr, err.Error())
+		_ = conn.Close()
 		return
 	}
 
-	addr := conn.RemoteAddr().String()
-	defer func() { _ = conn.Close() }()
-
-	m.mutex.Lock()
-	m.conn[addr] = conn
-	m.mutex.Unlock()
+	r := &replicaConn{
+		conn:  conn,
+		addr:  addr,
+		buf:   &bytes.Buffer{},
+		state: ConnectedStateSendBulk,
+	}
+	r.cond = sync.NewCond(&r.mutex)
+
+	if t := m.resumeTransfer(r, replID, offset, position); t != nil {
+		common.Infof("full resync resumed. addr=%s, replid=%s, offset=%d, position=%d", addr, replID, offset,
+			position)
+		err = m.sendTransfer(r, t, position)
+	} else if position < 0 && m.resume(r, replID, offset) {
+		common.Infof("partial resync accepted. addr=%s, replid=%s, offset=%d", addr, replID, offset)
+	} else {
+		err = m.fullSync(r)
+	}
 
-	filePath := fmt.Sprintf("/tmp/vertex/%s", util.GenNewUUID())
-	tmpFile, err := os.Create(filePath)
 	if err != nil {
-		_ = common.Errorf("create temporary file failed. addr=%s, path=%s, err=%s", addr, filePath, err.Error())
+		_ = common.Errorf("full resync failed. addr=%s, err=%s", addr, err.Error())
+		m.drop(r)
 		return
 	}
 
-	defer func() {
-		_ = tmpFile.Close()
-		_ = os.Remove(filePath)
+	m.mutex.Lock()
+	r.state, r.ackTime = ConnectedStateOnline, time.Now()
+	m.mutex.Unlock()
+
+	// the replica sends the acknowledgements only, the read ends once it is disconnected
+	go func() {
+		err := m.readAcks(reader, r)
+		common.Debugf("read replica ends. addr=%s, err=%s", addr, err.Error())
+		m.drop(r)
 	}()
 
-	err = m.file.Flush()
-	if err != nil {
-		_ = common.Errorf("save to file failed. addr=%s, path=%s, err=%s", addr, filePath, err.Error())
-		return
+	if err := r.writeLoop(); err != nil {
+		common.Warnf("stream to replica failed. addr=%s, err=%s", addr, err.Error())
 	}
+	m.drop(r)
+}
+
+// readAcks reads REPLCONF ACK offset from the replica until the conn is broken, the others are ignored
+func (m *master) readAcks(reader *bufio.Reader, r *replicaConn) error {
+	rr := protocol.NewRESPReader(reader)
 
-	rawFile, err := os.Open(m.filePath)
+	for {
+		obj, err := rr.ReadObject()
+		if err != nil {
+			return err
+		}
+
+		request, ok := obj.(protocol.RedisArray)
+		if !ok || len(request.Data()) != 3 {
+			continue
+		}
+
+		var items []string
+		for _, item := range request.Data() {
+			if s, ok := item.(protocol.RedisString); ok {
+				items = append(items, strings.ToLower(s.Data()))
+			}
+		}
+
+		if len(items) != 3 || items[0] != "replconf" || items[1] != "ack" {
+			continue
+		}
+
+		offset, err := strconv.ParseInt(items[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		m.mutex.Lock()
+		if offset > r.ackOffset {
+			r.ackOffset = offset
+		}
+		r.ackTime = time.Now()
+		m.mutex.Unlock()
+
+		m.dataset.Acknowledged()
+	}
+}
+
+// readSyncRequest reads PSYNC replid offset [position] from a replica, the position is -1 if absent
+func readSyncRequest(reader *bufio.Reader) (string, int64, int64, error) {
+	obj, err := protocol.Parse(reader)
 	if err != nil {
-		_ = common.Errorf("open raw file failed. addr=%s, path=%s, raw_path=%s, err=%s", addr, filePath, m.filePath, err.Error())
-		return
+		return "", 0, 0, err
+	}
+
+	request, ok := obj.(protocol.RedisArray)
+	if !ok || len(request.Data()) < 3 || len(request.Data()) > 4 {
+		return "", 0, 0, ErrInvalidSyncRequest
+	}
+
+	var items []string
+	for _, item := range request.Data() {
+		s, ok := item.(protocol.RedisString)
+		if !ok {
+			return "", 0, 0, ErrInvalidSyncRequest
+		}
+		items = append(items, s.Data())
+	}
+
+	offset, err := strconv.ParseInt(items[2], 10, 64)
+	if strings.ToLower(items[0]) != "psync" || err != nil {
+		return "", 0, 0, ErrInvalidSyncRequest
+	}
+
+	position := int64(-1)
+	if len(items) == 4 {
+		position, err = strconv.ParseInt(items[3], 10, 64)
+		if err != nil || position < 0 {
+			return "", 0, 0, ErrInvalidSyncRequest
+		}
+	}
+
+	return items[1], offset, position, nil
+}
+
+// resume registers the replica with the bytes after its offset, false if they are not in the backlog
+func (m *master) resume(r *replicaConn, replID string, offset int64) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if replID != m.replID || atomic.LoadInt32(&m.shutdown) == 1 {
+		return false
+	}
+
+	missed, ok := m.backlog.since(offset)
+	if !ok {
+		return false
+	}
+
+	r.buf.WriteString(fmt.Sprintf("+CONTINUE %s\r\n", m.replID))
+	r.buf.Write(missed)
+	m.replicas[r.addr] = r
+
+	return true
+}
+
+// resumeTransfer registers the replica with the bytes after the offset of the last transfer, and returns
+// the transfer to be sent from the position. It returns nil if no position is requested, the transfer is
+// not the one requested or its spill is dropped, or the bytes are not in the backlog.
+func (m *master) resumeTransfer(r *replicaConn, replID string, offset int64, position int64) *syncTransfer {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	t := m.transfer
+	if position < 0 || t == nil || t.replID != replID || t.offset != offset || !t.spill.kept() ||
+		atomic.LoadInt32(&m.shutdown) == 1 {
+		return nil
+	}
+
+	missed, ok := m.backlog.since(offset)
+	if !ok {
+		return nil
+	}
+
+	r.buf.Write(missed)
+	m.replicas[r.addr] = r
+
+	return t
+}
+
+// fullSync streams the records of the whole dataset as they are dumped, the replica is registered at the
+// offset they are built, and the bytes fed meanwhile are sent after them. The transfer replaces the last one
+// once it starts, so it is resumed once interrupted.
+func (m *master) fullSync(r *replicaConn) error {
+	t := &syncTransfer{
+		spill: newSyncSpill(),
+		done:  make(chan struct{}),
 	}
+	stream := &syncStream{r: r, transfer: t}
+
+	err := m.dataset.SyncSnapshot(func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		t.replID, t.offset = m.replID, m.offset
+		if atomic.LoadInt32(&m.shutdown) == 1 {
+			r.close()
+			t.spill.drop()
+			return
+		}
+
+		m.replicas[r.addr] = r
+		if m.transfer != nil {
+			m.transfer.spill.drop()
+		}
+		m.transfer = t
+	}, stream.write)
+
+	t.spill.finish(err)
+	close(t.done)
+	defer m.keepTransfer(t)
 
-	n, err := io.Copy(tmpFile, rawFile)
 	if err != nil {
-		_ = common.Errorf("copy file failed. addr=%s, path=%s, err=%s", addr, filePath, err.Error())
-		return
+		return fmt.Errorf("build sync snapshot failed. err={%w}", err)
 	}
 
-	ret, err := tmpFile.Seek(0, 0)
-	if ret != 0 {
-		_ = common.Errorf("seek file failed. addr=%s, path=%s, ret=%d", addr, filePath, ret)
-		return
-	} else if err != nil {
-		_ = common.Errorf("seek file failed. addr=%s, path=%s, ret=%d, err=%s", addr, filePath, ret, err.Error())
-		return
+	return stream.close()
+}
+
+// write sends the records to the replica, the header is sent before the first ones since the offset is
+// known once the dump starts. It fails only if neither the replica nor the spill takes the records.
+func (s *syncStream) write(data []byte) error {
+	t := s.transfer
+
+	if s.writer == nil {
+		common.Infof("full resync started. addr=%s, replid=%s, offset=%d", s.r.addr, t.replID, t.offset)
+
+		// the size is unknown until the dump ends, as the diskless sync of redis
+		if _, err := s.r.conn.Write([]byte(fmt.Sprintf("+FULLRESYNC %s %d -1\r\n", t.replID, t.offset))); err != nil {
+			s.err = fmt.Errorf("write sync header failed. err={%w}", err)
+		}
+		s.writer = newChunkWriter(s.r.conn, 0, -1, s.r.addr)
+	}
+
+	t.spill.write(data)
+	if s.err == nil {
+		if _, err := s.writer.Write(data); err != nil {
+			s.err = fmt.Errorf("write sync payload failed. err={%w}", err)
+		}
+	}
+
+	if s.err != nil && !t.spill.kept() {
+		return s.err
+	}
+
+	return nil
+}
+
+// close ends the chunks once the dump ends, it returns the error met by the writes
+func (s *syncStream) close() error {
+	if s.writer == nil {
+		return fmt.Errorf("write sync payload failed. err={%w}", ErrIncompleteMessage)
+	} else if s.err != nil {
+		return s.err
 	}
 
-	s, err := tmpFile.Stat()
+	return s.writer.Close()
+}
+
+// sendTransfer writes the reply and the chunks of the transfer from the position once its dump ends, then
+// keeps the transfer for syncTransferKeep since it may be resumed again
+func (m *master) sendTransfer(r *replicaConn, t *syncTransfer, position int64) error {
+	defer m.keepTransfer(t)
+
+	<-t.done
+	reader, size, err := t.spill.open(position)
 	if err != nil {
-		_ = common.Errorf("cannot obtain status of file. addr=%s, path=%s, err=%s", addr, filePath, err.Error())
-		return
+		return fmt.Errorf("resume transfer failed. err={%w}", err)
 	}
+	defer reader.Close()
 
-	// TODO: Only use int32 now. Chunk the file if the size is too large.
-	lengthByte := make([]byte, 4)
-	binary.LittleEndian.PutUint32(lengthByte, uint32(s.Size()))
+	if position > size {
+		return fmt.Errorf("resume transfer failed. position=%d, size=%d, err={%w}", position, size,
+			ErrInvalidSyncRequest)
+	}
 
-	n, err = io.Copy(conn, bytes.NewReader(lengthByte))
-	if n != 4 {
-		_ = common.Errorf("write length of file mismatch. written=%d, addr=%s, path=%s", n, addr, filePath)
-		return
-	} else if err != nil {
-		_ = common.Errorf("write length of file failed. written=%d, addr=%s, path=%s, err=%s", n, addr, filePath, err.Error())
-		return
+	header := fmt.Sprintf("+RESUMESYNC %s %d %d %d\r\n", t.replID, t.offset, size, position)
+	if _, err := r.conn.Write([]byte(header)); err != nil {
+		return fmt.Errorf("write sync header failed. err={%w}", err)
 	}
 
-	n, err = io.Copy(conn, tmpFile)
-	if n != s.Size() {
-		_ = common.Errorf("write length of file mismatch. written=%d, addr=%s, path=%s", n, addr, filePath)
-		return
-	} else if err != nil {
-		_ = common.Errorf("write length of file failed. written=%d, addr=%s, path=%s, err=%s", n, addr, filePath, err.Error())
-		return
+	writer := newChunkWriter(r.conn, position, size, r.addr)
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("write sync payload failed. err={%w}", err)
 	}
 
+	return writer.Close()
+}
+
+// keepTransfer drops the transfer once it is neither sent nor resumed for syncTransferKeep
+func (m *master) keepTransfer(t *syncTransfer) {
 	m.mutex.Lock()
-	delete(m.conn, addr)
+	t.deadline = time.Now().Add(syncTransferKeep)
 	m.mutex.Unlock()
+
+	time.AfterFunc(syncTransferKeep, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		if m.transfer == t && !time.Now().Before(t.deadline) {
+			t.spill.drop()
+			m.transfer = nil
+		}
+	})
+}
+
+// drop closes the replica and stops feeding it
+func (m *master) drop(r *replicaConn) {
+	m.mutex.Lock()
+	if m.replicas[r.addr] == r {
+		delete(m.replicas, r.addr)
+	}
+	m.mutex.Unlock()
+
+	r.close()
+}
+
+func (m *master) Feed(records []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.offset += int64(len(records))
+	m.backlog.write(records)
+
+	for _, r := range m.replicas {
+		if err := r.send(records); err != nil {
+			common.Warnf("replica is disconnected. addr=%s, err=%s", r.addr, err.Error())
+			delete(m.replicas, r.addr)
+			go r.close()
+		}
+	}
+}
+
+func (m *master) SetBacklogSize(size int) {
+	if size <= 0 {
+		size = common.DefaultReplBacklogSize
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.backlog = newBacklog(size, m.offset)
+}
+
+func (m *master) Status() MasterStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	status := MasterStatus{
+		ReplicationID:      m.replID,
+		Offset:             m.offset,
+		BacklogSize:        len(m.backlog.buf),
+		BacklogFirstOffset: m.backlog.offset - int64(m.backlog.size) + 1,
+		BacklogHistlen:     m.backlog.size,
+	}
+
+	for _, r := range m.replicas {
+		status.Replicas = append(status.Replicas, ConnectedReplica{
+			Addr:      r.addr,
+			State:     r.state,
+			AckOffset: r.ackOffset,
+			AckTime:   r.ackTime,
+		})
+	}
+
+	sort.Slice(status.Replicas, func(i, j int) bool {
+		return status.Replicas[i].Addr < status.Replicas[j].Addr
+	})
+
+	return status
+}
+
+func (m *master) AckedReplicas(offset int64) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count := 0
+	for _, r := range m.replicas {
+		if r.state == ConnectedStateOnline && r.ackOffset >= offset {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (m *master) GoodReplicas(lag time.Duration) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count := 0
+	for _, r := range m.replicas {
+		if r.state == ConnectedStateOnline && time.Since(r.ackTime) <= lag {
+			count++
+		}
+	}
+
+	return count
 }
 
 func (m *master) Start() {
@@ -184,10 +655,56 @@ func (m *master) Stop() {
 	m.stop()
 }
 
-func (m *master) SetFile(file *log.PersistentFile, filePath string) {
-	m.file = file
-	m.filePath = filePath
+func (m *master) master() {
 }
 
-func (m *master) master() {
+// send buffers the bytes to be written by writeLoop
+func (r *replicaConn) send(p []byte) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrReplicaClosed
+	} else if r.buf.Len()+len(p) > replicaBufferLimit {
+		return ErrReplicaTooSlow
+	}
+
+	r.buf.Write(p)
+	r.cond.Signal()
+
+	return nil
+}
+
+// writeLoop writes the buffered bytes into the conn until it is closed
+func (r *replicaConn) writeLoop() error {
+	for {
+		r.mutex.Lock()
+		for r.buf.Len() == 0 && !r.closed {
+			r.cond.Wait()
+		}
+
+		if r.closed {
+			r.mutex.Unlock()
+			return nil
+		}
+
+		batch := r.buf
+		r.buf = &bytes.Buffer{}
+		r.mutex.Unlock()
+
+		if _, err := r.conn.Write(batch.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *replicaConn) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.closed {
+		r.closed = true
+		_ = r.conn.Close()
+		r.cond.Broadcast()
+	}
 }
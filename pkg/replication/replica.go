@@ -2,64 +2,472 @@ package replication
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/lxdlam/vertex/pkg/common"
 	"github.com/lxdlam/vertex/pkg/log"
-	"github.com/lxdlam/vertex/pkg/util"
+	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
-var ErrIncompleteMessage = errors.New("replica: incomplete message")
+const (
+	// replicaRetryMinInterval and replicaRetryMaxInterval bound the interval between two attempts to sync
+	// with the master, it doubles after each failed attempt and is reset once a sync is done
+	replicaRetryMinInterval = 100 * time.Millisecond
+	replicaRetryMaxInterval = 10 * time.Second
+)
+
+// replicaDialTimeout is the timeout of connecting the master
+const replicaDialTimeout = 5 * time.Second
+
+// replicaAckInterval is the interval between two acknowledgements of the offset while the stream is idle
+const replicaAckInterval = time.Second
+
+var (
+	// ErrIncompleteMessage will be raised if the master closes the conn in a message
+	ErrIncompleteMessage = errors.New("replica: incomplete message")
+
+	// ErrInvalidSyncReply will be raised if the master replies neither FULLRESYNC nor CONTINUE
+	ErrInvalidSyncReply = errors.New("replica: invalid sync reply")
+)
+
+// Handler applies what a replica receives from the master
+type Handler interface {
+	// BeginSync drops the dataset before the one of the master is applied, the dataset is loading until
+	// EndSync is called.
+	BeginSync()
 
-// The message layout is like:
-//     layout: | length | log data |
-//       byte: |    4   |    length's data |
-// If the send is failed, length section will be an invalid data.
+	// Apply applies a record of the dataset or the stream of the master.
+	Apply(*log.VertexLog)
+
+	// EndSync is called once the whole dataset of the master is applied.
+	EndSync()
+}
+
+// The states of the link with the master, named as the ones replied by ROLE of redis
+const (
+	// ReplicaStateConnect means the replica is waiting to connect the master
+	ReplicaStateConnect = "connect"
+
+	// ReplicaStateConnecting means the replica is connecting the master and requesting the sync
+	ReplicaStateConnecting = "connecting"
+
+	// ReplicaStateSync means the replica is receiving the dataset from the master
+	ReplicaStateSync = "sync"
+
+	// ReplicaStateConnected means the replica is applying the stream of the master
+	ReplicaStateConnected = "connected"
+)
 
-// Replica will works like a one shot query to the master node.
-// It will be redesign later.
+// Replica follows a master. It requests a partial resync with the replication id and the offset it has
+// received, so the dataset is only sent again if the master can not resume from the offset. The dataset
+// is applied as its chunks arrive, and an interrupted transfer is resumed from the position applied. The
+// replica syncs again once the conn is broken until it is stopped, the interval between the attempts grows
+// while they keep failing. The offset applied is acknowledged to the master while the stream is applied.
 type Replica interface {
-	Receive() ([]*log.VertexLog, error)
+	Start()
+	Stop()
+
+	// Status returns the state of the link with the master.
+	Status() ReplicaStatus
+}
+
+// ReplicaStatus is the state of the link of a replica with its master.
+type ReplicaStatus struct {
+	// Addr is the address of the master for the replicas.
+	Addr string
+
+	// State is one of the ReplicaState constants.
+	State string
+
+	// ReplicationID and Offset are the position of the stream received, which are ? and -1 before the
+	// first sync.
+	ReplicationID string
+	Offset        int64
+
+	// LastIO is the time anything is received from the master last time, zero if nothing is received.
+	LastIO time.Time
+
+	// DownSince is the time the link is broken, zero while the replica is applying the stream.
+	DownSince time.Time
+
+	// SyncSize and SyncPosition are the bytes of the dataset in transfer and the ones applied, both are
+	// zero if no transfer is in progress. The size is -1 until it is known, as it is streamed by the master.
+	SyncSize     int64
+	SyncPosition int64
+}
+
+// transfer is a full resync in progress, the dataset built at the offset is applied up to the position
+type transfer struct {
+	replID   string
+	offset   int64
+	size     int64
+	position int64
 }
 
 type replica struct {
-	conn net.Conn
+	addr    string
+	handler Handler
+
+	mutex     sync.Mutex
+	conn      net.Conn
+	replID    string
+	offset    int64
+	state     string
+	lastIO    time.Time
+	downSince time.Time
+	transfer  *transfer
+
+	shutChan chan struct{}
+	done     chan struct{}
+	stopped  bool
 }
 
-func NewReplica(conn net.Conn) Replica {
-	if conn == nil {
-		return nil
+// NewReplica returns a replica following the master listening on addr for the replicas
+func NewReplica(addr string, handler Handler) Replica {
+	return &replica{
+		addr:      addr,
+		handler:   handler,
+		replID:    "?",
+		offset:    -1,
+		state:     ReplicaStateConnect,
+		downSince: time.Now(),
+		shutChan:  make(chan struct{}),
+		done:      make(chan struct{}),
 	}
+}
 
-	return &replica{conn: conn}
+func (r *replica) Start() {
+	go r.loop()
 }
 
-func (r *replica) Receive() ([]*log.VertexLog, error) {
-	reader := bufio.NewReader(r.conn)
+func (r *replica) Stop() {
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return
+	}
+
+	r.stopped = true
+	close(r.shutChan)
+	if r.conn != nil {
+		_ = r.conn.Close()
+	}
+	r.mutex.Unlock()
+
+	<-r.done
+}
+
+func (r *replica) Status() ReplicaStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	status := ReplicaStatus{
+		Addr:          r.addr,
+		State:         r.state,
+		ReplicationID: r.replID,
+		Offset:        r.offset,
+		LastIO:        r.lastIO,
+		DownSince:     r.downSince,
+	}
+
+	if r.transfer != nil {
+		status.SyncSize = r.transfer.size
+		status.SyncPosition = r.transfer.position
+	}
+
+	return status
+}
+
+func (r *replica) loop() {
+	defer close(r.done)
 
-	lengthBytes, err := util.ReadExactBytes(reader, 4)
-	if len(lengthBytes) != 4 {
-		return nil, ErrIncompleteMessage
-	} else if err != nil {
-		return nil, err
+	interval := replicaRetryMinInterval
+	for {
+		linked, err := r.sync()
+
+		r.mutex.Lock()
+		if r.state == ReplicaStateConnected {
+			r.downSince = time.Now()
+		}
+		r.state = ReplicaStateConnect
+		r.mutex.Unlock()
+
+		select {
+		case <-r.shutChan:
+			return
+		default:
+		}
+
+		if err != nil {
+			common.Warnf("sync with master failed. addr=%s, retry=%s, err=%s", r.addr, interval, err.Error())
+		}
+
+		// a link broken after the sync is done is retried soon, the failed attempts are retried slower
+		if linked {
+			interval = replicaRetryMinInterval
+		}
+
+		select {
+		case <-r.shutChan:
+			return
+		case <-time.After(interval):
+		}
+
+		if !linked {
+			interval *= 2
+			if interval > replicaRetryMaxInterval {
+				interval = replicaRetryMaxInterval
+			}
+		}
 	}
+}
 
-	length := int(binary.LittleEndian.Uint32(lengthBytes))
+// setState sets the state of the link, and the time anything is received if io is set
+func (r *replica) setState(state string, io bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.state = state
+	if io {
+		r.lastIO = time.Now()
+	}
+}
+
+// sync connects the master, then applies the stream until the conn is broken. It reports if the stream is
+// ever applied, i.e., the sync is done.
+func (r *replica) sync() (bool, error) {
+	r.setState(ReplicaStateConnecting, false)
+
+	conn, err := net.DialTimeout("tcp", r.addr, replicaDialTimeout)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = conn.Close() }()
 
-	if length < 0 {
-		return nil, ErrIncompleteMessage
-	} else if length == 0 {
-		return nil, nil
+	r.mutex.Lock()
+	if r.stopped {
+		r.mutex.Unlock()
+		return false, nil
 	}
+	r.conn = conn
+	replID, offset, t := r.replID, r.offset, r.transfer
+	r.mutex.Unlock()
 
-	message, err := util.ReadExactBytes(reader, length)
-	if len(message) != length {
-		return nil, ErrIncompleteMessage
-	} else if err != nil {
-		return nil, err
+	// an interrupted transfer is resumed from the position applied
+	args := []string{"psync", replID, strconv.FormatInt(offset, 10)}
+	if t != nil {
+		args = []string{"psync", t.replID, strconv.FormatInt(t.offset, 10), strconv.FormatInt(t.position, 10)}
 	}
 
-	return log.ParseLog(bytes.NewReader(message))
+	var objects []protocol.RedisObject
+	for _, arg := range args {
+		objects = append(objects, protocol.NewBulkRedisString(arg))
+	}
+
+	if _, err := conn.Write([]byte(protocol.NewRedisArray(objects).String())); err != nil {
+		return false, fmt.Errorf("send sync request failed. err={%w}", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("read sync reply failed. err={%w}", err)
+	}
+
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 4 && fields[0] == "+FULLRESYNC":
+		t, err = parseTransfer(fields[1], fields[2], fields[3], "0")
+		if err != nil {
+			return false, fmt.Errorf("parse sync reply failed. reply=%s, err={%w}", strconv.Quote(line), err)
+		}
+
+		// the dataset is dropped, so the stream received before is useless from now on
+		r.mutex.Lock()
+		r.replID, r.offset, r.transfer = "?", -1, t
+		r.mutex.Unlock()
+
+		r.setState(ReplicaStateSync, true)
+		r.handler.BeginSync()
+		common.Infof("full resync with master started. addr=%s, replid=%s, offset=%d, size=%d", r.addr, t.replID,
+			t.offset, t.size)
+	case len(fields) == 5 && fields[0] == "+RESUMESYNC" && t != nil:
+		resumed, err := parseTransfer(fields[1], fields[2], fields[3], fields[4])
+		if err != nil || resumed.replID != t.replID || resumed.offset != t.offset || resumed.position != t.position {
+			return false, fmt.Errorf("parse sync reply failed. reply=%s, err={%w}", strconv.Quote(line), ErrInvalidSyncReply)
+		}
+
+		// the size is known once the dump of the master ends
+		r.mutex.Lock()
+		t.size = resumed.size
+		r.mutex.Unlock()
+
+		r.setState(ReplicaStateSync, true)
+		common.Infof("full resync with master resumed. addr=%s, replid=%s, offset=%d, position=%d", r.addr,
+			t.replID, t.offset, t.position)
+	case len(fields) == 2 && fields[0] == "+CONTINUE" && fields[1] == replID && t == nil:
+		common.Infof("partial resync with master accepted. addr=%s, replid=%s, offset=%d", r.addr, replID, offset)
+	default:
+		return false, fmt.Errorf("parse sync reply failed. reply=%s, err={%w}", strconv.Quote(line), ErrInvalidSyncReply)
+	}
+
+	if t != nil {
+		if err := r.receive(reader, t); err != nil {
+			return false, err
+		}
+
+		r.mutex.Lock()
+		replID, offset, r.transfer = t.replID, t.offset, nil
+		r.mutex.Unlock()
+
+		r.handler.EndSync()
+		common.Infof("full resync with master done. addr=%s, replid=%s, offset=%d", r.addr, replID, offset)
+	}
+
+	r.mutex.Lock()
+	r.replID, r.offset = replID, offset
+	r.state, r.lastIO, r.downSince = ReplicaStateConnected, time.Now(), time.Time{}
+	r.mutex.Unlock()
+
+	return true, r.stream(reader, conn)
+}
+
+// parseTransfer parses the fields of the reply starting a transfer, the size is -1 if it is unknown
+func parseTransfer(replID string, offset string, size string, position string) (*transfer, error) {
+	t := &transfer{replID: replID}
+
+	var err error
+	if t.offset, err = strconv.ParseInt(offset, 10, 64); err != nil {
+		return nil, ErrInvalidSyncReply
+	} else if t.size, err = strconv.ParseInt(size, 10, 64); err != nil {
+		return nil, ErrInvalidSyncReply
+	} else if t.position, err = strconv.ParseInt(position, 10, 64); err != nil {
+		return nil, ErrInvalidSyncReply
+	} else if t.size >= 0 && t.position > t.size {
+		return nil, ErrInvalidSyncReply
+	}
+
+	return t, nil
+}
+
+// receive applies the dataset in the chunks from the position of the transfer, which advances with each
+// record applied, so an interrupted transfer is resumed from the record after it
+func (r *replica) receive(reader *bufio.Reader, t *transfer) error {
+	cr := newChunkReader(reader)
+	progress := newSyncProgress("receive", r.addr, t.size)
+
+	if t.position == 0 {
+		version, err := log.ReadHeader(cr)
+		if err != nil {
+			return fmt.Errorf("read sync payload failed. err={%w}", err)
+		} else if version != log.LogVersion {
+			return fmt.Errorf("read sync payload failed. version=%d, err={%w}", version, log.ErrUnsupportedLogVersion)
+		}
+
+		r.advance(t, log.HeaderSize)
+	}
+
+	rr := log.NewRecordReader(cr)
+	for {
+		vl, n, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("read sync payload failed. position=%d, err={%w}", t.position, err)
+		}
+
+		r.handler.Apply(vl)
+		r.advance(t, n)
+		progress.update(t.position)
+	}
+
+	if t.size >= 0 && t.position != t.size {
+		return fmt.Errorf("read sync payload failed. position=%d, size=%d, err={%w}", t.position, t.size,
+			ErrIncompleteMessage)
+	}
+
+	return nil
+}
+
+// advance moves the position of the transfer by the bytes applied
+func (r *replica) advance(t *transfer, n int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t.position += n
+	r.lastIO = time.Now()
+}
+
+// stream applies the records from the master, the offset advances with each of them. The offset is
+// acknowledged once the records received are applied, and every replicaAckInterval by another goroutine.
+func (r *replica) stream(reader *bufio.Reader, conn net.Conn) error {
+	var writeMutex sync.Mutex
+	if err := r.ack(conn, &writeMutex); err != nil {
+		return fmt.Errorf("send ack failed. err={%w}", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		ticker := time.NewTicker(replicaAckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				// a broken conn fails the read of the stream as well
+				_ = r.ack(conn, &writeMutex)
+			}
+		}
+	}()
+
+	rr := log.NewRecordReader(reader)
+	for {
+		vl, n, err := rr.Next()
+		if err != nil {
+			return fmt.Errorf("read stream failed. err={%w}", err)
+		}
+
+		r.handler.Apply(vl)
+
+		r.mutex.Lock()
+		r.offset += n
+		r.lastIO = time.Now()
+		r.mutex.Unlock()
+
+		if reader.Buffered() == 0 {
+			if err := r.ack(conn, &writeMutex); err != nil {
+				return fmt.Errorf("send ack failed. err={%w}", err)
+			}
+		}
+	}
+}
+
+// ack sends REPLCONF ACK with the offset applied, the writes into the conn are serialized by the mutex
+func (r *replica) ack(conn net.Conn, writeMutex *sync.Mutex) error {
+	r.mutex.Lock()
+	offset := r.offset
+	r.mutex.Unlock()
+
+	request := protocol.NewRedisArray([]protocol.RedisObject{
+		protocol.NewBulkRedisString("replconf"),
+		protocol.NewBulkRedisString("ack"),
+		protocol.NewBulkRedisString(strconv.FormatInt(offset, 10)),
+	})
+
+	writeMutex.Lock()
+	defer writeMutex.Unlock()
+
+	_, err := conn.Write([]byte(request.String()))
+	return err
 }
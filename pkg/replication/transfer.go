@@ -0,0 +1,309 @@
+package replication
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/common"
+)
+
+const (
+	// syncChunkSize is the bytes of the dataset sent in one chunk
+	syncChunkSize = 64 * 1024
+
+	// syncChunkLimit is the max length of a chunk accepted by a replica, so a corrupted length does not
+	// allocate too much
+	syncChunkLimit = 16 * 1024 * 1024
+
+	// syncProgressInterval is the min interval between two progress logs of a transfer
+	syncProgressInterval = time.Second
+
+	// syncSpillLimit is the max bytes of a transfer spilled for resuming, an interrupted transfer of a
+	// larger dataset starts over
+	syncSpillLimit = 1024 * 1024 * 1024
+)
+
+var chunkTable = crc32.MakeTable(crc32.Castagnoli)
+
+var (
+	// ErrInvalidChunk will be raised if the length of a chunk exceeds the limit
+	ErrInvalidChunk = errors.New("replication: invalid chunk length")
+
+	// ErrChunkCorrupted will be raised if the checksum of a chunk mismatches its data
+	ErrChunkCorrupted = errors.New("replication: chunk is corrupted")
+
+	// ErrSpillDropped will be raised if a transfer is resumed from a spill which is not kept
+	ErrSpillDropped = errors.New("replication: sync spill is dropped")
+)
+
+// chunkWriter writes the data in chunks of at most syncChunkSize bytes, and the empty chunk ending them
+// by Close:
+//
+//	represent: | length | checksum | data |
+//	bytes:         ^4        ^4      ^length
+//
+// The checksum is the CRC32C of the data. The progress is logged with the name of the peer.
+type chunkWriter struct {
+	writer   *bufio.Writer
+	header   []byte
+	position int64
+	progress *syncProgress
+}
+
+// newChunkWriter returns a writer of the transfer from the position, the total is -1 if it is unknown
+func newChunkWriter(w io.Writer, position int64, total int64, peer string) *chunkWriter {
+	return &chunkWriter{
+		writer:   bufio.NewWriterSize(w, syncChunkSize+8),
+		header:   make([]byte, 8),
+		position: position,
+		progress: newSyncProgress("send", peer, total),
+	}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > syncChunkSize {
+			chunk = chunk[:syncChunkSize]
+		}
+
+		binary.LittleEndian.PutUint32(c.header, uint32(len(chunk)))
+		binary.LittleEndian.PutUint32(c.header[4:], crc32.Checksum(chunk, chunkTable))
+
+		_, _ = c.writer.Write(c.header)
+		if _, err := c.writer.Write(chunk); err != nil {
+			return written, fmt.Errorf("write chunk failed. position=%d, err={%w}", c.position, err)
+		}
+
+		written += len(chunk)
+		c.position += int64(len(chunk))
+		c.progress.update(c.position)
+		p = p[len(chunk):]
+	}
+
+	return written, nil
+}
+
+// Close writes the empty chunk and flushes the chunks, the underlying writer is not closed
+func (c *chunkWriter) Close() error {
+	binary.LittleEndian.PutUint32(c.header, 0)
+	binary.LittleEndian.PutUint32(c.header[4:], 0)
+	_, _ = c.writer.Write(c.header)
+
+	if err := c.writer.Flush(); err != nil {
+		return fmt.Errorf("write chunk failed. position=%d, err={%w}", c.position, err)
+	}
+
+	return nil
+}
+
+// syncSpill keeps the bytes of a transfer in a temporary file, so an interrupted transfer is resumed from
+// it. The spill is dropped once it exceeds syncSpillLimit or fails to be written.
+type syncSpill struct {
+	mutex sync.Mutex
+	file  *os.File
+	path  string
+	size  int64
+	err   error
+}
+
+func newSyncSpill() *syncSpill {
+	file, err := ioutil.TempFile("", "vertex-sync-")
+	if err != nil {
+		common.Warnf("create sync spill failed. err=%s", err.Error())
+		return &syncSpill{err: err}
+	}
+
+	return &syncSpill{
+		file: file,
+		path: file.Name(),
+	}
+}
+
+// write appends the bytes to the spill
+func (s *syncSpill) write(data []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return
+	}
+
+	if s.size+int64(len(data)) > syncSpillLimit {
+		s.dropLocked(fmt.Errorf("spill exceeds the limit. limit=%d, err={%w}", syncSpillLimit, ErrSpillDropped))
+		return
+	}
+
+	if _, err := s.file.Write(data); err != nil {
+		s.dropLocked(fmt.Errorf("write sync spill failed. err={%w}", err))
+		return
+	}
+
+	s.size += int64(len(data))
+}
+
+// finish closes the file written, the spill is dropped if the transfer is not built completely
+func (s *syncSpill) finish(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err != nil {
+		s.dropLocked(err)
+	} else if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
+
+// open returns the bytes of the spill from the position and its size
+func (s *syncSpill) open(position int64) (io.ReadCloser, int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, 0, s.err
+	}
+
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open sync spill failed. err={%w}", err)
+	} else if _, err := file.Seek(position, io.SeekStart); err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("seek sync spill failed. position=%d, err={%w}", position, err)
+	}
+
+	return file, s.size, nil
+}
+
+// kept reports if the spill is not dropped
+func (s *syncSpill) kept() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.err == nil
+}
+
+// drop removes the file, the readers opened keep reading it
+func (s *syncSpill) drop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.dropLocked(ErrSpillDropped)
+}
+
+func (s *syncSpill) dropLocked(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+
+	if s.path != "" {
+		_ = os.Remove(s.path)
+		s.path = ""
+	}
+}
+
+// chunkReader reads the data of the chunks written by chunkWriter, the data of a chunk is returned only if
+// its checksum matches. It returns io.EOF after the empty chunk, and nothing after it is read.
+type chunkReader struct {
+	reader *bufio.Reader
+	chunk  []byte
+	done   bool
+}
+
+func newChunkReader(reader *bufio.Reader) *chunkReader {
+	return &chunkReader{
+		reader: reader,
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.chunk) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		if err := c.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.chunk)
+	c.chunk = c.chunk[n:]
+
+	return n, nil
+}
+
+// next reads the next chunk
+func (c *chunkReader) next() error {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return ErrIncompleteMessage
+	}
+
+	length := binary.LittleEndian.Uint32(header)
+	if length == 0 {
+		c.done = true
+		return nil
+	} else if length > syncChunkLimit {
+		return fmt.Errorf("read chunk failed. length=%d, err={%w}", length, ErrInvalidChunk)
+	}
+
+	chunk := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, chunk); err != nil {
+		return ErrIncompleteMessage
+	}
+
+	if binary.LittleEndian.Uint32(header[4:]) != crc32.Checksum(chunk, chunkTable) {
+		return ErrChunkCorrupted
+	}
+
+	c.chunk = chunk
+	return nil
+}
+
+// syncProgress logs the progress of a transfer at most once per syncProgressInterval
+type syncProgress struct {
+	action string
+	peer   string
+	total  int64
+	last   time.Time
+}
+
+func newSyncProgress(action string, peer string, total int64) *syncProgress {
+	return &syncProgress{
+		action: action,
+		peer:   peer,
+		total:  total,
+		last:   time.Now(),
+	}
+}
+
+func (s *syncProgress) update(position int64) {
+	if time.Since(s.last) < syncProgressInterval || s.total == 0 {
+		return
+	}
+
+	s.last = time.Now()
+	if s.total < 0 {
+		common.Infof("full resync in progress. action=%s, peer=%s, position=%d", s.action, s.peer, position)
+		return
+	}
+
+	common.Infof("full resync in progress. action=%s, peer=%s, position=%d, total=%d, perc=%.2f%%", s.action,
+		s.peer, position, s.total, float64(position)*100/float64(s.total))
+}
@@ -0,0 +1,454 @@
+// Package snapshot is the binary format of a point-in-time snapshot of all dbs:
+//
+//	represent: | magic | version | created | id | entry ... | eof | checksum |
+//	bytes:         ^4      ^2        ^8      ^s     ^entry      ^1     ^4
+//
+// The integers are little endian, and a string(s) is its length as an uvarint then the bytes. An entry is
+// a key with its value:
+//
+//	represent: | type | db | deadline | key | value |
+//	bytes:        ^1   ^uv    ^varint    ^s    ^type dependent
+//
+// The deadline is in unix milliseconds, -1 if the key has none. The value of a string is a string, the
+// ones of a list and a set are the count as an uvarint then the elements, the one of a hash is the count
+// then the fields and values in turn, and the one of a sorted set is the count then the members and their
+// scores in turn, a score is a float64 in 8 bytes. The checksum is the CRC32C of all bytes before it.
+package snapshot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"math"
+	"time"
+)
+
+// Version is the version of the format written
+const Version = 1
+
+const magic = "VSNP"
+
+// The types of the entries
+const (
+	StringEntry    byte = 1
+	ListEntry      byte = 2
+	HashEntry      byte = 3
+	SetEntry       byte = 4
+	SortedSetEntry byte = 5
+
+	eofEntry byte = 0xff
+)
+
+// NoDeadline is the deadline of a key without one
+const NoDeadline int64 = -1
+
+// maxStringLen is the max length of a string read, so a corrupted length does not allocate too much
+const maxStringLen = 512 * 1024 * 1024
+
+var (
+	// ErrInvalidSnapshot will be raised if the file is not a snapshot, or is corrupted
+	ErrInvalidSnapshot = errors.New("snapshot: invalid snapshot")
+
+	// ErrChecksumMismatch will be raised if the checksum is not the one of the content
+	ErrChecksumMismatch = errors.New("snapshot: checksum mismatch")
+
+	// ErrUnsupportedVersion will be raised if the snapshot is written by a newer version
+	ErrUnsupportedVersion = errors.New("snapshot: unsupported version")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Header is the header of a snapshot. ID tells the snapshot from the others, e.g., to find the log records
+// written after it.
+type Header struct {
+	Version int
+	Created time.Time
+	ID      string
+}
+
+// Entry is a key in a snapshot. Values holds the value of a string, the elements of a list or a set, the
+// fields and values in turn of a hash, or the members of a sorted set, whose scores are in Scores.
+type Entry struct {
+	Type     byte
+	DB       int
+	Key      string
+	Deadline int64
+	Values   []string
+	Scores   []float64
+}
+
+// Writer writes a snapshot into an io.Writer, the checksum is computed while it is written
+type Writer struct {
+	writer  io.Writer
+	crc     hash.Hash32
+	scratch [binary.MaxVarintLen64]byte
+}
+
+// NewWriter writes the header into the writer and returns a Writer to write the entries
+func NewWriter(writer io.Writer, header Header) (*Writer, error) {
+	crc := crc32.New(crcTable)
+	w := &Writer{
+		writer: io.MultiWriter(writer, crc),
+		crc:    crc,
+	}
+
+	var buf [10]byte
+	copy(buf[:4], magic)
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(Version))
+	if _, err := w.writer.Write(buf[:6]); err != nil {
+		return nil, fmt.Errorf("write snapshot header failed. err={%w}", err)
+	}
+
+	binary.LittleEndian.PutUint64(buf[:8], uint64(header.Created.UnixNano()/int64(time.Millisecond)))
+	if _, err := w.writer.Write(buf[:8]); err != nil {
+		return nil, fmt.Errorf("write snapshot header failed. err={%w}", err)
+	}
+
+	if err := w.writeString(header.ID); err != nil {
+		return nil, fmt.Errorf("write snapshot header failed. err={%w}", err)
+	}
+
+	return w, nil
+}
+
+func (w *Writer) writeUvarint(n uint64) error {
+	_, err := w.writer.Write(w.scratch[:binary.PutUvarint(w.scratch[:], n)])
+	return err
+}
+
+func (w *Writer) writeVarint(n int64) error {
+	_, err := w.writer.Write(w.scratch[:binary.PutVarint(w.scratch[:], n)])
+	return err
+}
+
+func (w *Writer) writeString(s string) error {
+	if err := w.writeUvarint(uint64(len(s))); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w.writer, s)
+	return err
+}
+
+// WriteEntry writes an entry, the count of its values should match its type
+func (w *Writer) WriteEntry(e *Entry) error {
+	if err := w.writeEntry(e); err != nil {
+		return fmt.Errorf("write snapshot entry failed. key=%s, err={%w}", e.Key, err)
+	}
+
+	return nil
+}
+
+func (w *Writer) writeEntry(e *Entry) error {
+	if _, err := w.writer.Write([]byte{e.Type}); err != nil {
+		return err
+	} else if err = w.writeUvarint(uint64(e.DB)); err != nil {
+		return err
+	} else if err = w.writeVarint(e.Deadline); err != nil {
+		return err
+	} else if err = w.writeString(e.Key); err != nil {
+		return err
+	}
+
+	switch e.Type {
+	case StringEntry:
+		if len(e.Values) != 1 {
+			return ErrInvalidSnapshot
+		}
+		return w.writeString(e.Values[0])
+	case ListEntry, SetEntry:
+		return w.writeStrings(e.Values)
+	case HashEntry:
+		if len(e.Values)%2 != 0 {
+			return ErrInvalidSnapshot
+		}
+		if err := w.writeUvarint(uint64(len(e.Values) / 2)); err != nil {
+			return err
+		}
+		for _, s := range e.Values {
+			if err := w.writeString(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	case SortedSetEntry:
+		if len(e.Values) != len(e.Scores) {
+			return ErrInvalidSnapshot
+		}
+		if err := w.writeUvarint(uint64(len(e.Values))); err != nil {
+			return err
+		}
+		for idx, member := range e.Values {
+			if err := w.writeString(member); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint64(w.scratch[:8], math.Float64bits(e.Scores[idx]))
+			if _, err := w.writer.Write(w.scratch[:8]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return ErrInvalidSnapshot
+}
+
+func (w *Writer) writeStrings(values []string) error {
+	if err := w.writeUvarint(uint64(len(values))); err != nil {
+		return err
+	}
+
+	for _, s := range values {
+		if err := w.writeString(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close writes the end of the snapshot and its checksum, nothing can be written after it
+func (w *Writer) Close() error {
+	if _, err := w.writer.Write([]byte{eofEntry}); err != nil {
+		return fmt.Errorf("write snapshot eof failed. err={%w}", err)
+	}
+
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], w.crc.Sum32())
+
+	// the checksum is not a part of itself
+	if _, err := w.writer.Write(buf[:]); err != nil {
+		return fmt.Errorf("write snapshot checksum failed. err={%w}", err)
+	}
+
+	return nil
+}
+
+// Reader reads the entries of a snapshot one by one. The checksum is verified once all entries are read,
+// so the snapshot should be checked by Verify before the entries are applied.
+type Reader struct {
+	buffered *bufio.Reader
+	crc      hash.Hash32
+	header   Header
+	done     bool
+}
+
+// Read reads from the snapshot and feeds the bytes consumed into the checksum
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.buffered.Read(p)
+	_, _ = r.crc.Write(p[:n])
+	return n, err
+}
+
+// ReadByte reads a byte from the snapshot and feeds it into the checksum
+func (r *Reader) ReadByte() (byte, error) {
+	b, err := r.buffered.ReadByte()
+	if err == nil {
+		_, _ = r.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// NewReader reads the header from the reader and returns a Reader to read the entries
+func NewReader(reader io.Reader) (*Reader, error) {
+	r := &Reader{
+		buffered: bufio.NewReader(reader),
+		crc:      crc32.New(crcTable),
+	}
+
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:6]); err != nil {
+		return nil, fmt.Errorf("read snapshot header failed. err={%w}", invalid(err))
+	} else if string(buf[:4]) != magic {
+		return nil, fmt.Errorf("read snapshot header failed. magic=%q, err={%w}", buf[:4], ErrInvalidSnapshot)
+	}
+
+	r.header.Version = int(binary.LittleEndian.Uint16(buf[4:6]))
+	if r.header.Version > Version {
+		return nil, fmt.Errorf("read snapshot header failed. version=%d, err={%w}", r.header.Version, ErrUnsupportedVersion)
+	}
+
+	if _, err := io.ReadFull(r, buf[:8]); err != nil {
+		return nil, fmt.Errorf("read snapshot header failed. err={%w}", invalid(err))
+	}
+	created := int64(binary.LittleEndian.Uint64(buf[:8]))
+	r.header.Created = time.Unix(0, created*int64(time.Millisecond))
+
+	id, err := r.readString()
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot header failed. err={%w}", err)
+	}
+	r.header.ID = id
+
+	return r, nil
+}
+
+// Header returns the header of the snapshot
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// invalid reports an unexpected end as an invalid snapshot
+func invalid(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrInvalidSnapshot
+	}
+
+	return err
+}
+
+func (r *Reader) readString() (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", invalid(err)
+	} else if n > maxStringLen {
+		return "", ErrInvalidSnapshot
+	}
+
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", invalid(err)
+	}
+
+	return string(buf), nil
+}
+
+func (r *Reader) readStrings(count uint64) ([]string, error) {
+	var ret []string
+	for idx := uint64(0); idx < count; idx++ {
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+
+	return ret, nil
+}
+
+// Next returns the next entry, io.EOF is returned once all entries are read and the checksum matches
+func (r *Reader) Next() (*Entry, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	e, err := r.next()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("read snapshot entry failed. err={%w}", err)
+	}
+
+	return e, err
+}
+
+func (r *Reader) next() (*Entry, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, invalid(err)
+	}
+
+	if t == eofEntry {
+		return nil, r.readChecksum()
+	}
+
+	e := &Entry{Type: t}
+
+	db, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, invalid(err)
+	}
+	e.DB = int(db)
+
+	if e.Deadline, err = binary.ReadVarint(r); err != nil {
+		return nil, invalid(err)
+	}
+
+	if e.Key, err = r.readString(); err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case StringEntry:
+		value, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		e.Values = []string{value}
+	case ListEntry, SetEntry, HashEntry, SortedSetEntry:
+		count, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, invalid(err)
+		}
+
+		if t == HashEntry {
+			e.Values, err = r.readStrings(count * 2)
+		} else if t == SortedSetEntry {
+			err = r.readScored(e, count)
+		} else {
+			e.Values, err = r.readStrings(count)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrInvalidSnapshot
+	}
+
+	return e, nil
+}
+
+func (r *Reader) readScored(e *Entry, count uint64) error {
+	var buf [8]byte
+	for idx := uint64(0); idx < count; idx++ {
+		member, err := r.readString()
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return invalid(err)
+		}
+
+		e.Values = append(e.Values, member)
+		e.Scores = append(e.Scores, math.Float64frombits(binary.LittleEndian.Uint64(buf[:])))
+	}
+
+	return nil
+}
+
+// readChecksum reads the checksum after the eof entry and compares it with the one of the content
+func (r *Reader) readChecksum() error {
+	expected := r.crc.Sum32()
+
+	var buf [4]byte
+	if _, err := io.ReadFull(r.buffered, buf[:]); err != nil {
+		return invalid(err)
+	}
+
+	if actual := binary.LittleEndian.Uint32(buf[:]); actual != expected {
+		return fmt.Errorf("checksum=%08x, expected=%08x, err={%w}", actual, expected, ErrChecksumMismatch)
+	}
+
+	r.done = true
+	return io.EOF
+}
+
+// Verify reads the whole snapshot and checks its checksum, then the header is returned
+func Verify(reader io.Reader) (Header, error) {
+	r, err := NewReader(reader)
+	if err != nil {
+		return Header{}, err
+	}
+
+	for {
+		if _, err := r.Next(); errors.Is(err, io.EOF) {
+			return r.Header(), nil
+		} else if err != nil {
+			return Header{}, err
+		}
+	}
+}
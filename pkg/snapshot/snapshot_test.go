@@ -0,0 +1,128 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/lxdlam/vertex/pkg/snapshot"
+	"github.com/stretchr/testify/assert"
+)
+
+var testEntries = []*Entry{
+	{Type: StringEntry, DB: 0, Key: "string", Deadline: NoDeadline, Values: []string{"value"}},
+	{Type: StringEntry, DB: 0, Key: "", Deadline: 1600000000000, Values: []string{""}},
+	{Type: ListEntry, DB: 1, Key: "list", Deadline: NoDeadline, Values: []string{"a", "b", "a"}},
+	{Type: HashEntry, DB: 2, Key: "hash", Deadline: NoDeadline, Values: []string{"f1", "v1", "f2", "\r\n"}},
+	{Type: SetEntry, DB: 15, Key: "set", Deadline: 1, Values: []string{"m"}},
+	{Type: SortedSetEntry, DB: 3, Key: "zset", Deadline: NoDeadline, Values: []string{"a", "b", "c"},
+		Scores: []float64{math.Inf(-1), 1.5, math.Inf(1)}},
+}
+
+func writeTestSnapshot(t *testing.T, header Header) []byte {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, header)
+	assert.Nil(t, err)
+
+	for _, e := range testEntries {
+		assert.Nil(t, w.WriteEntry(e))
+	}
+	assert.Nil(t, w.Close())
+
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Unix(1600000000, 123*int64(time.Millisecond))
+	data := writeTestSnapshot(t, Header{Created: created, ID: "snapshot-id"})
+
+	r, err := NewReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+
+	header := r.Header()
+	assert.Equal(t, Version, header.Version)
+	assert.Equal(t, "snapshot-id", header.ID)
+	assert.True(t, created.Equal(header.Created))
+
+	var entries []*Entry
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.Nil(t, err)
+		entries = append(entries, e)
+	}
+
+	assert.Equal(t, testEntries, entries)
+
+	_, err = r.Next()
+	assert.True(t, errors.Is(err, io.EOF))
+
+	header, err = Verify(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.Equal(t, "snapshot-id", header.ID)
+}
+
+func TestEmptySnapshot(t *testing.T) {
+	var buf bytes.Buffer
+
+	w, err := NewWriter(&buf, Header{Created: time.Now()})
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	r, err := NewReader(&buf)
+	assert.Nil(t, err)
+
+	e, err := r.Next()
+	assert.Nil(t, e)
+	assert.True(t, errors.Is(err, io.EOF))
+}
+
+func TestCorruptedSnapshot(t *testing.T) {
+	data := writeTestSnapshot(t, Header{Created: time.Now(), ID: "id"})
+
+	// every truncation is invalid
+	for idx := 0; idx < len(data); idx++ {
+		_, err := Verify(bytes.NewReader(data[:idx]))
+		assert.NotNil(t, err, "len=%d", idx)
+	}
+
+	// every flipped byte is detected
+	for idx := 0; idx < len(data); idx++ {
+		corrupted := append([]byte(nil), data...)
+		corrupted[idx] ^= 0x20
+
+		_, err := Verify(bytes.NewReader(corrupted))
+		assert.NotNil(t, err, "index=%d", idx)
+	}
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-1] ^= 0x01
+	_, err := Verify(bytes.NewReader(corrupted))
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	_, err = Verify(bytes.NewReader([]byte("*1\r\n$4\r\nping\r\n")))
+	assert.True(t, errors.Is(err, ErrInvalidSnapshot))
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	data := writeTestSnapshot(t, Header{Created: time.Now()})
+	data[4] = Version + 1
+
+	_, err := NewReader(bytes.NewReader(data))
+	assert.True(t, errors.Is(err, ErrUnsupportedVersion))
+}
+
+func TestInvalidEntry(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{}, Header{})
+	assert.Nil(t, err)
+
+	assert.NotNil(t, w.WriteEntry(&Entry{Type: HashEntry, Key: "hash", Values: []string{"field"}}))
+	assert.NotNil(t, w.WriteEntry(&Entry{Type: SortedSetEntry, Key: "zset", Values: []string{"a"}}))
+	assert.NotNil(t, w.WriteEntry(&Entry{Type: 42, Key: "unknown"}))
+}
@@ -0,0 +1,321 @@
+package types
+
+import (
+	"sync/atomic"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
+)
+
+// Replier is the output of a session. The replies are queued in order by the RESPWriter returned by Writer
+// and sent by Flush, so the replies of a burst of pipelined requests are sent at once. CloseAfterReply
+// closes the connection once the queued replies are sent, nothing can be queued after it.
+type Replier interface {
+	Writer() protocol.RESPWriter
+	Flush()
+	CloseAfterReply()
+}
+
+// nextClientID is the id of the last created session, the ids start from 1
+var nextClientID int64
+
+// Session is the state of a client connection which lives across the requests, e.g., the selected db.
+// It is created along with the connection and carried by every request of it. The engine handles the
+// requests one by one, so a request always sees the state left by the previous one, even if they are
+// pipelined.
+type Session struct {
+	id       string
+	clientID int64
+	db       int
+
+	// protocol is the RESP version negotiated by HELLO
+	protocol int
+
+	// name is set by CLIENT SETNAME, the library name and version are set by CLIENT SETINFO
+	name       string
+	libName    string
+	libVersion string
+
+	// replier is nil if the session has no client, e.g., it replays the log
+	replier Replier
+
+	// inflight is the count of the requests submitted to the engine but not handled yet
+	inflight int32
+
+	// transaction states, the requests are queued after MULTI until EXEC or DISCARD
+	multi       bool
+	multiFailed bool
+	queued      [][]protocol.RedisObject
+
+	// watched keys and whether they existed when they are watched, dirty is set once any of them is modified
+	watched map[WatchedKey]bool
+	dirty   bool
+
+	// subscribed channels and patterns, the session is in the subscribed mode if any of them is not empty
+	channels map[string]struct{}
+	patterns map[string]struct{}
+
+	// blocked and subscribed tell whether the client waits without sending requests, they are read by the
+	// connection from another goroutine
+	blocked    int32
+	subscribed int32
+}
+
+// WatchedKey is a key in the db watched by WATCH
+type WatchedKey struct {
+	DB  int
+	Key string
+}
+
+// NewSession returns a new session of the connection, the db 0 and RESP2 are selected by default
+func NewSession(id string) *Session {
+	return &Session{
+		id:       id,
+		clientID: atomic.AddInt64(&nextClientID, 1),
+		db:       0,
+		protocol: protocol.Resp2,
+		watched:  make(map[WatchedKey]bool),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+	}
+}
+
+// ID returns the id of the connection
+func (s *Session) ID() string {
+	return s.id
+}
+
+// ClientID returns the unique increasing id of the client, which is replied by CLIENT ID
+func (s *Session) ClientID() int64 {
+	return s.clientID
+}
+
+// Protocol returns the RESP version of the client, RESP2 by default
+func (s *Session) Protocol() int {
+	return s.protocol
+}
+
+// SetProtocol sets the RESP version of the client, the version should be checked by the caller
+func (s *Session) SetProtocol(version int) {
+	s.protocol = version
+}
+
+// Name returns the name of the client, empty if it is not set
+func (s *Session) Name() string {
+	return s.name
+}
+
+// SetName sets the name of the client, an empty name removes it
+func (s *Session) SetName(name string) {
+	s.name = name
+}
+
+// LibName returns the library name reported by the client
+func (s *Session) LibName() string {
+	return s.libName
+}
+
+// SetLibName sets the library name reported by the client
+func (s *Session) SetLibName(name string) {
+	s.libName = name
+}
+
+// LibVersion returns the library version reported by the client
+func (s *Session) LibVersion() string {
+	return s.libVersion
+}
+
+// SetLibVersion sets the library version reported by the client
+func (s *Session) SetLibVersion(version string) {
+	s.libVersion = version
+}
+
+// Replier returns the output of the session, nil if it has no client
+func (s *Session) Replier() Replier {
+	return s.replier
+}
+
+// SetReplier sets the output of the session, it should be set before any request is submitted
+func (s *Session) SetReplier(r Replier) {
+	s.replier = r
+}
+
+// Submit counts a request submitted to the engine
+func (s *Session) Submit() {
+	atomic.AddInt32(&s.inflight, 1)
+}
+
+// Done counts a request handled by the engine, it reports whether all submitted requests are handled
+func (s *Session) Done() bool {
+	return atomic.AddInt32(&s.inflight, -1) == 0
+}
+
+// DB returns the index of the selected db
+func (s *Session) DB() int {
+	return s.db
+}
+
+// SetDB selects the db, the index should be checked by the caller
+func (s *Session) SetDB(index int) {
+	s.db = index
+}
+
+// InMulti reports whether the session is in a transaction
+func (s *Session) InMulti() bool {
+	return s.multi
+}
+
+// Multi starts a transaction
+func (s *Session) Multi() {
+	s.multi = true
+	s.multiFailed = false
+	s.queued = nil
+}
+
+// Queue queues a request into the transaction
+func (s *Session) Queue(objects []protocol.RedisObject) {
+	s.queued = append(s.queued, objects)
+}
+
+// Queued returns the queued requests of the transaction
+func (s *Session) Queued() [][]protocol.RedisObject {
+	return s.queued
+}
+
+// FailMulti marks the transaction failed because of a queuing error, it will be discarded by EXEC
+func (s *Session) FailMulti() {
+	s.multiFailed = true
+}
+
+// MultiFailed reports whether a request failed to queue
+func (s *Session) MultiFailed() bool {
+	return s.multiFailed
+}
+
+// ResetMulti leaves the transaction and drops the queued requests
+func (s *Session) ResetMulti() {
+	s.multi = false
+	s.multiFailed = false
+	s.queued = nil
+}
+
+// Watch watches the key, false if it is watched already
+func (s *Session) Watch(key WatchedKey, existed bool) bool {
+	if _, ok := s.watched[key]; ok {
+		return false
+	}
+
+	s.watched[key] = existed
+	return true
+}
+
+// Watched returns the watched keys and whether they existed when they are watched
+func (s *Session) Watched() map[WatchedKey]bool {
+	return s.watched
+}
+
+// Unwatch forgets all watched keys and clears the dirty flag
+func (s *Session) Unwatch() {
+	s.watched = make(map[WatchedKey]bool)
+	s.dirty = false
+}
+
+// SetDirty marks that a watched key is modified
+func (s *Session) SetDirty() {
+	s.dirty = true
+}
+
+// Dirty reports whether any watched key is modified
+func (s *Session) Dirty() bool {
+	return s.dirty
+}
+
+// Subscribe subscribes the channel, false if it is subscribed already
+func (s *Session) Subscribe(channel string) bool {
+	if _, ok := s.channels[channel]; ok {
+		return false
+	}
+
+	s.channels[channel] = struct{}{}
+	s.countSubscriptions()
+	return true
+}
+
+// Unsubscribe unsubscribes the channel, false if it is not subscribed
+func (s *Session) Unsubscribe(channel string) bool {
+	if _, ok := s.channels[channel]; !ok {
+		return false
+	}
+
+	delete(s.channels, channel)
+	s.countSubscriptions()
+	return true
+}
+
+// Channels returns the subscribed channels
+func (s *Session) Channels() []string {
+	var ret []string
+
+	for channel := range s.channels {
+		ret = append(ret, channel)
+	}
+
+	return ret
+}
+
+// PSubscribe subscribes the pattern, false if it is subscribed already
+func (s *Session) PSubscribe(pattern string) bool {
+	if _, ok := s.patterns[pattern]; ok {
+		return false
+	}
+
+	s.patterns[pattern] = struct{}{}
+	s.countSubscriptions()
+	return true
+}
+
+// PUnsubscribe unsubscribes the pattern, false if it is not subscribed
+func (s *Session) PUnsubscribe(pattern string) bool {
+	if _, ok := s.patterns[pattern]; !ok {
+		return false
+	}
+
+	delete(s.patterns, pattern)
+	s.countSubscriptions()
+	return true
+}
+
+// Patterns returns the subscribed patterns
+func (s *Session) Patterns() []string {
+	var ret []string
+
+	for pattern := range s.patterns {
+		ret = append(ret, pattern)
+	}
+
+	return ret
+}
+
+// Subscriptions returns the count of the subscribed channels and patterns
+func (s *Session) Subscriptions() int {
+	return len(s.channels) + len(s.patterns)
+}
+
+func (s *Session) countSubscriptions() {
+	atomic.StoreInt32(&s.subscribed, int32(s.Subscriptions()))
+}
+
+// SetBlocked sets whether the client is blocked by a command until it is served or timed out
+func (s *Session) SetBlocked(blocked bool) {
+	var value int32
+	if blocked {
+		value = 1
+	}
+
+	atomic.StoreInt32(&s.blocked, value)
+}
+
+// Waiting reports whether the client is blocked or subscribed, i.e., it waits for the replies or the
+// messages without sending any request. It is safe to be called by another goroutine.
+func (s *Session) Waiting() bool {
+	return atomic.LoadInt32(&s.blocked) == 1 || atomic.LoadInt32(&s.subscribed) > 0
+}
@@ -0,0 +1,112 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
+	. "github.com/lxdlam/vertex/pkg/types"
+)
+
+func TestSessionMulti(t *testing.T) {
+	s := NewSession("test")
+	assert.False(t, s.InMulti())
+
+	s.Multi()
+	s.Queue([]protocol.RedisObject{protocol.NewBulkRedisString("set")})
+	s.Queue([]protocol.RedisObject{protocol.NewBulkRedisString("get")})
+	assert.True(t, s.InMulti())
+	assert.Equal(t, 2, len(s.Queued()))
+
+	s.FailMulti()
+	assert.True(t, s.MultiFailed())
+
+	s.ResetMulti()
+	assert.False(t, s.InMulti())
+	assert.False(t, s.MultiFailed())
+	assert.Nil(t, s.Queued())
+}
+
+func TestSessionWatch(t *testing.T) {
+	s := NewSession("test")
+
+	assert.True(t, s.Watch(WatchedKey{DB: 0, Key: "a"}, true))
+	assert.False(t, s.Watch(WatchedKey{DB: 0, Key: "a"}, false))
+	assert.True(t, s.Watch(WatchedKey{DB: 1, Key: "a"}, false))
+	assert.Equal(t, map[WatchedKey]bool{{DB: 0, Key: "a"}: true, {DB: 1, Key: "a"}: false}, s.Watched())
+
+	s.SetDirty()
+	assert.True(t, s.Dirty())
+
+	s.Unwatch()
+	assert.False(t, s.Dirty())
+	assert.Empty(t, s.Watched())
+}
+
+func TestSessionSubscribe(t *testing.T) {
+	s := NewSession("test")
+
+	assert.True(t, s.Subscribe("a"))
+	assert.False(t, s.Subscribe("a"))
+	assert.True(t, s.PSubscribe("a*"))
+	assert.Equal(t, 2, s.Subscriptions())
+	assert.Equal(t, []string{"a"}, s.Channels())
+	assert.Equal(t, []string{"a*"}, s.Patterns())
+
+	assert.False(t, s.Unsubscribe("b"))
+	assert.True(t, s.Unsubscribe("a"))
+	assert.True(t, s.PUnsubscribe("a*"))
+	assert.False(t, s.PUnsubscribe("a*"))
+	assert.Equal(t, 0, s.Subscriptions())
+	assert.Nil(t, s.Channels())
+}
+
+func TestSessionWaiting(t *testing.T) {
+	s := NewSession("test")
+	assert.False(t, s.Waiting())
+
+	s.SetBlocked(true)
+	assert.True(t, s.Waiting())
+	s.SetBlocked(false)
+	assert.False(t, s.Waiting())
+
+	assert.True(t, s.PSubscribe("a*"))
+	assert.True(t, s.Waiting())
+	assert.True(t, s.Subscribe("a"))
+	assert.True(t, s.PUnsubscribe("a*"))
+	assert.True(t, s.Waiting())
+	assert.True(t, s.Unsubscribe("a"))
+	assert.False(t, s.Waiting())
+}
+
+func TestSessionInflight(t *testing.T) {
+	s := NewSession("test")
+
+	s.Submit()
+	s.Submit()
+	assert.False(t, s.Done())
+	assert.True(t, s.Done())
+}
+
+func TestSessionClient(t *testing.T) {
+	first := NewSession("first")
+	second := NewSession("second")
+	assert.True(t, second.ClientID() > first.ClientID())
+
+	assert.Equal(t, "", first.Name())
+	first.SetName("worker")
+	first.SetLibName("go-redis")
+	first.SetLibVersion("9.0.0")
+	assert.Equal(t, "worker", first.Name())
+	assert.Equal(t, "go-redis", first.LibName())
+	assert.Equal(t, "9.0.0", first.LibVersion())
+}
+
+func TestSessionProtocol(t *testing.T) {
+	s := NewSession("test")
+	assert.Equal(t, protocol.Resp2, s.Protocol())
+
+	s.SetProtocol(protocol.Resp3)
+	assert.Equal(t, protocol.Resp3, s.Protocol())
+}
@@ -0,0 +1,114 @@
+package util
+
+// GlobMatch reports if the string matches the glob-style pattern, it follows the rules of redis:
+//   - `?` matches any single character
+//   - `*` matches any sequence of characters, including the empty one
+//   - `[abc]` matches one of the characters, `[^abc]` matches one character not in the set and
+//     `[a-z]` matches one character in the range
+//   - `\` escapes the next character, so `\*` matches a literal `*`
+//
+// A mismatch after a `*` retries with the last `*` matching one more character only, as the earlier ones
+// never need to match more, so a string is matched in O(len(pattern) * len(s)) at most.
+func GlobMatch(pattern, s string) bool {
+	var star, starS string
+	backtrack := false
+
+	for len(pattern) > 0 || len(s) > 0 {
+		if len(pattern) > 0 && pattern[0] == '*' {
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true
+			}
+
+			star, starS, backtrack = pattern, s, true
+			continue
+		}
+
+		if len(pattern) > 0 && len(s) > 0 {
+			if matched, rest := matchOne(pattern, s[0]); matched {
+				pattern, s = rest, s[1:]
+				continue
+			}
+		}
+
+		if !backtrack || len(starS) == 0 {
+			return false
+		}
+
+		starS = starS[1:]
+		pattern, s = star, starS
+	}
+
+	return true
+}
+
+// matchOne matches c against the first element of the pattern which is not `*`, it returns the match
+// result and the pattern after the element
+func matchOne(pattern string, c byte) (bool, string) {
+	switch pattern[0] {
+	case '?':
+		return true, pattern[1:]
+	case '[':
+		// the pattern points to the closing bracket or is exhausted
+		matched, rest := matchClass(pattern[1:], c)
+		if len(rest) > 0 {
+			rest = rest[1:]
+		}
+
+		return matched, rest
+	case '\\':
+		if len(pattern) >= 2 {
+			pattern = pattern[1:]
+		}
+	}
+
+	return pattern[0] == c, pattern[1:]
+}
+
+// matchClass matches c against the class starts right after `[`, it returns the match result and the
+// pattern which starts at the closing `]`. An unclosed class consumes the whole pattern.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := false
+	matched := false
+
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case len(pattern) >= 3 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+
+			if c >= start && c <= end {
+				matched = true
+			}
+
+			pattern = pattern[2:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+		}
+
+		pattern = pattern[1:]
+	}
+
+	if negate {
+		matched = !matched
+	}
+
+	return matched, pattern
+}
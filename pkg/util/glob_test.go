@@ -0,0 +1,113 @@
+package util_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/lxdlam/vertex/pkg/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobMatch(t *testing.T) {
+	testCases := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"", "", true},
+		{"", "a", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h**llo", "heeeello", true},
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h[\\]]llo", "h]llo", true},
+		{"user:*:session", "user:42:session", true},
+		{"user:*:session", "user:42:sessions", false},
+		{"*suffix", "suffix!", false},
+		{"*suffix", "hassuffix", true},
+		{"h[ae", "ha", true},
+		{"abc\\", "abc\\", true},
+		{"*a*b", "aaabab", true},
+		{"*a*b", "aaabaa", false},
+		{"a*b*c", "abcbc", true},
+		{"a*?c", "ac", false},
+		{"*[0-9]", "key:12", true},
+		{"*\\**", "a*b", true},
+		{"*\\**", "ab", false},
+		// the negated classes and the ranges
+		{"[^a-c]", "d", true},
+		{"[^a-c]", "b", false},
+		{"[^abc]x", "ax", false},
+		{"[^abc]x", "dx", true},
+		{"[a-cx-z]", "y", true},
+		{"[a-cx-z]", "m", false},
+		{"[0-9][0-9]", "42", true},
+		{"[0-9][0-9]", "4a", false},
+		{"*[^a]", "aaa", false},
+		{"*[^a]", "aab", true},
+		{"[]a", "a", false},
+		// the escapes, in and out of the classes
+		{"\\?", "?", true},
+		{"\\?", "a", false},
+		{"\\[a]", "[a]", true},
+		{"\\[a]", "a", false},
+		{"a\\\\b", "a\\b", true},
+		{"[\\-]", "-", true},
+		{"[a\\-z]", "-", true},
+		{"[a\\-z]", "b", false},
+		{"[^\\]]", "]", false},
+		{"[^\\]]", "a", true},
+		{"\\*\\?\\[", "*?[", true},
+		// the stars which may match wrongly without a retry
+		{"*a*a*a*b", "aaab", true},
+		{"*a*a*a*b", "aab", false},
+		{"*a*a*a*b", "aaaba", false},
+		{"*ab*ab", "aabab", true},
+		{"*?*?*?", "ab", false},
+		{"*?*?*?", "abc", true},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, GlobMatch(testCase.pattern, testCase.s), "pattern=%q, s=%q", testCase.pattern, testCase.s)
+	}
+}
+
+// TestGlobMatchStars matches the patterns of many stars, which take exponential time by a recursive
+// backtracking
+func TestGlobMatchStars(t *testing.T) {
+	long := strings.Repeat("a", 10000)
+
+	testCases := []struct {
+		pattern, s string
+		expected   bool
+	}{
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100), false},
+		{strings.Repeat("a*", 30) + "b", strings.Repeat("a", 100) + "b", true},
+		{strings.Repeat("*a", 50) + "*b", long, false},
+		{strings.Repeat("*a", 50) + "*b", long + "b", true},
+		{strings.Repeat("*?", 50) + "b", long, false},
+		{strings.Repeat("*[a-z]", 50) + "b", long, false},
+		{strings.Repeat("*[^b]", 50) + "b", long + "c", false},
+		{strings.Repeat("*\\a", 50) + "*b", long, false},
+		{"*" + strings.Repeat("a", 100) + "*b", long + "b", true},
+	}
+
+	for _, testCase := range testCases {
+		start := time.Now()
+		assert.Equal(t, testCase.expected, GlobMatch(testCase.pattern, testCase.s), "pattern=%q", testCase.pattern)
+		assert.True(t, time.Since(start) < time.Second, "pattern=%q, elapsed=%s", testCase.pattern, time.Since(start))
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/lxdlam/vertex/pkg/protocol"
 )
 
 var localAddr = "unknown"
@@ -81,6 +84,17 @@ func ParseInt64(s string) (int64, error) {
 	return strconv.ParseInt(s, 10, 64)
 }
 
+// FormatFloat formats a float in the shortest representation which parses back to the same value, as
+// redis replies the scores. The exponent form is only used for very large or small values.
+func FormatFloat(f float64) string {
+	return protocol.FormatDouble(f)
+}
+
+// UnixMilli returns the current unix timestamp in milliseconds, which is the resolution of all key deadlines.
+func UnixMilli() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
 // GetIP will returns the local external ip by iterate all net interfaces.
 // If no ip is find, localAddr will be "unknown" by default.
 func GetIP() string {
@@ -2,6 +2,7 @@ package util_test
 
 import (
 	"errors"
+	"math"
 	"testing"
 
 	. "github.com/lxdlam/vertex/pkg/util"
@@ -133,3 +134,25 @@ func TestParseInt64(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatFloat(t *testing.T) {
+	testCases := []struct {
+		f        float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{100, "100"},
+		{-3, "-3"},
+		{0, "0"},
+		{0.1, "0.1"},
+		{1234567, "1234567"},
+		{1e21, "1e+21"},
+		{1e-7, "1e-07"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, FormatFloat(testCase.f), "f=%v", testCase.f)
+	}
+}